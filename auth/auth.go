@@ -0,0 +1,225 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	ipld "github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/fluent"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	basicnode "github.com/ipld/go-ipld-prime/node/basic"
+	"github.com/libp2p/go-libp2p-core/peer"
+
+	"github.com/ipfs/go-graphsync"
+	"github.com/ipfs/go-graphsync/ipldutil"
+)
+
+var (
+	// ErrNoToken means a request has no auth token
+	ErrNoToken = errors.New("request has no auth token")
+	// ErrTokenExpired means a request's auth token has expired
+	ErrTokenExpired = errors.New("auth token expired")
+	// ErrTokenMismatch means a request's auth token was issued for a different
+	// requestor, root or selector
+	ErrTokenMismatch = errors.New("auth token does not match request")
+)
+
+// Token authorizes a requestor to request a root and selector until it expires
+type Token struct {
+	Peer         peer.ID
+	Root         cid.Cid
+	SelectorHash []byte
+	Expiry       time.Time
+	Signature    []byte
+}
+
+// NewToken returns a token authorizing the given requestor to request the given
+// root and selector until expiry, signed with the given signer
+func NewToken(signer Signer, requestor peer.ID, root cid.Cid, selector ipld.Node, expiry time.Time) (Token, error) {
+	selectorHash, err := hashSelector(selector)
+	if err != nil {
+		return Token{}, err
+	}
+	token := Token{
+		Peer:         requestor,
+		Root:         root,
+		SelectorHash: selectorHash,
+		Expiry:       time.Unix(0, expiry.UnixNano()/int64(time.Millisecond)*int64(time.Millisecond)),
+	}
+	message, err := token.signedMessage()
+	if err != nil {
+		return Token{}, err
+	}
+	token.Signature, err = signer.Sign(message)
+	if err != nil {
+		return Token{}, err
+	}
+	return token, nil
+}
+
+// Verify checks the token was signed by the given verifier's signer, and
+// authorizes the given requestor to make the given request at the given time
+func (t Token) Verify(verifier Verifier, requestor peer.ID, request graphsync.RequestData, now time.Time) error {
+	message, err := t.signedMessage()
+	if err != nil {
+		return err
+	}
+	err = verifier.Verify(message, t.Signature)
+	if err != nil {
+		return err
+	}
+	if !now.Before(t.Expiry) {
+		return ErrTokenExpired
+	}
+	selectorHash, err := hashSelector(request.Selector())
+	if err != nil {
+		return err
+	}
+	if t.Peer != requestor || !t.Root.Equals(request.Root()) || !bytes.Equal(t.SelectorHash, selectorHash) {
+		return ErrTokenMismatch
+	}
+	return nil
+}
+
+// signedMessage is the encoding of every field of the token but its signature
+func (t Token) signedMessage() ([]byte, error) {
+	node, err := fluent.Build(basicnode.Style.Map, func(na fluent.NodeAssembler) {
+		na.CreateMap(4, func(na fluent.MapAssembler) {
+			na.AssembleEntry("peer").AssignBytes([]byte(t.Peer))
+			na.AssembleEntry("root").AssignLink(cidlink.Link{Cid: t.Root})
+			na.AssembleEntry("selectorHash").AssignBytes(t.SelectorHash)
+			na.AssembleEntry("expiry").AssignInt(int(t.Expiry.UnixNano() / int64(time.Millisecond)))
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ipldutil.EncodeNode(node)
+}
+
+// EncodeToken returns encoded cbor data for a token, for the auth extension
+func EncodeToken(token Token) ([]byte, error) {
+	node, err := fluent.Build(basicnode.Style.Map, func(na fluent.NodeAssembler) {
+		na.CreateMap(5, func(na fluent.MapAssembler) {
+			na.AssembleEntry("peer").AssignBytes([]byte(token.Peer))
+			na.AssembleEntry("root").AssignLink(cidlink.Link{Cid: token.Root})
+			na.AssembleEntry("selectorHash").AssignBytes(token.SelectorHash)
+			na.AssembleEntry("expiry").AssignInt(int(token.Expiry.UnixNano() / int64(time.Millisecond)))
+			na.AssembleEntry("signature").AssignBytes(token.Signature)
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ipldutil.EncodeNode(node)
+}
+
+// DecodeToken returns a token decoded from data for the auth extension
+func DecodeToken(data []byte) (Token, error) {
+	node, err := ipldutil.DecodeNode(data)
+	if err != nil {
+		return Token{}, err
+	}
+	peerData, err := lookupBytes(node, "peer")
+	if err != nil {
+		return Token{}, err
+	}
+	rootNode, err := node.LookupString("root")
+	if err != nil {
+		return Token{}, err
+	}
+	root, err := rootNode.AsLink()
+	if err != nil {
+		return Token{}, err
+	}
+	rootCidLink, ok := root.(cidlink.Link)
+	if !ok {
+		return Token{}, errors.New("root is not a cid")
+	}
+	selectorHash, err := lookupBytes(node, "selectorHash")
+	if err != nil {
+		return Token{}, err
+	}
+	expiryNode, err := node.LookupString("expiry")
+	if err != nil {
+		return Token{}, err
+	}
+	expiry, err := expiryNode.AsInt()
+	if err != nil {
+		return Token{}, err
+	}
+	signature, err := lookupBytes(node, "signature")
+	if err != nil {
+		return Token{}, err
+	}
+	return Token{
+		Peer:         peer.ID(peerData),
+		Root:         rootCidLink.Cid,
+		SelectorHash: selectorHash,
+		Expiry:       time.Unix(0, int64(expiry)*int64(time.Millisecond)),
+		Signature:    signature,
+	}, nil
+}
+
+// NewExtension returns the auth extension for a request from the given
+// requestor for the given root and selector, with a token signed by the given
+// signer that expires at expiry
+func NewExtension(signer Signer, requestor peer.ID, root ipld.Link, selector ipld.Node, expiry time.Time) (graphsync.ExtensionData, error) {
+	rootCidLink, ok := root.(cidlink.Link)
+	if !ok {
+		return graphsync.ExtensionData{}, errors.New("root is not a cid")
+	}
+	token, err := NewToken(signer, requestor, rootCidLink.Cid, selector, expiry)
+	if err != nil {
+		return graphsync.ExtensionData{}, err
+	}
+	data, err := EncodeToken(token)
+	if err != nil {
+		return graphsync.ExtensionData{}, err
+	}
+	return graphsync.ExtensionData{Name: graphsync.ExtensionAuth, Data: data}, nil
+}
+
+// Authenticator returns an incoming request hook that validates requests with
+// an auth token the given verifier accepts, and terminates all other requests
+// with an error
+func Authenticator(verifier Verifier) graphsync.OnIncomingRequestHook {
+	return func(p peer.ID, request graphsync.RequestData, hookActions graphsync.IncomingRequestHookActions) {
+		data, has := request.Extension(graphsync.ExtensionAuth)
+		if !has {
+			hookActions.TerminateWithError(ErrNoToken)
+			return
+		}
+		token, err := DecodeToken(data)
+		if err != nil {
+			hookActions.TerminateWithError(err)
+			return
+		}
+		err = token.Verify(verifier, p, request, time.Now())
+		if err != nil {
+			hookActions.TerminateWithError(err)
+			return
+		}
+		hookActions.ValidateRequest()
+	}
+}
+
+func hashSelector(selector ipld.Node) ([]byte, error) {
+	selectorData, err := ipldutil.EncodeNode(selector)
+	if err != nil {
+		return nil, err
+	}
+	hash := sha256.Sum256(selectorData)
+	return hash[:], nil
+}
+
+func lookupBytes(node ipld.Node, key string) ([]byte, error) {
+	bytesNode, err := node.LookupString(key)
+	if err != nil {
+		return nil, err
+	}
+	return bytesNode.AsBytes()
+}
@@ -0,0 +1,149 @@
+package auth
+
+import (
+	"crypto/rand"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ipfs/go-graphsync"
+	gsmsg "github.com/ipfs/go-graphsync/message"
+	"github.com/ipfs/go-graphsync/testutil"
+)
+
+// fakeHookActions records the actions the authenticator takes. It embeds the
+// interface so only the actions the authenticator uses need implementing
+type fakeHookActions struct {
+	graphsync.IncomingRequestHookActions
+	validated bool
+	err       error
+}
+
+func (fha *fakeHookActions) TerminateWithError(err error) { fha.err = err }
+func (fha *fakeHookActions) ValidateRequest()             { fha.validated = true }
+
+func TestDecodeEncodeToken(t *testing.T) {
+	peers := testutil.GeneratePeers(1)
+	root := testutil.GenerateCids(1)[0]
+	selector := testutil.NewInvalidSelectorSpec()
+	expiry := time.Now().Add(time.Minute)
+	token, err := NewToken(NewHMAC([]byte("secret")), peers[0], root, selector, expiry)
+	require.NoError(t, err)
+	encoded, err := EncodeToken(token)
+	require.NoError(t, err, "encode errored")
+	decoded, err := DecodeToken(encoded)
+	require.NoError(t, err, "decode errored")
+	require.Equal(t, token.Peer, decoded.Peer)
+	require.True(t, token.Root.Equals(decoded.Root))
+	require.Equal(t, token.SelectorHash, decoded.SelectorHash)
+	require.True(t, token.Expiry.Equal(decoded.Expiry))
+	require.Equal(t, token.Signature, decoded.Signature)
+}
+
+func TestAuthenticator(t *testing.T) {
+	peers := testutil.GeneratePeers(2)
+	roots := testutil.GenerateCids(2)
+	selector := testutil.NewInvalidSelectorSpec()
+	otherSelector := testutil.NewUnparsableSelectorSpec()
+	privKey, pubKey, err := crypto.GenerateEd25519Key(rand.Reader)
+	require.NoError(t, err)
+	otherPrivKey, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	require.NoError(t, err)
+	hmacKey := NewHMAC([]byte("secret"))
+
+	testCases := map[string]struct {
+		signer    Signer
+		verifier  Verifier
+		noToken   bool
+		requestor peer.ID
+		root      int
+		selector  bool
+		expiry    time.Duration
+		err       error
+	}{
+		"valid hmac token": {
+			signer:   hmacKey,
+			verifier: hmacKey,
+		},
+		"valid ed25519 token": {
+			signer:   NewKeySigner(privKey),
+			verifier: NewKeyVerifier(pubKey),
+		},
+		"no token": {
+			verifier: hmacKey,
+			noToken:  true,
+			err:      ErrNoToken,
+		},
+		"wrong hmac key": {
+			signer:   NewHMAC([]byte("other secret")),
+			verifier: hmacKey,
+			err:      ErrInvalidSignature,
+		},
+		"wrong ed25519 key": {
+			signer:   NewKeySigner(otherPrivKey),
+			verifier: NewKeyVerifier(pubKey),
+			err:      ErrInvalidSignature,
+		},
+		"expired token": {
+			signer:   hmacKey,
+			verifier: hmacKey,
+			expiry:   -time.Minute,
+			err:      ErrTokenExpired,
+		},
+		"token for another requestor": {
+			signer:    hmacKey,
+			verifier:  hmacKey,
+			requestor: peers[1],
+			err:       ErrTokenMismatch,
+		},
+		"token for another root": {
+			signer:   hmacKey,
+			verifier: hmacKey,
+			root:     1,
+			err:      ErrTokenMismatch,
+		},
+		"token for another selector": {
+			signer:   hmacKey,
+			verifier: hmacKey,
+			selector: true,
+			err:      ErrTokenMismatch,
+		},
+	}
+	for testCase, data := range testCases {
+		t.Run(testCase, func(t *testing.T) {
+			var extensions []graphsync.ExtensionData
+			if !data.noToken {
+				requestor := peers[0]
+				if data.requestor != "" {
+					requestor = data.requestor
+				}
+				tokenSelector := selector
+				if data.selector {
+					tokenSelector = otherSelector
+				}
+				expiry := time.Minute
+				if data.expiry != 0 {
+					expiry = data.expiry
+				}
+				token, err := NewToken(data.signer, requestor, roots[data.root], tokenSelector, time.Now().Add(expiry))
+				require.NoError(t, err)
+				tokenData, err := EncodeToken(token)
+				require.NoError(t, err)
+				extensions = append(extensions, graphsync.ExtensionData{Name: graphsync.ExtensionAuth, Data: tokenData})
+			}
+			request := gsmsg.NewRequest(graphsync.RequestID(1), roots[0], selector, graphsync.Priority(0), extensions...)
+			hookActions := &fakeHookActions{}
+			Authenticator(data.verifier)(peers[0], request, hookActions)
+			if data.err != nil {
+				require.Equal(t, data.err, hookActions.err)
+				require.False(t, hookActions.validated)
+			} else {
+				require.NoError(t, hookActions.err)
+				require.True(t, hookActions.validated)
+			}
+		})
+	}
+}
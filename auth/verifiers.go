@@ -0,0 +1,88 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+
+	"github.com/libp2p/go-libp2p-core/crypto"
+)
+
+// ErrInvalidSignature means an auth token was not signed by a trusted signer
+var ErrInvalidSignature = errors.New("invalid auth token signature")
+
+// Signer signs auth tokens
+type Signer interface {
+	Sign(message []byte) ([]byte, error)
+}
+
+// Verifier checks auth tokens were signed by a trusted signer
+type Verifier interface {
+	Verify(message []byte, signature []byte) error
+}
+
+// HMAC signs and verifies auth tokens with HMAC-SHA256, using a secret key
+// shared by whoever issues tokens and the responder
+type HMAC struct {
+	key []byte
+}
+
+// NewHMAC returns an HMAC signer and verifier using the given secret key
+func NewHMAC(key []byte) *HMAC {
+	return &HMAC{key: key}
+}
+
+// Sign returns the HMAC of the message
+func (h *HMAC) Sign(message []byte) ([]byte, error) {
+	mac := hmac.New(sha256.New, h.key)
+	_, _ = mac.Write(message)
+	return mac.Sum(nil), nil
+}
+
+// Verify checks the signature is the HMAC of the message
+func (h *HMAC) Verify(message []byte, signature []byte) error {
+	expected, _ := h.Sign(message)
+	if !hmac.Equal(expected, signature) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// KeySigner signs auth tokens with a private key, such as an ed25519 key
+type KeySigner struct {
+	key crypto.PrivKey
+}
+
+// NewKeySigner returns a signer using the given private key
+func NewKeySigner(key crypto.PrivKey) *KeySigner {
+	return &KeySigner{key: key}
+}
+
+// Sign signs the message with the private key
+func (ks *KeySigner) Sign(message []byte) ([]byte, error) {
+	return ks.key.Sign(message)
+}
+
+// KeyVerifier verifies auth tokens signed with the private key for a public key
+type KeyVerifier struct {
+	key crypto.PubKey
+}
+
+// NewKeyVerifier returns a verifier for signatures made with the private key
+// for the given public key
+func NewKeyVerifier(key crypto.PubKey) *KeyVerifier {
+	return &KeyVerifier{key: key}
+}
+
+// Verify checks the signature was made over the message with the private key
+// for the public key
+func (kv *KeyVerifier) Verify(message []byte, signature []byte) error {
+	ok, err := kv.key.Verify(message, signature)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrInvalidSignature
+	}
+	return nil
+}
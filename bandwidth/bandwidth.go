@@ -0,0 +1,233 @@
+package bandwidth
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// Limiter limits the rate at which bytes are sent, both across all peers and
+// to each individual peer, using token buckets. A rate of zero means unlimited.
+// Rates can be changed at any time
+type Limiter struct {
+	lk              sync.Mutex
+	global          *bucket
+	defaultPeerRate uint64
+	peers           map[peer.ID]*peerBucket
+}
+
+// peerBucket is the bucket for a single peer. Its rate is the lowest of the
+// rates set for requests in progress, if there are any, and otherwise the
+// rate set for the peer or the default
+type peerBucket struct {
+	*bucket
+	isCustom     bool
+	customRate   uint64
+	requestRates map[*requestRate]struct{}
+	// isRemoved is set when the peer is removed while requests are still in
+	// progress, so the bucket is removed once they finish
+	isRemoved bool
+}
+
+type requestRate struct {
+	rate uint64
+}
+
+// NewLimiter returns a limiter with the given global and default per peer
+// rates, in bytes per second
+func NewLimiter(globalRate uint64, defaultPeerRate uint64) *Limiter {
+	return &Limiter{
+		global:          newBucket(globalRate, time.Now()),
+		defaultPeerRate: defaultPeerRate,
+		peers:           make(map[peer.ID]*peerBucket),
+	}
+}
+
+// SetGlobalRate sets the rate across all peers, in bytes per second
+func (l *Limiter) SetGlobalRate(rate uint64) {
+	l.lk.Lock()
+	defer l.lk.Unlock()
+	l.global.setRate(rate, time.Now())
+}
+
+// SetDefaultPeerRate sets the rate for each peer that has not had its own rate
+// set, in bytes per second
+func (l *Limiter) SetDefaultPeerRate(rate uint64) {
+	l.lk.Lock()
+	defer l.lk.Unlock()
+	now := time.Now()
+	l.defaultPeerRate = rate
+	for _, pb := range l.peers {
+		pb.setRate(l.peerRate(pb), now)
+	}
+}
+
+// SetPeerRate sets the rate for a single peer, in bytes per second,
+// overriding the default per peer rate
+func (l *Limiter) SetPeerRate(p peer.ID, rate uint64) {
+	l.lk.Lock()
+	defer l.lk.Unlock()
+	pb := l.peerBucket(p, time.Now())
+	pb.isCustom = true
+	pb.customRate = rate
+	pb.setRate(l.peerRate(pb), time.Now())
+}
+
+// ClearPeerRate returns a peer to the default per peer rate
+func (l *Limiter) ClearPeerRate(p peer.ID) {
+	l.lk.Lock()
+	defer l.lk.Unlock()
+	pb, ok := l.peers[p]
+	if !ok {
+		return
+	}
+	if len(pb.requestRates) == 0 {
+		delete(l.peers, p)
+		return
+	}
+	pb.isCustom = false
+	pb.setRate(l.peerRate(pb), time.Now())
+}
+
+// SetRequestRate sets the rate for a single peer, in bytes per second, while
+// a request from it is in progress, meaning until the given context is done.
+// It overrides the peer's other rates, and if there are several requests with
+// rates, the lowest applies
+func (l *Limiter) SetRequestRate(ctx context.Context, p peer.ID, rate uint64) {
+	l.lk.Lock()
+	defer l.lk.Unlock()
+	now := time.Now()
+	pb := l.peerBucket(p, now)
+	rr := &requestRate{rate}
+	pb.requestRates[rr] = struct{}{}
+	pb.setRate(l.peerRate(pb), now)
+	go func() {
+		<-ctx.Done()
+		l.lk.Lock()
+		defer l.lk.Unlock()
+		delete(pb.requestRates, rr)
+		if pb.isRemoved && len(pb.requestRates) == 0 && l.peers[p] == pb {
+			delete(l.peers, p)
+			return
+		}
+		pb.setRate(l.peerRate(pb), time.Now())
+	}()
+}
+
+// RemovePeer forgets the bucket for a peer, such as when it disconnects. A
+// rate set for the peer with SetPeerRate is kept, as are rates for requests
+// still in progress
+func (l *Limiter) RemovePeer(p peer.ID) {
+	l.lk.Lock()
+	defer l.lk.Unlock()
+	pb, ok := l.peers[p]
+	if !ok || pb.isCustom {
+		return
+	}
+	if len(pb.requestRates) > 0 {
+		pb.isRemoved = true
+		return
+	}
+	delete(l.peers, p)
+}
+
+// Wait blocks until the given number of bytes can be sent to the given peer,
+// or the context is cancelled
+func (l *Limiter) Wait(ctx context.Context, p peer.ID, size uint64) error {
+	l.lk.Lock()
+	now := time.Now()
+	pb := l.peerBucket(p, now)
+	delay := l.global.reserve(size, now)
+	if peerDelay := pb.reserve(size, now); peerDelay > delay {
+		delay = peerDelay
+	}
+	l.lk.Unlock()
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+func (l *Limiter) peerBucket(p peer.ID, now time.Time) *peerBucket {
+	pb, ok := l.peers[p]
+	if !ok {
+		pb = &peerBucket{
+			bucket:       newBucket(l.defaultPeerRate, now),
+			requestRates: make(map[*requestRate]struct{}),
+		}
+		l.peers[p] = pb
+	}
+	pb.isRemoved = false
+	return pb
+}
+
+func (l *Limiter) peerRate(pb *peerBucket) uint64 {
+	if len(pb.requestRates) > 0 {
+		var lowest uint64
+		for rr := range pb.requestRates {
+			if rr.rate != 0 && (lowest == 0 || rr.rate < lowest) {
+				lowest = rr.rate
+			}
+		}
+		return lowest
+	}
+	if pb.isCustom {
+		return pb.customRate
+	}
+	return l.defaultPeerRate
+}
+
+// bucket is a token bucket holding up to one second's worth of bytes. Sends are
+// reserved up front, so the balance can go negative, and the caller waits until
+// it would be positive again
+type bucket struct {
+	rate   uint64
+	tokens float64
+	last   time.Time
+}
+
+func newBucket(rate uint64, now time.Time) *bucket {
+	return &bucket{rate: rate, tokens: float64(rate), last: now}
+}
+
+func (b *bucket) refill(now time.Time) {
+	elapsed := now.Sub(b.last).Seconds()
+	b.last = now
+	b.tokens += elapsed * float64(b.rate)
+	if b.tokens > float64(b.rate) {
+		b.tokens = float64(b.rate)
+	}
+}
+
+func (b *bucket) setRate(rate uint64, now time.Time) {
+	b.refill(now)
+	// an unlimited bucket was as good as full
+	if b.rate == 0 {
+		b.tokens = float64(rate)
+	}
+	b.rate = rate
+	if b.tokens > float64(rate) {
+		b.tokens = float64(rate)
+	}
+}
+
+func (b *bucket) reserve(size uint64, now time.Time) time.Duration {
+	if b.rate == 0 {
+		return 0
+	}
+	b.refill(now)
+	b.tokens -= float64(size)
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / float64(b.rate) * float64(time.Second))
+}
@@ -0,0 +1,110 @@
+package bandwidth
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/ipfs/go-graphsync/testutil"
+)
+
+func timeWait(ctx context.Context, t *testing.T, limiter *Limiter, size uint64, peerIndex int) time.Duration {
+	start := time.Now()
+	require.NoError(t, limiter.Wait(ctx, testPeers[peerIndex], size))
+	return time.Since(start)
+}
+
+var testPeers = testutil.GeneratePeers(2)
+
+func TestUnlimited(t *testing.T) {
+	ctx := context.Background()
+	limiter := NewLimiter(0, 0)
+	require.Less(t, int64(timeWait(ctx, t, limiter, 1<<30, 0)), int64(10*time.Millisecond))
+}
+
+func TestGlobalRate(t *testing.T) {
+	ctx := context.Background()
+	limiter := NewLimiter(10000, 0)
+	// burst is available immediately
+	require.Less(t, int64(timeWait(ctx, t, limiter, 10000, 0)), int64(10*time.Millisecond))
+	// after that, both peers share the global rate
+	require.GreaterOrEqual(t, int64(timeWait(ctx, t, limiter, 1000, 1)), int64(80*time.Millisecond))
+
+	limiter.SetGlobalRate(0)
+	require.Less(t, int64(timeWait(ctx, t, limiter, 10000, 0)), int64(10*time.Millisecond))
+}
+
+func TestPeerRate(t *testing.T) {
+	ctx := context.Background()
+	limiter := NewLimiter(0, 10000)
+	require.Less(t, int64(timeWait(ctx, t, limiter, 10000, 0)), int64(10*time.Millisecond))
+	// other peers have their own bucket
+	require.Less(t, int64(timeWait(ctx, t, limiter, 10000, 1)), int64(10*time.Millisecond))
+	require.GreaterOrEqual(t, int64(timeWait(ctx, t, limiter, 1000, 0)), int64(80*time.Millisecond))
+
+	// custom peer rates override the default
+	limiter.SetPeerRate(testPeers[0], 0)
+	limiter.SetDefaultPeerRate(1)
+	require.Less(t, int64(timeWait(ctx, t, limiter, 10000, 0)), int64(10*time.Millisecond))
+
+	limiter.ClearPeerRate(testPeers[0])
+	require.Less(t, int64(timeWait(ctx, t, limiter, 1, 0)), int64(10*time.Millisecond))
+}
+
+func TestWaitCancelled(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	limiter := NewLimiter(1000, 0)
+	require.NoError(t, limiter.Wait(ctx, testPeers[0], 1000))
+	require.Error(t, limiter.Wait(ctx, testPeers[0], 1000))
+}
+
+func TestRequestRate(t *testing.T) {
+	ctx := context.Background()
+	limiter := NewLimiter(0, 0)
+	requestCtx, cancelRequest := context.WithCancel(ctx)
+	otherRequestCtx, cancelOtherRequest := context.WithCancel(ctx)
+	limiter.SetRequestRate(requestCtx, testPeers[0], 10000)
+	limiter.SetRequestRate(otherRequestCtx, testPeers[0], 20000)
+	// the lowest request rate applies, and only to the requesting peer
+	require.Less(t, int64(timeWait(ctx, t, limiter, 10000, 0)), int64(10*time.Millisecond))
+	require.GreaterOrEqual(t, int64(timeWait(ctx, t, limiter, 1000, 0)), int64(80*time.Millisecond))
+	require.Less(t, int64(timeWait(ctx, t, limiter, 1<<30, 1)), int64(10*time.Millisecond))
+
+	// once the requests finish, the peer returns to its own rate
+	cancelRequest()
+	cancelOtherRequest()
+	require.Eventually(t, func() bool {
+		return timeWait(ctx, t, limiter, 1<<30, 0) < 10*time.Millisecond
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestRemovePeer(t *testing.T) {
+	ctx := context.Background()
+	limiter := NewLimiter(0, 10000)
+	timeWait(ctx, t, limiter, 1, 0)
+	limiter.SetPeerRate(testPeers[1], 10000)
+	limiter.RemovePeer(testPeers[0])
+	limiter.RemovePeer(testPeers[1])
+	limiter.lk.Lock()
+	require.NotContains(t, limiter.peers, testPeers[0], "should remove peer")
+	require.Contains(t, limiter.peers, testPeers[1], "should keep peer with its own rate")
+	limiter.lk.Unlock()
+
+	// peers with requests in progress are removed once the requests finish
+	requestCtx, cancelRequest := context.WithCancel(ctx)
+	limiter.SetRequestRate(requestCtx, testPeers[0], 10000)
+	limiter.RemovePeer(testPeers[0])
+	limiter.lk.Lock()
+	require.Contains(t, limiter.peers, testPeers[0], "should keep peer while request is in progress")
+	limiter.lk.Unlock()
+	cancelRequest()
+	require.Eventually(t, func() bool {
+		limiter.lk.Lock()
+		defer limiter.lk.Unlock()
+		_, ok := limiter.peers[testPeers[0]]
+		return !ok
+	}, time.Second, 10*time.Millisecond)
+}
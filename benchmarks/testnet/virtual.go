@@ -16,6 +16,7 @@ import (
 	"github.com/libp2p/go-libp2p-core/peer"
 	tnet "github.com/libp2p/go-libp2p-testing/net"
 	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	ma "github.com/multiformats/go-multiaddr"
 )
 
 // VirtualNetwork generates a new testnet instance - a fake network that
@@ -212,6 +213,8 @@ func (nc *networkClient) SetDelegate(r gsnet.Receiver) {
 	nc.Receiver = r
 }
 
+func (nc *networkClient) AddAddrs(peer.ID, []ma.Multiaddr) {}
+
 func (nc *networkClient) ConnectTo(_ context.Context, p peer.ID) error {
 	nc.network.mu.Lock()
 	otherClient, ok := nc.network.clients[p]
@@ -0,0 +1,178 @@
+package byterange
+
+import (
+	"context"
+	"errors"
+
+	ipld "github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/fluent"
+	basicnode "github.com/ipld/go-ipld-prime/node/basic"
+	"github.com/ipld/go-ipld-prime/traversal/selector"
+	"github.com/ipld/go-ipld-prime/traversal/selector/builder"
+	"github.com/libp2p/go-libp2p-core/peer"
+
+	"github.com/ipfs/go-graphsync"
+	"github.com/ipfs/go-graphsync/ipldutil"
+)
+
+// EncodeByteRange returns encoded cbor data for the bytes [start, end) of a
+// UnixFS file, for the byte range extension
+func EncodeByteRange(start uint64, end uint64) ([]byte, error) {
+	if end < start {
+		return nil, errors.New("byte range ends before it starts")
+	}
+	node, err := fluent.Build(basicnode.Style.Map, func(na fluent.NodeAssembler) {
+		na.CreateMap(2, func(na fluent.MapAssembler) {
+			na.AssembleEntry("start").AssignInt(int(start))
+			na.AssembleEntry("end").AssignInt(int(end))
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ipldutil.EncodeNode(node)
+}
+
+// DecodeByteRange returns the start and end of a byte range decoded from data
+// for the byte range extension
+func DecodeByteRange(data []byte) (uint64, uint64, error) {
+	node, err := ipldutil.DecodeNode(data)
+	if err != nil {
+		return 0, 0, err
+	}
+	startNode, err := node.LookupString("start")
+	if err != nil {
+		return 0, 0, err
+	}
+	start, err := startNode.AsInt()
+	if err != nil {
+		return 0, 0, err
+	}
+	endNode, err := node.LookupString("end")
+	if err != nil {
+		return 0, 0, err
+	}
+	end, err := endNode.AsInt()
+	if err != nil {
+		return 0, 0, err
+	}
+	if start < 0 || end < start {
+		return 0, 0, errors.New("invalid byte range")
+	}
+	return uint64(start), uint64(end), nil
+}
+
+// RangeForRequest returns a ByteRange limiting the traversal for a request to
+// the byte range it asks for, or nil if the request has no byte range
+// extension
+func RangeForRequest(request graphsync.RequestData) (*ipldutil.ByteRange, error) {
+	data, has := request.Extension(graphsync.ExtensionByteRange)
+	if !has {
+		return nil, nil
+	}
+	start, end, err := DecodeByteRange(data)
+	if err != nil {
+		return nil, err
+	}
+	return ipldutil.NewByteRange(start, end), nil
+}
+
+// FileSelector returns a selector for every node in a UnixFS file, to send
+// with the byte range extension
+func FileSelector() ipld.Node {
+	ssb := builder.NewSelectorSpecBuilder(basicnode.Style.Any)
+	return ssb.ExploreRecursive(selector.RecursionLimitNone(), ssb.ExploreAll(ssb.ExploreRecursiveEdge())).Node()
+}
+
+// Request requests the bytes [start, end) of the UnixFS file at the given root
+// from the given peer. It returns the bytes received, which stop short of end
+// when the file does, along with the size of the whole file
+func Request(ctx context.Context,
+	exchange graphsync.GraphExchange,
+	p peer.ID,
+	root ipld.Link,
+	start uint64,
+	end uint64,
+	extensions ...graphsync.ExtensionData) ([]byte, uint64, error) {
+	rangeData, err := EncodeByteRange(start, end)
+	if err != nil {
+		return nil, 0, err
+	}
+	requestExtensions := make([]graphsync.ExtensionData, 0, len(extensions)+1)
+	requestExtensions = append(requestExtensions, extensions...)
+	requestExtensions = append(requestExtensions, graphsync.ExtensionData{
+		Name: graphsync.ExtensionByteRange,
+		Data: rangeData,
+	})
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	responses, errs := exchange.Request(ctx, p, root, FileSelector(), requestExtensions...)
+
+	byteRange := ipldutil.NewByteRange(start, end)
+	var data []byte
+	var firstErr error
+	for responses != nil || errs != nil {
+		select {
+		case response, ok := <-responses:
+			if !ok {
+				responses = nil
+				continue
+			}
+			if firstErr != nil {
+				continue
+			}
+			nodeData, offset, err := byteRange.Visit(response.Path, response.Node)
+			if err != nil {
+				firstErr = err
+				cancel()
+				continue
+			}
+			if response.Path.String() == "" {
+				data = make([]byte, rangeLength(start, end, byteRange.FileSize()))
+			}
+			copyOverlap(data, start, nodeData, offset)
+		case err, ok := <-errs:
+			if !ok {
+				errs = nil
+				continue
+			}
+			if firstErr == nil {
+				firstErr = err
+				cancel()
+			}
+		}
+	}
+	if firstErr != nil {
+		return nil, 0, firstErr
+	}
+	return data, byteRange.FileSize(), nil
+}
+
+// rangeLength is the number of bytes in [start, end) that lie within a file
+// of the given size
+func rangeLength(start uint64, end uint64, fileSize uint64) uint64 {
+	if end > fileSize {
+		end = fileSize
+	}
+	if start >= end {
+		return 0
+	}
+	return end - start
+}
+
+// copyOverlap copies the part of nodeData, which sits at the given offset in
+// the file, that overlaps the range starting at start into data
+func copyOverlap(data []byte, start uint64, nodeData []byte, offset uint64) {
+	low := offset
+	if low < start {
+		low = start
+	}
+	high := offset + uint64(len(nodeData))
+	if rangeEnd := start + uint64(len(data)); high > rangeEnd {
+		high = rangeEnd
+	}
+	if low >= high {
+		return
+	}
+	copy(data[low-start:high-start], nodeData[low-offset:high-offset])
+}
@@ -0,0 +1,36 @@
+package byterange
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodeEncodeByteRange(t *testing.T) {
+	encoded, err := EncodeByteRange(1000, 5000)
+	require.NoError(t, err, "encode errored")
+	start, end, err := DecodeByteRange(encoded)
+	require.NoError(t, err, "decode errored")
+	require.Equal(t, uint64(1000), start)
+	require.Equal(t, uint64(5000), end)
+
+	_, err = EncodeByteRange(5000, 1000)
+	require.Error(t, err, "should not encode a range that ends before it starts")
+}
+
+func TestCopyOverlap(t *testing.T) {
+	data := make([]byte, 4)
+	copyOverlap(data, 10, []byte("abcdef"), 8)
+	require.Equal(t, []byte("cdef"), data)
+
+	data = make([]byte, 4)
+	copyOverlap(data, 10, []byte("xy"), 13)
+	require.Equal(t, []byte{0, 0, 0, 'x'}, data)
+
+	data = make([]byte, 4)
+	copyOverlap(data, 10, []byte("xy"), 20)
+	require.Equal(t, make([]byte, 4), data)
+
+	require.Equal(t, uint64(5), rangeLength(10, 20, 15))
+	require.Equal(t, uint64(0), rangeLength(20, 30, 15))
+}
@@ -0,0 +1,120 @@
+package byterange
+
+import (
+	"context"
+	"errors"
+	"io"
+
+	ipld "github.com/ipld/go-ipld-prime"
+	"github.com/libp2p/go-libp2p-core/peer"
+
+	"github.com/ipfs/go-graphsync"
+)
+
+// DefaultReadAhead is the number of bytes a Reader requests at a time
+const DefaultReadAhead = 1 << 20
+
+// Reader is an io.ReadSeeker over a UnixFS file on another peer. It requests
+// the bytes it reads with byte range requests, so seeking in the file only
+// transfers the blocks around the new position
+type Reader struct {
+	ctx        context.Context
+	exchange   graphsync.GraphExchange
+	p          peer.ID
+	root       ipld.Link
+	extensions []graphsync.ExtensionData
+	readAhead  uint64
+
+	offset    int64
+	fileSize  uint64
+	sizeKnown bool
+	buffer    []byte
+	bufferAt  uint64
+}
+
+// NewReader returns a Reader over the UnixFS file at the given root on the
+// given peer. The given extensions are sent with every request the reader makes
+func NewReader(ctx context.Context,
+	exchange graphsync.GraphExchange,
+	p peer.ID,
+	root ipld.Link,
+	extensions ...graphsync.ExtensionData) *Reader {
+	return &Reader{
+		ctx:        ctx,
+		exchange:   exchange,
+		p:          p,
+		root:       root,
+		extensions: extensions,
+		readAhead:  DefaultReadAhead,
+	}
+}
+
+// SetReadAhead sets the number of bytes the reader requests at a time
+func (r *Reader) SetReadAhead(readAhead uint64) {
+	if readAhead > 0 {
+		r.readAhead = readAhead
+	}
+}
+
+// Read reads from the current position in the file, requesting the bytes
+// from the current position on if they are not already buffered
+func (r *Reader) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	offset := uint64(r.offset)
+	if r.sizeKnown && offset >= r.fileSize {
+		return 0, io.EOF
+	}
+	if offset < r.bufferAt || offset >= r.bufferAt+uint64(len(r.buffer)) {
+		err := r.fetch(offset, offset+r.readAhead)
+		if err != nil {
+			return 0, err
+		}
+		if len(r.buffer) == 0 {
+			return 0, io.EOF
+		}
+	}
+	n := copy(p, r.buffer[offset-r.bufferAt:])
+	r.offset += int64(n)
+	return n, nil
+}
+
+// Seek sets the position for the next Read. Seeking relative to the end of
+// the file requests the size of the file if it is not yet known
+func (r *Reader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		if !r.sizeKnown {
+			err := r.fetch(0, 0)
+			if err != nil {
+				return r.offset, err
+			}
+		}
+		offset += int64(r.fileSize)
+	default:
+		return r.offset, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return r.offset, errors.New("negative position")
+	}
+	r.offset = offset
+	return r.offset, nil
+}
+
+func (r *Reader) fetch(start uint64, end uint64) error {
+	data, fileSize, err := Request(r.ctx, r.exchange, r.p, r.root, start, end, r.extensions...)
+	if err != nil {
+		return err
+	}
+	r.fileSize = fileSize
+	r.sizeKnown = true
+	if end > start {
+		r.buffer = data
+		r.bufferAt = start
+	}
+	return nil
+}
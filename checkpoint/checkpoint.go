@@ -0,0 +1,132 @@
+package checkpoint
+
+import (
+	ipld "github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/fluent"
+	basicnode "github.com/ipld/go-ipld-prime/node/basic"
+	"github.com/libp2p/go-libp2p-core/peer"
+
+	"github.com/ipfs/go-graphsync"
+	"github.com/ipfs/go-graphsync/cidset"
+	"github.com/ipfs/go-graphsync/ipldutil"
+)
+
+// EncodeCheckpoint encodes a request checkpoint to an IPLD node then serializes
+// it to raw bytes
+func EncodeCheckpoint(checkpoint graphsync.Checkpoint) ([]byte, error) {
+	selectorData, err := ipldutil.EncodeNode(checkpoint.Selector)
+	if err != nil {
+		return nil, err
+	}
+	verifiedCidsData, err := cidset.EncodeCidSet(checkpoint.VerifiedCids)
+	if err != nil {
+		return nil, err
+	}
+	node, err := fluent.Build(basicnode.Style.Map, func(na fluent.NodeAssembler) {
+		na.CreateMap(6, func(na fluent.MapAssembler) {
+			na.AssembleEntry("id").AssignString(checkpoint.ID)
+			na.AssembleEntry("root").AssignLink(checkpoint.Root)
+			na.AssembleEntry("selector").AssignBytes(selectorData)
+			na.AssembleEntry("peer").AssignBytes([]byte(checkpoint.Peer))
+			na.AssembleEntry("extensions").CreateList(len(checkpoint.Extensions), func(na fluent.ListAssembler) {
+				for _, extension := range checkpoint.Extensions {
+					na.AssembleValue().CreateMap(2, func(na fluent.MapAssembler) {
+						na.AssembleEntry("name").AssignString(string(extension.Name))
+						na.AssembleEntry("data").AssignBytes(extension.Data)
+					})
+				}
+			})
+			na.AssembleEntry("verifiedCids").AssignBytes(verifiedCidsData)
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ipldutil.EncodeNode(node)
+}
+
+// DecodeCheckpoint assembles a request checkpoint from a raw byte array, first
+// deserializing as a node and then assembling into a checkpoint struct
+func DecodeCheckpoint(data []byte) (graphsync.Checkpoint, error) {
+	node, err := ipldutil.DecodeNode(data)
+	if err != nil {
+		return graphsync.Checkpoint{}, err
+	}
+	idNode, err := node.LookupString("id")
+	if err != nil {
+		return graphsync.Checkpoint{}, err
+	}
+	id, err := idNode.AsString()
+	if err != nil {
+		return graphsync.Checkpoint{}, err
+	}
+	rootNode, err := node.LookupString("root")
+	if err != nil {
+		return graphsync.Checkpoint{}, err
+	}
+	root, err := rootNode.AsLink()
+	if err != nil {
+		return graphsync.Checkpoint{}, err
+	}
+	selectorData, err := lookupBytes(node, "selector")
+	if err != nil {
+		return graphsync.Checkpoint{}, err
+	}
+	selector, err := ipldutil.DecodeNode(selectorData)
+	if err != nil {
+		return graphsync.Checkpoint{}, err
+	}
+	peerData, err := lookupBytes(node, "peer")
+	if err != nil {
+		return graphsync.Checkpoint{}, err
+	}
+	extensionsNode, err := node.LookupString("extensions")
+	if err != nil {
+		return graphsync.Checkpoint{}, err
+	}
+	var extensions []graphsync.ExtensionData
+	iterator := extensionsNode.ListIterator()
+	for !iterator.Done() {
+		_, item, err := iterator.Next()
+		if err != nil {
+			return graphsync.Checkpoint{}, err
+		}
+		nameNode, err := item.LookupString("name")
+		if err != nil {
+			return graphsync.Checkpoint{}, err
+		}
+		name, err := nameNode.AsString()
+		if err != nil {
+			return graphsync.Checkpoint{}, err
+		}
+		data, err := lookupBytes(item, "data")
+		if err != nil {
+			return graphsync.Checkpoint{}, err
+		}
+		extensions = append(extensions, graphsync.ExtensionData{Name: graphsync.ExtensionName(name), Data: data})
+	}
+	verifiedCidsData, err := lookupBytes(node, "verifiedCids")
+	if err != nil {
+		return graphsync.Checkpoint{}, err
+	}
+	verifiedCids, err := cidset.DecodeCidSet(verifiedCidsData)
+	if err != nil {
+		return graphsync.Checkpoint{}, err
+	}
+	return graphsync.Checkpoint{
+		ID:           id,
+		Root:         root,
+		Selector:     selector,
+		Peer:         peer.ID(peerData),
+		Extensions:   extensions,
+		VerifiedCids: verifiedCids,
+	}, nil
+}
+
+func lookupBytes(node ipld.Node, key string) ([]byte, error) {
+	valueNode, err := node.LookupString(key)
+	if err != nil {
+		return nil, err
+	}
+	return valueNode.AsBytes()
+}
@@ -0,0 +1,96 @@
+package checkpoint
+
+import (
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	dss "github.com/ipfs/go-datastore/sync"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	basicnode "github.com/ipld/go-ipld-prime/node/basic"
+	"github.com/ipld/go-ipld-prime/traversal/selector"
+	"github.com/ipld/go-ipld-prime/traversal/selector/builder"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ipfs/go-graphsync"
+	"github.com/ipfs/go-graphsync/ipldutil"
+	"github.com/ipfs/go-graphsync/testutil"
+)
+
+func newTestCheckpoint() graphsync.Checkpoint {
+	ssb := builder.NewSelectorSpecBuilder(basicnode.Style.Any)
+	verifiedCids := cid.NewSet()
+	for _, c := range testutil.GenerateCids(10) {
+		verifiedCids.Add(c)
+	}
+	return graphsync.Checkpoint{
+		ID:       "applesauce",
+		Root:     cidlink.Link{Cid: testutil.GenerateCids(1)[0]},
+		Selector: ssb.ExploreRecursive(selector.RecursionLimitDepth(5), ssb.ExploreAll(ssb.ExploreRecursiveEdge())).Node(),
+		Peer:     testutil.GeneratePeers(1)[0],
+		Extensions: []graphsync.ExtensionData{
+			{
+				Name: graphsync.ExtensionName("AppleSauce/McGee"),
+				Data: testutil.RandomBytes(100),
+			},
+		},
+		VerifiedCids: verifiedCids,
+	}
+}
+
+func requireCheckpointsEqual(t *testing.T, expected graphsync.Checkpoint, actual graphsync.Checkpoint) {
+	require.Equal(t, expected.ID, actual.ID)
+	require.Equal(t, expected.Root, actual.Root)
+	require.Equal(t, expected.Peer, actual.Peer)
+	require.Equal(t, expected.Extensions, actual.Extensions)
+	expectedSelector, err := ipldutil.EncodeNode(expected.Selector)
+	require.NoError(t, err)
+	actualSelector, err := ipldutil.EncodeNode(actual.Selector)
+	require.NoError(t, err)
+	require.Equal(t, expectedSelector, actualSelector)
+	require.Equal(t, expected.VerifiedCids.Len(), actual.VerifiedCids.Len())
+	err = expected.VerifiedCids.ForEach(func(c cid.Cid) error {
+		require.True(t, actual.VerifiedCids.Has(c))
+		return nil
+	})
+	require.NoError(t, err)
+}
+
+func TestDecodeEncodeCheckpoint(t *testing.T) {
+	checkpoint := newTestCheckpoint()
+	encoded, err := EncodeCheckpoint(checkpoint)
+	require.NoError(t, err, "encode errored")
+	decoded, err := DecodeCheckpoint(encoded)
+	require.NoError(t, err, "decode errored")
+	requireCheckpointsEqual(t, checkpoint, decoded)
+}
+
+func TestDatastoreStore(t *testing.T) {
+	ds := dss.MutexWrap(datastore.NewMapDatastore())
+	store := NewDatastoreStore(ds)
+	checkpoint := newTestCheckpoint()
+
+	_, err := store.Get(checkpoint.ID)
+	require.Equal(t, datastore.ErrNotFound, err)
+
+	require.NoError(t, store.Put(checkpoint))
+	stored, err := store.Get(checkpoint.ID)
+	require.NoError(t, err)
+	requireCheckpointsEqual(t, checkpoint, stored)
+	has, err := ds.Has(datastore.NewKey("/graphsync/checkpoints").ChildString(checkpoint.ID))
+	require.NoError(t, err)
+	require.True(t, has, "should store checkpoints under their own prefix")
+	has, err = ds.Has(datastore.NewKey(checkpoint.ID))
+	require.NoError(t, err)
+	require.False(t, has)
+
+	checkpoint.VerifiedCids.Add(testutil.GenerateCids(1)[0])
+	require.NoError(t, store.Put(checkpoint))
+	stored, err = store.Get(checkpoint.ID)
+	require.NoError(t, err)
+	requireCheckpointsEqual(t, checkpoint, stored)
+
+	require.NoError(t, store.Delete(checkpoint.ID))
+	_, err = store.Get(checkpoint.ID)
+	require.Equal(t, datastore.ErrNotFound, err)
+}
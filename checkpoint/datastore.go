@@ -0,0 +1,50 @@
+package checkpoint
+
+import (
+	"github.com/ipfs/go-datastore"
+
+	"github.com/ipfs/go-graphsync"
+)
+
+// keyPrefix keeps checkpoints apart from anything else in a shared datastore
+var keyPrefix = datastore.NewKey("/graphsync/checkpoints")
+
+// DatastoreStore is a graphsync.CheckpointStore that persists checkpoints in
+// a go-datastore, keyed by checkpoint ID under its own prefix
+type DatastoreStore struct {
+	ds datastore.Datastore
+}
+
+var _ graphsync.CheckpointStore = (*DatastoreStore)(nil)
+
+// NewDatastoreStore returns a new checkpoint store backed by the given datastore
+func NewDatastoreStore(ds datastore.Datastore) *DatastoreStore {
+	return &DatastoreStore{ds}
+}
+
+func key(id string) datastore.Key {
+	return keyPrefix.ChildString(id)
+}
+
+// Put records a checkpoint, replacing any previous checkpoint with the same ID
+func (dss *DatastoreStore) Put(checkpoint graphsync.Checkpoint) error {
+	data, err := EncodeCheckpoint(checkpoint)
+	if err != nil {
+		return err
+	}
+	return dss.ds.Put(key(checkpoint.ID), data)
+}
+
+// Get returns the checkpoint with the given ID
+func (dss *DatastoreStore) Get(id string) (graphsync.Checkpoint, error) {
+	data, err := dss.ds.Get(key(id))
+	if err != nil {
+		return graphsync.Checkpoint{}, err
+	}
+	return DecodeCheckpoint(data)
+}
+
+// Delete removes the checkpoint with the given ID
+func (dss *DatastoreStore) Delete(id string) error {
+	return dss.ds.Delete(key(id))
+}
@@ -0,0 +1,38 @@
+package deadline
+
+import (
+	"errors"
+	"time"
+
+	basicnode "github.com/ipld/go-ipld-prime/node/basic"
+
+	"github.com/ipfs/go-graphsync/ipldutil"
+)
+
+// EncodeDeadline returns encoded cbor data for a request deadline, in
+// milliseconds since the unix epoch
+func EncodeDeadline(deadline time.Time) ([]byte, error) {
+	nb := basicnode.Style.Int.NewBuilder()
+	err := nb.AssignInt(int(deadline.UnixNano() / int64(time.Millisecond)))
+	if err != nil {
+		return nil, err
+	}
+	nd := nb.Build()
+	return ipldutil.EncodeNode(nd)
+}
+
+// DecodeDeadline returns a request deadline decoded from cbor data
+func DecodeDeadline(data []byte) (time.Time, error) {
+	nd, err := ipldutil.DecodeNode(data)
+	if err != nil {
+		return time.Time{}, err
+	}
+	milliseconds, err := nd.AsInt()
+	if err != nil {
+		return time.Time{}, err
+	}
+	if milliseconds < 0 {
+		return time.Time{}, errors.New("negative deadline")
+	}
+	return time.Unix(0, int64(milliseconds)*int64(time.Millisecond)), nil
+}
@@ -0,0 +1,22 @@
+package deadline
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodeEncodeDeadline(t *testing.T) {
+	deadline := time.Now().Add(1500 * time.Millisecond).Truncate(time.Millisecond)
+	encoded, err := EncodeDeadline(deadline)
+	require.NoError(t, err, "encode errored")
+	decoded, err := DecodeDeadline(encoded)
+	require.NoError(t, err, "decode errored")
+	require.True(t, deadline.Equal(decoded))
+
+	encoded, err = EncodeDeadline(time.Unix(0, 0).Add(-time.Second))
+	require.NoError(t, err, "encode errored")
+	_, err = DecodeDeadline(encoded)
+	require.Error(t, err, "should not decode deadlines before the epoch")
+}
@@ -43,4 +43,5 @@ require (
 	github.com/whyrusleeping/cbor-gen v0.0.0-20200402171437-3d27c146c105 // indirect
 	go.uber.org/multierr v1.4.0 // indirect
 	golang.org/x/tools v0.0.0-20200827010519-17fd2f27a9e3 // indirect
+	gopkg.in/yaml.v2 v2.2.4
 )
@@ -46,6 +46,64 @@ const (
 	// for requests that have the same key. The data for the extension is a string key
 	ExtensionDeDupByKey = ExtensionName("graphsync/dedup-by-key")
 
+	// ExtensionRetryAfter is sent by a responder that rejects a request with
+	// RequestFailedBusy, to tell the requestor how long to wait before trying
+	// again. The data for the extension is a number of milliseconds
+	ExtensionRetryAfter = ExtensionName("graphsync/retry-after")
+
+	// ExtensionUpdatePriority is sent with an update to an in progress request to
+	// change its priority on the responder. The data for the extension is the new
+	// priority
+	ExtensionUpdatePriority = ExtensionName("graphsync/update-priority")
+
+	// ExtensionRedirect is sent by a responder with an AdditionalPeers response,
+	// to tell the requestor which peers to send the request to instead. The data
+	// for the extension is a list of peer IDs and their addresses
+	ExtensionRedirect = ExtensionName("graphsync/redirect")
+
+	// ExtensionPaymentRequest is sent by a responder with a NotEnoughGas response,
+	// to tell the requestor how much it must pay for the response to continue.
+	// The data for the extension is the amount owed
+	ExtensionPaymentRequest = ExtensionName("graphsync/payment-request")
+
+	// ExtensionPayment is sent by a requestor with an update to a request paused
+	// with NotEnoughGas, to pay for the response to continue. The data for the
+	// extension is a voucher for the total amount paid for the request
+	ExtensionPayment = ExtensionName("graphsync/payment")
+
+	// ExtensionMetadataOnly asks the responding peer to traverse the selector and
+	// send metadata for every link traversed, including block sizes, without
+	// sending any blocks
+	ExtensionMetadataOnly = ExtensionName("graphsync/metadata-only")
+
+	// ExtensionByteRange limits a request for a UnixFS file to the blocks that
+	// hold the bytes [start, end) of the file, as found from the block sizes of
+	// the file's nodes. The data for the extension is the start and end offsets
+	ExtensionByteRange = ExtensionName("graphsync/byte-range")
+
+	// ExtensionDeadline tells the responding peer when the requestor will stop
+	// waiting for a response, so it can stop responding once that time passes.
+	// The data for the extension is the deadline in milliseconds since the unix
+	// epoch
+	ExtensionDeadline = ExtensionName("graphsync/deadline")
+
+	// ExtensionAuth carries a token authorizing a requestor to make a request,
+	// signed over the requestor's peer ID, the request root and selector, and
+	// the time the token expires
+	ExtensionAuth = ExtensionName("graphsync/auth")
+
+	// ExtensionRejectReason is sent by a responder that rejects a request with
+	// RequestRejected or RequestFailedLegal, to tell the requestor why. The data
+	// for the extension is a string reason
+	ExtensionRejectReason = ExtensionName("graphsync/reject-reason")
+
+	// ExtensionPartition tells the responding peer to only send the blocks in
+	// one of several disjoint shares of the traversal, for requests sent to
+	// several peers at once. Blocks outside the share are listed in metadata as
+	// present but not sent. The data for the extension is the index of the
+	// share and the number of shares
+	ExtensionPartition = ExtensionName("graphsync/partition")
+
 	// GraphSync Response Status Codes
 
 	// Informational Response Codes (partial)
@@ -94,6 +152,13 @@ const (
 	RequestCancelled = ResponseStatusCode(35)
 )
 
+// RequestRejectedErr is an error message received on the error channel when the peer rejects the request
+type RequestRejectedErr struct{}
+
+func (e RequestRejectedErr) Error() string {
+	return "Request Failed - Rejected"
+}
+
 // RequestFailedBusyErr is an error message received on the error channel when the peer is busy
 type RequestFailedBusyErr struct{}
 
@@ -129,6 +194,33 @@ func (e RequestCancelledErr) Error() string {
 	return "Request Failed - Responder Cancelled"
 }
 
+// RequestFailedPeerDisconnectedErr is an error message received on the error channel when the peer
+// disconnects before finishing the request
+type RequestFailedPeerDisconnectedErr struct{}
+
+func (e RequestFailedPeerDisconnectedErr) Error() string {
+	return "Request Failed - Peer Disconnected"
+}
+
+// RequestStalledErr is an error message received on the error channel when no new blocks are
+// received for a request within its stall timeout
+type RequestStalledErr struct{}
+
+func (e RequestStalledErr) Error() string {
+	return "Request Failed - Stalled Waiting For Blocks"
+}
+
+// RequestRedirectedErr is an error message received on the error channel when
+// the responder redirected the request to other peers, and the request did not
+// follow the redirect
+type RequestRedirectedErr struct {
+	Peers []peer.AddrInfo
+}
+
+func (e RequestRedirectedErr) Error() string {
+	return "Request Failed - Redirected To Other Peers"
+}
+
 var (
 	// ErrExtensionAlreadyRegistered means a user extension can be registered only once
 	ErrExtensionAlreadyRegistered = errors.New("extension already registered")
@@ -144,6 +236,14 @@ type ResponseProgress struct {
 	}
 }
 
+// LinkMetadata describes a link a responder traversed for a metadata only
+// request, and the block it has for the link, if any
+type LinkMetadata struct {
+	Link         ipld.Link
+	BlockPresent bool
+	BlockSize    uint64
+}
+
 // RequestData describes a received graphsync request.
 type RequestData interface {
 	// ID Returns the request ID for this Request
@@ -200,6 +300,20 @@ type IncomingRequestHookActions interface {
 	TerminateWithError(error)
 	ValidateRequest()
 	PauseResponse()
+	// SetPeerBandwidthLimit sets the outgoing bandwidth limit for the requesting
+	// peer, in bytes per second, until the response to this request finishes
+	SetPeerBandwidthLimit(bytesPerSecond uint64)
+	// RedirectRequest ends the request with an AdditionalPeers response, telling
+	// the requestor to send it to the given peers instead, unless a hook rejects
+	// the request or terminates it with an error
+	RedirectRequest(peers ...peer.AddrInfo)
+	// RequestPayment pauses the response with a NotEnoughGas response, asking the
+	// requestor to pay the given amount with an update before it continues
+	RequestPayment(amount uint64)
+	// RejectRequest ends the request with the given status, RequestRejected or
+	// RequestFailedLegal, telling the requestor the reason with the reject reason
+	// extension
+	RejectRequest(status ResponseStatusCode, reason string)
 }
 
 // OutgoingBlockHookActions are actions that an outgoing block hook can take to
@@ -208,6 +322,9 @@ type OutgoingBlockHookActions interface {
 	SendExtensionData(ExtensionData)
 	TerminateWithError(error)
 	PauseResponse()
+	// RequestPayment pauses the response with a NotEnoughGas response, asking the
+	// requestor to pay the given amount with an update before it continues
+	RequestPayment(amount uint64)
 }
 
 // OutgoingRequestHookActions are actions that an outgoing request hook can take
@@ -282,6 +399,130 @@ type OnResponseCompletedListener func(p peer.ID, request RequestData, status Res
 // OnRequestorCancelledListener provides a way to listen for responses the requestor canncels
 type OnRequestorCancelledListener func(p peer.ID, request RequestData)
 
+// OnRequestCompletedListener provides a way to listen for when an outgoing request
+// finishes, with RequestCompletedFull if it received every block, or the status
+// it failed with. RequestCancelled means it was cancelled by the requestor
+type OnRequestCompletedListener func(p peer.ID, request RequestData, status ResponseStatusCode)
+
+// OnRequestPausedListener provides a way to listen for outgoing requests paused by the responder
+type OnRequestPausedListener func(p peer.ID, request RequestData)
+
+// OnRequestErrorListener provides a way to listen for errors on outgoing requests
+type OnRequestErrorListener func(p peer.ID, request RequestData, err error)
+
+// Checkpoint records the progress of a request so it can be resumed later,
+// even from a new process
+type Checkpoint struct {
+	ID           string
+	Root         ipld.Link
+	Selector     ipld.Node
+	Peer         peer.ID
+	Extensions   []ExtensionData
+	VerifiedCids *cid.Set
+}
+
+// CheckpointStore durably stores request checkpoints
+type CheckpointStore interface {
+	Put(checkpoint Checkpoint) error
+	Get(id string) (Checkpoint, error)
+	Delete(id string) error
+}
+
+// PausedResponse records a response paused on the responder, with the links
+// already sent, so it can be continued after the responder restarts
+type PausedResponse struct {
+	Peer       peer.ID
+	RequestID  RequestID
+	Root       cid.Cid
+	Selector   ipld.Node
+	Priority   Priority
+	Extensions []ExtensionData
+	SentLinks  []ipld.Link
+}
+
+// PausedResponseStore durably stores paused responses
+type PausedResponseStore interface {
+	Put(pausedResponse PausedResponse) error
+	Delete(p peer.ID, requestID RequestID) error
+	List() ([]PausedResponse, error)
+}
+
+// RequestStatus is the state of a request made with a RequestHandle
+type RequestStatus int
+
+const (
+	// RequestStatusRunning means the request is in progress
+	RequestStatusRunning RequestStatus = iota
+	// RequestStatusPaused means the request is paused and can be unpaused
+	RequestStatusPaused
+	// RequestStatusCompleted means the request finished without errors
+	RequestStatusCompleted
+	// RequestStatusFailed means the request finished with one or more errors
+	RequestStatusFailed
+	// RequestStatusCancelled means the request was cancelled by the caller
+	RequestStatusCancelled
+	// RequestStatusQueued means the request is waiting for other requests to
+	// finish before it is sent
+	RequestStatusQueued
+)
+
+// RequestStats reports the progress of a request made with a RequestHandle
+type RequestStats struct {
+	// BlocksReceived is the number of blocks loaded for the traversal
+	BlocksReceived uint64
+	// BytesReceived is the total size of blocks loaded for the traversal
+	BytesReceived uint64
+	// BytesOnWire is the total size of blocks received from the network for
+	// the request, including duplicates
+	BytesOnWire uint64
+	// LocalBlocks is the number of blocks loaded from the local store
+	LocalBlocks uint64
+	// RemoteBlocks is the number of blocks loaded from the network
+	RemoteBlocks uint64
+	// DuplicateBlocks is the number of blocks received from the network for
+	// the request more than once
+	DuplicateBlocks uint64
+	// Redirects are the peers responders redirected the request to, in the
+	// order it was sent to them
+	Redirects []peer.ID
+}
+
+// UnverifiedBlockStats reports the current size of the stores holding blocks
+// received but not yet verified as part of a traversal
+type UnverifiedBlockStats struct {
+	// MemoryBytes is the total size of blocks held in memory
+	MemoryBytes uint64
+	// SpilledBytes is the total size of blocks held in the spill store
+	SpilledBytes uint64
+	// DroppedBlocks is the number of blocks discarded because the memory budget
+	// was exhausted
+	DroppedBlocks uint64
+}
+
+// RequestHandle is an in progress request, with methods to follow and control it
+type RequestHandle interface {
+	// ID is the ID of the request. Requests shared by identical callers have the same ID
+	ID() RequestID
+	// Peer is the peer the request was sent to
+	Peer() peer.ID
+	// Responses receives progress of the traversal, and closes when it finishes
+	Responses() <-chan ResponseProgress
+	// Errors receives any errors for the request, and closes when it finishes
+	Errors() <-chan error
+	// Status returns the current state of the request
+	Status() RequestStatus
+	// Stats returns the blocks and bytes received for the request so far
+	Stats() RequestStats
+	// Pause pauses the request (may take 1 or more blocks to process)
+	Pause() error
+	// Unpause unpauses the request, sending the given extensions with it
+	Unpause(...ExtensionData) error
+	// Cancel cancels the request
+	Cancel()
+	// Update sends the given extensions to the peer for the in progress request
+	Update(...ExtensionData) error
+}
+
 // UnregisterHookFunc is a function call to unregister a hook that was previously registered
 type UnregisterHookFunc func()
 
@@ -290,7 +531,32 @@ type GraphExchange interface {
 	// Request initiates a new GraphSync request to the given peer using the given selector spec.
 	Request(ctx context.Context, p peer.ID, root ipld.Link, selector ipld.Node, extensions ...ExtensionData) (<-chan ResponseProgress, <-chan error)
 
-	// RegisterPersistenceOption registers an alternate loader/storer combo that can be substituted for the default
+	// RequestWithOptions initiates a new GraphSync request to the given peer using the given selector spec,
+	// configured with the given options (extensions, fallback peers, etc)
+	RequestWithOptions(ctx context.Context, p peer.ID, root ipld.Link, selector ipld.Node, options ...RequestOption) (<-chan ResponseProgress, <-chan error)
+
+	// RequestMulti initiates a new GraphSync request for the given selector spec to several peers at once.
+	// Each peer is asked for a disjoint partition of the blocks, and when a peer fails or is missing
+	// blocks, its partition is shared out between the remaining peers. Peers that don't support the
+	// partition extension send every block. Requests to several peers don't follow redirects, which
+	// fail the peer that sent them. The request fails only if all peers fail.
+	RequestMulti(ctx context.Context, peers []peer.ID, root ipld.Link, selector ipld.Node, extensions ...ExtensionData) (<-chan ResponseProgress, <-chan error)
+
+	// RequestWithHandle initiates a new GraphSync request to the given peer using the given selector spec,
+	// configured with the given options, returning a handle to follow and control the request
+	RequestWithHandle(ctx context.Context, p peer.ID, root ipld.Link, selector ipld.Node, options ...RequestOption) RequestHandle
+
+	// ResumeRequest resumes a request from the checkpoint with the given ID, so that
+	// blocks already received are not sent again
+	ResumeRequest(ctx context.Context, checkpointID string) (<-chan ResponseProgress, <-chan error)
+
+	// RequestMetadata asks the given peer which links the given selector spec
+	// traverses, whether it has their blocks and how large they are, without
+	// transferring any blocks
+	RequestMetadata(ctx context.Context, p peer.ID, root ipld.Link, selector ipld.Node, extensions ...ExtensionData) ([]LinkMetadata, error)
+
+	// RegisterPersistenceOption registers an alternate loader/storer combo that can be substituted for the default.
+	// Names starting with graphsync/staged/ are reserved for staged requests
 	RegisterPersistenceOption(name string, loader ipld.Loader, storer ipld.Storer) error
 
 	// UnregisterPersistenceOption unregisters an alternate loader/storer combo
@@ -321,13 +587,31 @@ type GraphExchange interface {
 	// responses cancelled by the requestor
 	RegisterRequestorCancelledListener(listener OnRequestorCancelledListener) UnregisterHookFunc
 
+	// RegisterCompletedRequestListener adds a listener on the requestor for completed requests
+	RegisterCompletedRequestListener(listener OnRequestCompletedListener) UnregisterHookFunc
+
+	// RegisterRequestPausedListener adds a listener on the requestor for requests
+	// paused by the responder, including requests paused until they are paid for
+	RegisterRequestPausedListener(listener OnRequestPausedListener) UnregisterHookFunc
+
+	// RegisterRequestErrorListener adds a listener on the requestor for errors on requests
+	RegisterRequestErrorListener(listener OnRequestErrorListener) UnregisterHookFunc
+
 	// UnpauseRequest unpauses a request that was paused in a block hook based request ID
-	// Can also send extensions with unpause
+	// Can also send extensions with unpause. For a request the responder paused
+	// with NotEnoughGas, it sends the extensions, such as a payment, to the responder
 	UnpauseRequest(RequestID, ...ExtensionData) error
 
 	// PauseRequest pauses an in progress request (may take 1 or more blocks to process)
 	PauseRequest(RequestID) error
 
+	// UpdatePriority changes the priority of an in progress request on the peers it was sent to
+	UpdatePriority(RequestID, Priority) error
+
+	// CommitStagedBlocks writes the blocks received so far for a request made with
+	// WithStagedPersistence to the store, and writes further blocks straight through
+	CommitStagedBlocks(RequestID) error
+
 	// UnpauseResponse unpauses a response that was paused in a block hook based on peer ID and request ID
 	// Can also send extensions with unpause
 	UnpauseResponse(peer.ID, RequestID, ...ExtensionData) error
@@ -337,4 +621,20 @@ type GraphExchange interface {
 
 	// CancelResponse cancels an in progress response
 	CancelResponse(peer.ID, RequestID) error
+
+	// SetOutgoingBandwidthLimit sets the limit on bytes per second sent across all peers.
+	// Zero means unlimited
+	SetOutgoingBandwidthLimit(bytesPerSecond uint64)
+
+	// SetOutgoingBandwidthLimitPerPeer sets the limit on bytes per second sent to each peer
+	// that does not have its own limit. Zero means unlimited
+	SetOutgoingBandwidthLimitPerPeer(bytesPerSecond uint64)
+
+	// SetPeerOutgoingBandwidthLimit sets the limit on bytes per second sent to the given peer.
+	// Zero means unlimited
+	SetPeerOutgoingBandwidthLimit(p peer.ID, bytesPerSecond uint64)
+
+	// UnverifiedBlockStats returns the current size of the stores holding blocks
+	// received but not yet verified
+	UnverifiedBlockStats() UnverifiedBlockStats
 }
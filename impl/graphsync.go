@@ -2,6 +2,7 @@ package graphsync
 
 import (
 	"context"
+	"time"
 
 	logging "github.com/ipfs/go-log"
 	"github.com/ipfs/go-peertaskqueue"
@@ -9,12 +10,15 @@ import (
 	"github.com/libp2p/go-libp2p-core/peer"
 
 	"github.com/ipfs/go-graphsync"
+	"github.com/ipfs/go-graphsync/bandwidth"
 	gsmsg "github.com/ipfs/go-graphsync/message"
 	"github.com/ipfs/go-graphsync/messagequeue"
 	gsnet "github.com/ipfs/go-graphsync/network"
 	"github.com/ipfs/go-graphsync/peermanager"
+	"github.com/ipfs/go-graphsync/policy"
 	"github.com/ipfs/go-graphsync/requestmanager"
 	"github.com/ipfs/go-graphsync/requestmanager/asyncloader"
+	"github.com/ipfs/go-graphsync/requestmanager/asyncloader/unverifiedblockstore"
 	requestorhooks "github.com/ipfs/go-graphsync/requestmanager/hooks"
 	"github.com/ipfs/go-graphsync/responsemanager"
 	responderhooks "github.com/ipfs/go-graphsync/responsemanager/hooks"
@@ -47,7 +51,11 @@ type GraphSync struct {
 	incomingResponseHooks       *requestorhooks.IncomingResponseHooks
 	outgoingRequestHooks        *requestorhooks.OutgoingRequestHooks
 	incomingBlockHooks          *requestorhooks.IncomingBlockHooks
+	completedRequestListeners   *requestorhooks.CompletedRequestListeners
+	requestPausedListeners      *requestorhooks.RequestPausedListeners
+	requestErrorListeners       *requestorhooks.RequestErrorListeners
 	persistenceOptions          *persistenceoptions.PersistenceOptions
+	bandwidthLimiter            *bandwidth.Limiter
 	ctx                         context.Context
 	cancel                      context.CancelFunc
 	unregisterDefaultValidator  graphsync.UnregisterHookFunc
@@ -65,21 +73,177 @@ func RejectAllRequestsByDefault() Option {
 	}
 }
 
+// UseRequestPolicy replaces the default request validation with the given
+// policy engine, which can keep being updated while graphsync runs
+func UseRequestPolicy(engine *policy.Engine) Option {
+	return func(gs *GraphSync) {
+		gs.unregisterDefaultValidator()
+		gs.incomingRequestHooks.Register(engine.Hook())
+	}
+}
+
+// UseCheckpointStore sets the store requests made with graphsync.WithCheckpoint
+// record their progress to, and how often they do so
+func UseCheckpointStore(checkpointStore graphsync.CheckpointStore, checkpointInterval time.Duration) Option {
+	return func(gs *GraphSync) {
+		gs.requestManager.SetCheckpointStore(checkpointStore, checkpointInterval)
+	}
+}
+
+// UsePausedResponseStore sets the store paused responses are recorded to, so
+// they can be continued after a restart. Requestors keep requests paused by a
+// responder through a disconnect, for up to the paused disconnect timeout, so a
+// restored response continues once the requestor reconnects and unpauses it
+func UsePausedResponseStore(pausedResponseStore graphsync.PausedResponseStore) Option {
+	return func(gs *GraphSync) {
+		gs.responseManager.SetPausedResponseStore(pausedResponseStore)
+	}
+}
+
+// MaxInProgressResponses sets how many responses the responder processes at
+// once. Values below one are ignored
+func MaxInProgressResponses(maxInProgressResponses int) Option {
+	return func(gs *GraphSync) {
+		err := gs.responseManager.SetMaxInProcessRequests(maxInProgressResponses)
+		if err != nil {
+			log.Warnf("ignoring max in progress responses: %s", err)
+		}
+	}
+}
+
+// MaxQueuedRequestsPerPeer sets how many requests from a single peer can wait to
+// be processed before further requests from that peer fail with RequestFailedBusy
+func MaxQueuedRequestsPerPeer(maxQueuedRequestsPerPeer int) Option {
+	return func(gs *GraphSync) {
+		gs.responseManager.SetMaxQueuedRequestsPerPeer(maxQueuedRequestsPerPeer)
+	}
+}
+
+// MaxTotalQueuedRequests sets how many requests across all peers can wait to be
+// processed before further requests fail with RequestFailedBusy
+func MaxTotalQueuedRequests(maxTotalQueuedRequests int) Option {
+	return func(gs *GraphSync) {
+		gs.responseManager.SetMaxTotalQueuedRequests(maxTotalQueuedRequests)
+	}
+}
+
+// BusyRetryAfter sets how long requestors are told to wait before trying again
+// when their requests fail with RequestFailedBusy
+func BusyRetryAfter(busyRetryAfter time.Duration) Option {
+	return func(gs *GraphSync) {
+		gs.responseManager.SetBusyRetryAfter(busyRetryAfter)
+	}
+}
+
+// MaxInProgressRequests sets how many outgoing requests can be in progress at
+// once. Further requests are queued, highest priority first, until one finishes
+func MaxInProgressRequests(maxInProgressRequests int) Option {
+	return func(gs *GraphSync) {
+		gs.requestManager.SetMaxInProgressRequests(maxInProgressRequests)
+	}
+}
+
+// MaxInProgressRequestsPerPeer sets how many outgoing requests to a single peer
+// can be in progress at once. Further requests to that peer are queued, highest
+// priority first, until one to it finishes
+func MaxInProgressRequestsPerPeer(maxInProgressRequestsPerPeer int) Option {
+	return func(gs *GraphSync) {
+		gs.requestManager.SetMaxInProgressRequestsPerPeer(maxInProgressRequestsPerPeer)
+	}
+}
+
+// PausedDisconnectTimeout sets how long a request paused by a responder that
+// disconnects waits for it to reconnect and continue the request, when the
+// request has no other peers to move to
+func PausedDisconnectTimeout(pausedDisconnectTimeout time.Duration) Option {
+	return func(gs *GraphSync) {
+		gs.requestManager.SetPausedDisconnectTimeout(pausedDisconnectTimeout)
+	}
+}
+
+// CheckOutgoingSelectors makes outgoing requests fail before they are sent if
+// their selectors do not meet the given selector policy
+func CheckOutgoingSelectors(selectorPolicy selectorvalidator.SelectorPolicy) Option {
+	return func(gs *GraphSync) {
+		gs.requestManager.SetSelectorPolicy(selectorPolicy)
+	}
+}
+
+// ResponseQueueThawSpeed sets how often peers frozen in the responder's task
+// queue are thawed
+func ResponseQueueThawSpeed(thawSpeed time.Duration) Option {
+	return func(gs *GraphSync) {
+		gs.responseManager.SetThawSpeed(thawSpeed)
+	}
+}
+
+// MaxOutgoingBandwidth limits the bytes per second sent across all peers
+func MaxOutgoingBandwidth(bytesPerSecond uint64) Option {
+	return func(gs *GraphSync) {
+		gs.bandwidthLimiter.SetGlobalRate(bytesPerSecond)
+	}
+}
+
+// MaxOutgoingBandwidthPerPeer limits the bytes per second sent to each peer,
+// unless a different limit is set for a peer
+func MaxOutgoingBandwidthPerPeer(bytesPerSecond uint64) Option {
+	return func(gs *GraphSync) {
+		gs.bandwidthLimiter.SetDefaultPeerRate(bytesPerSecond)
+	}
+}
+
+// MaxInboundMemory pauses requests while blocks received but not yet processed
+// take up more than the given number of bytes, resuming them once half drained
+func MaxInboundMemory(bytes uint64) Option {
+	return func(gs *GraphSync) {
+		gs.requestManager.SetInboundMemoryBudget(bytes)
+	}
+}
+
+// MaxUnverifiedBlockMemory limits the memory taken up by blocks received but not
+// yet verified. Blocks that do not fit are written to the given spill store, if
+// any, and otherwise kept or dropped according to the exhaustion policy. Either
+// way, requests are paused while over the limit, and resumed once half drained,
+// requesting dropped blocks again. It is independent of MaxInboundMemory, and
+// when both are set, requests are paused at the smaller limit
+func MaxUnverifiedBlockMemory(bytes uint64,
+	exhaustionPolicy unverifiedblockstore.ExhaustionPolicy,
+	spillStore unverifiedblockstore.SpillStore) Option {
+	return func(gs *GraphSync) {
+		gs.asyncLoader.SetUnverifiedBlockBudget(bytes, exhaustionPolicy, spillStore)
+		gs.requestManager.SetUnverifiedBlockBudget(bytes)
+	}
+}
+
+// MaxStagedBlockMemory limits the memory each request made with
+// graphsync.WithStagedPersistence may take up holding blocks before they are
+// committed. Requests that need more fail. It defaults to 256MiB, and zero
+// means no limit
+func MaxStagedBlockMemory(bytes uint64) Option {
+	return func(gs *GraphSync) {
+		gs.asyncLoader.SetMaxStagedBytes(bytes)
+	}
+}
+
 // New creates a new GraphSync Exchange on the given network,
 // and the given link loader+storer.
 func New(parent context.Context, network gsnet.GraphSyncNetwork,
 	loader ipld.Loader, storer ipld.Storer, options ...Option) graphsync.GraphExchange {
 	ctx, cancel := context.WithCancel(parent)
 
+	bandwidthLimiter := bandwidth.NewLimiter(0, 0)
 	createMessageQueue := func(ctx context.Context, p peer.ID) peermanager.PeerQueue {
-		return messagequeue.New(ctx, p, network)
+		return messagequeue.New(ctx, p, network, bandwidthLimiter)
 	}
 	peerManager := peermanager.NewMessageManager(ctx, createMessageQueue)
 	asyncLoader := asyncloader.New(ctx, loader, storer)
 	incomingResponseHooks := requestorhooks.NewResponseHooks()
 	outgoingRequestHooks := requestorhooks.NewRequestHooks()
 	incomingBlockHooks := requestorhooks.NewBlockHooks()
-	requestManager := requestmanager.New(ctx, asyncLoader, outgoingRequestHooks, incomingResponseHooks, incomingBlockHooks)
+	completedRequestListeners := requestorhooks.NewCompletedRequestListeners()
+	requestPausedListeners := requestorhooks.NewRequestPausedListeners()
+	requestErrorListeners := requestorhooks.NewRequestErrorListeners()
+	requestManager := requestmanager.New(ctx, asyncLoader, outgoingRequestHooks, incomingResponseHooks, incomingBlockHooks, completedRequestListeners, requestPausedListeners, requestErrorListeners)
 	peerTaskQueue := peertaskqueue.New()
 	createdResponseQueue := func(ctx context.Context, p peer.ID) peerresponsemanager.PeerResponseSender {
 		return peerresponsemanager.NewResponseSender(ctx, p, peerManager)
@@ -92,7 +256,8 @@ func New(parent context.Context, network gsnet.GraphSyncNetwork,
 	completedResponseListeners := responderhooks.NewCompletedResponseListeners()
 	requestorCancelledListeners := responderhooks.NewRequestorCancelledListeners()
 	responseManager := responsemanager.New(ctx, loader, peerResponseManager, peerTaskQueue, incomingRequestHooks, outgoingBlockHooks, requestUpdatedHooks, completedResponseListeners, requestorCancelledListeners)
-	unregisterDefaultValidator := incomingRequestHooks.Register(selectorvalidator.SelectorValidator(maxRecursionDepth))
+	responseManager.SetBandwidthLimiter(bandwidthLimiter)
+	unregisterDefaultValidator := incomingRequestHooks.Register(policy.NewDefaultEngine(maxRecursionDepth).Hook())
 	graphSync := &GraphSync{
 		network:                     network,
 		loader:                      loader,
@@ -101,6 +266,7 @@ func New(parent context.Context, network gsnet.GraphSyncNetwork,
 		requestManager:              requestManager,
 		peerManager:                 peerManager,
 		persistenceOptions:          persistenceOptions,
+		bandwidthLimiter:            bandwidthLimiter,
 		incomingRequestHooks:        incomingRequestHooks,
 		outgoingBlockHooks:          outgoingBlockHooks,
 		requestUpdatedHooks:         requestUpdatedHooks,
@@ -109,6 +275,9 @@ func New(parent context.Context, network gsnet.GraphSyncNetwork,
 		incomingResponseHooks:       incomingResponseHooks,
 		outgoingRequestHooks:        outgoingRequestHooks,
 		incomingBlockHooks:          incomingBlockHooks,
+		completedRequestListeners:   completedRequestListeners,
+		requestPausedListeners:      requestPausedListeners,
+		requestErrorListeners:       requestErrorListeners,
 		peerTaskQueue:               peerTaskQueue,
 		peerResponseManager:         peerResponseManager,
 		responseManager:             responseManager,
@@ -123,6 +292,7 @@ func New(parent context.Context, network gsnet.GraphSyncNetwork,
 
 	asyncLoader.Startup()
 	requestManager.SetDelegate(peerManager)
+	requestManager.SetAddrBook(network)
 	requestManager.Startup()
 	responseManager.Startup()
 	network.SetDelegate((*graphSyncReceiver)(graphSync))
@@ -134,6 +304,37 @@ func (gs *GraphSync) Request(ctx context.Context, p peer.ID, root ipld.Link, sel
 	return gs.requestManager.SendRequest(ctx, p, root, selector, extensions...)
 }
 
+// ResumeRequest resumes a request from the checkpoint with the given ID, so that
+// blocks already received are not sent again
+func (gs *GraphSync) ResumeRequest(ctx context.Context, checkpointID string) (<-chan graphsync.ResponseProgress, <-chan error) {
+	return gs.requestManager.ResumeRequest(ctx, checkpointID)
+}
+
+// RequestMetadata asks the given peer which links the given selector spec
+// traverses, whether it has their blocks and how large they are, without
+// transferring any blocks
+func (gs *GraphSync) RequestMetadata(ctx context.Context, p peer.ID, root ipld.Link, selector ipld.Node, extensions ...graphsync.ExtensionData) ([]graphsync.LinkMetadata, error) {
+	return gs.requestManager.RequestMetadata(ctx, p, root, selector, extensions...)
+}
+
+// RequestWithOptions initiates a new GraphSync request to the given peer using the given selector spec,
+// configured with the given request options
+func (gs *GraphSync) RequestWithOptions(ctx context.Context, p peer.ID, root ipld.Link, selector ipld.Node, options ...graphsync.RequestOption) (<-chan graphsync.ResponseProgress, <-chan error) {
+	return gs.requestManager.SendRequestWithOptions(ctx, p, root, selector, options...)
+}
+
+// RequestWithHandle initiates a new GraphSync request to the given peer using the given selector spec,
+// configured with the given options, returning a handle to follow and control the request
+func (gs *GraphSync) RequestWithHandle(ctx context.Context, p peer.ID, root ipld.Link, selector ipld.Node, options ...graphsync.RequestOption) graphsync.RequestHandle {
+	return gs.requestManager.SendRequestWithHandle(ctx, p, root, selector, options...)
+}
+
+// RequestMulti initiates a new GraphSync request for a single selector query to several peers,
+// each sending a disjoint partition of the blocks, merged into one response stream
+func (gs *GraphSync) RequestMulti(ctx context.Context, peers []peer.ID, root ipld.Link, selector ipld.Node, extensions ...graphsync.ExtensionData) (<-chan graphsync.ResponseProgress, <-chan error) {
+	return gs.requestManager.SendRequestMulti(ctx, peers, root, selector, extensions...)
+}
+
 // RegisterIncomingRequestHook adds a hook that runs when a request is received
 // If overrideDefaultValidation is set to true, then if the hook does not error,
 // it is considered to have "validated" the request -- and that validation supersedes
@@ -196,6 +397,22 @@ func (gs *GraphSync) RegisterRequestorCancelledListener(listener graphsync.OnReq
 	return gs.requestorCancelledListeners.Register(listener)
 }
 
+// RegisterCompletedRequestListener adds a listener on the requestor for completed requests
+func (gs *GraphSync) RegisterCompletedRequestListener(listener graphsync.OnRequestCompletedListener) graphsync.UnregisterHookFunc {
+	return gs.completedRequestListeners.Register(listener)
+}
+
+// RegisterRequestPausedListener adds a listener on the requestor for requests
+// paused by the responder
+func (gs *GraphSync) RegisterRequestPausedListener(listener graphsync.OnRequestPausedListener) graphsync.UnregisterHookFunc {
+	return gs.requestPausedListeners.Register(listener)
+}
+
+// RegisterRequestErrorListener adds a listener on the requestor for errors on requests
+func (gs *GraphSync) RegisterRequestErrorListener(listener graphsync.OnRequestErrorListener) graphsync.UnregisterHookFunc {
+	return gs.requestErrorListeners.Register(listener)
+}
+
 // UnpauseRequest unpauses a request that was paused in a block hook based request ID
 // Can also send extensions with unpause
 func (gs *GraphSync) UnpauseRequest(requestID graphsync.RequestID, extensions ...graphsync.ExtensionData) error {
@@ -207,6 +424,17 @@ func (gs *GraphSync) PauseRequest(requestID graphsync.RequestID) error {
 	return gs.requestManager.PauseRequest(requestID)
 }
 
+// UpdatePriority changes the priority of an in progress request on the peers it was sent to
+func (gs *GraphSync) UpdatePriority(requestID graphsync.RequestID, priority graphsync.Priority) error {
+	return gs.requestManager.UpdatePriority(requestID, priority)
+}
+
+// CommitStagedBlocks writes the blocks received so far for a request made with
+// WithStagedPersistence to the store, and writes further blocks straight through
+func (gs *GraphSync) CommitStagedBlocks(requestID graphsync.RequestID) error {
+	return gs.requestManager.CommitStagedBlocks(requestID)
+}
+
 // UnpauseResponse unpauses a response that was paused in a block hook based on peer ID and request ID
 func (gs *GraphSync) UnpauseResponse(p peer.ID, requestID graphsync.RequestID, extensions ...graphsync.ExtensionData) error {
 	return gs.responseManager.UnpauseResponse(p, requestID, extensions...)
@@ -222,6 +450,30 @@ func (gs *GraphSync) CancelResponse(p peer.ID, requestID graphsync.RequestID) er
 	return gs.responseManager.CancelResponse(p, requestID)
 }
 
+// SetOutgoingBandwidthLimit sets the limit on bytes per second sent across all peers.
+// Zero means unlimited
+func (gs *GraphSync) SetOutgoingBandwidthLimit(bytesPerSecond uint64) {
+	gs.bandwidthLimiter.SetGlobalRate(bytesPerSecond)
+}
+
+// SetOutgoingBandwidthLimitPerPeer sets the limit on bytes per second sent to each peer
+// that does not have its own limit. Zero means unlimited
+func (gs *GraphSync) SetOutgoingBandwidthLimitPerPeer(bytesPerSecond uint64) {
+	gs.bandwidthLimiter.SetDefaultPeerRate(bytesPerSecond)
+}
+
+// SetPeerOutgoingBandwidthLimit sets the limit on bytes per second sent to the given peer.
+// Zero means unlimited
+func (gs *GraphSync) SetPeerOutgoingBandwidthLimit(p peer.ID, bytesPerSecond uint64) {
+	gs.bandwidthLimiter.SetPeerRate(p, bytesPerSecond)
+}
+
+// UnverifiedBlockStats returns the current size of the stores holding blocks
+// received but not yet verified
+func (gs *GraphSync) UnverifiedBlockStats() graphsync.UnverifiedBlockStats {
+	return gs.asyncLoader.UnverifiedBlockStats()
+}
+
 type graphSyncReceiver GraphSync
 
 func (gsr *graphSyncReceiver) graphSync() *GraphSync {
@@ -258,4 +510,6 @@ func (gsr *graphSyncReceiver) Connected(p peer.ID) {
 func (gsr *graphSyncReceiver) Disconnected(p peer.ID) {
 	gsr.graphSync().peerManager.Disconnected(p)
 	gsr.graphSync().peerResponseManager.Disconnected(p)
+	gsr.graphSync().requestManager.Disconnected(p)
+	gsr.graphSync().bandwidthLimiter.RemovePeer(p)
 }
@@ -11,6 +11,7 @@ import (
 	"math/rand"
 	"os"
 	"path/filepath"
+	"sync"
 	"testing"
 	"time"
 
@@ -40,10 +41,12 @@ import (
 	"github.com/stretchr/testify/require"
 
 	"github.com/ipfs/go-graphsync"
+	"github.com/ipfs/go-graphsync/byterange"
 	"github.com/ipfs/go-graphsync/cidset"
 	"github.com/ipfs/go-graphsync/ipldutil"
 	gsmsg "github.com/ipfs/go-graphsync/message"
 	gsnet "github.com/ipfs/go-graphsync/network"
+	"github.com/ipfs/go-graphsync/payments"
 	"github.com/ipfs/go-graphsync/testutil"
 )
 
@@ -232,6 +235,53 @@ func TestGraphsyncRoundTrip(t *testing.T) {
 	require.Equal(t, graphsync.RequestCompletedFull, finalResponseStatus)
 }
 
+func TestGraphsyncRoundTripMultiPeer(t *testing.T) {
+	// create network
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	td := newGsTestData(ctx, t)
+	host3, err := td.mn.GenPeer()
+	require.NoError(t, err, "error generating host")
+	require.NoError(t, td.mn.LinkAll(), "error linking hosts")
+
+	// initialize graphsync on first node to make requests
+	requestor := td.GraphSyncHost1()
+
+	// setup two responders with the same blocks
+	blockChainLength := 100
+	blockChain := testutil.SetupBlockChain(ctx, t, td.loader2, td.storer2, 100, blockChainLength)
+	responders := []graphsync.GraphExchange{
+		td.GraphSyncHost2(),
+		New(ctx, gsnet.NewFromLibp2pHost(host3), td.loader2, td.storer2),
+	}
+	var blocksSentLk sync.Mutex
+	blocksSent := make([]int, len(responders))
+	for i, responder := range responders {
+		i := i
+		responder.RegisterOutgoingBlockHook(func(p peer.ID, requestData graphsync.RequestData, blockData graphsync.BlockData, hookActions graphsync.OutgoingBlockHookActions) {
+			if blockData.BlockSizeOnWire() > 0 {
+				blocksSentLk.Lock()
+				blocksSent[i]++
+				blocksSentLk.Unlock()
+			}
+		})
+	}
+
+	progressChan, errChan := requestor.RequestMulti(ctx, []peer.ID{td.host2.ID(), host3.ID()}, blockChain.TipLink, blockChain.Selector())
+
+	blockChain.VerifyWholeChain(ctx, progressChan)
+	testutil.VerifyEmptyErrors(ctx, t, errChan)
+	require.Len(t, td.blockStore1, blockChainLength, "did not store all blocks")
+
+	// each block is sent by exactly one responder
+	blocksSentLk.Lock()
+	defer blocksSentLk.Unlock()
+	require.Equal(t, blockChainLength, blocksSent[0]+blocksSent[1])
+	require.NotZero(t, blocksSent[0])
+	require.NotZero(t, blocksSent[1])
+}
+
 func TestGraphsyncRoundTripPartial(t *testing.T) {
 	// create network
 	ctx := context.Background()
@@ -264,6 +314,18 @@ func TestGraphsyncRoundTripPartial(t *testing.T) {
 	allSelector := ssb.ExploreRecursive(selector.RecursionLimitDepth(10),
 		ssb.ExploreAll(ssb.ExploreRecursiveEdge())).Node()
 
+	requestStatusChan := make(chan graphsync.ResponseStatusCode, 1)
+	requestor.RegisterCompletedRequestListener(func(p peer.ID, request graphsync.RequestData, status graphsync.ResponseStatusCode) {
+		requestStatusChan <- status
+	})
+	requestErrChan := make(chan error, 1)
+	requestor.RegisterRequestErrorListener(func(p peer.ID, request graphsync.RequestData, err error) {
+		select {
+		case requestErrChan <- err:
+		default:
+		}
+	})
+
 	_, errChan := requestor.Request(ctx, td.host2.ID(), tree.RootNodeLnk, allSelector)
 
 	for err := range errChan {
@@ -279,6 +341,97 @@ func TestGraphsyncRoundTripPartial(t *testing.T) {
 	var finalResponseStatus graphsync.ResponseStatusCode
 	testutil.AssertReceive(ctx, t, finalResponseStatusChan, &finalResponseStatus, "should receive status")
 	require.Equal(t, graphsync.RequestCompletedPartial, finalResponseStatus)
+
+	// verify requestor listeners
+	var requestErr error
+	testutil.AssertReceive(ctx, t, requestErrChan, &requestErr, "should receive error")
+	require.EqualError(t, requestErr, fmt.Sprintf("Remote Peer Is Missing Block: %s", tree.LeafBetaLnk.String()))
+	var requestStatus graphsync.ResponseStatusCode
+	testutil.AssertReceive(ctx, t, requestStatusChan, &requestStatus, "should receive status")
+	require.Equal(t, graphsync.RequestCompletedPartial, requestStatus)
+}
+
+func TestGraphsyncRoundTripStagedPartial(t *testing.T) {
+	// create network
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 1*time.Second)
+	defer cancel()
+	td := newGsTestData(ctx, t)
+
+	// initialize graphsync on first node to make requests
+	requestor := td.GraphSyncHost1()
+
+	// setup an IPLD tree and put all but 1 node into the second nodes block store
+	tree := testutil.NewTestIPLDTree()
+	td.blockStore2[tree.LeafAlphaLnk] = tree.LeafAlphaBlock.RawData()
+	td.blockStore2[tree.MiddleMapNodeLnk] = tree.MiddleMapBlock.RawData()
+	td.blockStore2[tree.MiddleListNodeLnk] = tree.MiddleListBlock.RawData()
+	td.blockStore2[tree.RootNodeLnk] = tree.RootBlock.RawData()
+
+	// initialize graphsync on second node to response to requests
+	td.GraphSyncHost2()
+
+	// create a selector to traverse the whole tree
+	ssb := builder.NewSelectorSpecBuilder(basicnode.Style.Any)
+	allSelector := ssb.ExploreRecursive(selector.RecursionLimitDepth(10),
+		ssb.ExploreAll(ssb.ExploreRecursiveEdge())).Node()
+
+	_, errChan := requestor.RequestWithOptions(ctx, td.host2.ID(), tree.RootNodeLnk, allSelector, graphsync.WithStagedPersistence())
+
+	for err := range errChan {
+		// verify the error is received for leaf beta node being missing
+		require.EqualError(t, err, fmt.Sprintf("Remote Peer Is Missing Block: %s", tree.LeafBetaLnk.String()))
+	}
+	// verify the blocks that were received are discarded
+	require.Len(t, td.blockStore1, 0, "should not store blocks from incomplete request")
+}
+
+func TestGraphsyncRoundTripLocalFirst(t *testing.T) {
+	// create network
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 1*time.Second)
+	defer cancel()
+	td := newGsTestData(ctx, t)
+
+	// initialize graphsync on first node to make requests
+	requestor := td.GraphSyncHost1()
+
+	// setup receiving peer to just record message coming in
+	blockChainLength := 100
+	blockChain := testutil.SetupBlockChain(ctx, t, td.loader2, td.storer2, 100, blockChainLength)
+
+	firstHalf := blockChain.Blocks(0, 50)
+	for _, blk := range firstHalf {
+		td.blockStore1[cidlink.Link{Cid: blk.Cid()}] = blk.RawData()
+	}
+
+	// initialize graphsync on second node to response to requests
+	responder := td.GraphSyncHost2()
+
+	requestsReceived := 0
+	responder.RegisterIncomingRequestHook(func(p peer.ID, requestData graphsync.RequestData, hookActions graphsync.IncomingRequestHookActions) {
+		requestsReceived++
+	})
+	totalSentOnWire := 0
+	responder.RegisterOutgoingBlockHook(func(p peer.ID, requestData graphsync.RequestData, blockData graphsync.BlockData, hookActions graphsync.OutgoingBlockHookActions) {
+		if blockData.BlockSizeOnWire() > 0 {
+			totalSentOnWire++
+		}
+	})
+
+	progressChan, errChan := requestor.RequestWithOptions(ctx, td.host2.ID(), blockChain.TipLink, blockChain.Selector(), graphsync.WithLocalFirst())
+
+	blockChain.VerifyWholeChain(ctx, progressChan)
+	testutil.VerifyEmptyErrors(ctx, t, errChan)
+	require.Len(t, td.blockStore1, blockChainLength, "did not store all blocks")
+	require.Equal(t, 1, requestsReceived)
+	require.Equal(t, blockChainLength-len(firstHalf), totalSentOnWire, "should not send blocks present locally")
+
+	// with every block now present locally, the request never reaches the network
+	progressChan, errChan = requestor.RequestWithOptions(ctx, td.host2.ID(), blockChain.TipLink, blockChain.Selector(), graphsync.WithLocalFirst())
+	blockChain.VerifyWholeChain(ctx, progressChan)
+	testutil.VerifyEmptyErrors(ctx, t, errChan)
+	require.Equal(t, 1, requestsReceived)
 }
 
 func TestGraphsyncRoundTripIgnoreCids(t *testing.T) {
@@ -487,6 +640,94 @@ func TestPauseResumeViaUpdate(t *testing.T) {
 	require.Equal(t, td.extensionUpdateData, receivedUpdateData, "did not receive correct extension update data")
 }
 
+func TestRequestMetadata(t *testing.T) {
+	// create network
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 1*time.Second)
+	defer cancel()
+	td := newGsTestData(ctx, t)
+
+	// initialize graphsync on first node to make requests
+	requestor := td.GraphSyncHost1()
+
+	// setup receiving peer to just record message coming in
+	blockChainLength := 100
+	blockChain := testutil.SetupBlockChain(ctx, t, td.loader2, td.storer2, 100, blockChainLength)
+
+	// initialize graphsync on second node to response to requests
+	responder := td.GraphSyncHost2()
+	var blocksSentOnWire uint64
+	responder.RegisterOutgoingBlockHook(func(p peer.ID, requestData graphsync.RequestData, blockData graphsync.BlockData, hookActions graphsync.OutgoingBlockHookActions) {
+		blocksSentOnWire += blockData.BlockSizeOnWire()
+	})
+
+	links, err := requestor.RequestMetadata(ctx, td.host2.ID(), blockChain.TipLink, blockChain.Selector())
+	require.NoError(t, err)
+	require.Len(t, links, blockChainLength, "did not return metadata for every link")
+	for _, link := range links {
+		require.True(t, link.BlockPresent)
+		require.Equal(t, uint64(len(td.blockStore2[link.Link])), link.BlockSize, "did not return block size")
+	}
+	require.Empty(t, td.blockStore1, "should not store any blocks")
+	require.Zero(t, blocksSentOnWire, "should not send any blocks")
+}
+
+func TestPaymentNegotiation(t *testing.T) {
+	// create network
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	td := newGsTestData(ctx, t)
+
+	// a single payment channel stands in for both ends of a real one
+	channel := payments.NewMockChannel(1000)
+	price := uint64(100)
+
+	// initialize graphsync on first node to make requests, paying as asked
+	requestor := td.GraphSyncHost1()
+	requestor.RegisterIncomingResponseHook(payments.PayOnRequest(channel))
+
+	// setup receiving peer to just record message coming in
+	blockChainLength := 100
+	blockChain := testutil.SetupBlockChain(ctx, t, td.loader2, td.storer2, 100, blockChainLength)
+
+	// initialize graphsync on second node to charge for every 25 blocks sent
+	responder := td.GraphSyncHost2()
+	blocksPerPayment := 25
+	blocksSent := 0
+	var owed uint64
+	responder.RegisterOutgoingBlockHook(func(p peer.ID, requestData graphsync.RequestData, blockData graphsync.BlockData, hookActions graphsync.OutgoingBlockHookActions) {
+		blocksSent++
+		if blocksSent%blocksPerPayment == 0 && blocksSent < blockChainLength {
+			owed += price
+			hookActions.RequestPayment(price)
+		}
+	})
+	responder.RegisterRequestUpdatedHook(func(p peer.ID, request graphsync.RequestData, update graphsync.RequestData, hookActions graphsync.RequestUpdatedHookActions) {
+		amount, ok, err := payments.RedeemPayment(channel, update)
+		if !ok {
+			return
+		}
+		if err != nil {
+			hookActions.TerminateWithError(err)
+			return
+		}
+		if amount > owed {
+			amount = owed
+		}
+		owed -= amount
+		if owed == 0 {
+			hookActions.UnpauseResponse()
+		}
+	})
+	progressChan, errChan := requestor.Request(ctx, td.host2.ID(), blockChain.TipLink, blockChain.Selector())
+
+	blockChain.VerifyWholeChain(ctx, progressChan)
+	testutil.VerifyEmptyErrors(ctx, t, errChan)
+	require.Len(t, td.blockStore1, blockChainLength, "did not store all blocks")
+	require.Equal(t, 3*price, channel.Redeemed(), "did not pay for each pause")
+}
+
 func TestPauseResumeViaUpdateOnBlockHook(t *testing.T) {
 	// create network
 	ctx := context.Background()
@@ -865,6 +1106,124 @@ func TestUnixFSFetch(t *testing.T) {
 	require.Equal(t, origBytes, finalBytes, "should have gotten same bytes written as read but didn't")
 }
 
+func TestUnixFSByteRange(t *testing.T) {
+	if testing.Short() {
+		t.Skip()
+	}
+
+	const unixfsChunkSize uint64 = 1 << 10
+	const unixfsLinksPerLevel = 4
+
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 20*time.Second)
+	defer cancel()
+
+	makeLoader := func(bs bstore.Blockstore) ipld.Loader {
+		return func(lnk ipld.Link, lnkCtx ipld.LinkContext) (io.Reader, error) {
+			c, ok := lnk.(cidlink.Link)
+			if !ok {
+				return nil, errors.New("Incorrect Link Type")
+			}
+			block, err := bs.Get(c.Cid)
+			if err != nil {
+				return nil, err
+			}
+			return bytes.NewReader(block.RawData()), nil
+		}
+	}
+
+	makeStorer := func(bs bstore.Blockstore) ipld.Storer {
+		return func(lnkCtx ipld.LinkContext) (io.Writer, ipld.StoreCommitter, error) {
+			var buf bytes.Buffer
+			var committer ipld.StoreCommitter = func(lnk ipld.Link) error {
+				c, ok := lnk.(cidlink.Link)
+				if !ok {
+					return errors.New("Incorrect Link Type")
+				}
+				block, err := blocks.NewBlockWithCid(buf.Bytes(), c.Cid)
+				if err != nil {
+					return err
+				}
+				return bs.Put(block)
+			}
+			return &buf, committer, nil
+		}
+	}
+	countBlocks := func(bs bstore.Blockstore) int {
+		keys, err := bs.AllKeysChan(ctx)
+		require.NoError(t, err)
+		count := 0
+		for range keys {
+			count++
+		}
+		return count
+	}
+
+	bs1 := bstore.NewBlockstore(dss.MutexWrap(datastore.NewMapDatastore()))
+	bs2 := bstore.NewBlockstore(dss.MutexWrap(datastore.NewMapDatastore()))
+	dagService2 := merkledag.NewDAGService(blockservice.New(bs2, offline.Exchange(bs2)))
+
+	// import a fixture file to UnixFS, in a tree several levels deep
+	path, err := filepath.Abs(filepath.Join("fixtures", "lorem.txt"))
+	require.NoError(t, err, "unable to create path for fixture file")
+	f, err := os.Open(path)
+	require.NoError(t, err, "unable to open fixture file")
+	var buf bytes.Buffer
+	tr := io.TeeReader(f, &buf)
+	file := files.NewReaderFile(tr)
+	bufferedDS := ipldformat.NewBufferedDAG(ctx, dagService2)
+	params := ihelper.DagBuilderParams{
+		Maxlinks:   unixfsLinksPerLevel,
+		RawLeaves:  true,
+		CidBuilder: nil,
+		Dagserv:    bufferedDS,
+	}
+	db, err := params.New(chunker.NewSizeSplitter(file, int64(unixfsChunkSize)))
+	require.NoError(t, err, "unable to setup dag builder")
+	nd, err := balanced.Layout(db)
+	require.NoError(t, err, "unable to create unix fs node")
+	err = bufferedDS.Commit()
+	require.NoError(t, err, "unable to commit unix fs node")
+	origBytes := buf.Bytes()
+
+	td := newGsTestData(ctx, t)
+	requestor := New(ctx, td.gsnet1, makeLoader(bs1), makeStorer(bs1))
+	responder := New(ctx, td.gsnet2, makeLoader(bs2), makeStorer(bs2))
+	responder.RegisterIncomingRequestHook(func(p peer.ID, requestData graphsync.RequestData, hookActions graphsync.IncomingRequestHookActions) {
+		hookActions.ValidateRequest()
+	})
+	clink := cidlink.Link{Cid: nd.Cid()}
+
+	// a byte range request only transfers the blocks that overlap the range
+	data, fileSize, err := byterange.Request(ctx, requestor, td.host2.ID(), clink, 5000, 7000)
+	require.NoError(t, err)
+	require.Equal(t, uint64(len(origBytes)), fileSize)
+	require.Equal(t, origBytes[5000:7000], data)
+	require.Less(t, countBlocks(bs1), countBlocks(bs2))
+
+	// ranges past the end of the file stop at the end of the file
+	data, _, err = byterange.Request(ctx, requestor, td.host2.ID(), clink, uint64(len(origBytes)-10), uint64(len(origBytes)+10))
+	require.NoError(t, err)
+	require.Equal(t, origBytes[len(origBytes)-10:], data)
+
+	// a reader seeks through the file, requesting ranges as it reads
+	reader := byterange.NewReader(ctx, requestor, td.host2.ID(), clink)
+	reader.SetReadAhead(2 * unixfsChunkSize)
+	position, err := reader.Seek(12000, io.SeekStart)
+	require.NoError(t, err)
+	require.Equal(t, int64(12000), position)
+	readBytes := make([]byte, 3000)
+	_, err = io.ReadFull(reader, readBytes)
+	require.NoError(t, err)
+	require.Equal(t, origBytes[12000:15000], readBytes)
+
+	_, err = reader.Seek(-100, io.SeekEnd)
+	require.NoError(t, err)
+	readBytes, err = ioutil.ReadAll(reader)
+	require.NoError(t, err)
+	require.Equal(t, origBytes[len(origBytes)-100:], readBytes)
+}
+
 type gsTestData struct {
 	mn                       mocknet.Mocknet
 	ctx                      context.Context
@@ -0,0 +1,108 @@
+package ipldutil
+
+import (
+	"fmt"
+
+	"github.com/ipfs/go-unixfs"
+	ipld "github.com/ipld/go-ipld-prime"
+)
+
+type fileSpan struct {
+	offset uint64
+	size   uint64
+}
+
+// ByteRange tracks where the nodes of a UnixFS file sit in the file as a
+// traversal visits them, using the block sizes of dag-pb file nodes, so the
+// traversal can skip the blocks that hold none of the bytes [start, end)
+type ByteRange struct {
+	start    uint64
+	end      uint64
+	fileSize uint64
+	spans    map[string]fileSpan
+}
+
+// NewByteRange returns a ByteRange for the bytes [start, end) of the UnixFS
+// file at the root of a traversal
+func NewByteRange(start uint64, end uint64) *ByteRange {
+	return &ByteRange{
+		start: start,
+		end:   end,
+		spans: make(map[string]fileSpan),
+	}
+}
+
+// FileSize returns the size of the whole file, once the root of the file has
+// been visited
+func (br *ByteRange) FileSize() uint64 {
+	return br.fileSize
+}
+
+// Include indicates whether a traversal should load the block at the given
+// link path. Links that are not children of a visited file node are always
+// loaded
+func (br *ByteRange) Include(linkPath ipld.Path) bool {
+	span, ok := br.spans[linkPath.String()]
+	if !ok {
+		return true
+	}
+	if span.offset < br.end && span.offset+span.size > br.start {
+		return true
+	}
+	delete(br.spans, linkPath.String())
+	return false
+}
+
+// Visit records where the children of the file node at the given path sit in
+// the file, and returns the file data held in the node itself along with its
+// offset in the file. Nodes that are not the root of a file block, such as the
+// fields of a dag-pb node, are ignored and return no data
+func (br *ByteRange) Visit(path ipld.Path, node ipld.Node) ([]byte, uint64, error) {
+	key := path.String()
+	span, ok := br.spans[key]
+	if !ok && key != "" {
+		return nil, 0, nil
+	}
+	delete(br.spans, key)
+	if node.ReprKind() == ipld.ReprKind_Bytes {
+		data, err := node.AsBytes()
+		if err != nil {
+			return nil, 0, err
+		}
+		if key == "" {
+			br.fileSize = uint64(len(data))
+		}
+		return data, span.offset, nil
+	}
+	dataNode, err := node.LookupString("Data")
+	if err != nil {
+		return nil, 0, fmt.Errorf("not a UnixFS file node: %s", err)
+	}
+	pbData, err := dataNode.AsBytes()
+	if err != nil {
+		return nil, 0, err
+	}
+	fsNode, err := unixfs.FSNodeFromBytes(pbData)
+	if err != nil {
+		return nil, 0, err
+	}
+	if key == "" {
+		br.fileSize = fsNode.FileSize()
+	}
+	childOffset := span.offset + uint64(len(fsNode.Data()))
+	for i, size := range fsNode.BlockSizes() {
+		br.spans[childLinkPath(key, i)] = fileSpan{offset: childOffset, size: size}
+		childOffset += size
+	}
+	return fsNode.Data(), span.offset, nil
+}
+
+// childLinkPath is the path a traversal follows to the link to the child of a
+// dag-pb node at the given index
+func childLinkPath(parentPath string, index int) string {
+	linkPath := fmt.Sprintf("Links/%d/Hash", index)
+	if parentPath == "" {
+		return linkPath
+	}
+	return parentPath + "/" + linkPath
+}
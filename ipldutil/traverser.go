@@ -26,6 +26,9 @@ type TraversalBuilder struct {
 	Selector ipld.Node
 	Visitor  traversal.AdvVisitFn
 	Chooser  traversal.LinkTargetNodeStyleChooser
+	// Range, if set, limits the traversal to the blocks of a UnixFS file that
+	// hold the bytes in the range
+	Range *ByteRange
 }
 
 // Traverser is an interface for performing a selector traversal that operates iteratively --
@@ -67,6 +70,7 @@ func (tb TraversalBuilder) Start(parentCtx context.Context) Traverser {
 		selector:     tb.Selector,
 		visitor:      defaultVisitor,
 		chooser:      defaultChooser,
+		byteRange:    tb.Range,
 		awaitRequest: make(chan struct{}, 1),
 		stateChan:    make(chan state, 1),
 		responses:    make(chan nextResponse),
@@ -92,6 +96,7 @@ type traverser struct {
 	selector       ipld.Node
 	visitor        traversal.AdvVisitFn
 	chooser        traversal.LinkTargetNodeStyleChooser
+	byteRange      *ByteRange
 	currentLink    ipld.Link
 	currentContext ipld.LinkContext
 	isDone         bool
@@ -129,12 +134,17 @@ func (t *traverser) writeDone(err error) {
 func (t *traverser) start() {
 	select {
 	case <-t.ctx.Done():
+		// nothing will run, so shutting down must not wait for it
+		close(t.stopped)
 		return
 	case t.awaitRequest <- struct{}{}:
 	}
 	go func() {
 		defer close(t.stopped)
 		loader := func(lnk ipld.Link, lnkCtx ipld.LinkContext) (io.Reader, error) {
+			if t.byteRange != nil && !t.byteRange.Include(lnkCtx.LinkPath) {
+				return nil, traversal.SkipMe{}
+			}
 			select {
 			case <-t.ctx.Done():
 				return nil, ContextCancelError{}
@@ -171,11 +181,20 @@ func (t *traverser) start() {
 				LinkLoader:                 loader,
 				LinkTargetNodeStyleChooser: t.chooser,
 			},
-		}.WalkAdv(nd, sel, t.visitor)
+		}.WalkAdv(nd, sel, t.visit)
 		t.writeDone(err)
 	}()
 }
 
+func (t *traverser) visit(tp traversal.Progress, node ipld.Node, tr traversal.VisitReason) error {
+	if t.byteRange != nil {
+		if _, _, err := t.byteRange.Visit(tp.Path, node); err != nil {
+			return err
+		}
+	}
+	return t.visitor(tp, node, tr)
+}
+
 func (t *traverser) Shutdown(ctx context.Context) {
 	t.cancel()
 	select {
@@ -25,7 +25,8 @@ func IsTerminalSuccessCode(status graphsync.ResponseStatusCode) bool {
 // IsTerminalFailureCode returns true if the response code indicates the
 // request terminated in failure.
 func IsTerminalFailureCode(status graphsync.ResponseStatusCode) bool {
-	return status == graphsync.RequestFailedBusy ||
+	return status == graphsync.RequestRejected ||
+		status == graphsync.RequestFailedBusy ||
 		status == graphsync.RequestFailedContentNotFound ||
 		status == graphsync.RequestFailedLegal ||
 		status == graphsync.RequestFailedUnknown ||
@@ -380,6 +381,18 @@ func (gsr GraphSyncRequest) Extension(name graphsync.ExtensionName) ([]byte, boo
 	return val, true
 }
 
+// Extensions returns all extensions on this request
+func (gsr GraphSyncRequest) Extensions() []graphsync.ExtensionData {
+	if gsr.extensions == nil {
+		return nil
+	}
+	extensions := make([]graphsync.ExtensionData, 0, len(gsr.extensions))
+	for name, data := range gsr.extensions {
+		extensions = append(extensions, graphsync.ExtensionData{Name: graphsync.ExtensionName(name), Data: data})
+	}
+	return extensions
+}
+
 // IsCancel returns true if this particular request is being cancelled
 func (gsr GraphSyncRequest) IsCancel() bool { return gsr.isCancel }
 
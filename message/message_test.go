@@ -374,3 +374,13 @@ func TestMergeExtensions(t *testing.T) {
 		require.Equal(t, []byte("cheese"), extData3)
 	})
 }
+
+func TestTerminalCodes(t *testing.T) {
+	require.True(t, IsTerminalFailureCode(graphsync.RequestRejected))
+	require.True(t, IsTerminalResponseCode(graphsync.RequestRejected))
+	require.False(t, IsTerminalSuccessCode(graphsync.RequestRejected))
+	require.True(t, IsTerminalFailureCode(graphsync.RequestFailedLegal))
+	require.True(t, IsTerminalSuccessCode(graphsync.RequestCompletedFull))
+	require.False(t, IsTerminalResponseCode(graphsync.PartialResponse))
+	require.False(t, IsTerminalResponseCode(graphsync.RequestPaused))
+}
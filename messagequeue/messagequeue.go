@@ -24,11 +24,17 @@ type MessageNetwork interface {
 	ConnectTo(context.Context, peer.ID) error
 }
 
+// BandwidthLimiter limits the rate bytes are sent to peers
+type BandwidthLimiter interface {
+	Wait(ctx context.Context, p peer.ID, size uint64) error
+}
+
 // MessageQueue implements queue of want messages to send to peers.
 type MessageQueue struct {
-	p       peer.ID
-	network MessageNetwork
-	ctx     context.Context
+	p                peer.ID
+	network          MessageNetwork
+	bandwidthLimiter BandwidthLimiter
+	ctx              context.Context
 
 	outgoingWork chan struct{}
 	done         chan struct{}
@@ -40,14 +46,15 @@ type MessageQueue struct {
 	sender             gsnet.MessageSender
 }
 
-// New creats a new MessageQueue.
-func New(ctx context.Context, p peer.ID, network MessageNetwork) *MessageQueue {
+// New creats a new MessageQueue. The bandwidth limiter is optional
+func New(ctx context.Context, p peer.ID, network MessageNetwork, bandwidthLimiter BandwidthLimiter) *MessageQueue {
 	return &MessageQueue{
-		ctx:          ctx,
-		network:      network,
-		p:            p,
-		outgoingWork: make(chan struct{}, 1),
-		done:         make(chan struct{}),
+		ctx:              ctx,
+		network:          network,
+		bandwidthLimiter: bandwidthLimiter,
+		p:                p,
+		outgoingWork:     make(chan struct{}, 1),
+		done:             make(chan struct{}),
 	}
 }
 
@@ -152,6 +159,16 @@ func (mq *MessageQueue) sendMessage() {
 		return
 	}
 
+	if mq.bandwidthLimiter != nil {
+		var size uint64
+		for _, block := range message.Blocks() {
+			size += uint64(len(block.RawData()))
+		}
+		if err := mq.bandwidthLimiter.Wait(mq.ctx, mq.p, size); err != nil {
+			return
+		}
+	}
+
 	err := mq.initializeSender()
 	if err != nil {
 		log.Infof("cant open message sender to peer %s: %s", mq.p, err)
@@ -11,6 +11,7 @@ import (
 	basicnode "github.com/ipld/go-ipld-prime/node/basic"
 	"github.com/ipld/go-ipld-prime/traversal/selector/builder"
 	"github.com/libp2p/go-libp2p-core/peer"
+	ma "github.com/multiformats/go-multiaddr"
 	"github.com/stretchr/testify/require"
 
 	"github.com/ipfs/go-graphsync"
@@ -30,6 +31,8 @@ func (fmn *fakeMessageNetwork) ConnectTo(context.Context, peer.ID) error {
 	return fmn.connectError
 }
 
+func (fmn *fakeMessageNetwork) AddAddrs(peer.ID, []ma.Multiaddr) {}
+
 func (fmn *fakeMessageNetwork) NewMessageSender(context.Context, peer.ID) (gsnet.MessageSender, error) {
 	fmn.wait.Done()
 	if fmn.messageSenderError == nil {
@@ -65,7 +68,7 @@ func TestStartupAndShutdown(t *testing.T) {
 	var waitGroup sync.WaitGroup
 	messageNetwork := &fakeMessageNetwork{nil, nil, messageSender, &waitGroup}
 
-	messageQueue := New(ctx, peer, messageNetwork)
+	messageQueue := New(ctx, peer, messageNetwork, nil)
 	messageQueue.Startup()
 	id := graphsync.RequestID(rand.Int31())
 	priority := graphsync.Priority(rand.Int31())
@@ -100,7 +103,7 @@ func TestShutdownDuringMessageSend(t *testing.T) {
 	var waitGroup sync.WaitGroup
 	messageNetwork := &fakeMessageNetwork{nil, nil, messageSender, &waitGroup}
 
-	messageQueue := New(ctx, peer, messageNetwork)
+	messageQueue := New(ctx, peer, messageNetwork, nil)
 	messageQueue.Startup()
 	id := graphsync.RequestID(rand.Int31())
 	priority := graphsync.Priority(rand.Int31())
@@ -145,7 +148,7 @@ func TestProcessingNotification(t *testing.T) {
 	var waitGroup sync.WaitGroup
 	messageNetwork := &fakeMessageNetwork{nil, nil, messageSender, &waitGroup}
 
-	messageQueue := New(ctx, peer, messageNetwork)
+	messageQueue := New(ctx, peer, messageNetwork, nil)
 	waitGroup.Add(1)
 	blks := testutil.GenerateBlocksOfSize(3, 128)
 
@@ -193,7 +196,7 @@ func TestDedupingMessages(t *testing.T) {
 	var waitGroup sync.WaitGroup
 	messageNetwork := &fakeMessageNetwork{nil, nil, messageSender, &waitGroup}
 
-	messageQueue := New(ctx, peer, messageNetwork)
+	messageQueue := New(ctx, peer, messageNetwork, nil)
 	messageQueue.Startup()
 	waitGroup.Add(1)
 	id := graphsync.RequestID(rand.Int31())
@@ -246,3 +249,45 @@ func TestDedupingMessages(t *testing.T) {
 		}
 	}
 }
+
+type limiterWait struct {
+	p    peer.ID
+	size uint64
+}
+
+type fakeBandwidthLimiter struct {
+	waits chan limiterWait
+}
+
+func (fbl *fakeBandwidthLimiter) Wait(ctx context.Context, p peer.ID, size uint64) error {
+	fbl.waits <- limiterWait{p, size}
+	return nil
+}
+
+func TestBandwidthLimiting(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 1*time.Second)
+	defer cancel()
+
+	peer := testutil.GeneratePeers(1)[0]
+	messagesSent := make(chan gsmsg.GraphSyncMessage)
+	resetChan := make(chan struct{}, 1)
+	fullClosedChan := make(chan struct{}, 1)
+	messageSender := &fakeMessageSender{nil, fullClosedChan, resetChan, messagesSent}
+	var waitGroup sync.WaitGroup
+	messageNetwork := &fakeMessageNetwork{nil, nil, messageSender, &waitGroup}
+	limiter := &fakeBandwidthLimiter{make(chan limiterWait, 1)}
+
+	messageQueue := New(ctx, peer, messageNetwork, limiter)
+	messageQueue.Startup()
+	waitGroup.Add(1)
+	blks := testutil.GenerateBlocksOfSize(3, 128)
+	responseID := graphsync.RequestID(rand.Int31())
+	messageQueue.AddResponses([]gsmsg.GraphSyncResponse{gsmsg.NewResponse(responseID, graphsync.RequestCompletedFull)}, blks)
+
+	var wait limiterWait
+	testutil.AssertReceive(ctx, t, limiter.waits, &wait, "did not wait on limiter")
+	require.Equal(t, peer, wait.p)
+	require.Equal(t, uint64(3*128), wait.size)
+	testutil.AssertDoesReceive(ctx, t, messagesSent, "message did not send")
+}
@@ -12,6 +12,9 @@ import (
 type Item struct {
 	Link         ipld.Link
 	BlockPresent bool
+	// BlockSize is only sent for metadata only responses, which describe blocks
+	// without sending them
+	BlockSize uint64
 }
 
 // Metadata is information about metadata contained in a response, which can be
@@ -52,7 +55,15 @@ func DecodeMetadata(data []byte) (Metadata, error) {
 		if err != nil {
 			return nil, err
 		}
-		metadata = append(metadata, Item{link, blockPresent})
+		var blockSize uint64
+		if blockSizeNode, err := item.LookupString("blockSize"); err == nil {
+			size, err := blockSizeNode.AsInt()
+			if err != nil {
+				return nil, err
+			}
+			blockSize = uint64(size)
+		}
+		metadata = append(metadata, Item{link, blockPresent, blockSize})
 	}
 	return metadata, err
 }
@@ -62,9 +73,16 @@ func EncodeMetadata(entries Metadata) ([]byte, error) {
 	node, err := fluent.Build(basicnode.Style.List, func(na fluent.NodeAssembler) {
 		na.CreateList(len(entries), func(na fluent.ListAssembler) {
 			for _, item := range entries {
-				na.AssembleValue().CreateMap(2, func(na fluent.MapAssembler) {
+				entries := 2
+				if item.BlockSize > 0 {
+					entries++
+				}
+				na.AssembleValue().CreateMap(entries, func(na fluent.MapAssembler) {
 					na.AssembleEntry("link").AssignLink(item.Link)
 					na.AssembleEntry("blockPresent").AssignBool(item.BlockPresent)
+					if item.BlockSize > 0 {
+						na.AssembleEntry("blockSize").AssignInt(int(item.BlockSize))
+					}
 				})
 			}
 		})
@@ -16,7 +16,25 @@ func TestDecodeEncodeMetadata(t *testing.T) {
 	for _, k := range cids {
 		link := cidlink.Link{Cid: k}
 		blockPresent := rand.Int31()%2 == 0
-		initialMetadata = append(initialMetadata, Item{link, blockPresent})
+		initialMetadata = append(initialMetadata, Item{Link: link, BlockPresent: blockPresent})
+	}
+	encoded, err := EncodeMetadata(initialMetadata)
+	require.NoError(t, err, "encode errored")
+	decodedMetadata, err := DecodeMetadata(encoded)
+	require.NoError(t, err, "decode errored")
+	require.Equal(t, initialMetadata, decodedMetadata, "metadata changed during encoding and decoding")
+}
+
+func TestDecodeEncodeMetadataWithBlockSizes(t *testing.T) {
+	cids := testutil.GenerateCids(10)
+	initialMetadata := make(Metadata, 0, 10)
+	for _, k := range cids {
+		link := cidlink.Link{Cid: k}
+		item := Item{Link: link, BlockPresent: rand.Int31()%2 == 0}
+		if item.BlockPresent {
+			item.BlockSize = uint64(rand.Int31n(1000) + 1)
+		}
+		initialMetadata = append(initialMetadata, item)
 	}
 	encoded, err := EncodeMetadata(initialMetadata)
 	require.NoError(t, err, "encode errored")
@@ -5,6 +5,7 @@ import (
 
 	"github.com/libp2p/go-libp2p-core/peer"
 	"github.com/libp2p/go-libp2p-core/protocol"
+	ma "github.com/multiformats/go-multiaddr"
 
 	gsmsg "github.com/ipfs/go-graphsync/message"
 )
@@ -30,6 +31,10 @@ type GraphSyncNetwork interface {
 	// ConnectTo establishes a connection to the given peer
 	ConnectTo(context.Context, peer.ID) error
 
+	// AddAddrs records addresses the given peer can be reached at, for
+	// connecting to it later
+	AddAddrs(peer.ID, []ma.Multiaddr)
+
 	NewMessageSender(context.Context, peer.ID) (MessageSender, error)
 }
 
@@ -12,6 +12,7 @@ import (
 	"github.com/libp2p/go-libp2p-core/host"
 	"github.com/libp2p/go-libp2p-core/network"
 	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/peerstore"
 	ma "github.com/multiformats/go-multiaddr"
 
 	gsmsg "github.com/ipfs/go-graphsync/message"
@@ -127,6 +128,10 @@ func (gsnet *libp2pGraphSyncNetwork) ConnectTo(ctx context.Context, p peer.ID) e
 	return gsnet.host.Connect(ctx, peer.AddrInfo{ID: p})
 }
 
+func (gsnet *libp2pGraphSyncNetwork) AddAddrs(p peer.ID, addrs []ma.Multiaddr) {
+	gsnet.host.Peerstore().AddAddrs(p, addrs, peerstore.TempAddrTTL)
+}
+
 // handleNewStream receives a new stream from the network.
 func (gsnet *libp2pGraphSyncNetwork) handleNewStream(s network.Stream) {
 	defer s.Close()
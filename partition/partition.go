@@ -0,0 +1,82 @@
+package partition
+
+import (
+	"errors"
+	"hash/fnv"
+
+	ipld "github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/fluent"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	basicnode "github.com/ipld/go-ipld-prime/node/basic"
+
+	"github.com/ipfs/go-graphsync/ipldutil"
+)
+
+// Partition is one of count disjoint shares of the blocks in a traversal,
+// assigned by the hash of each block's CID
+type Partition struct {
+	Index int
+	Count int
+}
+
+// Has returns true if the block for the given link belongs to the partition.
+// Links that are not CIDs belong to every partition
+func (p Partition) Has(lnk ipld.Link) bool {
+	if p.Count <= 1 {
+		return true
+	}
+	asCidLink, ok := lnk.(cidlink.Link)
+	if !ok {
+		return true
+	}
+	h := fnv.New32a()
+	_, _ = h.Write(asCidLink.Cid.Hash())
+	return int(h.Sum32()%uint32(p.Count)) == p.Index
+}
+
+// EncodePartition returns encoded cbor data for a partition, for the partition
+// extension
+func EncodePartition(p Partition) ([]byte, error) {
+	if p.Index < 0 || p.Index >= p.Count {
+		return nil, errors.New("invalid partition")
+	}
+	node, err := fluent.Build(basicnode.Style.Map, func(na fluent.NodeAssembler) {
+		na.CreateMap(2, func(na fluent.MapAssembler) {
+			na.AssembleEntry("index").AssignInt(p.Index)
+			na.AssembleEntry("count").AssignInt(p.Count)
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ipldutil.EncodeNode(node)
+}
+
+// DecodePartition returns a partition decoded from data for the partition
+// extension
+func DecodePartition(data []byte) (Partition, error) {
+	node, err := ipldutil.DecodeNode(data)
+	if err != nil {
+		return Partition{}, err
+	}
+	indexNode, err := node.LookupString("index")
+	if err != nil {
+		return Partition{}, err
+	}
+	index, err := indexNode.AsInt()
+	if err != nil {
+		return Partition{}, err
+	}
+	countNode, err := node.LookupString("count")
+	if err != nil {
+		return Partition{}, err
+	}
+	count, err := countNode.AsInt()
+	if err != nil {
+		return Partition{}, err
+	}
+	if index < 0 || index >= count {
+		return Partition{}, errors.New("invalid partition")
+	}
+	return Partition{index, count}, nil
+}
@@ -0,0 +1,37 @@
+package partition
+
+import (
+	"testing"
+
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ipfs/go-graphsync/testutil"
+)
+
+func TestDecodeEncodePartition(t *testing.T) {
+	p := Partition{Index: 1, Count: 3}
+	encoded, err := EncodePartition(p)
+	require.NoError(t, err, "encode errored")
+	decoded, err := DecodePartition(encoded)
+	require.NoError(t, err, "decode errored")
+	require.Equal(t, p, decoded)
+
+	_, err = EncodePartition(Partition{Index: 3, Count: 3})
+	require.Error(t, err, "should not encode index outside count")
+}
+
+func TestPartitionsAreDisjoint(t *testing.T) {
+	partitions := []Partition{{0, 3}, {1, 3}, {2, 3}}
+	for _, c := range testutil.GenerateCids(100) {
+		lnk := cidlink.Link{Cid: c}
+		owners := 0
+		for _, p := range partitions {
+			if p.Has(lnk) {
+				owners++
+			}
+		}
+		require.Equal(t, 1, owners, "each block should belong to exactly one partition")
+		require.True(t, Partition{0, 1}.Has(lnk), "a single partition should have every block")
+	}
+}
@@ -0,0 +1,74 @@
+package payments
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"sync"
+)
+
+// ErrInsufficientFunds indicates a payer cannot pay the amount asked for
+var ErrInsufficientFunds = errors.New("insufficient funds in payment channel")
+
+// MockChannel is a local payment channel with a fixed amount of funds, for
+// tests. It is both the payer and the payee, and signs vouchers with a secret
+// key so vouchers it did not create are rejected
+type MockChannel struct {
+	key      []byte
+	lk       sync.Mutex
+	funds    uint64
+	paid     uint64
+	redeemed uint64
+}
+
+// NewMockChannel returns a new mock payment channel holding the given funds
+func NewMockChannel(funds uint64) *MockChannel {
+	key := make([]byte, 32)
+	_, _ = rand.Read(key)
+	return &MockChannel{key: key, funds: funds}
+}
+
+// CreateVoucher returns a voucher paying the given amount on top of all
+// vouchers created before it
+func (mc *MockChannel) CreateVoucher(amount uint64) (Voucher, error) {
+	mc.lk.Lock()
+	defer mc.lk.Unlock()
+	if amount > mc.funds-mc.paid {
+		return Voucher{}, ErrInsufficientFunds
+	}
+	mc.paid += amount
+	return Voucher{Amount: mc.paid, Signature: mc.sign(mc.paid)}, nil
+}
+
+// RedeemVoucher checks a voucher and returns the amount it pays on top of all
+// vouchers redeemed before it
+func (mc *MockChannel) RedeemVoucher(voucher Voucher) (uint64, error) {
+	mc.lk.Lock()
+	defer mc.lk.Unlock()
+	if !hmac.Equal(voucher.Signature, mc.sign(voucher.Amount)) {
+		return 0, errors.New("invalid voucher signature")
+	}
+	if voucher.Amount <= mc.redeemed {
+		return 0, errors.New("voucher already redeemed")
+	}
+	amount := voucher.Amount - mc.redeemed
+	mc.redeemed = voucher.Amount
+	return amount, nil
+}
+
+// Redeemed returns the total amount redeemed over the channel
+func (mc *MockChannel) Redeemed() uint64 {
+	mc.lk.Lock()
+	defer mc.lk.Unlock()
+	return mc.redeemed
+}
+
+func (mc *MockChannel) sign(amount uint64) []byte {
+	mac := hmac.New(sha256.New, mc.key)
+	var amountBytes [8]byte
+	binary.BigEndian.PutUint64(amountBytes[:], amount)
+	_, _ = mac.Write(amountBytes[:])
+	return mac.Sum(nil)
+}
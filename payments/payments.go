@@ -0,0 +1,157 @@
+package payments
+
+import (
+	"errors"
+
+	"github.com/ipld/go-ipld-prime/fluent"
+	basicnode "github.com/ipld/go-ipld-prime/node/basic"
+	"github.com/libp2p/go-libp2p-core/peer"
+
+	"github.com/ipfs/go-graphsync"
+	"github.com/ipfs/go-graphsync/ipldutil"
+)
+
+// Voucher is a payment over a payment channel. Amount is the total paid over
+// the channel, so each voucher replaces the ones before it
+type Voucher struct {
+	Amount    uint64
+	Signature []byte
+}
+
+// Payer creates vouchers to pay for responses
+type Payer interface {
+	// CreateVoucher returns a voucher paying the given amount on top of all
+	// vouchers created before it
+	CreateVoucher(amount uint64) (Voucher, error)
+}
+
+// Payee redeems vouchers sent to pay for responses
+type Payee interface {
+	// RedeemVoucher checks a voucher and returns the amount it pays on top of
+	// all vouchers redeemed before it
+	RedeemVoucher(voucher Voucher) (uint64, error)
+}
+
+// EncodePaymentRequest returns encoded cbor data for the amount owed, for the
+// payment request extension
+func EncodePaymentRequest(amount uint64) ([]byte, error) {
+	nb := basicnode.Style.Int.NewBuilder()
+	err := nb.AssignInt(int(amount))
+	if err != nil {
+		return nil, err
+	}
+	nd := nb.Build()
+	return ipldutil.EncodeNode(nd)
+}
+
+// DecodePaymentRequest returns the amount owed, decoded from data for the
+// payment request extension
+func DecodePaymentRequest(data []byte) (uint64, error) {
+	nd, err := ipldutil.DecodeNode(data)
+	if err != nil {
+		return 0, err
+	}
+	amount, err := nd.AsInt()
+	if err != nil {
+		return 0, err
+	}
+	if amount < 0 {
+		return 0, errors.New("negative payment amount")
+	}
+	return uint64(amount), nil
+}
+
+// EncodeVoucher returns encoded cbor data for a voucher, for the payment
+// extension
+func EncodeVoucher(voucher Voucher) ([]byte, error) {
+	node, err := fluent.Build(basicnode.Style.Map, func(na fluent.NodeAssembler) {
+		na.CreateMap(2, func(na fluent.MapAssembler) {
+			na.AssembleEntry("amount").AssignInt(int(voucher.Amount))
+			na.AssembleEntry("signature").AssignBytes(voucher.Signature)
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ipldutil.EncodeNode(node)
+}
+
+// DecodeVoucher returns a voucher decoded from data for the payment extension
+func DecodeVoucher(data []byte) (Voucher, error) {
+	node, err := ipldutil.DecodeNode(data)
+	if err != nil {
+		return Voucher{}, err
+	}
+	amountNode, err := node.LookupString("amount")
+	if err != nil {
+		return Voucher{}, err
+	}
+	amount, err := amountNode.AsInt()
+	if err != nil {
+		return Voucher{}, err
+	}
+	if amount < 0 {
+		return Voucher{}, errors.New("negative payment amount")
+	}
+	signatureNode, err := node.LookupString("signature")
+	if err != nil {
+		return Voucher{}, err
+	}
+	signature, err := signatureNode.AsBytes()
+	if err != nil {
+		return Voucher{}, err
+	}
+	return Voucher{Amount: uint64(amount), Signature: signature}, nil
+}
+
+// PayOnRequest returns a requestor hook that pays for responses paused with
+// NotEnoughGas, by sending a voucher from the given payer for the amount owed
+// as an update to the request
+func PayOnRequest(payer Payer) graphsync.OnIncomingResponseHook {
+	return func(p peer.ID, responseData graphsync.ResponseData, hookActions graphsync.IncomingResponseHookActions) {
+		if responseData.Status() != graphsync.NotEnoughGas {
+			return
+		}
+		data, ok := responseData.Extension(graphsync.ExtensionPaymentRequest)
+		if !ok {
+			return
+		}
+		amount, err := DecodePaymentRequest(data)
+		if err != nil {
+			hookActions.TerminateWithError(err)
+			return
+		}
+		voucher, err := payer.CreateVoucher(amount)
+		if err != nil {
+			hookActions.TerminateWithError(err)
+			return
+		}
+		voucherData, err := EncodeVoucher(voucher)
+		if err != nil {
+			hookActions.TerminateWithError(err)
+			return
+		}
+		hookActions.UpdateRequestWithExtensions(graphsync.ExtensionData{
+			Name: graphsync.ExtensionPayment,
+			Data: voucherData,
+		})
+	}
+}
+
+// RedeemPayment redeems the voucher sent with an update to a request, returning
+// the amount it pays. It returns false if the update carries no payment
+func RedeemPayment(payee Payee, update graphsync.RequestData) (uint64, bool, error) {
+	data, ok := update.Extension(graphsync.ExtensionPayment)
+	if !ok {
+		return 0, false, nil
+	}
+	voucher, err := DecodeVoucher(data)
+	if err != nil {
+		return 0, true, err
+	}
+	amount, err := payee.RedeemVoucher(voucher)
+	if err != nil {
+		return 0, true, err
+	}
+	return amount, true, nil
+}
@@ -0,0 +1,58 @@
+package payments
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodeEncodePaymentRequest(t *testing.T) {
+	encoded, err := EncodePaymentRequest(1000)
+	require.NoError(t, err, "encode errored")
+	decoded, err := DecodePaymentRequest(encoded)
+	require.NoError(t, err, "decode errored")
+	require.Equal(t, uint64(1000), decoded)
+}
+
+func TestDecodeEncodeVoucher(t *testing.T) {
+	voucher := Voucher{Amount: 1000, Signature: []byte("signature")}
+	encoded, err := EncodeVoucher(voucher)
+	require.NoError(t, err, "encode errored")
+	decoded, err := DecodeVoucher(encoded)
+	require.NoError(t, err, "decode errored")
+	require.Equal(t, voucher, decoded)
+}
+
+func TestMockChannel(t *testing.T) {
+	channel := NewMockChannel(100)
+
+	first, err := channel.CreateVoucher(40)
+	require.NoError(t, err)
+	require.Equal(t, uint64(40), first.Amount)
+	second, err := channel.CreateVoucher(50)
+	require.NoError(t, err)
+	require.Equal(t, uint64(90), second.Amount)
+	_, err = channel.CreateVoucher(20)
+	require.Equal(t, ErrInsufficientFunds, err)
+
+	amount, err := channel.RedeemVoucher(first)
+	require.NoError(t, err)
+	require.Equal(t, uint64(40), amount)
+	amount, err = channel.RedeemVoucher(second)
+	require.NoError(t, err)
+	require.Equal(t, uint64(50), amount)
+	require.Equal(t, uint64(90), channel.Redeemed())
+
+	_, err = channel.RedeemVoucher(first)
+	require.Error(t, err, "should not redeem a voucher twice")
+	forged := Voucher{Amount: 100, Signature: second.Signature}
+	_, err = channel.RedeemVoucher(forged)
+	require.Error(t, err, "should not redeem a forged voucher")
+	require.Equal(t, uint64(90), channel.Redeemed())
+
+	other := NewMockChannel(100)
+	otherVoucher, err := other.CreateVoucher(100)
+	require.NoError(t, err)
+	_, err = channel.RedeemVoucher(otherVoucher)
+	require.Error(t, err, "should not redeem a voucher from another channel")
+}
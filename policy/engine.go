@@ -0,0 +1,131 @@
+package policy
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	logging "github.com/ipfs/go-log"
+	"github.com/libp2p/go-libp2p-core/peer"
+
+	"github.com/ipfs/go-graphsync"
+)
+
+var log = logging.Logger("graphsync")
+
+// Engine enforces a policy on incoming requests. Its policy can be replaced
+// while it runs, for example when the file it was loaded from changes
+type Engine struct {
+	lk     sync.RWMutex
+	policy *compiledPolicy
+}
+
+// NewEngine returns an engine enforcing the given policy
+func NewEngine(policy Policy) (*Engine, error) {
+	cp, err := compile(policy)
+	if err != nil {
+		return nil, err
+	}
+	return &Engine{policy: cp}, nil
+}
+
+// NewDefaultEngine returns an engine enforcing DefaultPolicy
+func NewDefaultEngine(maxRecursionDepth int) *Engine {
+	// the default policy has no peers or codecs to decode, so always compiles
+	cp, _ := compile(DefaultPolicy(maxRecursionDepth))
+	return &Engine{policy: cp}
+}
+
+// LoadFile returns an engine enforcing the policy in the given JSON or YAML
+// file
+func LoadFile(path string) (*Engine, error) {
+	policy, err := readFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewEngine(policy)
+}
+
+// SetPolicy replaces the policy the engine enforces. If the policy is invalid,
+// the engine keeps enforcing its current policy
+func (e *Engine) SetPolicy(policy Policy) error {
+	cp, err := compile(policy)
+	if err != nil {
+		return err
+	}
+	e.lk.Lock()
+	e.policy = cp
+	e.lk.Unlock()
+	return nil
+}
+
+// Reload replaces the policy the engine enforces with the one in the given
+// file
+func (e *Engine) Reload(path string) error {
+	policy, err := readFile(path)
+	if err != nil {
+		return err
+	}
+	return e.SetPolicy(policy)
+}
+
+// Watch checks the given file for changes every interval until the context is
+// cancelled, and reloads the policy when it changes. Policies that fail to load
+// are logged and ignored
+func (e *Engine) Watch(ctx context.Context, path string, interval time.Duration) {
+	var lastModified time.Time
+	if info, err := os.Stat(path); err == nil {
+		lastModified = info.ModTime()
+	}
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			info, err := os.Stat(path)
+			if err != nil {
+				log.Warnf("unable to check policy file %s: %s", path, err)
+				continue
+			}
+			if info.ModTime().Equal(lastModified) {
+				continue
+			}
+			lastModified = info.ModTime()
+			err = e.Reload(path)
+			if err != nil {
+				log.Warnf("unable to reload policy file %s: %s", path, err)
+			}
+		}
+	}()
+}
+
+// Hook returns an incoming request hook that enforces the engine's policy
+func (e *Engine) Hook() graphsync.OnIncomingRequestHook {
+	return func(p peer.ID, request graphsync.RequestData, hookActions graphsync.IncomingRequestHookActions) {
+		e.lk.RLock()
+		rule := e.policy.match(p, request, time.Now())
+		e.lk.RUnlock()
+		switch rule.action {
+		case Allow:
+			hookActions.ValidateRequest()
+		case Reject:
+			hookActions.RejectRequest(graphsync.RequestRejected, rule.reason)
+		case RejectLegal:
+			hookActions.RejectRequest(graphsync.RequestFailedLegal, rule.reason)
+		}
+	}
+}
+
+func readFile(path string) (Policy, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return Policy{}, err
+	}
+	return Parse(data)
+}
@@ -0,0 +1,218 @@
+package policy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"gopkg.in/yaml.v2"
+
+	"github.com/ipfs/go-graphsync"
+	"github.com/ipfs/go-graphsync/selectorvalidator"
+)
+
+// Action is what a policy does with a request
+type Action string
+
+const (
+	// None leaves the request to other hooks, neither validating nor rejecting it
+	None Action = ""
+	// Allow validates the request
+	Allow Action = "allow"
+	// Reject rejects the request with RequestRejected
+	Reject Action = "reject"
+	// RejectLegal rejects the request with RequestFailedLegal
+	RejectLegal Action = "reject-legal"
+)
+
+// Rule applies an action to requests that meet every one of its conditions.
+// A condition that is left empty is met by all requests
+type Rule struct {
+	Action Action `json:"action" yaml:"action"`
+	// Reason is sent to the requestor when the rule rejects a request
+	Reason string `json:"reason,omitempty" yaml:"reason,omitempty"`
+	// Peers are the base58 encoded IDs of the requestors the rule applies to
+	Peers []string `json:"peers,omitempty" yaml:"peers,omitempty"`
+	// RootPrefixes are prefixes of the string form of the root CIDs the rule
+	// applies to
+	RootPrefixes []string `json:"rootPrefixes,omitempty" yaml:"rootPrefixes,omitempty"`
+	// Codecs are the names of the root CID codecs the rule applies to, such as
+	// "protobuf", "cbor" or "raw"
+	Codecs []string `json:"codecs,omitempty" yaml:"codecs,omitempty"`
+	// MaxRecursionDepth, when set, limits the rule to requests whose selector
+	// only has recursions limited to at most this depth
+	MaxRecursionDepth *int `json:"maxRecursionDepth,omitempty" yaml:"maxRecursionDepth,omitempty"`
+	// Selector, when set, limits the rule to requests whose selector meets the
+	// selector policy
+	Selector *selectorvalidator.SelectorPolicy `json:"selector,omitempty" yaml:"selector,omitempty"`
+	// NotBefore and NotAfter limit the rule to requests received between them
+	NotBefore *time.Time `json:"notBefore,omitempty" yaml:"notBefore,omitempty"`
+	NotAfter  *time.Time `json:"notAfter,omitempty" yaml:"notAfter,omitempty"`
+}
+
+// Policy is an ordered list of rules. The first rule a request meets decides
+// what happens to it, and requests that meet no rule get the default action
+type Policy struct {
+	Default       Action `json:"default,omitempty" yaml:"default,omitempty"`
+	DefaultReason string `json:"defaultReason,omitempty" yaml:"defaultReason,omitempty"`
+	Rules         []Rule `json:"rules,omitempty" yaml:"rules,omitempty"`
+}
+
+// DefaultPolicy returns the policy graphsync uses unless it is given another:
+// it allows requests whose selectors only recurse to at most maxRecursionDepth,
+// and leaves all other requests to other hooks
+func DefaultPolicy(maxRecursionDepth int) Policy {
+	return Policy{
+		Rules: []Rule{
+			{Action: Allow, MaxRecursionDepth: &maxRecursionDepth},
+		},
+	}
+}
+
+// Parse reads a policy from JSON or YAML data
+func Parse(data []byte) (Policy, error) {
+	var policy Policy
+	var err error
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(&policy)
+	} else {
+		err = yaml.UnmarshalStrict(data, &policy)
+	}
+	if err != nil {
+		return Policy{}, err
+	}
+	return policy, nil
+}
+
+type compiledRule struct {
+	action            Action
+	reason            string
+	peers             map[peer.ID]struct{}
+	rootPrefixes      []string
+	codecs            map[uint64]struct{}
+	maxRecursionDepth *int
+	selector          *selectorvalidator.SelectorPolicy
+	notBefore         *time.Time
+	notAfter          *time.Time
+}
+
+type compiledPolicy struct {
+	rules       []compiledRule
+	defaultRule compiledRule
+}
+
+func compile(policy Policy) (*compiledPolicy, error) {
+	if err := checkAction(policy.Default); err != nil {
+		return nil, err
+	}
+	cp := &compiledPolicy{
+		defaultRule: compiledRule{action: policy.Default, reason: policy.DefaultReason},
+	}
+	for i, rule := range policy.Rules {
+		cr, err := compileRule(rule)
+		if err != nil {
+			return nil, fmt.Errorf("rule %d: %s", i, err)
+		}
+		cp.rules = append(cp.rules, cr)
+	}
+	return cp, nil
+}
+
+func compileRule(rule Rule) (compiledRule, error) {
+	if err := checkAction(rule.Action); err != nil {
+		return compiledRule{}, err
+	}
+	cr := compiledRule{
+		action:            rule.Action,
+		reason:            rule.Reason,
+		rootPrefixes:      rule.RootPrefixes,
+		maxRecursionDepth: rule.MaxRecursionDepth,
+		selector:          rule.Selector,
+		notBefore:         rule.NotBefore,
+		notAfter:          rule.NotAfter,
+	}
+	if len(rule.Peers) > 0 {
+		cr.peers = make(map[peer.ID]struct{}, len(rule.Peers))
+		for _, p := range rule.Peers {
+			id, err := peer.IDB58Decode(p)
+			if err != nil {
+				return compiledRule{}, fmt.Errorf("invalid peer %s: %s", p, err)
+			}
+			cr.peers[id] = struct{}{}
+		}
+	}
+	if len(rule.Codecs) > 0 {
+		cr.codecs = make(map[uint64]struct{}, len(rule.Codecs))
+		for _, name := range rule.Codecs {
+			codec, ok := cid.Codecs[name]
+			if !ok {
+				return compiledRule{}, fmt.Errorf("unknown codec %s", name)
+			}
+			cr.codecs[codec] = struct{}{}
+		}
+	}
+	return cr, nil
+}
+
+func checkAction(action Action) error {
+	switch action {
+	case None, Allow, Reject, RejectLegal:
+		return nil
+	default:
+		return fmt.Errorf("unknown action %s", action)
+	}
+}
+
+func (cp *compiledPolicy) match(p peer.ID, request graphsync.RequestData, now time.Time) compiledRule {
+	for _, rule := range cp.rules {
+		if rule.matches(p, request, now) {
+			return rule
+		}
+	}
+	return cp.defaultRule
+}
+
+func (cr compiledRule) matches(p peer.ID, request graphsync.RequestData, now time.Time) bool {
+	if cr.peers != nil {
+		if _, ok := cr.peers[p]; !ok {
+			return false
+		}
+	}
+	if cr.notBefore != nil && now.Before(*cr.notBefore) {
+		return false
+	}
+	if cr.notAfter != nil && now.After(*cr.notAfter) {
+		return false
+	}
+	if len(cr.rootPrefixes) > 0 && !hasAnyPrefix(request.Root().String(), cr.rootPrefixes) {
+		return false
+	}
+	if cr.codecs != nil {
+		if _, ok := cr.codecs[request.Root().Type()]; !ok {
+			return false
+		}
+	}
+	if cr.maxRecursionDepth != nil &&
+		selectorvalidator.ValidateMaxRecursionDepth(request.Selector(), *cr.maxRecursionDepth) != nil {
+		return false
+	}
+	if cr.selector != nil && cr.selector.Validate(request.Selector()) != nil {
+		return false
+	}
+	return true
+}
+
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+	return false
+}
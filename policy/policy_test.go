@@ -0,0 +1,296 @@
+package policy
+
+import (
+	"context"
+	"crypto/rand"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	ipld "github.com/ipld/go-ipld-prime"
+	basicnode "github.com/ipld/go-ipld-prime/node/basic"
+	"github.com/ipld/go-ipld-prime/traversal"
+	"github.com/ipld/go-ipld-prime/traversal/selector"
+	"github.com/ipld/go-ipld-prime/traversal/selector/builder"
+	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ipfs/go-graphsync"
+	gsmsg "github.com/ipfs/go-graphsync/message"
+	"github.com/ipfs/go-graphsync/selectorvalidator"
+	"github.com/ipfs/go-graphsync/testutil"
+)
+
+type fakeHookActions struct {
+	validated    bool
+	rejectStatus graphsync.ResponseStatusCode
+	rejectReason string
+}
+
+func (fha *fakeHookActions) SendExtensionData(graphsync.ExtensionData)                          {}
+func (fha *fakeHookActions) UsePersistenceOption(name string)                                   {}
+func (fha *fakeHookActions) UseLinkTargetNodeStyleChooser(traversal.LinkTargetNodeStyleChooser) {}
+func (fha *fakeHookActions) TerminateWithError(err error)                                       {}
+func (fha *fakeHookActions) ValidateRequest()                                                   { fha.validated = true }
+func (fha *fakeHookActions) PauseResponse()                                                     {}
+func (fha *fakeHookActions) SetPeerBandwidthLimit(bytesPerSecond uint64)                        {}
+func (fha *fakeHookActions) RedirectRequest(peers ...peer.AddrInfo)                             {}
+func (fha *fakeHookActions) RequestPayment(amount uint64)                                       {}
+func (fha *fakeHookActions) RejectRequest(status graphsync.ResponseStatusCode, reason string) {
+	fha.rejectStatus = status
+	fha.rejectReason = reason
+}
+
+func generatePeer(t *testing.T) peer.ID {
+	_, pubKey, err := crypto.GenerateEd25519Key(rand.Reader)
+	require.NoError(t, err)
+	p, err := peer.IDFromPublicKey(pubKey)
+	require.NoError(t, err)
+	return p
+}
+
+func recursiveSelector(limit selector.RecursionLimit) ipld.Node {
+	ssb := builder.NewSelectorSpecBuilder(basicnode.Style.Any)
+	return ssb.ExploreRecursive(limit, ssb.ExploreAll(ssb.ExploreRecursiveEdge())).Node()
+}
+
+func TestParse(t *testing.T) {
+	jsonPolicy := `{
+		"default": "reject",
+		"defaultReason": "not allowed",
+		"rules": [
+			{"action": "allow", "peers": ["QmcgpsyWgH8Y8ajJz1Cu72KnS5uo2Aa2LpzU7kinSupNKC"], "maxRecursionDepth": 10},
+			{"action": "reject-legal", "reason": "takedown", "rootPrefixes": ["QmBad"], "codecs": ["protobuf"]}
+		]
+	}`
+	yamlPolicy := `
+default: reject
+defaultReason: not allowed
+rules:
+  - action: allow
+    peers: [QmcgpsyWgH8Y8ajJz1Cu72KnS5uo2Aa2LpzU7kinSupNKC]
+    maxRecursionDepth: 10
+  - action: reject-legal
+    reason: takedown
+    rootPrefixes: [QmBad]
+    codecs: [protobuf]
+`
+	for name, data := range map[string]string{"json": jsonPolicy, "yaml": yamlPolicy} {
+		t.Run(name, func(t *testing.T) {
+			policy, err := Parse([]byte(data))
+			require.NoError(t, err)
+			require.Equal(t, Reject, policy.Default)
+			require.Equal(t, "not allowed", policy.DefaultReason)
+			require.Len(t, policy.Rules, 2)
+			require.Equal(t, Allow, policy.Rules[0].Action)
+			require.Equal(t, []string{"QmcgpsyWgH8Y8ajJz1Cu72KnS5uo2Aa2LpzU7kinSupNKC"}, policy.Rules[0].Peers)
+			require.Equal(t, 10, *policy.Rules[0].MaxRecursionDepth)
+			require.Equal(t, RejectLegal, policy.Rules[1].Action)
+			require.Equal(t, "takedown", policy.Rules[1].Reason)
+			require.Equal(t, []string{"QmBad"}, policy.Rules[1].RootPrefixes)
+			require.Equal(t, []string{"protobuf"}, policy.Rules[1].Codecs)
+			_, err = NewEngine(policy)
+			require.NoError(t, err)
+		})
+	}
+
+	_, err := Parse([]byte(`{"rules": [{"action": "allow", "unknown": true}]}`))
+	require.Error(t, err, "should not parse unknown fields")
+
+	_, err = NewEngine(Policy{Rules: []Rule{{Action: "maybe"}}})
+	require.Error(t, err, "should not compile unknown actions")
+	_, err = NewEngine(Policy{Rules: []Rule{{Action: Allow, Peers: []string{"not a peer"}}}})
+	require.Error(t, err, "should not compile invalid peers")
+	_, err = NewEngine(Policy{Rules: []Rule{{Action: Allow, Codecs: []string{"not a codec"}}}})
+	require.Error(t, err, "should not compile unknown codecs")
+}
+
+func TestEngineHook(t *testing.T) {
+	allowedPeer := generatePeer(t)
+	otherPeer := generatePeer(t)
+	root := testutil.GenerateCids(1)[0]
+	rawRoot := cid.NewCidV1(cid.Raw, root.Hash())
+	shallowSelector := recursiveSelector(selector.RecursionLimitDepth(5))
+	deepSelector := recursiveSelector(selector.RecursionLimitNone())
+	past := time.Now().Add(-time.Hour)
+	future := time.Now().Add(time.Hour)
+	maxDepth := 10
+
+	testCases := map[string]struct {
+		policy         Policy
+		peer           peer.ID
+		root           cid.Cid
+		selector       ipld.Node
+		expectedStatus graphsync.ResponseStatusCode
+		expectedReason string
+		validated      bool
+	}{
+		"no rules leaves request alone": {
+			policy: Policy{},
+		},
+		"default action": {
+			policy:         Policy{Default: Reject, DefaultReason: "go away"},
+			expectedStatus: graphsync.RequestRejected,
+			expectedReason: "go away",
+		},
+		"peer rule": {
+			policy: Policy{
+				Default: Reject,
+				Rules:   []Rule{{Action: Allow, Peers: []string{allowedPeer.Pretty()}}},
+			},
+			validated: true,
+		},
+		"peer rule for other peer": {
+			policy: Policy{
+				Default: Reject,
+				Rules:   []Rule{{Action: Allow, Peers: []string{allowedPeer.Pretty()}}},
+			},
+			peer:           otherPeer,
+			expectedStatus: graphsync.RequestRejected,
+		},
+		"root prefix rule": {
+			policy: Policy{
+				Rules: []Rule{{Action: RejectLegal, Reason: "takedown", RootPrefixes: []string{root.String()[:10]}}},
+			},
+			expectedStatus: graphsync.RequestFailedLegal,
+			expectedReason: "takedown",
+		},
+		"codec rule": {
+			policy: Policy{
+				Default: Allow,
+				Rules:   []Rule{{Action: Reject, Codecs: []string{"raw"}}},
+			},
+			root:           rawRoot,
+			expectedStatus: graphsync.RequestRejected,
+		},
+		"codec rule for other codec": {
+			policy: Policy{
+				Default: Allow,
+				Rules:   []Rule{{Action: Reject, Codecs: []string{"raw"}}},
+			},
+			validated: true,
+		},
+		"selector rule": {
+			policy: Policy{
+				Default: Reject,
+				Rules:   []Rule{{Action: Allow, MaxRecursionDepth: &maxDepth}},
+			},
+			validated: true,
+		},
+		"selector rule for deep selector": {
+			policy: Policy{
+				Default: Reject,
+				Rules:   []Rule{{Action: Allow, MaxRecursionDepth: &maxDepth}},
+			},
+			selector:       deepSelector,
+			expectedStatus: graphsync.RequestRejected,
+		},
+		"selector policy rule": {
+			policy: Policy{
+				Default: Reject,
+				Rules:   []Rule{{Action: Allow, Selector: &selectorvalidator.SelectorPolicy{MaxCost: 1 << 24}}},
+			},
+			validated: true,
+		},
+		"selector policy rule for costly selector": {
+			policy: Policy{
+				Default:       Reject,
+				DefaultReason: "too costly",
+				Rules:         []Rule{{Action: Allow, Selector: &selectorvalidator.SelectorPolicy{MaxCost: 1 << 24}}},
+			},
+			selector:       deepSelector,
+			expectedStatus: graphsync.RequestRejected,
+			expectedReason: "too costly",
+		},
+		"time window rule": {
+			policy: Policy{
+				Rules: []Rule{{Action: Allow, NotBefore: &past, NotAfter: &future}},
+			},
+			validated: true,
+		},
+		"time window rule that has ended": {
+			policy: Policy{
+				Default: Reject,
+				Rules:   []Rule{{Action: Allow, NotAfter: &past}},
+			},
+			expectedStatus: graphsync.RequestRejected,
+		},
+		"first matching rule wins": {
+			policy: Policy{
+				Rules: []Rule{
+					{Action: Reject, Peers: []string{otherPeer.Pretty()}},
+					{Action: Allow},
+					{Action: Reject},
+				},
+			},
+			validated: true,
+		},
+	}
+	for testCase, data := range testCases {
+		t.Run(testCase, func(t *testing.T) {
+			p := allowedPeer
+			if data.peer != "" {
+				p = data.peer
+			}
+			requestRoot := root
+			if data.root.Defined() {
+				requestRoot = data.root
+			}
+			requestSelector := shallowSelector
+			if data.selector != nil {
+				requestSelector = data.selector
+			}
+			engine, err := NewEngine(data.policy)
+			require.NoError(t, err)
+			request := gsmsg.NewRequest(graphsync.RequestID(1), requestRoot, requestSelector, graphsync.Priority(0))
+			hookActions := &fakeHookActions{}
+			engine.Hook()(p, request, hookActions)
+			require.Equal(t, data.validated, hookActions.validated)
+			require.Equal(t, data.expectedStatus, hookActions.rejectStatus)
+			require.Equal(t, data.expectedReason, hookActions.rejectReason)
+		})
+	}
+}
+
+func TestEngineReload(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	dir, err := ioutil.TempDir("", "policy")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "policy.yaml")
+	require.NoError(t, ioutil.WriteFile(path, []byte("default: allow\n"), 0644))
+
+	engine, err := LoadFile(path)
+	require.NoError(t, err)
+	p := generatePeer(t)
+	request := gsmsg.NewRequest(graphsync.RequestID(1), testutil.GenerateCids(1)[0], recursiveSelector(selector.RecursionLimitDepth(5)), graphsync.Priority(0))
+	hookActions := &fakeHookActions{}
+	engine.Hook()(p, request, hookActions)
+	require.True(t, hookActions.validated)
+
+	engine.Watch(ctx, path, 10*time.Millisecond)
+
+	// an invalid policy is ignored
+	require.NoError(t, ioutil.WriteFile(path, []byte("default: maybe\n"), 0644))
+	require.NoError(t, os.Chtimes(path, time.Now(), time.Now().Add(time.Minute)))
+	time.Sleep(50 * time.Millisecond)
+	hookActions = &fakeHookActions{}
+	engine.Hook()(p, request, hookActions)
+	require.True(t, hookActions.validated)
+
+	require.NoError(t, ioutil.WriteFile(path, []byte("default: reject\ndefaultReason: closed\n"), 0644))
+	require.NoError(t, os.Chtimes(path, time.Now(), time.Now().Add(2*time.Minute)))
+	require.Eventually(t, func() bool {
+		hookActions = &fakeHookActions{}
+		engine.Hook()(p, request, hookActions)
+		return hookActions.rejectStatus == graphsync.RequestRejected
+	}, 2*time.Second, 10*time.Millisecond)
+	require.Equal(t, "closed", hookActions.rejectReason)
+}
@@ -0,0 +1,81 @@
+package redirect
+
+import (
+	"github.com/ipld/go-ipld-prime/fluent"
+	basicnode "github.com/ipld/go-ipld-prime/node/basic"
+	"github.com/libp2p/go-libp2p-core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+
+	"github.com/ipfs/go-graphsync/ipldutil"
+)
+
+// EncodeRedirect returns encoded cbor data for the peers a request is
+// redirected to, for the redirect extension
+func EncodeRedirect(peers []peer.AddrInfo) ([]byte, error) {
+	node, err := fluent.Build(basicnode.Style.List, func(na fluent.NodeAssembler) {
+		na.CreateList(len(peers), func(na fluent.ListAssembler) {
+			for _, addrInfo := range peers {
+				na.AssembleValue().CreateMap(2, func(na fluent.MapAssembler) {
+					na.AssembleEntry("peer").AssignBytes([]byte(addrInfo.ID))
+					na.AssembleEntry("addrs").CreateList(len(addrInfo.Addrs), func(na fluent.ListAssembler) {
+						for _, addr := range addrInfo.Addrs {
+							na.AssembleValue().AssignBytes(addr.Bytes())
+						}
+					})
+				})
+			}
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ipldutil.EncodeNode(node)
+}
+
+// DecodeRedirect returns the peers a request is redirected to, decoded from
+// data for the redirect extension
+func DecodeRedirect(data []byte) ([]peer.AddrInfo, error) {
+	node, err := ipldutil.DecodeNode(data)
+	if err != nil {
+		return nil, err
+	}
+	var peers []peer.AddrInfo
+	iterator := node.ListIterator()
+	for !iterator.Done() {
+		_, item, err := iterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		peerNode, err := item.LookupString("peer")
+		if err != nil {
+			return nil, err
+		}
+		peerData, err := peerNode.AsBytes()
+		if err != nil {
+			return nil, err
+		}
+		addrsNode, err := item.LookupString("addrs")
+		if err != nil {
+			return nil, err
+		}
+		var addrs []ma.Multiaddr
+		addrsIterator := addrsNode.ListIterator()
+		for !addrsIterator.Done() {
+			_, addrNode, err := addrsIterator.Next()
+			if err != nil {
+				return nil, err
+			}
+			addrData, err := addrNode.AsBytes()
+			if err != nil {
+				return nil, err
+			}
+			addr, err := ma.NewMultiaddrBytes(addrData)
+			if err != nil {
+				return nil, err
+			}
+			addrs = append(addrs, addr)
+		}
+		peers = append(peers, peer.AddrInfo{ID: peer.ID(peerData), Addrs: addrs})
+	}
+	return peers, nil
+}
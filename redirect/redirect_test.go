@@ -0,0 +1,31 @@
+package redirect
+
+import (
+	"testing"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ipfs/go-graphsync/testutil"
+)
+
+func TestDecodeEncodeRedirect(t *testing.T) {
+	peerIDs := testutil.GeneratePeers(2)
+	addr, err := ma.NewMultiaddr("/ip4/127.0.0.1/tcp/4001")
+	require.NoError(t, err)
+	peers := []peer.AddrInfo{
+		{ID: peerIDs[0], Addrs: []ma.Multiaddr{addr}},
+		{ID: peerIDs[1]},
+	}
+	encoded, err := EncodeRedirect(peers)
+	require.NoError(t, err, "encode errored")
+	decoded, err := DecodeRedirect(encoded)
+	require.NoError(t, err, "decode errored")
+	require.Len(t, decoded, 2)
+	require.Equal(t, peerIDs[0], decoded[0].ID)
+	require.Len(t, decoded[0].Addrs, 1)
+	require.True(t, addr.Equal(decoded[0].Addrs[0]))
+	require.Equal(t, peerIDs[1], decoded[1].ID)
+	require.Empty(t, decoded[1].Addrs)
+}
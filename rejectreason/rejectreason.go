@@ -0,0 +1,29 @@
+package rejectreason
+
+import (
+	basicnode "github.com/ipld/go-ipld-prime/node/basic"
+
+	"github.com/ipfs/go-graphsync/ipldutil"
+)
+
+// EncodeRejectReason returns encoded cbor data for the reason a request was
+// rejected
+func EncodeRejectReason(reason string) ([]byte, error) {
+	nb := basicnode.Style.String.NewBuilder()
+	err := nb.AssignString(reason)
+	if err != nil {
+		return nil, err
+	}
+	nd := nb.Build()
+	return ipldutil.EncodeNode(nd)
+}
+
+// DecodeRejectReason returns the reason a request was rejected decoded from
+// cbor data
+func DecodeRejectReason(data []byte) (string, error) {
+	nd, err := ipldutil.DecodeNode(data)
+	if err != nil {
+		return "", err
+	}
+	return nd.AsString()
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"io/ioutil"
+	"sync/atomic"
 
 	blocks "github.com/ipfs/go-block-format"
 	"github.com/ipld/go-ipld-prime"
@@ -16,6 +17,10 @@ import (
 	"github.com/ipfs/go-graphsync/requestmanager/types"
 )
 
+// defaultMaxStagedBytes is how much a single staged request may hold in its
+// staging area unless set otherwise
+const defaultMaxStagedBytes = 256 << 20
+
 type loaderMessage interface {
 	handle(al *AsyncLoader)
 }
@@ -23,11 +28,17 @@ type loaderMessage interface {
 type alternateQueue struct {
 	responseCache    *responsecache.ResponseCache
 	loadAttemptQueue *loadattemptqueue.LoadAttemptQueue
+	loader           ipld.Loader
+	storer           ipld.Storer
 }
 
 // AsyncLoader manages loading links asynchronously in as new responses
 // come in from the network
 type AsyncLoader struct {
+	// bufferedBytes is accessed atomically, and must stay first in the struct
+	// for 64-bit alignment
+	bufferedBytes uint64
+
 	ctx              context.Context
 	cancel           context.CancelFunc
 	incomingMessages chan loaderMessage
@@ -38,16 +49,29 @@ type AsyncLoader struct {
 	activeRequests   map[graphsync.RequestID]struct{}
 	requestQueues    map[graphsync.RequestID]string
 	alternateQueues  map[string]alternateQueue
+	stagingAreas     map[graphsync.RequestID]*stagingArea
 	responseCache    *responsecache.ResponseCache
 	loadAttemptQueue *loadattemptqueue.LoadAttemptQueue
+
+	memoryBudget     *unverifiedblockstore.MemoryBudget
+	exhaustionPolicy unverifiedblockstore.ExhaustionPolicy
+	maxStagedBytes   uint64
+	storeStatus      atomic.Value
+	// storeChanged is set when a store's stats change, so the store status is
+	// only worked out again after messages that changed it
+	storeChanged bool
+}
+
+type storeStatus struct {
+	stats     unverifiedblockstore.Stats
+	exhausted bool
 }
 
 // New initializes a new link loading manager for asynchronous loads from the given context
 // and local store loading and storing function
 func New(ctx context.Context, loader ipld.Loader, storer ipld.Storer) *AsyncLoader {
-	responseCache, loadAttemptQueue := setupAttemptQueue(loader, storer)
 	ctx, cancel := context.WithCancel(ctx)
-	return &AsyncLoader{
+	al := &AsyncLoader{
 		ctx:              ctx,
 		cancel:           cancel,
 		incomingMessages: make(chan loaderMessage),
@@ -57,9 +81,33 @@ func New(ctx context.Context, loader ipld.Loader, storer ipld.Storer) *AsyncLoad
 		activeRequests:   make(map[graphsync.RequestID]struct{}),
 		requestQueues:    make(map[graphsync.RequestID]string),
 		alternateQueues:  make(map[string]alternateQueue),
-		responseCache:    responseCache,
-		loadAttemptQueue: loadAttemptQueue,
+		stagingAreas:     make(map[graphsync.RequestID]*stagingArea),
+		maxStagedBytes:   defaultMaxStagedBytes,
 	}
+	al.responseCache, al.loadAttemptQueue = setupAttemptQueue(loader, storer, nil, unverifiedblockstore.PauseWhenExhausted, nil, al.markStoreChanged)
+	al.storeStatus.Store(storeStatus{})
+	return al
+}
+
+// SetUnverifiedBlockBudget sets the most memory blocks received but not yet
+// verified may take up, shared by all persistence options and staged requests,
+// and what to do with blocks that do not fit. Blocks for requests using the default persistence option are
+// spilled to the given spill store, if any, before the exhaustion policy applies.
+// It must be called before Startup
+func (al *AsyncLoader) SetUnverifiedBlockBudget(memoryBudget uint64,
+	exhaustionPolicy unverifiedblockstore.ExhaustionPolicy,
+	spillStore unverifiedblockstore.SpillStore) {
+	al.memoryBudget = unverifiedblockstore.NewMemoryBudget(memoryBudget)
+	al.exhaustionPolicy = exhaustionPolicy
+	al.responseCache, al.loadAttemptQueue = setupAttemptQueue(al.defaultLoader, al.defaultStorer, al.memoryBudget, exhaustionPolicy, spillStore, al.markStoreChanged)
+}
+
+// SetMaxStagedBytes sets how much a single staged request may hold in its
+// staging area before its blocks are committed. Staged requests that verify
+// more fail with ErrStagingAreaFull. Zero means no limit. It must be called
+// before Startup
+func (al *AsyncLoader) SetMaxStagedBytes(maxStagedBytes uint64) {
+	al.maxStagedBytes = maxStagedBytes
 }
 
 // Startup starts processing of messages
@@ -78,6 +126,9 @@ func (al *AsyncLoader) RegisterPersistenceOption(name string, loader ipld.Loader
 	if name == "" {
 		return errors.New("Persistence option must have a name")
 	}
+	if isStagedQueueName(name) {
+		return errors.New("Persistence option names starting with " + stagedQueuePrefix + " are reserved")
+	}
 	response := make(chan error, 1)
 	err := al.sendSyncMessage(&registerPersistenceOptionMessage{name, loader, storer, response}, response)
 	return err
@@ -97,7 +148,25 @@ func (al *AsyncLoader) UnregisterPersistenceOption(name string) error {
 // continually attempt to load links for this request as new responses come in
 func (al *AsyncLoader) StartRequest(requestID graphsync.RequestID, persistenceOption string) error {
 	response := make(chan error, 1)
-	err := al.sendSyncMessage(&startRequestMessage{requestID, persistenceOption, response}, response)
+	err := al.sendSyncMessage(&startRequestMessage{requestID, persistenceOption, false, response}, response)
+	return err
+}
+
+// StartStagedRequest starts a request like StartRequest, but holds the blocks
+// it verifies in a staging area rather than writing them to the persistence
+// option's store. They are written when CommitRequest is called, or discarded
+// when the request is cleaned up
+func (al *AsyncLoader) StartStagedRequest(requestID graphsync.RequestID, persistenceOption string) error {
+	response := make(chan error, 1)
+	err := al.sendSyncMessage(&startRequestMessage{requestID, persistenceOption, true, response}, response)
+	return err
+}
+
+// CommitRequest writes the blocks staged for the given request to its
+// persistence option's store, and writes any further blocks straight through
+func (al *AsyncLoader) CommitRequest(requestID graphsync.RequestID) error {
+	response := make(chan error, 1)
+	err := al.sendSyncMessage(&commitRequestMessage{requestID, response}, response)
 	return err
 }
 
@@ -111,6 +180,24 @@ func (al *AsyncLoader) ProcessResponse(responses map[graphsync.RequestID]metadat
 	}
 }
 
+// BufferedBytes returns the total size of blocks received from the network that
+// have not yet been verified as part of a traversal
+func (al *AsyncLoader) BufferedBytes() uint64 {
+	return atomic.LoadUint64(&al.bufferedBytes)
+}
+
+// UnverifiedBlockStats returns the current size of the stores holding blocks
+// received but not yet verified, across all persistence options
+func (al *AsyncLoader) UnverifiedBlockStats() unverifiedblockstore.Stats {
+	return al.storeStatus.Load().(storeStatus).stats
+}
+
+// MemoryExhausted returns true if blocks have been dropped for not fitting within
+// the unverified block budget since the store they were received for last drained
+func (al *AsyncLoader) MemoryExhausted() bool {
+	return al.storeStatus.Load().(storeStatus).exhausted
+}
+
 // AsyncLoad asynchronously loads the given link for the given request ID. It returns a channel for data and a channel
 // for errors -- only one message will be sent over either.
 func (al *AsyncLoader) AsyncLoad(requestID graphsync.RequestID, link ipld.Link) <-chan types.AsyncLoadResult {
@@ -185,9 +272,15 @@ type unregisterPersistenceOptionMessage struct {
 type startRequestMessage struct {
 	requestID         graphsync.RequestID
 	persistenceOption string
+	staged            bool
 	response          chan error
 }
 
+type commitRequestMessage struct {
+	requestID graphsync.RequestID
+	response  chan error
+}
+
 type finishRequestMessage struct {
 	requestID graphsync.RequestID
 }
@@ -203,10 +296,34 @@ func (al *AsyncLoader) run() {
 			return
 		case message := <-al.outgoingMessages:
 			message.handle(al)
+			if al.storeChanged {
+				al.storeChanged = false
+				al.updateStoreStatus()
+			}
 		}
 	}
 }
 
+func (al *AsyncLoader) markStoreChanged() {
+	al.storeChanged = true
+}
+
+func (al *AsyncLoader) updateStoreStatus() {
+	status := storeStatus{
+		stats:     al.responseCache.Stats(),
+		exhausted: al.responseCache.MemoryExhausted(),
+	}
+	for _, alternateQueue := range al.alternateQueues {
+		stats := alternateQueue.responseCache.Stats()
+		status.stats.MemoryBytes += stats.MemoryBytes
+		status.stats.SpilledBytes += stats.SpilledBytes
+		status.stats.DroppedBlocks += stats.DroppedBlocks
+		status.exhausted = status.exhausted || alternateQueue.responseCache.MemoryExhausted()
+	}
+	atomic.StoreUint64(&al.bufferedBytes, status.stats.MemoryBytes)
+	al.storeStatus.Store(status)
+}
+
 func (al *AsyncLoader) messageQueueWorker() {
 	var messageBuffer []loaderMessage
 	nextMessage := func() loaderMessage {
@@ -262,8 +379,8 @@ func (rpom *registerPersistenceOptionMessage) register(al *AsyncLoader) error {
 	if existing {
 		return errors.New("already registerd a persistence option with this name")
 	}
-	responseCache, loadAttemptQueue := setupAttemptQueue(rpom.loader, rpom.storer)
-	al.alternateQueues[rpom.name] = alternateQueue{responseCache, loadAttemptQueue}
+	responseCache, loadAttemptQueue := setupAttemptQueue(rpom.loader, rpom.storer, al.memoryBudget, al.exhaustionPolicy, nil, al.markStoreChanged)
+	al.alternateQueues[rpom.name] = alternateQueue{responseCache, loadAttemptQueue, rpom.loader, rpom.storer}
 	return nil
 }
 
@@ -277,7 +394,7 @@ func (rpom *registerPersistenceOptionMessage) handle(al *AsyncLoader) {
 
 func (upom *unregisterPersistenceOptionMessage) unregister(al *AsyncLoader) error {
 	_, ok := al.alternateQueues[upom.name]
-	if !ok {
+	if !ok || isStagedQueueName(upom.name) {
 		return errors.New("Unknown persistence option")
 	}
 	for _, requestQueue := range al.requestQueues {
@@ -285,7 +402,13 @@ func (upom *unregisterPersistenceOptionMessage) unregister(al *AsyncLoader) erro
 			return errors.New("cannot unregister while requests are in progress")
 		}
 	}
+	for _, stagingArea := range al.stagingAreas {
+		if upom.name == stagingArea.persistenceOption {
+			return errors.New("cannot unregister while requests are in progress")
+		}
+	}
 	delete(al.alternateQueues, upom.name)
+	al.storeChanged = true
 	return nil
 }
 
@@ -300,15 +423,47 @@ func (upom *unregisterPersistenceOptionMessage) handle(al *AsyncLoader) {
 func (srm *startRequestMessage) startRequest(al *AsyncLoader) error {
 	if srm.persistenceOption != "" {
 		_, ok := al.alternateQueues[srm.persistenceOption]
-		if !ok {
+		if !ok || isStagedQueueName(srm.persistenceOption) {
 			return errors.New("Unknown persistence option")
 		}
 		al.requestQueues[srm.requestID] = srm.persistenceOption
 	}
+	if srm.staged {
+		al.stageRequest(srm.requestID, srm.persistenceOption)
+	}
 	al.activeRequests[srm.requestID] = struct{}{}
 	return nil
 }
 
+// stageRequest gives a request its own queue, whose store is a staging area in
+// front of the persistence option's store
+func (al *AsyncLoader) stageRequest(requestID graphsync.RequestID, persistenceOption string) {
+	loader, storer := al.defaultLoader, al.defaultStorer
+	if persistenceOption != "" {
+		loader, storer = al.alternateQueues[persistenceOption].loader, al.alternateQueues[persistenceOption].storer
+	}
+	stagingArea := newStagingArea(persistenceOption, loader, storer, al.maxStagedBytes)
+	queue := stagedQueueName(requestID)
+	responseCache, loadAttemptQueue := setupAttemptQueue(stagingArea.load, stagingArea.store, al.memoryBudget, al.exhaustionPolicy, nil, al.markStoreChanged)
+	al.alternateQueues[queue] = alternateQueue{responseCache, loadAttemptQueue, stagingArea.load, stagingArea.store}
+	al.requestQueues[requestID] = queue
+	al.stagingAreas[requestID] = stagingArea
+}
+
+func (crm *commitRequestMessage) handle(al *AsyncLoader) {
+	var err error
+	stagingArea, ok := al.stagingAreas[crm.requestID]
+	if ok {
+		err = stagingArea.commit()
+	} else {
+		err = errors.New("request is not staged")
+	}
+	select {
+	case <-al.ctx.Done():
+	case crm.response <- err:
+	}
+}
+
 func (srm *startRequestMessage) handle(al *AsyncLoader) {
 	err := srm.startRequest(al)
 	select {
@@ -346,14 +501,29 @@ func (crm *cleanupRequestMessage) handle(al *AsyncLoader) {
 	if ok {
 		al.alternateQueues[aq].responseCache.FinishRequest(crm.requestID)
 		delete(al.requestQueues, crm.requestID)
+		if _, isStaged := al.stagingAreas[crm.requestID]; isStaged {
+			// uncommitted blocks are discarded with the staging area
+			delete(al.alternateQueues, aq)
+			delete(al.stagingAreas, crm.requestID)
+			al.storeChanged = true
+		}
 		return
 	}
 	al.responseCache.FinishRequest(crm.requestID)
 }
 
-func setupAttemptQueue(loader ipld.Loader, storer ipld.Storer) (*responsecache.ResponseCache, *loadattemptqueue.LoadAttemptQueue) {
+func setupAttemptQueue(loader ipld.Loader, storer ipld.Storer,
+	memoryBudget *unverifiedblockstore.MemoryBudget,
+	exhaustionPolicy unverifiedblockstore.ExhaustionPolicy,
+	spillStore unverifiedblockstore.SpillStore,
+	onChange func()) (*responsecache.ResponseCache, *loadattemptqueue.LoadAttemptQueue) {
 
 	unverifiedBlockStore := unverifiedblockstore.New(storer)
+	unverifiedBlockStore.SetMemoryBudget(memoryBudget, exhaustionPolicy)
+	unverifiedBlockStore.SetChangeListener(onChange)
+	if spillStore != nil {
+		unverifiedBlockStore.SetSpillStore(spillStore)
+	}
 	responseCache := responsecache.New(unverifiedBlockStore)
 	loadAttemptQueue := loadattemptqueue.New(func(requestID graphsync.RequestID, link ipld.Link) types.AsyncLoadResult {
 		// load from response cache
@@ -2,6 +2,7 @@ package asyncloader
 
 import (
 	"context"
+	"errors"
 	"io"
 	"math/rand"
 	"testing"
@@ -14,6 +15,7 @@ import (
 
 	"github.com/ipfs/go-graphsync"
 	"github.com/ipfs/go-graphsync/metadata"
+	"github.com/ipfs/go-graphsync/requestmanager/asyncloader/unverifiedblockstore"
 	"github.com/ipfs/go-graphsync/requestmanager/types"
 	"github.com/ipfs/go-graphsync/testutil"
 )
@@ -55,6 +57,69 @@ func TestAsyncLoadInitialLoadSucceedsResponsePresent(t *testing.T) {
 	})
 }
 
+func TestBufferedBytes(t *testing.T) {
+	blocks := testutil.GenerateBlocksOfSize(1, 100)
+	block := blocks[0]
+	link := cidlink.Link{Cid: block.Cid()}
+
+	st := newStore()
+	withLoader(st, func(ctx context.Context, asyncLoader *AsyncLoader) {
+		requestID := graphsync.RequestID(rand.Int31())
+		err := asyncLoader.StartRequest(requestID, "")
+		require.NoError(t, err)
+		responses := map[graphsync.RequestID]metadata.Metadata{
+			requestID: metadata.Metadata{
+				metadata.Item{
+					Link:         link,
+					BlockPresent: true,
+				},
+			},
+		}
+		asyncLoader.ProcessResponse(responses, blocks)
+		// messages are processed in order, so this waits for the response to be processed
+		err = asyncLoader.StartRequest(requestID, "")
+		require.NoError(t, err)
+		require.Equal(t, uint64(100), asyncLoader.BufferedBytes())
+
+		resultChan := asyncLoader.AsyncLoad(requestID, link)
+		assertSuccessResponse(ctx, t, resultChan)
+		err = asyncLoader.StartRequest(requestID, "")
+		require.NoError(t, err)
+		require.Equal(t, uint64(0), asyncLoader.BufferedBytes())
+	})
+}
+
+func TestUnverifiedBlockBudget(t *testing.T) {
+	blocks := testutil.GenerateBlocksOfSize(2, 100)
+	st := newStore()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	asyncLoader := New(ctx, st.loader, st.storer)
+	asyncLoader.SetUnverifiedBlockBudget(100, unverifiedblockstore.DropWhenExhausted, nil)
+	asyncLoader.Startup()
+
+	requestID := graphsync.RequestID(rand.Int31())
+	err := asyncLoader.StartRequest(requestID, "")
+	require.NoError(t, err)
+	md := make(metadata.Metadata, 0, len(blocks))
+	for _, block := range blocks {
+		md = append(md, metadata.Item{Link: cidlink.Link{Cid: block.Cid()}, BlockPresent: true})
+	}
+	asyncLoader.ProcessResponse(map[graphsync.RequestID]metadata.Metadata{requestID: md}, blocks)
+	// messages are processed in order, so this waits for the response to be processed
+	err = asyncLoader.StartRequest(requestID, "")
+	require.NoError(t, err)
+	require.Equal(t, unverifiedblockstore.Stats{MemoryBytes: 100, DroppedBlocks: 1}, asyncLoader.UnverifiedBlockStats())
+	require.True(t, asyncLoader.MemoryExhausted())
+
+	resultChan := asyncLoader.AsyncLoad(requestID, cidlink.Link{Cid: blocks[0].Cid()})
+	assertSuccessResponse(ctx, t, resultChan)
+	err = asyncLoader.StartRequest(requestID, "")
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), asyncLoader.BufferedBytes())
+	require.False(t, asyncLoader.MemoryExhausted())
+}
+
 func TestAsyncLoadInitialLoadFails(t *testing.T) {
 	st := newStore()
 	withLoader(st, func(ctx context.Context, asyncLoader *AsyncLoader) {
@@ -216,8 +281,170 @@ func TestRegisterUnregister(t *testing.T) {
 		requestID3 := graphsync.RequestID(rand.Int31())
 		err = asyncLoader.StartRequest(requestID3, "other")
 		require.EqualError(t, err, "Unknown persistence option")
+
+		// staged requests' queues can't be registered or used as persistence options
+		err = asyncLoader.RegisterPersistenceOption(stagedQueueName(requestID3), otherSt.loader, otherSt.storer)
+		require.EqualError(t, err, "Persistence option names starting with graphsync/staged/ are reserved")
+		err = asyncLoader.StartStagedRequest(requestID3, "")
+		require.NoError(t, err)
+		requestID4 := graphsync.RequestID(rand.Int31())
+		err = asyncLoader.StartRequest(requestID4, stagedQueueName(requestID3))
+		require.EqualError(t, err, "Unknown persistence option")
+		err = asyncLoader.UnregisterPersistenceOption(stagedQueueName(requestID3))
+		require.EqualError(t, err, "Unknown persistence option")
 	})
 }
+func TestStagedRequest(t *testing.T) {
+	st := newStore()
+	blocks := testutil.GenerateBlocksOfSize(2, 100)
+	link1 := cidlink.Link{Cid: blocks[0].Cid()}
+	link2 := cidlink.Link{Cid: blocks[1].Cid()}
+	withLoader(st, func(ctx context.Context, asyncLoader *AsyncLoader) {
+		requestID1 := graphsync.RequestID(rand.Int31())
+		err := asyncLoader.CommitRequest(requestID1)
+		require.EqualError(t, err, "request is not staged")
+
+		err = asyncLoader.StartStagedRequest(requestID1, "")
+		require.NoError(t, err)
+		responses := map[graphsync.RequestID]metadata.Metadata{
+			requestID1: metadata.Metadata{
+				metadata.Item{
+					Link:         link1,
+					BlockPresent: true,
+				},
+			},
+		}
+		asyncLoader.ProcessResponse(responses, blocks[:1])
+		resultChan := asyncLoader.AsyncLoad(requestID1, link1)
+		assertSuccessResponse(ctx, t, resultChan)
+		require.Empty(t, st.blockstore, "should not store block before commit")
+
+		// staged blocks are loaded again from the staging area
+		resultChan = asyncLoader.AsyncLoad(requestID1, link1)
+		assertSuccessResponse(ctx, t, resultChan)
+		st.AssertLocalLoads(t, 0)
+
+		err = asyncLoader.CommitRequest(requestID1)
+		require.NoError(t, err)
+		st.AssertBlockStored(t, blocks[0])
+		asyncLoader.CompleteResponsesFor(requestID1)
+		asyncLoader.CleanupRequest(requestID1)
+
+		requestID2 := graphsync.RequestID(rand.Int31())
+		err = asyncLoader.StartStagedRequest(requestID2, "")
+		require.NoError(t, err)
+		responses = map[graphsync.RequestID]metadata.Metadata{
+			requestID2: metadata.Metadata{
+				metadata.Item{
+					Link:         link2,
+					BlockPresent: true,
+				},
+			},
+		}
+		asyncLoader.ProcessResponse(responses, blocks[1:])
+		resultChan = asyncLoader.AsyncLoad(requestID2, link2)
+		assertSuccessResponse(ctx, t, resultChan)
+		asyncLoader.CompleteResponsesFor(requestID2)
+		asyncLoader.CleanupRequest(requestID2)
+		require.NotContains(t, st.blockstore, ipld.Link(link2), "should discard uncommitted blocks")
+	})
+}
+
+func TestStagedRequestFailedCommit(t *testing.T) {
+	st := newStore()
+	failing := true
+	failingStorer := func(lnkCtx ipld.LinkContext) (io.Writer, ipld.StoreCommitter, error) {
+		if failing {
+			return nil, nil, errors.New("store unavailable")
+		}
+		return st.storer(lnkCtx)
+	}
+	blocks := testutil.GenerateBlocksOfSize(2, 100)
+	link1 := cidlink.Link{Cid: blocks[0].Cid()}
+	link2 := cidlink.Link{Cid: blocks[1].Cid()}
+	withLoader(st, func(ctx context.Context, asyncLoader *AsyncLoader) {
+		err := asyncLoader.RegisterPersistenceOption("failing", st.loader, failingStorer)
+		require.NoError(t, err)
+		requestID := graphsync.RequestID(rand.Int31())
+		err = asyncLoader.StartStagedRequest(requestID, "failing")
+		require.NoError(t, err)
+		responses := map[graphsync.RequestID]metadata.Metadata{
+			requestID: metadata.Metadata{
+				metadata.Item{
+					Link:         link1,
+					BlockPresent: true,
+				},
+			},
+		}
+		asyncLoader.ProcessResponse(responses, blocks[:1])
+		resultChan := asyncLoader.AsyncLoad(requestID, link1)
+		assertSuccessResponse(ctx, t, resultChan)
+
+		err = asyncLoader.CommitRequest(requestID)
+		require.EqualError(t, err, "store unavailable")
+		require.Empty(t, st.blockstore, "should not store blocks")
+
+		// blocks are still staged after a failed commit
+		responses = map[graphsync.RequestID]metadata.Metadata{
+			requestID: metadata.Metadata{
+				metadata.Item{
+					Link:         link2,
+					BlockPresent: true,
+				},
+			},
+		}
+		asyncLoader.ProcessResponse(responses, blocks[1:])
+		resultChan = asyncLoader.AsyncLoad(requestID, link2)
+		assertSuccessResponse(ctx, t, resultChan)
+		require.Empty(t, st.blockstore, "should not store blocks")
+
+		failing = false
+		err = asyncLoader.CommitRequest(requestID)
+		require.NoError(t, err)
+		st.AssertBlockStored(t, blocks[0])
+		st.AssertBlockStored(t, blocks[1])
+	})
+}
+
+func TestStagedRequestFull(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	st := newStore()
+	asyncLoader := New(ctx, st.loader, st.storer)
+	asyncLoader.SetMaxStagedBytes(150)
+	asyncLoader.Startup()
+
+	blocks := testutil.GenerateBlocksOfSize(2, 100)
+	link1 := cidlink.Link{Cid: blocks[0].Cid()}
+	link2 := cidlink.Link{Cid: blocks[1].Cid()}
+	requestID := graphsync.RequestID(rand.Int31())
+	err := asyncLoader.StartStagedRequest(requestID, "")
+	require.NoError(t, err)
+	responses := map[graphsync.RequestID]metadata.Metadata{
+		requestID: metadata.Metadata{
+			metadata.Item{
+				Link:         link1,
+				BlockPresent: true,
+			},
+			metadata.Item{
+				Link:         link2,
+				BlockPresent: true,
+			},
+		},
+	}
+	asyncLoader.ProcessResponse(responses, blocks)
+	resultChan := asyncLoader.AsyncLoad(requestID, link1)
+	assertSuccessResponse(ctx, t, resultChan)
+	// the second block doesn't fit, so the request fails
+	resultChan = asyncLoader.AsyncLoad(requestID, link2)
+	var result types.AsyncLoadResult
+	testutil.AssertReceive(ctx, t, resultChan, &result, "should close response channel with response")
+	require.Nil(t, result.Data, "should not send responses")
+	require.EqualError(t, result.Err, ErrStagingAreaFull.Error())
+	require.Empty(t, st.blockstore, "should not store blocks")
+}
+
 func TestRequestSplittingLoadLocallyFromBlockstore(t *testing.T) {
 	st := newStore()
 	otherSt := newStore()
@@ -12,6 +12,7 @@ import (
 	"github.com/ipfs/go-graphsync"
 	"github.com/ipfs/go-graphsync/linktracker"
 	"github.com/ipfs/go-graphsync/metadata"
+	"github.com/ipfs/go-graphsync/requestmanager/asyncloader/unverifiedblockstore"
 )
 
 var log = logging.Logger("graphsync")
@@ -22,6 +23,9 @@ type UnverifiedBlockStore interface {
 	PruneBlocks(func(ipld.Link) bool)
 	VerifyBlock(ipld.Link) ([]byte, error)
 	AddUnverifiedBlock(ipld.Link, []byte)
+	DataSize() uint64
+	Stats() unverifiedblockstore.Stats
+	Exhausted() bool
 }
 
 // ResponseCache maintains a store of unverified blocks and response
@@ -53,6 +57,28 @@ func (rc *ResponseCache) FinishRequest(requestID graphsync.RequestID) {
 	rc.responseCacheLk.Unlock()
 }
 
+// BufferedBytes returns the total size of blocks received but not yet verified
+func (rc *ResponseCache) BufferedBytes() uint64 {
+	rc.responseCacheLk.RLock()
+	defer rc.responseCacheLk.RUnlock()
+	return rc.unverifiedBlockStore.DataSize()
+}
+
+// Stats returns the current size of the unverified block store
+func (rc *ResponseCache) Stats() unverifiedblockstore.Stats {
+	rc.responseCacheLk.RLock()
+	defer rc.responseCacheLk.RUnlock()
+	return rc.unverifiedBlockStore.Stats()
+}
+
+// MemoryExhausted returns true if the unverified block store has run out of
+// memory for blocks and has not yet drained
+func (rc *ResponseCache) MemoryExhausted() bool {
+	rc.responseCacheLk.RLock()
+	defer rc.responseCacheLk.RUnlock()
+	return rc.unverifiedBlockStore.Exhausted()
+}
+
 // AttemptLoad attempts to laod the given block from the cache
 func (rc *ResponseCache) AttemptLoad(requestID graphsync.RequestID, link ipld.Link) ([]byte, error) {
 	rc.responseCacheLk.Lock()
@@ -60,7 +86,12 @@ func (rc *ResponseCache) AttemptLoad(requestID graphsync.RequestID, link ipld.Li
 	if rc.linkTracker.IsKnownMissingLink(requestID, link) {
 		return nil, fmt.Errorf("Remote Peer Is Missing Block: %s", link.String())
 	}
-	data, _ := rc.unverifiedBlockStore.VerifyBlock(link)
+	data, err := rc.unverifiedBlockStore.VerifyBlock(link)
+	// blocks not received yet may arrive later, but failing to store a received
+	// block fails the load
+	if err != nil && err != unverifiedblockstore.ErrBlockNotFound {
+		return nil, err
+	}
 	return data, nil
 }
 
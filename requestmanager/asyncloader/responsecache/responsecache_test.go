@@ -1,7 +1,6 @@
 package responsecache
 
 import (
-	"fmt"
 	"math/rand"
 	"testing"
 
@@ -12,6 +11,7 @@ import (
 
 	"github.com/ipfs/go-graphsync"
 	"github.com/ipfs/go-graphsync/metadata"
+	"github.com/ipfs/go-graphsync/requestmanager/asyncloader/unverifiedblockstore"
 	"github.com/ipfs/go-graphsync/testutil"
 )
 
@@ -34,12 +34,28 @@ func (ubs *fakeUnverifiedBlockStore) PruneBlocks(shouldPrune func(ipld.Link) boo
 func (ubs *fakeUnverifiedBlockStore) VerifyBlock(lnk ipld.Link) ([]byte, error) {
 	data, ok := ubs.inMemoryBlocks[lnk]
 	if !ok {
-		return nil, fmt.Errorf("Block not found")
+		return nil, unverifiedblockstore.ErrBlockNotFound
 	}
 	delete(ubs.inMemoryBlocks, lnk)
 	return data, nil
 }
 
+func (ubs *fakeUnverifiedBlockStore) DataSize() uint64 {
+	size := uint64(0)
+	for _, data := range ubs.inMemoryBlocks {
+		size += uint64(len(data))
+	}
+	return size
+}
+
+func (ubs *fakeUnverifiedBlockStore) Stats() unverifiedblockstore.Stats {
+	return unverifiedblockstore.Stats{MemoryBytes: ubs.DataSize()}
+}
+
+func (ubs *fakeUnverifiedBlockStore) Exhausted() bool {
+	return false
+}
+
 func (ubs *fakeUnverifiedBlockStore) blocks() []blocks.Block {
 	blks := make([]blocks.Block, 0, len(ubs.inMemoryBlocks))
 	for link, data := range ubs.inMemoryBlocks {
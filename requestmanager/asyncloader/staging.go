@@ -0,0 +1,108 @@
+package asyncloader
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	ipld "github.com/ipld/go-ipld-prime"
+
+	"github.com/ipfs/go-graphsync"
+)
+
+// ErrStagingAreaFull means a staged request verified more blocks than fit in
+// its staging area before they were committed, and fails the request
+var ErrStagingAreaFull = errors.New("staging area full")
+
+// stagingArea holds the blocks verified for a single staged request until they
+// are committed to the destination store, or discarded with the request. Once
+// committed, further blocks are written straight through to the destination.
+// It is only accessed from the async loader's run loop
+type stagingArea struct {
+	persistenceOption string
+	loader            ipld.Loader
+	storer            ipld.Storer
+	blocks            map[ipld.Link][]byte
+	order             []ipld.Link
+	size              uint64
+	maxSize           uint64
+	committed         bool
+}
+
+func newStagingArea(persistenceOption string, loader ipld.Loader, storer ipld.Storer, maxSize uint64) *stagingArea {
+	return &stagingArea{
+		persistenceOption: persistenceOption,
+		loader:            loader,
+		storer:            storer,
+		blocks:            make(map[ipld.Link][]byte),
+		maxSize:           maxSize,
+	}
+}
+
+// stagedQueuePrefix starts the names of the queues staged requests are given,
+// which persistence options can't use so the two never collide
+const stagedQueuePrefix = "graphsync/staged/"
+
+func stagedQueueName(requestID graphsync.RequestID) string {
+	return fmt.Sprintf("%s%d", stagedQueuePrefix, requestID)
+}
+
+func isStagedQueueName(name string) bool {
+	return strings.HasPrefix(name, stagedQueuePrefix)
+}
+
+// load reads staged blocks first, then the destination store
+func (sa *stagingArea) load(lnk ipld.Link, lnkCtx ipld.LinkContext) (io.Reader, error) {
+	if data, ok := sa.blocks[lnk]; ok {
+		return bytes.NewReader(data), nil
+	}
+	return sa.loader(lnk, lnkCtx)
+}
+
+func (sa *stagingArea) store(lnkCtx ipld.LinkContext) (io.Writer, ipld.StoreCommitter, error) {
+	if sa.committed {
+		return sa.storer(lnkCtx)
+	}
+	var buffer bytes.Buffer
+	return &buffer, func(lnk ipld.Link) error {
+		if _, ok := sa.blocks[lnk]; ok {
+			return nil
+		}
+		size := uint64(buffer.Len())
+		if sa.maxSize > 0 && sa.size+size > sa.maxSize {
+			return ErrStagingAreaFull
+		}
+		sa.order = append(sa.order, lnk)
+		sa.blocks[lnk] = buffer.Bytes()
+		sa.size += size
+		return nil
+	}, nil
+}
+
+// commit writes staged blocks to the destination store in the order they were
+// verified, and writes any further blocks straight through once they are all
+// written. If a write fails, the blocks not yet written stay staged
+func (sa *stagingArea) commit() error {
+	for len(sa.order) > 0 {
+		lnk := sa.order[0]
+		buffer, committer, err := sa.storer(ipld.LinkContext{})
+		if err != nil {
+			return err
+		}
+		_, err = buffer.Write(sa.blocks[lnk])
+		if err != nil {
+			return err
+		}
+		err = committer(lnk)
+		if err != nil {
+			return err
+		}
+		sa.size -= uint64(len(sa.blocks[lnk]))
+		delete(sa.blocks, lnk)
+		sa.order = sa.order[1:]
+	}
+	sa.committed = true
+	return nil
+}
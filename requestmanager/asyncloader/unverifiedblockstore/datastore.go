@@ -0,0 +1,38 @@
+package unverifiedblockstore
+
+import (
+	"github.com/ipfs/go-datastore"
+	ipld "github.com/ipld/go-ipld-prime"
+)
+
+// DatastoreSpillStore is a SpillStore that holds blocks in a go-datastore, such
+// as a flatfs or leveldb datastore in a temporary directory
+type DatastoreSpillStore struct {
+	ds datastore.Datastore
+}
+
+var _ SpillStore = (*DatastoreSpillStore)(nil)
+
+// NewDatastoreSpillStore returns a new spill store backed by the given datastore
+func NewDatastoreSpillStore(ds datastore.Datastore) *DatastoreSpillStore {
+	return &DatastoreSpillStore{ds}
+}
+
+func key(lnk ipld.Link) datastore.Key {
+	return datastore.NewKey(lnk.String())
+}
+
+// Put holds the data for the given link
+func (dss *DatastoreSpillStore) Put(lnk ipld.Link, data []byte) error {
+	return dss.ds.Put(key(lnk), data)
+}
+
+// Get returns the data held for the given link
+func (dss *DatastoreSpillStore) Get(lnk ipld.Link) ([]byte, error) {
+	return dss.ds.Get(key(lnk))
+}
+
+// Delete removes the data held for the given link
+func (dss *DatastoreSpillStore) Delete(lnk ipld.Link) error {
+	return dss.ds.Delete(key(lnk))
+}
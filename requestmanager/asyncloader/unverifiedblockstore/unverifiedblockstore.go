@@ -1,16 +1,91 @@
 package unverifiedblockstore
 
 import (
-	"fmt"
+	"errors"
 
+	logging "github.com/ipfs/go-log"
 	ipld "github.com/ipld/go-ipld-prime"
+
+	"github.com/ipfs/go-graphsync"
+)
+
+var log = logging.Logger("graphsync")
+
+// ErrBlockNotFound means a block to verify has not been received, or was
+// dropped
+var ErrBlockNotFound = errors.New("Block not found")
+
+// SpillStore holds unverified blocks that do not fit within the memory budget
+// of an UnverifiedBlockStore, typically on disk
+type SpillStore interface {
+	Put(ipld.Link, []byte) error
+	Get(ipld.Link) ([]byte, error)
+	Delete(ipld.Link) error
+}
+
+// ExhaustionPolicy determines what happens to a block that does not fit within
+// the memory budget and cannot be spilled
+type ExhaustionPolicy int
+
+const (
+	// PauseWhenExhausted keeps the block in memory anyway, so requests can be
+	// paused until the store drains back within its budget
+	PauseWhenExhausted ExhaustionPolicy = iota
+	// DropWhenExhausted discards the block, and marks the store exhausted so
+	// requests can be paused and the block requested again once it drains
+	DropWhenExhausted
 )
 
+// Stats reports the current size of an unverified block store
+type Stats = graphsync.UnverifiedBlockStats
+
+// MemoryBudget is the most memory blocks held in memory may take up, which can
+// be shared by several stores so they are limited together. Stores sharing a
+// budget must only be used from one goroutine
+type MemoryBudget struct {
+	limit     uint64
+	used      uint64
+	exhausted bool
+}
+
+// NewMemoryBudget returns a budget of the given number of bytes. Zero means no
+// budget
+func NewMemoryBudget(limit uint64) *MemoryBudget {
+	return &MemoryBudget{limit: limit}
+}
+
+func (mb *MemoryBudget) fits(size uint64) bool {
+	return mb == nil || mb.limit == 0 || mb.used+size <= mb.limit
+}
+
+func (mb *MemoryBudget) add(size uint64) {
+	if mb != nil {
+		mb.used += size
+	}
+}
+
+func (mb *MemoryBudget) remove(size uint64) {
+	if mb != nil {
+		mb.used -= size
+		if mb.exhausted && mb.used <= mb.limit/2 {
+			mb.exhausted = false
+		}
+	}
+}
+
 // UnverifiedBlockStore holds an in memory cache of receied blocks from the network
 // that have not been verified to be part of a traversal
 type UnverifiedBlockStore struct {
-	inMemoryBlocks map[ipld.Link][]byte
-	storer         ipld.Storer
+	inMemoryBlocks   map[ipld.Link][]byte
+	spilledBlocks    map[ipld.Link]uint64
+	storer           ipld.Storer
+	dataSize         uint64
+	memoryBudget     *MemoryBudget
+	exhaustionPolicy ExhaustionPolicy
+	spillStore       SpillStore
+	spilledSize      uint64
+	droppedBlocks    uint64
+	onChange         func()
 }
 
 // New initializes a new unverified store with the given storer function for writing
@@ -18,14 +93,92 @@ type UnverifiedBlockStore struct {
 func New(storer ipld.Storer) *UnverifiedBlockStore {
 	return &UnverifiedBlockStore{
 		inMemoryBlocks: make(map[ipld.Link][]byte),
+		spilledBlocks:  make(map[ipld.Link]uint64),
 		storer:         storer,
 	}
 }
 
+// SetMemoryBudget sets the budget for the memory blocks held in the store take
+// up, and what to do with blocks that do not fit. A nil budget means no budget
+func (ubs *UnverifiedBlockStore) SetMemoryBudget(memoryBudget *MemoryBudget, exhaustionPolicy ExhaustionPolicy) {
+	ubs.memoryBudget = memoryBudget
+	ubs.exhaustionPolicy = exhaustionPolicy
+}
+
+// SetSpillStore sets where blocks that do not fit within the memory budget are
+// held, before falling back to the exhaustion policy
+func (ubs *UnverifiedBlockStore) SetSpillStore(spillStore SpillStore) {
+	ubs.spillStore = spillStore
+}
+
+// SetChangeListener sets a function called each time the store's stats change
+func (ubs *UnverifiedBlockStore) SetChangeListener(onChange func()) {
+	ubs.onChange = onChange
+}
+
+func (ubs *UnverifiedBlockStore) changed() {
+	if ubs.onChange != nil {
+		ubs.onChange()
+	}
+}
+
 // AddUnverifiedBlock adds a new unverified block to the in memory cache as it
-// comes in as part of a traversal.
+// comes in as part of a traversal. A block is always kept in memory when the
+// store holds no others, so a block larger than the budget can't stall the
+// traversal waiting for room that never comes
 func (ubs *UnverifiedBlockStore) AddUnverifiedBlock(lnk ipld.Link, data []byte) {
+	ubs.removeBlock(lnk)
+	size := uint64(len(data))
+	if ubs.dataSize == 0 || ubs.memoryBudget.fits(size) {
+		ubs.addInMemory(lnk, data)
+		return
+	}
+	if ubs.spillStore != nil {
+		err := ubs.spillStore.Put(lnk, data)
+		if err == nil {
+			ubs.spilledBlocks[lnk] = size
+			ubs.spilledSize += size
+			ubs.changed()
+			return
+		}
+		log.Warnf("unable to spill block %s: %s", lnk.String(), err)
+	}
+	if ubs.exhaustionPolicy == DropWhenExhausted {
+		log.Debugf("unverified block memory exhausted, dropping block %s", lnk.String())
+		ubs.memoryBudget.exhausted = true
+		ubs.droppedBlocks++
+		ubs.changed()
+		return
+	}
+	ubs.addInMemory(lnk, data)
+}
+
+func (ubs *UnverifiedBlockStore) addInMemory(lnk ipld.Link, data []byte) {
 	ubs.inMemoryBlocks[lnk] = data
+	ubs.dataSize += uint64(len(data))
+	ubs.memoryBudget.add(uint64(len(data)))
+	ubs.changed()
+}
+
+// DataSize returns the total size of the blocks held in memory
+func (ubs *UnverifiedBlockStore) DataSize() uint64 {
+	return ubs.dataSize
+}
+
+// Stats returns the current size of the store
+func (ubs *UnverifiedBlockStore) Stats() Stats {
+	return Stats{
+		MemoryBytes:   ubs.dataSize,
+		SpilledBytes:  ubs.spilledSize,
+		DroppedBlocks: ubs.droppedBlocks,
+	}
+}
+
+// Exhausted returns true if blocks have been dropped, by this or any store
+// sharing its budget, since the blocks held in memory under the budget last
+// drained to half of it
+func (ubs *UnverifiedBlockStore) Exhausted() bool {
+	return ubs.memoryBudget != nil && ubs.memoryBudget.exhausted
 }
 
 // PruneBlocks removes blocks from the unverified store without committing them,
@@ -33,7 +186,12 @@ func (ubs *UnverifiedBlockStore) AddUnverifiedBlock(lnk ipld.Link, data []byte)
 func (ubs *UnverifiedBlockStore) PruneBlocks(shouldPrune func(ipld.Link) bool) {
 	for link := range ubs.inMemoryBlocks {
 		if shouldPrune(link) {
-			delete(ubs.inMemoryBlocks, link)
+			ubs.removeBlock(link)
+		}
+	}
+	for link := range ubs.spilledBlocks {
+		if shouldPrune(link) {
+			ubs.removeBlock(link)
 		}
 	}
 }
@@ -41,11 +199,11 @@ func (ubs *UnverifiedBlockStore) PruneBlocks(shouldPrune func(ipld.Link) bool) {
 // VerifyBlock verifies the data for the given link as being part of a traversal,
 // removes it from the unverified store, and writes it to permaneant storage.
 func (ubs *UnverifiedBlockStore) VerifyBlock(lnk ipld.Link) ([]byte, error) {
-	data, ok := ubs.inMemoryBlocks[lnk]
-	if !ok {
-		return nil, fmt.Errorf("Block not found")
+	data, err := ubs.getBlock(lnk)
+	if err != nil {
+		return nil, err
 	}
-	delete(ubs.inMemoryBlocks, lnk)
+	ubs.removeBlock(lnk)
 	buffer, committer, err := ubs.storer(ipld.LinkContext{})
 	if err != nil {
 		return nil, err
@@ -60,3 +218,31 @@ func (ubs *UnverifiedBlockStore) VerifyBlock(lnk ipld.Link) ([]byte, error) {
 	}
 	return data, nil
 }
+
+func (ubs *UnverifiedBlockStore) getBlock(lnk ipld.Link) ([]byte, error) {
+	if data, ok := ubs.inMemoryBlocks[lnk]; ok {
+		return data, nil
+	}
+	if _, ok := ubs.spilledBlocks[lnk]; ok {
+		return ubs.spillStore.Get(lnk)
+	}
+	return nil, ErrBlockNotFound
+}
+
+func (ubs *UnverifiedBlockStore) removeBlock(lnk ipld.Link) {
+	if data, ok := ubs.inMemoryBlocks[lnk]; ok {
+		delete(ubs.inMemoryBlocks, lnk)
+		ubs.dataSize -= uint64(len(data))
+		ubs.memoryBudget.remove(uint64(len(data)))
+		ubs.changed()
+	}
+	if size, ok := ubs.spilledBlocks[lnk]; ok {
+		delete(ubs.spilledBlocks, lnk)
+		ubs.spilledSize -= size
+		ubs.changed()
+		err := ubs.spillStore.Delete(lnk)
+		if err != nil {
+			log.Warnf("unable to remove spilled block %s: %s", lnk.String(), err)
+		}
+	}
+}
@@ -5,6 +5,8 @@ import (
 	"io"
 	"testing"
 
+	"github.com/ipfs/go-datastore"
+	dss "github.com/ipfs/go-datastore/sync"
 	"github.com/ipld/go-ipld-prime"
 	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
 	"github.com/stretchr/testify/require"
@@ -44,3 +46,138 @@ func TestVerifyBlockPresent(t *testing.T) {
 	require.Nil(t, data)
 	require.Error(t, err, "block cannot be verified twice")
 }
+
+func TestDataSize(t *testing.T) {
+	blocksWritten := make(map[ipld.Link][]byte)
+	_, storer := testutil.NewTestStore(blocksWritten)
+	unverifiedBlockStore := New(storer)
+	blks := testutil.GenerateBlocksOfSize(3, 100)
+	require.Equal(t, uint64(0), unverifiedBlockStore.DataSize())
+
+	for _, block := range blks {
+		unverifiedBlockStore.AddUnverifiedBlock(cidlink.Link{Cid: block.Cid()}, block.RawData())
+	}
+	require.Equal(t, uint64(300), unverifiedBlockStore.DataSize())
+
+	// adding the same block twice does not count it twice
+	unverifiedBlockStore.AddUnverifiedBlock(cidlink.Link{Cid: blks[0].Cid()}, blks[0].RawData())
+	require.Equal(t, uint64(300), unverifiedBlockStore.DataSize())
+
+	_, err := unverifiedBlockStore.VerifyBlock(cidlink.Link{Cid: blks[0].Cid()})
+	require.NoError(t, err)
+	require.Equal(t, uint64(200), unverifiedBlockStore.DataSize())
+
+	unverifiedBlockStore.PruneBlocks(func(link ipld.Link) bool {
+		return link.(cidlink.Link).Cid.Equals(blks[1].Cid())
+	})
+	require.Equal(t, uint64(100), unverifiedBlockStore.DataSize())
+}
+
+func TestChangeListener(t *testing.T) {
+	blocksWritten := make(map[ipld.Link][]byte)
+	_, storer := testutil.NewTestStore(blocksWritten)
+	unverifiedBlockStore := New(storer)
+	unverifiedBlockStore.SetMemoryBudget(NewMemoryBudget(100), DropWhenExhausted)
+	changes := 0
+	unverifiedBlockStore.SetChangeListener(func() { changes++ })
+	blks := testutil.GenerateBlocksOfSize(2, 100)
+
+	unverifiedBlockStore.AddUnverifiedBlock(cidlink.Link{Cid: blks[0].Cid()}, blks[0].RawData())
+	require.Equal(t, 1, changes)
+	unverifiedBlockStore.AddUnverifiedBlock(cidlink.Link{Cid: blks[1].Cid()}, blks[1].RawData())
+	require.Equal(t, 2, changes, "should change when dropping a block")
+
+	_, err := unverifiedBlockStore.VerifyBlock(cidlink.Link{Cid: blks[1].Cid()})
+	require.Error(t, err)
+	unverifiedBlockStore.PruneBlocks(func(ipld.Link) bool { return false })
+	require.Equal(t, 2, changes, "should not change when no blocks are removed")
+
+	_, err = unverifiedBlockStore.VerifyBlock(cidlink.Link{Cid: blks[0].Cid()})
+	require.NoError(t, err)
+	require.Equal(t, 3, changes)
+}
+
+func TestMemoryBudget(t *testing.T) {
+	blocksWritten := make(map[ipld.Link][]byte)
+	_, storer := testutil.NewTestStore(blocksWritten)
+	blks := testutil.GenerateBlocksOfSize(4, 100)
+
+	t.Run("pause when exhausted", func(t *testing.T) {
+		unverifiedBlockStore := New(storer)
+		unverifiedBlockStore.SetMemoryBudget(NewMemoryBudget(200), PauseWhenExhausted)
+		for _, block := range blks {
+			unverifiedBlockStore.AddUnverifiedBlock(cidlink.Link{Cid: block.Cid()}, block.RawData())
+		}
+		require.False(t, unverifiedBlockStore.Exhausted(), "no blocks should be dropped")
+		require.Equal(t, Stats{MemoryBytes: 400}, unverifiedBlockStore.Stats())
+	})
+
+	t.Run("drop when exhausted", func(t *testing.T) {
+		unverifiedBlockStore := New(storer)
+		unverifiedBlockStore.SetMemoryBudget(NewMemoryBudget(200), DropWhenExhausted)
+		for _, block := range blks {
+			unverifiedBlockStore.AddUnverifiedBlock(cidlink.Link{Cid: block.Cid()}, block.RawData())
+		}
+		require.True(t, unverifiedBlockStore.Exhausted())
+		require.Equal(t, Stats{MemoryBytes: 200, DroppedBlocks: 2}, unverifiedBlockStore.Stats())
+		_, err := unverifiedBlockStore.VerifyBlock(cidlink.Link{Cid: blks[3].Cid()})
+		require.Error(t, err, "dropped block should not be verifiable")
+
+		_, err = unverifiedBlockStore.VerifyBlock(cidlink.Link{Cid: blks[0].Cid()})
+		require.NoError(t, err)
+		require.False(t, unverifiedBlockStore.Exhausted(), "should no longer be exhausted once drained to half the budget")
+	})
+
+	t.Run("block larger than budget", func(t *testing.T) {
+		unverifiedBlockStore := New(storer)
+		unverifiedBlockStore.SetMemoryBudget(NewMemoryBudget(50), DropWhenExhausted)
+		for _, block := range blks[:2] {
+			unverifiedBlockStore.AddUnverifiedBlock(cidlink.Link{Cid: block.Cid()}, block.RawData())
+		}
+		require.Equal(t, Stats{MemoryBytes: 100, DroppedBlocks: 1}, unverifiedBlockStore.Stats(), "should keep a block when holding no others")
+		_, err := unverifiedBlockStore.VerifyBlock(cidlink.Link{Cid: blks[0].Cid()})
+		require.NoError(t, err)
+	})
+
+	t.Run("shared budget", func(t *testing.T) {
+		memoryBudget := NewMemoryBudget(300)
+		unverifiedBlockStore := New(storer)
+		unverifiedBlockStore.SetMemoryBudget(memoryBudget, DropWhenExhausted)
+		otherBlockStore := New(storer)
+		otherBlockStore.SetMemoryBudget(memoryBudget, DropWhenExhausted)
+		for _, block := range blks[:2] {
+			unverifiedBlockStore.AddUnverifiedBlock(cidlink.Link{Cid: block.Cid()}, block.RawData())
+		}
+		for _, block := range blks[2:] {
+			otherBlockStore.AddUnverifiedBlock(cidlink.Link{Cid: block.Cid()}, block.RawData())
+		}
+		require.Equal(t, Stats{MemoryBytes: 100, DroppedBlocks: 1}, otherBlockStore.Stats())
+		require.True(t, unverifiedBlockStore.Exhausted(), "should be exhausted when a store sharing the budget drops blocks")
+
+		unverifiedBlockStore.PruneBlocks(func(ipld.Link) bool { return true })
+		require.False(t, otherBlockStore.Exhausted(), "should no longer be exhausted once the budget drains to half")
+	})
+
+	t.Run("spill to store", func(t *testing.T) {
+		ds := dss.MutexWrap(datastore.NewMapDatastore())
+		unverifiedBlockStore := New(storer)
+		unverifiedBlockStore.SetMemoryBudget(NewMemoryBudget(200), DropWhenExhausted)
+		unverifiedBlockStore.SetSpillStore(NewDatastoreSpillStore(ds))
+		for _, block := range blks {
+			unverifiedBlockStore.AddUnverifiedBlock(cidlink.Link{Cid: block.Cid()}, block.RawData())
+		}
+		require.False(t, unverifiedBlockStore.Exhausted())
+		require.Equal(t, Stats{MemoryBytes: 200, SpilledBytes: 200}, unverifiedBlockStore.Stats())
+
+		data, err := unverifiedBlockStore.VerifyBlock(cidlink.Link{Cid: blks[3].Cid()})
+		require.NoError(t, err)
+		require.Equal(t, blks[3].RawData(), data, "spilled block should be returned on verification")
+		require.Equal(t, Stats{MemoryBytes: 200, SpilledBytes: 100}, unverifiedBlockStore.Stats())
+		has, err := ds.Has(datastore.NewKey(cidlink.Link{Cid: blks[3].Cid()}.String()))
+		require.NoError(t, err)
+		require.False(t, has, "verified block should be removed from the spill store")
+
+		unverifiedBlockStore.PruneBlocks(func(ipld.Link) bool { return true })
+		require.Equal(t, Stats{}, unverifiedBlockStore.Stats())
+	})
+}
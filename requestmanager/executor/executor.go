@@ -5,6 +5,7 @@ import (
 	"context"
 	"strings"
 	"sync/atomic"
+	"time"
 
 	"github.com/ipfs/go-cid"
 	ipld "github.com/ipld/go-ipld-prime"
@@ -13,9 +14,11 @@ import (
 	peer "github.com/libp2p/go-libp2p-core/peer"
 
 	"github.com/ipfs/go-graphsync"
+	"github.com/ipfs/go-graphsync/byterange"
 	"github.com/ipfs/go-graphsync/cidset"
 	"github.com/ipfs/go-graphsync/ipldutil"
 	gsmsg "github.com/ipfs/go-graphsync/message"
+	"github.com/ipfs/go-graphsync/partition"
 	"github.com/ipfs/go-graphsync/requestmanager/hooks"
 	"github.com/ipfs/go-graphsync/requestmanager/types"
 )
@@ -30,14 +33,20 @@ type ExecutionEnv struct {
 	SendRequest      func(peer.ID, gsmsg.GraphSyncRequest)
 	RunBlockHooks    func(p peer.ID, response graphsync.ResponseData, blk graphsync.BlockData) error
 	TerminateRequest func(graphsync.RequestID)
-	WaitForMessages  func(ctx context.Context, resumeMessages chan graphsync.ExtensionData) ([]graphsync.ExtensionData, error)
-	Loader           AsyncLoadFn
+	// NotifyCompletedListeners and NotifyErrorListeners report the outcome of requests
+	NotifyCompletedListeners func(p peer.ID, request graphsync.RequestData, status graphsync.ResponseStatusCode)
+	NotifyErrorListeners     func(p peer.ID, request graphsync.RequestData, err error)
+	WaitForMessages          func(ctx context.Context, resumeMessages chan graphsync.ExtensionData) ([]graphsync.ExtensionData, error)
+	Loader                   AsyncLoadFn
 }
 
 // RequestExecution are parameters for a single request execution
 type RequestExecution struct {
-	Ctx              context.Context
-	P                peer.ID
+	Ctx context.Context
+	P   peer.ID
+	// AdditionalPeers are sent the request along with P, each peer being asked
+	// for a disjoint partition of the blocks
+	AdditionalPeers  []peer.ID
 	NetworkError     chan error
 	Request          gsmsg.GraphSyncRequest
 	LastResponse     *atomic.Value
@@ -45,44 +54,93 @@ type RequestExecution struct {
 	NodeStyleChooser traversal.LinkTargetNodeStyleChooser
 	ResumeMessages   chan []graphsync.ExtensionData
 	PauseMessages    chan struct{}
+	FailoverMessages chan peer.ID
+	// DropPeerMessages tell the executor a peer stopped working on the request,
+	// so the request is sent again to the remaining peers, partitioned between
+	// them
+	DropPeerMessages chan peer.ID
+	// ResponseMessages tell the executor a response for the request arrived,
+	// which restarts the stall timeout
+	ResponseMessages chan struct{}
+	// RestartMessages tell the executor to send the request again, skipping blocks
+	// already received, once it next waits on the network
+	RestartMessages chan struct{}
+	// LocalFirst holds off sending the request until a block cannot be loaded
+	// locally, sending it then with the blocks loaded so far as do not send cids.
+	// If every block is present locally, the request is never sent
+	LocalFirst   bool
+	StallTimeout time.Duration
+	// Checkpoint, if set, is called with the current peer and the blocks received
+	// so far every CheckpointInterval, and once more when the request finishes
+	Checkpoint         func(p peer.ID, verifiedCids *cid.Set, complete bool)
+	CheckpointInterval time.Duration
+	// Commit, if set, is called once the traversal finishes with every block,
+	// before results are closed
+	Commit func() error
 }
 
 // Start begins execution of a request in a go routine
 func (ee ExecutionEnv) Start(re RequestExecution) (chan graphsync.ResponseProgress, chan error) {
 	executor := &requestExecutor{
-		inProgressChan:   make(chan graphsync.ResponseProgress),
-		inProgressErr:    make(chan error),
-		ctx:              re.Ctx,
-		p:                re.P,
-		networkError:     re.NetworkError,
-		request:          re.Request,
-		lastResponse:     re.LastResponse,
-		doNotSendCids:    re.DoNotSendCids,
-		nodeStyleChooser: re.NodeStyleChooser,
-		resumeMessages:   re.ResumeMessages,
-		pauseMessages:    re.PauseMessages,
-		env:              ee,
+		inProgressChan:     make(chan graphsync.ResponseProgress),
+		inProgressErr:      make(chan error),
+		ctx:                re.Ctx,
+		p:                  re.P,
+		additionalPeers:    re.AdditionalPeers,
+		networkError:       re.NetworkError,
+		request:            re.Request,
+		lastResponse:       re.LastResponse,
+		doNotSendCids:      re.DoNotSendCids,
+		nodeStyleChooser:   re.NodeStyleChooser,
+		resumeMessages:     re.ResumeMessages,
+		pauseMessages:      re.PauseMessages,
+		failoverMessages:   re.FailoverMessages,
+		dropPeerMessages:   re.DropPeerMessages,
+		responseMessages:   re.ResponseMessages,
+		restartMessages:    re.RestartMessages,
+		stallTimeout:       re.StallTimeout,
+		checkpoint:         re.Checkpoint,
+		checkpointInterval: re.CheckpointInterval,
+		lastCheckpoint:     time.Now(),
+		commit:             re.Commit,
+		env:                ee,
+	}
+	if re.LocalFirst {
+		executor.restartNeeded = true
+	} else {
+		executor.sendRequest(executor.request)
 	}
-	executor.sendRequest(executor.request)
 	go executor.run()
 	return executor.inProgressChan, executor.inProgressErr
 }
 
 type requestExecutor struct {
-	inProgressChan    chan graphsync.ResponseProgress
-	inProgressErr     chan error
-	ctx               context.Context
-	p                 peer.ID
-	networkError      chan error
-	request           gsmsg.GraphSyncRequest
-	lastResponse      *atomic.Value
-	nodeStyleChooser  traversal.LinkTargetNodeStyleChooser
-	resumeMessages    chan []graphsync.ExtensionData
-	pauseMessages     chan struct{}
-	doNotSendCids     *cid.Set
-	env               ExecutionEnv
-	restartNeeded     bool
-	pendingExtensions []graphsync.ExtensionData
+	inProgressChan     chan graphsync.ResponseProgress
+	inProgressErr      chan error
+	ctx                context.Context
+	p                  peer.ID
+	additionalPeers    []peer.ID
+	networkError       chan error
+	request            gsmsg.GraphSyncRequest
+	lastResponse       *atomic.Value
+	nodeStyleChooser   traversal.LinkTargetNodeStyleChooser
+	resumeMessages     chan []graphsync.ExtensionData
+	pauseMessages      chan struct{}
+	failoverMessages   chan peer.ID
+	dropPeerMessages   chan peer.ID
+	responseMessages   chan struct{}
+	restartMessages    chan struct{}
+	stallTimeout       time.Duration
+	checkpoint         func(p peer.ID, verifiedCids *cid.Set, complete bool)
+	checkpointInterval time.Duration
+	lastCheckpoint     time.Time
+	commit             func() error
+	missingBlocks      bool
+	errored            bool
+	doNotSendCids      *cid.Set
+	env                ExecutionEnv
+	restartNeeded      bool
+	pendingExtensions  []graphsync.ExtensionData
 }
 
 func (re *requestExecutor) visitor(tp traversal.Progress, node ipld.Node, tr traversal.VisitReason) error {
@@ -98,11 +156,16 @@ func (re *requestExecutor) visitor(tp traversal.Progress, node ipld.Node, tr tra
 }
 
 func (re *requestExecutor) traverse() error {
+	byteRange, err := byterange.RangeForRequest(re.request)
+	if err != nil {
+		return err
+	}
 	traverser := ipldutil.TraversalBuilder{
 		Root:     cidlink.Link{Cid: re.request.Root()},
 		Selector: re.request.Selector(),
 		Visitor:  re.visitor,
 		Chooser:  re.nodeStyleChooser,
+		Range:    byteRange,
 	}.Start(re.ctx)
 	defer traverser.Shutdown(context.Background())
 	for {
@@ -116,15 +179,10 @@ func (re *requestExecutor) traverse() error {
 		select {
 		case result = <-resultChan:
 		default:
-			err := re.sendRestartAsNeeded()
+			result, err = re.waitForResult(resultChan)
 			if err != nil {
 				return err
 			}
-			select {
-			case <-re.ctx.Done():
-				return ipldutil.ContextCancelError{}
-			case result = <-resultChan:
-			}
 		}
 		err = re.processResult(traverser, lnk, result)
 		if _, ok := err.(hooks.ErrPaused); ok {
@@ -142,31 +200,150 @@ func (re *requestExecutor) traverse() error {
 	}
 }
 
+func (re *requestExecutor) waitForResult(resultChan <-chan types.AsyncLoadResult) (types.AsyncLoadResult, error) {
+	var stallTimer *time.Timer
+	if re.stallTimeout > 0 {
+		stallTimer = time.NewTimer(re.stallTimeout)
+		defer stallTimer.Stop()
+	}
+	stalled := re.restartStallTimer(stallTimer)
+	for {
+		// a failover that arrived along with a resume goes first, so the restart
+		// is only sent to the new peer
+		select {
+		case p := <-re.failoverMessages:
+			re.failover(p)
+		case p := <-re.dropPeerMessages:
+			re.dropPeer(p)
+		default:
+		}
+		err := re.sendRestartAsNeeded()
+		if err != nil {
+			return types.AsyncLoadResult{}, err
+		}
+		select {
+		case <-re.ctx.Done():
+			return types.AsyncLoadResult{}, ipldutil.ContextCancelError{}
+		case result := <-resultChan:
+			return result, nil
+		case p := <-re.failoverMessages:
+			re.failover(p)
+			stalled = re.restartStallTimer(stallTimer)
+		case p := <-re.dropPeerMessages:
+			re.dropPeer(p)
+			stalled = re.restartStallTimer(stallTimer)
+		case <-re.restartMessages:
+			re.restartNeeded = true
+			stalled = re.restartStallTimer(stallTimer)
+		case <-re.responseMessages:
+			stalled = re.restartStallTimer(stallTimer)
+		case <-stalled:
+			re.sendRequest(gsmsg.CancelRequest(re.request.ID()))
+			return types.AsyncLoadResult{}, graphsync.RequestStalledErr{}
+		}
+	}
+}
+
+// restartStallTimer starts the stall timeout over, returning the channel it
+// fires on. The timeout doesn't run while the responder has paused the request
+func (re *requestExecutor) restartStallTimer(stallTimer *time.Timer) <-chan time.Time {
+	if stallTimer == nil {
+		return nil
+	}
+	if !stallTimer.Stop() {
+		select {
+		case <-stallTimer.C:
+		default:
+		}
+	}
+	switch re.lastResponse.Load().(gsmsg.GraphSyncResponse).Status() {
+	case graphsync.RequestPaused, graphsync.NotEnoughGas:
+		return nil
+	}
+	stallTimer.Reset(re.stallTimeout)
+	return stallTimer.C
+}
+
 func (re *requestExecutor) run() {
 	err := re.traverse()
-	if err != nil {
-		if !isContextErr(err) {
-			select {
-			case <-re.ctx.Done():
-			case re.inProgressErr <- err:
-			}
-		}
+	if err != nil && !isContextErr(err) {
+		re.sendError(re.ctx.Done(), err)
 	}
+	complete := err == nil && !re.missingBlocks
 	select {
 	case networkError := <-re.networkError:
-		select {
-		case re.inProgressErr <- networkError:
-		case <-re.env.Ctx.Done():
-		}
+		complete = false
+		re.sendError(re.env.Ctx.Done(), networkError)
 	default:
 	}
+	if complete && re.commit != nil {
+		err := re.commit()
+		if err != nil {
+			complete = false
+			re.sendError(re.env.Ctx.Done(), err)
+		}
+	}
+	if re.checkpoint != nil {
+		re.checkpoint(re.p, re.doNotSendCids, complete)
+	}
+	re.env.NotifyCompletedListeners(re.p, re.request, re.completionStatus(err, complete))
 	re.terminateRequest()
 	close(re.inProgressChan)
 	close(re.inProgressErr)
 }
 
+// sendError sends an error to the caller and error listeners, giving up on the
+// caller once done is closed
+func (re *requestExecutor) sendError(done <-chan struct{}, err error) bool {
+	re.errored = true
+	re.env.NotifyErrorListeners(re.p, re.request, err)
+	select {
+	case <-done:
+		return false
+	case re.inProgressErr <- err:
+		return true
+	}
+}
+
+// completionStatus is the status a finished request is reported with
+func (re *requestExecutor) completionStatus(err error, complete bool) graphsync.ResponseStatusCode {
+	if complete {
+		return graphsync.RequestCompletedFull
+	}
+	lastStatus := re.lastResponse.Load().(gsmsg.GraphSyncResponse).Status()
+	switch {
+	case gsmsg.IsTerminalFailureCode(lastStatus):
+		return lastStatus
+	case re.missingBlocks:
+		return graphsync.RequestCompletedPartial
+	case !re.errored && (re.ctx.Err() != nil || (err != nil && isContextErr(err))):
+		return graphsync.RequestCancelled
+	default:
+		return graphsync.RequestFailedUnknown
+	}
+}
+
+// sendRequest sends a request to the request's peers. When there are several,
+// each is asked only for its partition of the blocks
 func (re *requestExecutor) sendRequest(request gsmsg.GraphSyncRequest) {
-	re.env.SendRequest(re.p, request)
+	peers := append([]peer.ID{re.p}, re.additionalPeers...)
+	for i, p := range peers {
+		re.env.SendRequest(p, partitionRequest(request, i, len(peers)))
+	}
+}
+
+// partitionRequest adds the partition extension to a request for the given
+// peer, unless it is the only peer. If the partition can't be encoded, the
+// peer is asked for every block
+func partitionRequest(request gsmsg.GraphSyncRequest, index int, count int) gsmsg.GraphSyncRequest {
+	if count == 1 || request.IsCancel() {
+		return request
+	}
+	partitionData, err := partition.EncodePartition(partition.Partition{Index: index, Count: count})
+	if err != nil {
+		return request
+	}
+	return request.ReplaceExtensions([]graphsync.ExtensionData{{Name: graphsync.ExtensionPartition, Data: partitionData}})
 }
 
 func (re *requestExecutor) terminateRequest() {
@@ -178,13 +355,47 @@ func (re *requestExecutor) runBlockHooks(blk graphsync.BlockData) error {
 	return re.env.RunBlockHooks(re.p, response, blk)
 }
 
+// failover resumes the traversal against a new peer, skipping blocks we
+// already have, the next time it waits on the network
+func (re *requestExecutor) failover(p peer.ID) {
+	re.p = p
+	re.additionalPeers = nil
+	re.restartNeeded = true
+}
+
+// dropPeer stops sending the request to a peer that is no longer working on it,
+// and sends it again to the remaining peers, who share out the blocks still
+// needed
+func (re *requestExecutor) dropPeer(p peer.ID) {
+	remaining := make([]peer.ID, 0, len(re.additionalPeers))
+	for _, requestPeer := range append([]peer.ID{re.p}, re.additionalPeers...) {
+		if requestPeer != p {
+			remaining = append(remaining, requestPeer)
+		}
+	}
+	if len(remaining) == 0 {
+		return
+	}
+	re.p = remaining[0]
+	re.additionalPeers = remaining[1:]
+	re.restartNeeded = true
+}
+
+// waitForResume waits for a paused request to be resumed. Failovers while
+// paused switch peers, so the request resumes against the new peer
 func (re *requestExecutor) waitForResume() error {
-	select {
-	case <-re.ctx.Done():
-		return ipldutil.ContextCancelError{}
-	case re.pendingExtensions = <-re.resumeMessages:
-		re.restartNeeded = true
-		return nil
+	for {
+		select {
+		case <-re.ctx.Done():
+			return ipldutil.ContextCancelError{}
+		case p := <-re.failoverMessages:
+			re.failover(p)
+		case p := <-re.dropPeerMessages:
+			re.dropPeer(p)
+		case re.pendingExtensions = <-re.resumeMessages:
+			re.restartNeeded = true
+			return nil
+		}
 	}
 }
 
@@ -203,18 +414,26 @@ func (re *requestExecutor) onNewBlockWithPause(block graphsync.BlockData) error
 
 func (re *requestExecutor) onNewBlock(block graphsync.BlockData) error {
 	re.doNotSendCids.Add(block.Link().(cidlink.Link).Cid)
+	re.checkpointAsNeeded()
 	return re.runBlockHooks(block)
 }
 
+func (re *requestExecutor) checkpointAsNeeded() {
+	if re.checkpoint == nil || time.Since(re.lastCheckpoint) < re.checkpointInterval {
+		return
+	}
+	re.checkpoint(re.p, re.doNotSendCids, false)
+	re.lastCheckpoint = time.Now()
+}
+
 func (re *requestExecutor) processResult(traverser ipldutil.Traverser, link ipld.Link, result types.AsyncLoadResult) error {
 	if result.Err != nil {
-		select {
-		case <-re.ctx.Done():
+		re.missingBlocks = true
+		if !re.sendError(re.ctx.Done(), result.Err) {
 			return ipldutil.ContextCancelError{}
-		case re.inProgressErr <- result.Err:
-			traverser.Error(traversal.SkipMe{})
-			return nil
 		}
+		traverser.Error(traversal.SkipMe{})
+		return nil
 	}
 	err := re.onNewBlockWithPause(&blockData{link, result.Local, uint64(len(result.Data))})
 	if err != nil {
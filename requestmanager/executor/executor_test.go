@@ -19,6 +19,7 @@ import (
 	"github.com/ipfs/go-graphsync/cidset"
 	"github.com/ipfs/go-graphsync/ipldutil"
 	gsmsg "github.com/ipfs/go-graphsync/message"
+	"github.com/ipfs/go-graphsync/partition"
 	"github.com/ipfs/go-graphsync/requestmanager/executor"
 	"github.com/ipfs/go-graphsync/requestmanager/hooks"
 	"github.com/ipfs/go-graphsync/requestmanager/testloader"
@@ -42,6 +43,8 @@ func TestRequestExecutionBlockChain(t *testing.T) {
 				require.Equal(t, []requestSent{{ree.p, ree.request}}, ree.requestsSent)
 				require.Len(t, ree.blookHooksCalled, 10)
 				require.Equal(t, ree.request.ID(), ree.terminateRequested)
+				require.Equal(t, graphsync.RequestCompletedFull, ree.completedStatus)
+				require.Empty(t, ree.errorsNotified)
 				require.True(t, ree.nodeStyleChooserCalled)
 			},
 		},
@@ -57,6 +60,8 @@ func TestRequestExecutionBlockChain(t *testing.T) {
 				require.Equal(t, []requestSent{{ree.p, ree.request}}, ree.requestsSent)
 				require.Len(t, ree.blookHooksCalled, 6)
 				require.Equal(t, ree.request.ID(), ree.terminateRequested)
+				require.Equal(t, graphsync.RequestFailedUnknown, ree.completedStatus)
+				require.Equal(t, receivedErrors, ree.errorsNotified)
 				require.True(t, ree.nodeStyleChooserCalled)
 			},
 		},
@@ -71,6 +76,7 @@ func TestRequestExecutionBlockChain(t *testing.T) {
 				require.Equal(t, []requestSent{{ree.p, ree.request}}, ree.requestsSent)
 				require.Len(t, ree.blookHooksCalled, 6)
 				require.Equal(t, ree.request.ID(), ree.terminateRequested)
+				require.Equal(t, graphsync.RequestCancelled, ree.completedStatus)
 				require.True(t, ree.nodeStyleChooserCalled)
 			},
 		},
@@ -253,6 +259,205 @@ func TestRequestExecutionBlockChain(t *testing.T) {
 				require.True(t, ree.nodeStyleChooserCalled)
 			},
 		},
+		"failover to another peer": {
+			configureRequestExecution: func(p peer.ID, requestID graphsync.RequestID, tbc *testutil.TestBlockChain, ree *requestExecutionEnv) {
+				ree.failovers = append(ree.failovers, failoverKey{requestID, tbc.LinkTipIndex(6), testutil.GeneratePeers(1)[0]})
+				ree.loaderRanges = [][2]int{{0, 6}, {6, 10}}
+			},
+			verifyResults: func(t *testing.T, tbc *testutil.TestBlockChain, ree *requestExecutionEnv, responses []graphsync.ResponseProgress, receivedErrors []error) {
+				tbc.VerifyWholeChainSync(responses)
+				require.Empty(t, receivedErrors)
+				require.Equal(t, 1, ree.currentFailover)
+				require.Equal(t, requestSent{ree.p, ree.request}, ree.requestsSent[0])
+				require.Len(t, ree.requestsSent, 2)
+				require.Equal(t, ree.failovers[0].p, ree.requestsSent[1].p)
+				doNotSendCidsExt, has := ree.requestsSent[1].request.Extension(graphsync.ExtensionDoNotSendCIDs)
+				require.True(t, has)
+				cidSet, err := cidset.DecodeCidSet(doNotSendCidsExt)
+				require.NoError(t, err)
+				require.Equal(t, 6, cidSet.Len())
+				require.Len(t, ree.blookHooksCalled, 10)
+				require.Equal(t, ree.failovers[0].p, ree.blookHooksCalled[9].p)
+				require.Equal(t, ree.request.ID(), ree.terminateRequested)
+				require.True(t, ree.nodeStyleChooserCalled)
+			},
+		},
+		"failover while paused": {
+			configureRequestExecution: func(p peer.ID, requestID graphsync.RequestID, tbc *testutil.TestBlockChain, ree *requestExecutionEnv) {
+				ree.blockHookResults[blockHookKey{p, requestID, tbc.LinkTipIndex(5)}] = hooks.ErrPaused{}
+				ree.waitForResumeResults = append(ree.waitForResumeResults, nil)
+				ree.failoverWhilePaused = testutil.GeneratePeers(1)[0]
+				ree.loaderRanges = [][2]int{{0, 6}, {6, 10}}
+			},
+			verifyResults: func(t *testing.T, tbc *testutil.TestBlockChain, ree *requestExecutionEnv, responses []graphsync.ResponseProgress, receivedErrors []error) {
+				tbc.VerifyWholeChainSync(responses)
+				require.Empty(t, receivedErrors)
+				require.Equal(t, 1, ree.currentWaitForResumeResult)
+				require.Equal(t, requestSent{ree.p, ree.request}, ree.requestsSent[0])
+				require.Len(t, ree.requestsSent, 2, "should not send restart to old peer")
+				require.Equal(t, ree.failoverWhilePaused, ree.requestsSent[1].p)
+				doNotSendCidsExt, has := ree.requestsSent[1].request.Extension(graphsync.ExtensionDoNotSendCIDs)
+				require.True(t, has)
+				cidSet, err := cidset.DecodeCidSet(doNotSendCidsExt)
+				require.NoError(t, err)
+				require.Equal(t, 6, cidSet.Len())
+				require.Len(t, ree.blookHooksCalled, 10)
+				require.Equal(t, ree.failoverWhilePaused, ree.blookHooksCalled[9].p)
+				require.Equal(t, ree.request.ID(), ree.terminateRequested)
+			},
+		},
+		"restart": {
+			configureRequestExecution: func(p peer.ID, requestID graphsync.RequestID, tbc *testutil.TestBlockChain, ree *requestExecutionEnv) {
+				ree.restarts = append(ree.restarts, pauseKey{requestID, tbc.LinkTipIndex(6)})
+				ree.loaderRanges = [][2]int{{0, 6}, {6, 10}}
+			},
+			verifyResults: func(t *testing.T, tbc *testutil.TestBlockChain, ree *requestExecutionEnv, responses []graphsync.ResponseProgress, receivedErrors []error) {
+				tbc.VerifyWholeChainSync(responses)
+				require.Empty(t, receivedErrors)
+				require.Equal(t, 1, ree.currentRestart)
+				require.Equal(t, requestSent{ree.p, ree.request}, ree.requestsSent[0])
+				require.Len(t, ree.requestsSent, 2)
+				require.Equal(t, ree.p, ree.requestsSent[1].p)
+				doNotSendCidsExt, has := ree.requestsSent[1].request.Extension(graphsync.ExtensionDoNotSendCIDs)
+				require.True(t, has)
+				cidSet, err := cidset.DecodeCidSet(doNotSendCidsExt)
+				require.NoError(t, err)
+				require.Equal(t, 6, cidSet.Len())
+				require.Len(t, ree.blookHooksCalled, 10)
+				require.Equal(t, ree.request.ID(), ree.terminateRequested)
+			},
+		},
+		"local first": {
+			configureRequestExecution: func(p peer.ID, requestID graphsync.RequestID, tbc *testutil.TestBlockChain, ree *requestExecutionEnv) {
+				ree.localFirst = true
+				ree.fal.SuccessResponseOn(requestID, tbc.Blocks(0, 6))
+				ree.loaderRanges = [][2]int{{6, 10}}
+			},
+			verifyResults: func(t *testing.T, tbc *testutil.TestBlockChain, ree *requestExecutionEnv, responses []graphsync.ResponseProgress, receivedErrors []error) {
+				tbc.VerifyWholeChainSync(responses)
+				require.Empty(t, receivedErrors)
+				require.Len(t, ree.requestsSent, 1)
+				require.Equal(t, ree.p, ree.requestsSent[0].p)
+				doNotSendCidsExt, has := ree.requestsSent[0].request.Extension(graphsync.ExtensionDoNotSendCIDs)
+				require.True(t, has)
+				cidSet, err := cidset.DecodeCidSet(doNotSendCidsExt)
+				require.NoError(t, err)
+				require.Equal(t, 6, cidSet.Len())
+				require.Len(t, ree.blookHooksCalled, 10)
+				require.Equal(t, ree.request.ID(), ree.terminateRequested)
+			},
+		},
+		"local first, all blocks present": {
+			configureRequestExecution: func(p peer.ID, requestID graphsync.RequestID, tbc *testutil.TestBlockChain, ree *requestExecutionEnv) {
+				ree.localFirst = true
+				ree.fal.SuccessResponseOn(requestID, tbc.AllBlocks())
+			},
+			verifyResults: func(t *testing.T, tbc *testutil.TestBlockChain, ree *requestExecutionEnv, responses []graphsync.ResponseProgress, receivedErrors []error) {
+				tbc.VerifyWholeChainSync(responses)
+				require.Empty(t, receivedErrors)
+				require.Empty(t, ree.requestsSent)
+				require.Len(t, ree.blookHooksCalled, 10)
+				require.Equal(t, ree.request.ID(), ree.terminateRequested)
+			},
+		},
+		"stall timeout": {
+			configureRequestExecution: func(p peer.ID, requestID graphsync.RequestID, tbc *testutil.TestBlockChain, ree *requestExecutionEnv) {
+				ree.stallTimeout = 20 * time.Millisecond
+				ree.loaderRanges = [][2]int{{0, 6}}
+			},
+			verifyResults: func(t *testing.T, tbc *testutil.TestBlockChain, ree *requestExecutionEnv, responses []graphsync.ResponseProgress, receivedErrors []error) {
+				tbc.VerifyResponseRangeSync(responses, 0, 6)
+				require.Len(t, receivedErrors, 1)
+				require.IsType(t, graphsync.RequestStalledErr{}, receivedErrors[0])
+				require.Len(t, ree.requestsSent, 2)
+				require.Equal(t, requestSent{ree.p, ree.request}, ree.requestsSent[0])
+				require.True(t, ree.requestsSent[1].request.IsCancel())
+				require.Len(t, ree.blookHooksCalled, 6)
+				require.Equal(t, ree.request.ID(), ree.terminateRequested)
+			},
+		},
+		"stall timeout paused by responder": {
+			configureRequestExecution: func(p peer.ID, requestID graphsync.RequestID, tbc *testutil.TestBlockChain, ree *requestExecutionEnv) {
+				ree.stallTimeout = 20 * time.Millisecond
+				ree.responderPause = 100 * time.Millisecond
+				ree.loaderRanges = [][2]int{{0, 6}}
+			},
+			verifyResults: func(t *testing.T, tbc *testutil.TestBlockChain, ree *requestExecutionEnv, responses []graphsync.ResponseProgress, receivedErrors []error) {
+				tbc.VerifyWholeChainSync(responses)
+				require.Empty(t, receivedErrors)
+				require.Equal(t, []requestSent{{ree.p, ree.request}}, ree.requestsSent)
+				require.Len(t, ree.blookHooksCalled, 10)
+			},
+		},
+		"stall timeout restarts on dropped peer": {
+			configureRequestExecution: func(p peer.ID, requestID graphsync.RequestID, tbc *testutil.TestBlockChain, ree *requestExecutionEnv) {
+				ree.stallTimeout = 60 * time.Millisecond
+				ree.additionalPeers = testutil.GeneratePeers(1)
+				ree.dropPeers = append(ree.dropPeers, failoverKey{requestID, tbc.LinkTipIndex(6), ree.additionalPeers[0]})
+				ree.dropPeerDelay = 40 * time.Millisecond
+				ree.loaderRanges = [][2]int{{0, 6}, {6, 10}}
+				ree.configureLoader = func(p peer.ID, requestID graphsync.RequestID, tbc *testutil.TestBlockChain, fal *testloader.FakeAsyncLoader, startStop [2]int) {
+					if startStop[0] == 0 {
+						fal.SuccessResponseOn(requestID, tbc.Blocks(startStop[0], startStop[1]))
+						return
+					}
+					// the blocks arrive after the stall timeout measured from
+					// before the peer was dropped
+					go func() {
+						time.Sleep(40 * time.Millisecond)
+						fal.SuccessResponseOn(requestID, tbc.Blocks(startStop[0], startStop[1]))
+					}()
+				}
+			},
+			verifyResults: func(t *testing.T, tbc *testutil.TestBlockChain, ree *requestExecutionEnv, responses []graphsync.ResponseProgress, receivedErrors []error) {
+				tbc.VerifyWholeChainSync(responses)
+				require.Empty(t, receivedErrors)
+				require.Len(t, ree.requestsSent, 3)
+				require.Equal(t, ree.p, ree.requestsSent[2].p)
+				require.Len(t, ree.blookHooksCalled, 10)
+			},
+		},
+		"additional peers": {
+			configureRequestExecution: func(p peer.ID, requestID graphsync.RequestID, tbc *testutil.TestBlockChain, ree *requestExecutionEnv) {
+				ree.additionalPeers = testutil.GeneratePeers(2)
+			},
+			verifyResults: func(t *testing.T, tbc *testutil.TestBlockChain, ree *requestExecutionEnv, responses []graphsync.ResponseProgress, receivedErrors []error) {
+				tbc.VerifyWholeChainSync(responses)
+				require.Empty(t, receivedErrors)
+				require.Len(t, ree.requestsSent, 3)
+				for i, p := range append([]peer.ID{ree.p}, ree.additionalPeers...) {
+					require.Equal(t, p, ree.requestsSent[i].p)
+					requirePartition(t, ree.requestsSent[i].request, partition.Partition{Index: i, Count: 3})
+				}
+				require.Len(t, ree.blookHooksCalled, 10)
+				require.Equal(t, ree.request.ID(), ree.terminateRequested)
+			},
+		},
+		"dropped peer": {
+			configureRequestExecution: func(p peer.ID, requestID graphsync.RequestID, tbc *testutil.TestBlockChain, ree *requestExecutionEnv) {
+				ree.additionalPeers = testutil.GeneratePeers(2)
+				ree.dropPeers = append(ree.dropPeers, failoverKey{requestID, tbc.LinkTipIndex(6), ree.additionalPeers[0]})
+				ree.loaderRanges = [][2]int{{0, 6}, {6, 10}}
+			},
+			verifyResults: func(t *testing.T, tbc *testutil.TestBlockChain, ree *requestExecutionEnv, responses []graphsync.ResponseProgress, receivedErrors []error) {
+				tbc.VerifyWholeChainSync(responses)
+				require.Empty(t, receivedErrors)
+				require.Equal(t, 1, ree.currentDropPeer)
+				require.Len(t, ree.requestsSent, 5)
+				for i, p := range []peer.ID{ree.p, ree.additionalPeers[1]} {
+					restart := ree.requestsSent[3+i]
+					require.Equal(t, p, restart.p)
+					requirePartition(t, restart.request, partition.Partition{Index: i, Count: 2})
+					doNotSendCidsExt, has := restart.request.Extension(graphsync.ExtensionDoNotSendCIDs)
+					require.True(t, has)
+					cidSet, err := cidset.DecodeCidSet(doNotSendCidsExt)
+					require.NoError(t, err)
+					require.Equal(t, 6, cidSet.Len())
+				}
+				require.Len(t, ree.blookHooksCalled, 10)
+				require.Equal(t, ree.request.ID(), ree.terminateRequested)
+			},
+		},
 	}
 	for testCase, data := range testCases {
 		t.Run(testCase, func(t *testing.T) {
@@ -277,6 +482,10 @@ func TestRequestExecutionBlockChain(t *testing.T) {
 				p:                p,
 				resumeMessages:   make(chan []graphsync.ExtensionData, 1),
 				pauseMessages:    make(chan struct{}, 1),
+				failoverMessages: make(chan peer.ID, 1),
+				dropPeerMessages: make(chan peer.ID, 1),
+				responseMessages: make(chan struct{}, 1),
+				restartMessages:  make(chan struct{}, 1),
 				blockHookResults: make(map[blockHookKey]error),
 				doNotSendCids:    cid.NewSet(),
 				request:          gsmsg.NewRequest(requestID, tbc.TipLink.(cidlink.Link).Cid, tbc.Selector(), graphsync.Priority(rand.Int31())),
@@ -284,7 +493,7 @@ func TestRequestExecutionBlockChain(t *testing.T) {
 				tbc:              tbc,
 				configureLoader:  configureLoader,
 			}
-			fal.OnAsyncLoad(ree.checkPause)
+			fal.OnAsyncLoad(ree.onAsyncLoad)
 			if data.configureRequestExecution != nil {
 				data.configureRequestExecution(p, requestID, tbc, ree)
 			}
@@ -336,26 +545,50 @@ type pauseKey struct {
 	link      ipld.Link
 }
 
+type failoverKey struct {
+	requestID graphsync.RequestID
+	link      ipld.Link
+	p         peer.ID
+}
+
 type requestExecutionEnv struct {
 	// params
 	ctx                  context.Context
 	cancelFn             func()
 	request              gsmsg.GraphSyncRequest
 	p                    peer.ID
+	additionalPeers      []peer.ID
 	blockHookResults     map[blockHookKey]error
 	doNotSendCids        *cid.Set
 	waitForResumeResults [][]graphsync.ExtensionData
 	resumeMessages       chan []graphsync.ExtensionData
 	pauseMessages        chan struct{}
 	externalPauses       []pauseKey
+	failovers            []failoverKey
+	failoverMessages     chan peer.ID
+	failoverWhilePaused  peer.ID
+	dropPeers            []failoverKey
+	dropPeerMessages     chan peer.ID
+	dropPeerDelay        time.Duration
+	responseMessages     chan struct{}
+	responderPause       time.Duration
+	restarts             []pauseKey
+	restartMessages      chan struct{}
+	localFirst           bool
+	stallTimeout         time.Duration
 	loaderRanges         [][2]int
 
 	// results
 	currentPauseResult         int
 	currentWaitForResumeResult int
+	currentFailover            int
+	currentDropPeer            int
+	currentRestart             int
 	requestsSent               []requestSent
 	blookHooksCalled           []blockHookKey
 	terminateRequested         graphsync.RequestID
+	completedStatus            graphsync.ResponseStatusCode
+	errorsNotified             []error
 	nodeStyleChooserCalled     bool
 
 	// deps
@@ -368,6 +601,14 @@ func (ree *requestExecutionEnv) terminateRequest(requestID graphsync.RequestID)
 	ree.terminateRequested = requestID
 }
 
+func (ree *requestExecutionEnv) notifyCompletedListeners(p peer.ID, request graphsync.RequestData, status graphsync.ResponseStatusCode) {
+	ree.completedStatus = status
+}
+
+func (ree *requestExecutionEnv) notifyErrorListeners(p peer.ID, request graphsync.RequestData, err error) {
+	ree.errorsNotified = append(ree.errorsNotified, err)
+}
+
 func (ree *requestExecutionEnv) waitForResume() ([]graphsync.ExtensionData, error) {
 	if len(ree.waitForResumeResults) <= ree.currentWaitForResumeResult {
 		return nil, ipldutil.ContextCancelError{}
@@ -379,9 +620,19 @@ func (ree *requestExecutionEnv) waitForResume() ([]graphsync.ExtensionData, erro
 
 func (ree *requestExecutionEnv) sendRequest(p peer.ID, request gsmsg.GraphSyncRequest) {
 	ree.requestsSent = append(ree.requestsSent, requestSent{p, request})
-	if ree.currentWaitForResumeResult < len(ree.loaderRanges) && !request.IsCancel() {
-		ree.configureLoader(ree.p, ree.request.ID(), ree.tbc, ree.fal, ree.loaderRanges[ree.currentWaitForResumeResult])
+	currentRange := ree.currentWaitForResumeResult + ree.currentFailover + ree.currentDropPeer + ree.currentRestart
+	if currentRange < len(ree.loaderRanges) && !request.IsCancel() && !ree.isAdditionalPeer(p) {
+		ree.configureLoader(ree.p, ree.request.ID(), ree.tbc, ree.fal, ree.loaderRanges[currentRange])
+	}
+}
+
+func (ree *requestExecutionEnv) isAdditionalPeer(p peer.ID) bool {
+	for _, additionalPeer := range ree.additionalPeers {
+		if p == additionalPeer {
+			return true
+		}
 	}
+	return false
 }
 
 func (ree *requestExecutionEnv) nodeStyleChooser(ipld.Link, ipld.LinkContext) (ipld.NodeStyle, error) {
@@ -389,7 +640,54 @@ func (ree *requestExecutionEnv) nodeStyleChooser(ipld.Link, ipld.LinkContext) (i
 	return basicnode.Style.Any, nil
 }
 
-func (ree *requestExecutionEnv) checkPause(requestID graphsync.RequestID, link ipld.Link, result <-chan types.AsyncLoadResult) {
+func (ree *requestExecutionEnv) onAsyncLoad(requestID graphsync.RequestID, link ipld.Link, result <-chan types.AsyncLoadResult) {
+	ree.checkFailover(requestID, link)
+	ree.checkDropPeer(requestID, link)
+	ree.checkRestart(requestID, link)
+	ree.checkPause(requestID, link)
+}
+
+func (ree *requestExecutionEnv) checkRestart(requestID graphsync.RequestID, link ipld.Link) {
+	if ree.currentRestart >= len(ree.restarts) {
+		return
+	}
+	currentRestart := ree.restarts[ree.currentRestart]
+	if currentRestart.link == link && currentRestart.requestID == requestID {
+		ree.currentRestart++
+		ree.restartMessages <- struct{}{}
+	}
+}
+
+func (ree *requestExecutionEnv) checkFailover(requestID graphsync.RequestID, link ipld.Link) {
+	if ree.currentFailover >= len(ree.failovers) {
+		return
+	}
+	currentFailover := ree.failovers[ree.currentFailover]
+	if currentFailover.link == link && currentFailover.requestID == requestID {
+		ree.currentFailover++
+		ree.failoverMessages <- currentFailover.p
+	}
+}
+
+func (ree *requestExecutionEnv) checkDropPeer(requestID graphsync.RequestID, link ipld.Link) {
+	if ree.currentDropPeer >= len(ree.dropPeers) {
+		return
+	}
+	currentDropPeer := ree.dropPeers[ree.currentDropPeer]
+	if currentDropPeer.link == link && currentDropPeer.requestID == requestID {
+		ree.currentDropPeer++
+		if ree.dropPeerDelay == 0 {
+			ree.dropPeerMessages <- currentDropPeer.p
+			return
+		}
+		go func() {
+			time.Sleep(ree.dropPeerDelay)
+			ree.dropPeerMessages <- currentDropPeer.p
+		}()
+	}
+}
+
+func (ree *requestExecutionEnv) checkPause(requestID graphsync.RequestID, link ipld.Link) {
 	if ree.currentPauseResult >= len(ree.externalPauses) {
 		return
 	}
@@ -411,6 +709,9 @@ func (ree *requestExecutionEnv) runBlockHooks(p peer.ID, response graphsync.Resp
 	ree.blookHooksCalled = append(ree.blookHooksCalled, bhk)
 	err := ree.blockHookResults[bhk]
 	if _, ok := err.(hooks.ErrPaused); ok {
+		if ree.failoverWhilePaused != "" {
+			ree.failoverMessages <- ree.failoverWhilePaused
+		}
 		extensions, err := ree.waitForResume()
 		if err != nil {
 			ree.cancelFn()
@@ -424,19 +725,47 @@ func (ree *requestExecutionEnv) runBlockHooks(p peer.ID, response graphsync.Resp
 func (ree *requestExecutionEnv) requestExecution() (chan graphsync.ResponseProgress, chan error) {
 	var lastResponse atomic.Value
 	lastResponse.Store(gsmsg.NewResponse(ree.request.ID(), graphsync.RequestAcknowledged))
+	if ree.responderPause > 0 {
+		// the responder pauses after sending the first blocks, and sends the
+		// rest once it continues
+		lastResponse.Store(gsmsg.NewResponse(ree.request.ID(), graphsync.RequestPaused))
+		go func() {
+			time.Sleep(ree.responderPause)
+			lastResponse.Store(gsmsg.NewResponse(ree.request.ID(), graphsync.PartialResponse))
+			ree.responseMessages <- struct{}{}
+			ree.fal.SuccessResponseOn(ree.request.ID(), ree.tbc.RemainderBlocks(6))
+		}()
+	}
 	return executor.ExecutionEnv{
-		SendRequest:      ree.sendRequest,
-		RunBlockHooks:    ree.runBlockHooks,
-		TerminateRequest: ree.terminateRequest,
-		Loader:           ree.fal.AsyncLoad,
+		SendRequest:              ree.sendRequest,
+		RunBlockHooks:            ree.runBlockHooks,
+		TerminateRequest:         ree.terminateRequest,
+		NotifyCompletedListeners: ree.notifyCompletedListeners,
+		NotifyErrorListeners:     ree.notifyErrorListeners,
+		Loader:                   ree.fal.AsyncLoad,
 	}.Start(executor.RequestExecution{
 		Ctx:              ree.ctx,
 		P:                ree.p,
+		AdditionalPeers:  ree.additionalPeers,
 		LastResponse:     &lastResponse,
 		Request:          ree.request,
 		DoNotSendCids:    ree.doNotSendCids,
 		NodeStyleChooser: ree.nodeStyleChooser,
 		ResumeMessages:   ree.resumeMessages,
 		PauseMessages:    ree.pauseMessages,
+		FailoverMessages: ree.failoverMessages,
+		DropPeerMessages: ree.dropPeerMessages,
+		ResponseMessages: ree.responseMessages,
+		RestartMessages:  ree.restartMessages,
+		LocalFirst:       ree.localFirst,
+		StallTimeout:     ree.stallTimeout,
 	})
 }
+
+func requirePartition(t *testing.T, request gsmsg.GraphSyncRequest, expected partition.Partition) {
+	partitionData, has := request.Extension(graphsync.ExtensionPartition)
+	require.True(t, has)
+	p, err := partition.DecodePartition(partitionData)
+	require.NoError(t, err)
+	require.Equal(t, expected, p)
+}
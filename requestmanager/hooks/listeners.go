@@ -0,0 +1,106 @@
+package hooks
+
+import (
+	"github.com/hannahhoward/go-pubsub"
+	"github.com/libp2p/go-libp2p-core/peer"
+
+	"github.com/ipfs/go-graphsync"
+)
+
+// CompletedRequestListeners is a set of listeners for completed requests
+type CompletedRequestListeners struct {
+	pubSub *pubsub.PubSub
+}
+
+type internalCompletedRequestEvent struct {
+	p       peer.ID
+	request graphsync.RequestData
+	status  graphsync.ResponseStatusCode
+}
+
+func completedRequestDispatcher(event pubsub.Event, subscriberFn pubsub.SubscriberFn) error {
+	ie := event.(internalCompletedRequestEvent)
+	listener := subscriberFn.(graphsync.OnRequestCompletedListener)
+	listener(ie.p, ie.request, ie.status)
+	return nil
+}
+
+// NewCompletedRequestListeners returns a new list of completed request listeners
+func NewCompletedRequestListeners() *CompletedRequestListeners {
+	return &CompletedRequestListeners{pubSub: pubsub.New(completedRequestDispatcher)}
+}
+
+// Register registers a listener for completed requests
+func (crl *CompletedRequestListeners) Register(listener graphsync.OnRequestCompletedListener) graphsync.UnregisterHookFunc {
+	return graphsync.UnregisterHookFunc(crl.pubSub.Subscribe(listener))
+}
+
+// NotifyCompletedListeners notifies all completed listeners that a request has completed
+func (crl *CompletedRequestListeners) NotifyCompletedListeners(p peer.ID, request graphsync.RequestData, status graphsync.ResponseStatusCode) {
+	_ = crl.pubSub.Publish(internalCompletedRequestEvent{p, request, status})
+}
+
+// RequestPausedListeners is a set of listeners for requests paused by the responder
+type RequestPausedListeners struct {
+	pubSub *pubsub.PubSub
+}
+
+type internalRequestPausedEvent struct {
+	p       peer.ID
+	request graphsync.RequestData
+}
+
+func requestPausedDispatcher(event pubsub.Event, subscriberFn pubsub.SubscriberFn) error {
+	ie := event.(internalRequestPausedEvent)
+	listener := subscriberFn.(graphsync.OnRequestPausedListener)
+	listener(ie.p, ie.request)
+	return nil
+}
+
+// NewRequestPausedListeners returns a new list of listeners for requests paused by the responder
+func NewRequestPausedListeners() *RequestPausedListeners {
+	return &RequestPausedListeners{pubSub: pubsub.New(requestPausedDispatcher)}
+}
+
+// Register registers a listener for requests paused by the responder
+func (rpl *RequestPausedListeners) Register(listener graphsync.OnRequestPausedListener) graphsync.UnregisterHookFunc {
+	return graphsync.UnregisterHookFunc(rpl.pubSub.Subscribe(listener))
+}
+
+// NotifyPausedListeners notifies all listeners that the responder paused a request
+func (rpl *RequestPausedListeners) NotifyPausedListeners(p peer.ID, request graphsync.RequestData) {
+	_ = rpl.pubSub.Publish(internalRequestPausedEvent{p, request})
+}
+
+// RequestErrorListeners is a set of listeners for errors on requests
+type RequestErrorListeners struct {
+	pubSub *pubsub.PubSub
+}
+
+type internalRequestErrorEvent struct {
+	p       peer.ID
+	request graphsync.RequestData
+	err     error
+}
+
+func requestErrorDispatcher(event pubsub.Event, subscriberFn pubsub.SubscriberFn) error {
+	ie := event.(internalRequestErrorEvent)
+	listener := subscriberFn.(graphsync.OnRequestErrorListener)
+	listener(ie.p, ie.request, ie.err)
+	return nil
+}
+
+// NewRequestErrorListeners returns a new list of listeners for errors on requests
+func NewRequestErrorListeners() *RequestErrorListeners {
+	return &RequestErrorListeners{pubSub: pubsub.New(requestErrorDispatcher)}
+}
+
+// Register registers a listener for errors on requests
+func (rel *RequestErrorListeners) Register(listener graphsync.OnRequestErrorListener) graphsync.UnregisterHookFunc {
+	return graphsync.UnregisterHookFunc(rel.pubSub.Subscribe(listener))
+}
+
+// NotifyErrorListeners notifies all listeners of an error on a request
+func (rel *RequestErrorListeners) NotifyErrorListeners(p peer.ID, request graphsync.RequestData, err error) {
+	_ = rel.pubSub.Publish(internalRequestErrorEvent{p, request, err})
+}
@@ -0,0 +1,164 @@
+package requestmanager
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-ipld-prime"
+	"github.com/libp2p/go-libp2p-core/peer"
+
+	"github.com/ipfs/go-graphsync"
+	gsmsg "github.com/ipfs/go-graphsync/message"
+	"github.com/ipfs/go-graphsync/metadata"
+)
+
+// metadataRequest collects the links a responder reports for a metadata only
+// request. Metadata only requests load no blocks, so they have no executor,
+// and finish when their peer terminates them
+type metadataRequest struct {
+	links  []graphsync.LinkMetadata
+	result chan metadataResult
+}
+
+type metadataResult struct {
+	links []graphsync.LinkMetadata
+	err   error
+}
+
+type newMetadataRequestMessage struct {
+	p          peer.ID
+	root       ipld.Link
+	selector   ipld.Node
+	extensions []graphsync.ExtensionData
+	deadline   time.Time
+	requestIDs chan graphsync.RequestID
+	result     chan metadataResult
+}
+
+// RequestMetadata asks the given peer which links the given selector traverses,
+// whether it has their blocks and how large they are, without transferring any
+// blocks. Metadata only requests do not fail over or follow redirects, and
+// cannot be paused
+func (rm *RequestManager) RequestMetadata(ctx context.Context,
+	p peer.ID,
+	root ipld.Link,
+	selector ipld.Node,
+	extensions ...graphsync.ExtensionData) ([]graphsync.LinkMetadata, error) {
+	if err := rm.checkSelector(selector); err != nil {
+		return nil, err
+	}
+	nmrm := &newMetadataRequestMessage{
+		p:          p,
+		root:       root,
+		selector:   selector,
+		extensions: extensions,
+		deadline:   contextDeadline(ctx),
+		requestIDs: make(chan graphsync.RequestID, 1),
+		result:     make(chan metadataResult, 1),
+	}
+	select {
+	case rm.messages <- nmrm:
+	case <-rm.ctx.Done():
+		return nil, errors.New("context cancelled")
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	var requestID graphsync.RequestID
+	select {
+	case requestID = <-nmrm.requestIDs:
+	case <-rm.ctx.Done():
+		return nil, errors.New("context cancelled")
+	}
+	select {
+	case result := <-nmrm.result:
+		return result.links, result.err
+	case <-rm.ctx.Done():
+		return nil, errors.New("context cancelled")
+	case <-ctx.Done():
+		select {
+		case rm.messages <- &cancelRequestMessage{requestID, false}:
+		case <-rm.ctx.Done():
+		}
+		return nil, ctx.Err()
+	}
+}
+
+func (nmrm *newMetadataRequestMessage) handle(rm *RequestManager) {
+	requestID := rm.nextRequestID
+	rm.nextRequestID++
+	nmrm.requestIDs <- requestID
+	if !rm.hasFreeSlots([]peer.ID{nmrm.p}) {
+		rm.queueMetadataRequest(requestID, nmrm)
+		return
+	}
+	nmrm.setupRequest(requestID, rm)
+}
+
+func (nmrm *newMetadataRequestMessage) setupRequest(requestID graphsync.RequestID, rm *RequestManager) {
+	extensions := make([]graphsync.ExtensionData, 0, len(nmrm.extensions)+1)
+	extensions = append(extensions, nmrm.extensions...)
+	extensions = append(extensions, graphsync.ExtensionData{Name: graphsync.ExtensionMetadataOnly})
+	config := graphsync.NewRequestConfig(graphsync.WithExtensions(extensions...))
+	request, _, err := rm.validateRequest(requestID, nmrm.p, nmrm.root, nmrm.selector, config)
+	if err != nil {
+		nmrm.result <- metadataResult{err: err}
+		return
+	}
+	ctx, cancel := context.WithCancel(rm.ctx)
+	requestStatus := &inProgressRequestStatus{
+		ctx: ctx, cancelFn: cancel, request: request, p: nmrm.p, peers: []peer.ID{nmrm.p},
+		terminated: make(map[peer.ID]error), networkError: make(chan error, 1),
+		state: &requestState{}, receivedCids: cid.NewSet(),
+		metadata: &metadataRequest{result: nmrm.result},
+	}
+	requestStatus.lastResponse.Store(gsmsg.NewResponse(requestID, graphsync.RequestAcknowledged))
+	rm.inProgressRequestStatuses[requestID] = requestStatus
+	rm.occupySlots(requestStatus.peers)
+	withDeadline(nmrm.deadline, rm.peerHandler.SendRequest)(nmrm.p, request)
+}
+
+// collectMetadataOnly takes the metadata for metadata only requests out of the
+// metadata for responses, and records it for the request instead of loading
+// blocks for it
+func (rm *RequestManager) collectMetadataOnly(responseMetadata map[graphsync.RequestID]metadata.Metadata) {
+	for requestID, md := range responseMetadata {
+		requestStatus, ok := rm.inProgressRequestStatuses[requestID]
+		if !ok || requestStatus.metadata == nil {
+			continue
+		}
+		for _, item := range md {
+			requestStatus.metadata.links = append(requestStatus.metadata.links, graphsync.LinkMetadata{
+				Link:         item.Link,
+				BlockPresent: item.BlockPresent,
+				BlockSize:    item.BlockSize,
+			})
+		}
+		delete(responseMetadata, requestID)
+	}
+}
+
+// finishMetadataRequest returns the links collected for a metadata only
+// request, or the error it failed with, and cleans it up
+func (rm *RequestManager) finishMetadataRequest(requestID graphsync.RequestID, requestStatus *inProgressRequestStatus, status graphsync.ResponseStatusCode, err error) {
+	if err != nil {
+		rm.errorListeners.NotifyErrorListeners(requestStatus.p, requestStatus.request, err)
+		requestStatus.metadata.result <- metadataResult{err: err}
+	} else {
+		requestStatus.metadata.result <- metadataResult{links: requestStatus.metadata.links}
+	}
+	rm.completedListeners.NotifyCompletedListeners(requestStatus.p, requestStatus.request, status)
+	requestStatus.cancelFn()
+	(&terminateRequestMessage{requestID}).handle(rm)
+}
+
+// terminateMetadataRequest finishes a metadata only request once its peer
+// terminates it
+func (rm *RequestManager) terminateMetadataRequest(requestID graphsync.RequestID, requestStatus *inProgressRequestStatus, responseError error) {
+	status := requestStatus.lastResponse.Load().(gsmsg.GraphSyncResponse).Status()
+	if !gsmsg.IsTerminalResponseCode(status) {
+		status = graphsync.RequestFailedUnknown
+	}
+	rm.finishMetadataRequest(requestID, requestStatus, status, responseError)
+}
@@ -0,0 +1,213 @@
+package requestmanager
+
+import (
+	"context"
+	"sync"
+
+	"github.com/ipfs/go-graphsync"
+)
+
+// maxFanoutBuffer is how many responses a shared request keeps. Callers that
+// join late receive them first. Once the buffer fills, no more callers can join,
+// responses every caller has read are discarded, and no more are read from the
+// request until the slowest caller catches up
+const maxFanoutBuffer = 1024
+
+// requestFanout sends the responses and errors from a single in progress request
+// to every caller subscribed to it. Callers that subscribe after the request
+// started receive everything sent so far first. The slowest caller sets the pace,
+// so the request's traversal waits for it rather than responses building up
+type requestFanout struct {
+	ctx context.Context
+
+	lk                sync.Mutex
+	updated           chan struct{}
+	responses         []graphsync.ResponseProgress
+	firstResponse     int
+	responsesDone     bool
+	errors            []error
+	errorsDone        bool
+	joinable          bool
+	nextSubscriber    int
+	responsePositions map[int]int
+}
+
+func newRequestFanout(ctx context.Context,
+	incomingResponses <-chan graphsync.ResponseProgress,
+	incomingErrors <-chan error) *requestFanout {
+	rf := &requestFanout{
+		ctx:               ctx,
+		updated:           make(chan struct{}),
+		joinable:          true,
+		responsePositions: make(map[int]int),
+	}
+	go rf.collect(incomingResponses, incomingErrors)
+	return rf
+}
+
+func (rf *requestFanout) collect(incomingResponses <-chan graphsync.ResponseProgress, incomingErrors <-chan error) {
+	for incomingResponses != nil || incomingErrors != nil {
+		responses := incomingResponses
+		var progressed <-chan struct{}
+		rf.lk.Lock()
+		if len(rf.responses) >= maxFanoutBuffer {
+			responses = nil
+			progressed = rf.updated
+		}
+		rf.lk.Unlock()
+		select {
+		case <-rf.ctx.Done():
+			return
+		case <-progressed:
+		case response, ok := <-responses:
+			rf.lk.Lock()
+			if !ok {
+				incomingResponses = nil
+				rf.responsesDone = true
+			} else {
+				rf.responses = append(rf.responses, response)
+				if len(rf.responses) >= maxFanoutBuffer {
+					rf.joinable = false
+					rf.prune()
+				}
+			}
+			rf.notify()
+			rf.lk.Unlock()
+		case err, ok := <-incomingErrors:
+			rf.lk.Lock()
+			if !ok {
+				incomingErrors = nil
+				rf.errorsDone = true
+			} else {
+				rf.errors = append(rf.errors, err)
+			}
+			rf.notify()
+			rf.lk.Unlock()
+		}
+	}
+}
+
+// notify wakes up subscribers waiting for more responses or errors, and the
+// collector waiting for subscribers to read responses. It must be called with
+// the lock held
+func (rf *requestFanout) notify() {
+	close(rf.updated)
+	rf.updated = make(chan struct{})
+}
+
+// prune discards responses every subscriber has read, once no more subscribers
+// can join, and wakes up the collector if that made room. It must be called
+// with the lock held
+func (rf *requestFanout) prune() {
+	if rf.joinable {
+		return
+	}
+	nextResponse := rf.firstResponse + len(rf.responses)
+	for _, position := range rf.responsePositions {
+		if position < nextResponse {
+			nextResponse = position
+		}
+	}
+	if nextResponse == rf.firstResponse {
+		return
+	}
+	rf.responses = rf.responses[nextResponse-rf.firstResponse:]
+	rf.firstResponse = nextResponse
+	rf.notify()
+}
+
+// subscribe returns channels that receive every response and error for the
+// request until the given context is cancelled, or false if the request can
+// no longer be joined
+func (rf *requestFanout) subscribe(ctx context.Context) (<-chan graphsync.ResponseProgress, <-chan error, bool) {
+	rf.lk.Lock()
+	defer rf.lk.Unlock()
+	if !rf.joinable {
+		return nil, nil, false
+	}
+	subscriber := rf.nextSubscriber
+	rf.nextSubscriber++
+	rf.responsePositions[subscriber] = rf.firstResponse
+	responses := make(chan graphsync.ResponseProgress)
+	errors := make(chan error)
+	go rf.sendResponses(ctx, subscriber, responses)
+	go rf.sendErrors(ctx, errors)
+	return responses, errors, true
+}
+
+func (rf *requestFanout) sendResponses(ctx context.Context, subscriber int, responses chan<- graphsync.ResponseProgress) {
+	defer close(responses)
+	defer func() {
+		rf.lk.Lock()
+		delete(rf.responsePositions, subscriber)
+		rf.prune()
+		rf.lk.Unlock()
+	}()
+	for {
+		rf.lk.Lock()
+		position := rf.responsePositions[subscriber]
+		if position < rf.firstResponse+len(rf.responses) {
+			response := rf.responses[position-rf.firstResponse]
+			rf.lk.Unlock()
+			select {
+			case <-rf.ctx.Done():
+				return
+			case <-ctx.Done():
+				return
+			case responses <- response:
+			}
+			rf.lk.Lock()
+			rf.responsePositions[subscriber] = position + 1
+			rf.prune()
+			rf.lk.Unlock()
+			continue
+		}
+		if rf.responsesDone {
+			rf.lk.Unlock()
+			return
+		}
+		updated := rf.updated
+		rf.lk.Unlock()
+		select {
+		case <-rf.ctx.Done():
+			return
+		case <-ctx.Done():
+			return
+		case <-updated:
+		}
+	}
+}
+
+func (rf *requestFanout) sendErrors(ctx context.Context, errors chan<- error) {
+	defer close(errors)
+	position := 0
+	for {
+		rf.lk.Lock()
+		if position < len(rf.errors) {
+			err := rf.errors[position]
+			rf.lk.Unlock()
+			select {
+			case <-rf.ctx.Done():
+				return
+			case <-ctx.Done():
+				return
+			case errors <- err:
+			}
+			position++
+			continue
+		}
+		if rf.errorsDone {
+			rf.lk.Unlock()
+			return
+		}
+		updated := rf.updated
+		rf.lk.Unlock()
+		select {
+		case <-rf.ctx.Done():
+			return
+		case <-ctx.Done():
+			return
+		case <-updated:
+		}
+	}
+}
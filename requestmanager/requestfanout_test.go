@@ -0,0 +1,58 @@
+package requestmanager
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/ipfs/go-graphsync"
+	"github.com/ipfs/go-graphsync/testutil"
+)
+
+func TestFanoutSlowestSubscriberSetsPace(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	incomingResponses := make(chan graphsync.ResponseProgress)
+	incomingErrors := make(chan error)
+	rf := newRequestFanout(ctx, incomingResponses, incomingErrors)
+
+	fastResponses, _, joined := rf.subscribe(ctx)
+	require.True(t, joined)
+	slowResponses, _, joined := rf.subscribe(ctx)
+	require.True(t, joined)
+
+	// the buffer fills while the slow subscriber reads nothing
+	for i := 0; i < maxFanoutBuffer; i++ {
+		testutil.AssertSends(ctx, t, incomingResponses, graphsync.ResponseProgress{}, "should buffer response")
+		var response graphsync.ResponseProgress
+		testutil.AssertReceive(ctx, t, fastResponses, &response, "fast subscriber should receive response")
+	}
+
+	// once it is full, no more responses are read from the request
+	select {
+	case incomingResponses <- graphsync.ResponseProgress{}:
+		t.Fatal("should not read responses once the buffer is full")
+	default:
+	}
+	_, _, joined = rf.subscribe(ctx)
+	require.False(t, joined, "should not join once responses were discarded")
+
+	// the slow subscriber reading a response makes room for another
+	var response graphsync.ResponseProgress
+	testutil.AssertReceive(ctx, t, slowResponses, &response, "slow subscriber should receive response")
+	testutil.AssertSends(ctx, t, incomingResponses, graphsync.ResponseProgress{}, "should read response once there is room")
+	testutil.AssertReceive(ctx, t, fastResponses, &response, "fast subscriber should receive response")
+
+	close(incomingResponses)
+	close(incomingErrors)
+	for i := 0; i < maxFanoutBuffer; i++ {
+		testutil.AssertReceive(ctx, t, slowResponses, &response, "slow subscriber should receive remaining responses")
+	}
+	_, ok := <-slowResponses
+	require.False(t, ok, "should close responses once all are read")
+	_, ok = <-fastResponses
+	require.False(t, ok, "should close responses once all are read")
+}
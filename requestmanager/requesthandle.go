@@ -0,0 +1,208 @@
+package requestmanager
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+
+	"github.com/ipfs/go-graphsync"
+)
+
+// requestState is the part of an in progress request's status read by handles
+// to it from outside the run loop. All fields but redirects are accessed atomically
+type requestState struct {
+	queued          int32
+	paused          int32
+	blocksReceived  uint64
+	bytesReceived   uint64
+	bytesOnWire     uint64
+	localBlocks     uint64
+	remoteBlocks    uint64
+	duplicateBlocks uint64
+	redirectsLk     sync.Mutex
+	redirects       []peer.ID
+}
+
+func (rs *requestState) setQueued(queued bool) {
+	var value int32
+	if queued {
+		value = 1
+	}
+	atomic.StoreInt32(&rs.queued, value)
+}
+
+func (rs *requestState) isQueued() bool {
+	return atomic.LoadInt32(&rs.queued) == 1
+}
+
+func (rs *requestState) setPaused(paused bool) {
+	var value int32
+	if paused {
+		value = 1
+	}
+	atomic.StoreInt32(&rs.paused, value)
+}
+
+func (rs *requestState) isPaused() bool {
+	return atomic.LoadInt32(&rs.paused) == 1
+}
+
+// recordBlock records a block loaded for the traversal
+func (rs *requestState) recordBlock(blk graphsync.BlockData) {
+	atomic.AddUint64(&rs.blocksReceived, 1)
+	atomic.AddUint64(&rs.bytesReceived, blk.BlockSize())
+	if blk.BlockSizeOnWire() == 0 {
+		atomic.AddUint64(&rs.localBlocks, 1)
+	} else {
+		atomic.AddUint64(&rs.remoteBlocks, 1)
+	}
+}
+
+// recordReceivedBlock records a block received from the network for the request
+func (rs *requestState) recordReceivedBlock(size uint64, duplicate bool) {
+	atomic.AddUint64(&rs.bytesOnWire, size)
+	if duplicate {
+		atomic.AddUint64(&rs.duplicateBlocks, 1)
+	}
+}
+
+// recordRedirect records that a responder redirected the request to the given peer
+func (rs *requestState) recordRedirect(p peer.ID) {
+	rs.redirectsLk.Lock()
+	rs.redirects = append(rs.redirects, p)
+	rs.redirectsLk.Unlock()
+}
+
+func (rs *requestState) stats() graphsync.RequestStats {
+	rs.redirectsLk.Lock()
+	redirects := append([]peer.ID(nil), rs.redirects...)
+	rs.redirectsLk.Unlock()
+	return graphsync.RequestStats{
+		BlocksReceived:  atomic.LoadUint64(&rs.blocksReceived),
+		BytesReceived:   atomic.LoadUint64(&rs.bytesReceived),
+		BytesOnWire:     atomic.LoadUint64(&rs.bytesOnWire),
+		LocalBlocks:     atomic.LoadUint64(&rs.localBlocks),
+		RemoteBlocks:    atomic.LoadUint64(&rs.remoteBlocks),
+		DuplicateBlocks: atomic.LoadUint64(&rs.duplicateBlocks),
+		Redirects:       redirects,
+	}
+}
+
+// requestHandle is a graphsync.RequestHandle for a request sent through the
+// request manager
+type requestHandle struct {
+	ctx       context.Context
+	cancel    func()
+	rm        *RequestManager
+	requestID graphsync.RequestID
+	p         peer.ID
+	// state is nil if the request never started
+	state     *requestState
+	responses <-chan graphsync.ResponseProgress
+	errors    <-chan error
+	// finalStatus is set once no more errors can arrive
+	finalStatus int32
+	finished    int32
+}
+
+var _ graphsync.RequestHandle = (*requestHandle)(nil)
+
+// watchErrors returns a channel receiving the given errors, recording the final
+// status of the request once they close
+func (rh *requestHandle) watchErrors(incomingErrors <-chan error) <-chan error {
+	outgoingErrors := make(chan error)
+	go func() {
+		defer close(outgoingErrors)
+		failed := false
+		for err := range incomingErrors {
+			failed = true
+			select {
+			case outgoingErrors <- err:
+			case <-rh.ctx.Done():
+			}
+		}
+		finalStatus := graphsync.RequestStatusCompleted
+		if rh.ctx.Err() != nil {
+			finalStatus = graphsync.RequestStatusCancelled
+		} else if failed {
+			finalStatus = graphsync.RequestStatusFailed
+		}
+		atomic.StoreInt32(&rh.finalStatus, int32(finalStatus))
+		atomic.StoreInt32(&rh.finished, 1)
+	}()
+	return outgoingErrors
+}
+
+// ID is the ID of the request
+func (rh *requestHandle) ID() graphsync.RequestID {
+	return rh.requestID
+}
+
+// Peer is the peer the request was sent to
+func (rh *requestHandle) Peer() peer.ID {
+	return rh.p
+}
+
+// Responses receives progress of the traversal
+func (rh *requestHandle) Responses() <-chan graphsync.ResponseProgress {
+	return rh.responses
+}
+
+// Errors receives any errors for the request
+func (rh *requestHandle) Errors() <-chan error {
+	return rh.errors
+}
+
+// Status returns the current state of the request
+func (rh *requestHandle) Status() graphsync.RequestStatus {
+	if atomic.LoadInt32(&rh.finished) == 1 {
+		return graphsync.RequestStatus(atomic.LoadInt32(&rh.finalStatus))
+	}
+	if rh.state != nil && rh.state.isQueued() {
+		return graphsync.RequestStatusQueued
+	}
+	if rh.state != nil && rh.state.isPaused() {
+		return graphsync.RequestStatusPaused
+	}
+	return graphsync.RequestStatusRunning
+}
+
+// Stats returns the blocks and bytes received for the request so far
+func (rh *requestHandle) Stats() graphsync.RequestStats {
+	if rh.state == nil {
+		return graphsync.RequestStats{}
+	}
+	return rh.state.stats()
+}
+
+// Pause pauses the request
+func (rh *requestHandle) Pause() error {
+	if rh.state == nil {
+		return errors.New("request not found")
+	}
+	return rh.rm.PauseRequest(rh.requestID)
+}
+
+// Unpause unpauses the request, sending the given extensions with it
+func (rh *requestHandle) Unpause(extensions ...graphsync.ExtensionData) error {
+	if rh.state == nil {
+		return errors.New("request not found")
+	}
+	return rh.rm.UnpauseRequest(rh.requestID, extensions...)
+}
+
+// Cancel cancels the request
+func (rh *requestHandle) Cancel() {
+	rh.cancel()
+}
+
+// Update sends the given extensions to the peer for the in progress request
+func (rh *requestHandle) Update(extensions ...graphsync.ExtensionData) error {
+	if rh.state == nil {
+		return errors.New("request not found")
+	}
+	return rh.rm.UpdateRequest(rh.requestID, extensions...)
+}
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"sync/atomic"
+	"time"
 
 	blocks "github.com/ipfs/go-block-format"
 	"github.com/ipfs/go-cid"
@@ -12,6 +13,7 @@ import (
 	"github.com/ipld/go-ipld-prime"
 	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
 	"github.com/libp2p/go-libp2p-core/peer"
+	ma "github.com/multiformats/go-multiaddr"
 
 	"github.com/ipfs/go-graphsync"
 	"github.com/ipfs/go-graphsync/cidset"
@@ -19,9 +21,12 @@ import (
 	ipldutil "github.com/ipfs/go-graphsync/ipldutil"
 	gsmsg "github.com/ipfs/go-graphsync/message"
 	"github.com/ipfs/go-graphsync/metadata"
+	"github.com/ipfs/go-graphsync/redirect"
 	"github.com/ipfs/go-graphsync/requestmanager/executor"
 	"github.com/ipfs/go-graphsync/requestmanager/hooks"
 	"github.com/ipfs/go-graphsync/requestmanager/types"
+	"github.com/ipfs/go-graphsync/selectorvalidator"
+	"github.com/ipfs/go-graphsync/updatepriority"
 )
 
 var log = logging.Logger("graphsync")
@@ -29,17 +34,88 @@ var log = logging.Logger("graphsync")
 const (
 	// defaultPriority is the default priority for requests sent by graphsync
 	defaultPriority = graphsync.Priority(0)
+	// defaultCheckpointInterval is how often requests record checkpoints
+	// when no interval is given
+	defaultCheckpointInterval = 10 * time.Second
+	// defaultPausedDisconnectTimeout is how long a request waits for a peer that
+	// disconnected while it had the request paused to continue it
+	defaultPausedDisconnectTimeout = time.Minute
+	// throttleCheckInterval is how often requests paused for exceeding the inbound
+	// memory budget check whether they can continue
+	throttleCheckInterval = 100 * time.Millisecond
 )
 
 type inProgressRequestStatus struct {
-	ctx            context.Context
-	cancelFn       func()
-	p              peer.ID
-	networkError   chan error
-	resumeMessages chan []graphsync.ExtensionData
-	pauseMessages  chan struct{}
-	paused         bool
-	lastResponse   atomic.Value
+	ctx              context.Context
+	cancelFn         func()
+	request          gsmsg.GraphSyncRequest
+	p                peer.ID
+	peers            []peer.ID
+	terminated       map[peer.ID]error
+	peerProvider     graphsync.PeerProvider
+	networkError     chan error
+	resumeMessages   chan []graphsync.ExtensionData
+	pauseMessages    chan struct{}
+	failoverMessages chan peer.ID
+	dropPeerMessages chan peer.ID
+	responseMessages chan struct{}
+	restartMessages  chan struct{}
+	paused           bool
+	throttled        bool
+	// awaitingPayment are the peers that paused the request with NotEnoughGas,
+	// which UnpauseRequest sends its extensions to
+	awaitingPayment map[peer.ID]struct{}
+	// pausedDisconnects are the peers that disconnected while they had the
+	// request paused, with the messages that fail the request on them if they
+	// don't continue it in time
+	pausedDisconnects map[peer.ID]*pausedDisconnectExpiredMessage
+	lastResponse      atomic.Value
+	// state is shared with handles to the request
+	state        *requestState
+	receivedCids *cid.Set
+	// redirects counts the redirects followed, up to maxRedirects, and
+	// redirectPeers are the peers from the last redirect not yet tried
+	maxRedirects  int
+	redirects     int
+	redirectPeers []peer.ID
+	// fanout and dedupKey are set for requests shared by identical callers
+	fanout      *requestFanout
+	dedupKey    string
+	subscribers int
+	// deadline is the deadline of the context of the caller that started the
+	// request, which the responder is told about
+	deadline time.Time
+	// metadata is set for metadata only requests
+	metadata *metadataRequest
+}
+
+func (ipr *inProgressRequestStatus) setPaused(paused bool) {
+	ipr.paused = paused
+	ipr.state.setPaused(paused)
+}
+
+func (ipr *inProgressRequestStatus) hasPeer(p peer.ID) bool {
+	for _, requestPeer := range ipr.peers {
+		if requestPeer == p {
+			return true
+		}
+	}
+	return false
+}
+
+// hasAlternatePeers indicates blocks missing from one peer may still be
+// received from another
+func (ipr *inProgressRequestStatus) hasAlternatePeers() bool {
+	return len(ipr.peers) > 1 || ipr.peerProvider != nil
+}
+
+func (ipr *inProgressRequestStatus) allFailed() bool {
+	for _, err := range ipr.terminated {
+		if err == nil {
+			return false
+		}
+	}
+	return true
 }
 
 // PeerHandler is an interface that can send requests to peers
@@ -47,15 +123,24 @@ type PeerHandler interface {
 	SendRequest(p peer.ID, graphSyncRequest gsmsg.GraphSyncRequest)
 }
 
+// AddrBook is an interface for recording addresses peers can be reached at
+type AddrBook interface {
+	AddAddrs(p peer.ID, addrs []ma.Multiaddr)
+}
+
 // AsyncLoader is an interface for loading links asynchronously, returning
 // results as new responses are processed
 type AsyncLoader interface {
 	StartRequest(graphsync.RequestID, string) error
+	StartStagedRequest(graphsync.RequestID, string) error
+	CommitRequest(graphsync.RequestID) error
 	ProcessResponse(responses map[graphsync.RequestID]metadata.Metadata,
 		blks []blocks.Block)
 	AsyncLoad(requestID graphsync.RequestID, link ipld.Link) <-chan types.AsyncLoadResult
 	CompleteResponsesFor(requestID graphsync.RequestID)
 	CleanupRequest(requestID graphsync.RequestID)
+	BufferedBytes() uint64
+	MemoryExhausted() bool
 }
 
 // RequestManager tracks outgoing requests and processes incoming reponses
@@ -65,14 +150,34 @@ type RequestManager struct {
 	cancel      func()
 	messages    chan requestManagerMessage
 	peerHandler PeerHandler
+	addrBook    AddrBook
 	rc          *responseCollector
 	asyncLoader AsyncLoader
+	// checkpointStore and checkpointInterval are only set before startup
+	checkpointStore    graphsync.CheckpointStore
+	checkpointInterval time.Duration
+	// inboundMemoryBudget and unverifiedBlockBudget are only set before startup
+	inboundMemoryBudget   uint64
+	unverifiedBlockBudget uint64
+	// maxInProgressRequests and maxInProgressRequestsPerPeer are only set before startup
+	maxInProgressRequests        int
+	maxInProgressRequestsPerPeer int
+	// selectorPolicy is only set before startup
+	selectorPolicy *selectorvalidator.SelectorPolicy
+	// pausedDisconnectTimeout is only set before startup
+	pausedDisconnectTimeout time.Duration
 	// dont touch out side of run loop
 	nextRequestID             graphsync.RequestID
 	inProgressRequestStatuses map[graphsync.RequestID]*inProgressRequestStatus
+	inProgressRequestsPerPeer map[peer.ID]int
+	queuedRequests            requestQueue
+	dedupedRequests           map[string]graphsync.RequestID
 	requestHooks              RequestHooks
 	responseHooks             ResponseHooks
 	blockHooks                BlockHooks
+	completedListeners        CompletedListeners
+	pausedListeners           PausedListeners
+	errorListeners            ErrorListeners
 }
 
 type requestManagerMessage interface {
@@ -94,12 +199,30 @@ type BlockHooks interface {
 	ProcessBlockHooks(p peer.ID, response graphsync.ResponseData, block graphsync.BlockData) hooks.UpdateResult
 }
 
+// CompletedListeners is an interface for notifying listeners that requests are complete
+type CompletedListeners interface {
+	NotifyCompletedListeners(p peer.ID, request graphsync.RequestData, status graphsync.ResponseStatusCode)
+}
+
+// PausedListeners is an interface for notifying listeners that responders paused requests
+type PausedListeners interface {
+	NotifyPausedListeners(p peer.ID, request graphsync.RequestData)
+}
+
+// ErrorListeners is an interface for notifying listeners of errors on requests
+type ErrorListeners interface {
+	NotifyErrorListeners(p peer.ID, request graphsync.RequestData, err error)
+}
+
 // New generates a new request manager from a context, network, and selectorQuerier
 func New(ctx context.Context,
 	asyncLoader AsyncLoader,
 	requestHooks RequestHooks,
 	responseHooks ResponseHooks,
-	blockHooks BlockHooks) *RequestManager {
+	blockHooks BlockHooks,
+	completedListeners CompletedListeners,
+	pausedListeners PausedListeners,
+	errorListeners ErrorListeners) *RequestManager {
 	ctx, cancel := context.WithCancel(ctx)
 	return &RequestManager{
 		ctx:                       ctx,
@@ -108,9 +231,16 @@ func New(ctx context.Context,
 		rc:                        newResponseCollector(ctx),
 		messages:                  make(chan requestManagerMessage, 16),
 		inProgressRequestStatuses: make(map[graphsync.RequestID]*inProgressRequestStatus),
+		inProgressRequestsPerPeer: make(map[peer.ID]int),
+		dedupedRequests:           make(map[string]graphsync.RequestID),
 		requestHooks:              requestHooks,
 		responseHooks:             responseHooks,
 		blockHooks:                blockHooks,
+		completedListeners:        completedListeners,
+		pausedListeners:           pausedListeners,
+		errorListeners:            errorListeners,
+		checkpointInterval:        defaultCheckpointInterval,
+		pausedDisconnectTimeout:   defaultPausedDisconnectTimeout,
 	}
 }
 
@@ -119,17 +249,88 @@ func (rm *RequestManager) SetDelegate(peerHandler PeerHandler) {
 	rm.peerHandler = peerHandler
 }
 
+// SetAddrBook sets where addresses for peers requests are redirected to are
+// recorded. It must be called before Startup
+func (rm *RequestManager) SetAddrBook(addrBook AddrBook) {
+	rm.addrBook = addrBook
+}
+
+// SetCheckpointStore sets where requests made with a checkpoint ID record their
+// progress, and how often they do so. It must be called before Startup
+func (rm *RequestManager) SetCheckpointStore(checkpointStore graphsync.CheckpointStore, checkpointInterval time.Duration) {
+	rm.checkpointStore = checkpointStore
+	if checkpointInterval > 0 {
+		rm.checkpointInterval = checkpointInterval
+	}
+}
+
+// SetInboundMemoryBudget sets the most memory that blocks received but not yet
+// processed may take up. Requests receiving blocks while over budget are paused
+// until the buffered blocks drain to half the budget. Zero means no budget.
+// It must be called before Startup
+func (rm *RequestManager) SetInboundMemoryBudget(inboundMemoryBudget uint64) {
+	rm.inboundMemoryBudget = inboundMemoryBudget
+}
+
+// SetUnverifiedBlockBudget tells the request manager the memory budget of the
+// async loader's unverified block store, so requests are paused while the store
+// is over it or dropping blocks, as for the inbound memory budget. When both are
+// set, the smaller applies. It must be called before Startup
+func (rm *RequestManager) SetUnverifiedBlockBudget(unverifiedBlockBudget uint64) {
+	rm.unverifiedBlockBudget = unverifiedBlockBudget
+}
+
+// memoryBudget returns the smaller of the inbound memory budget and unverified
+// block budget that are set, or zero if neither is
+func (rm *RequestManager) memoryBudget() uint64 {
+	if rm.inboundMemoryBudget == 0 || (rm.unverifiedBlockBudget > 0 && rm.unverifiedBlockBudget < rm.inboundMemoryBudget) {
+		return rm.unverifiedBlockBudget
+	}
+	return rm.inboundMemoryBudget
+}
+
+// SetMaxInProgressRequests sets how many requests can be in progress at once
+// across all peers. Further requests wait in a queue, highest priority first,
+// until a request finishes. Zero means no limit. It must be called before Startup
+func (rm *RequestManager) SetMaxInProgressRequests(maxInProgressRequests int) {
+	rm.maxInProgressRequests = maxInProgressRequests
+}
+
+// SetMaxInProgressRequestsPerPeer sets how many requests can be in progress at
+// once to a single peer. Further requests to that peer wait in a queue, highest
+// priority first, until a request to it finishes. Zero means no limit. It must
+// be called before Startup
+func (rm *RequestManager) SetMaxInProgressRequestsPerPeer(maxInProgressRequestsPerPeer int) {
+	rm.maxInProgressRequestsPerPeer = maxInProgressRequestsPerPeer
+}
+
+// SetPausedDisconnectTimeout sets how long a request waits for a peer that
+// disconnected while it had the request paused to continue it, before failing
+// the request on that peer. It must be called before Startup
+func (rm *RequestManager) SetPausedDisconnectTimeout(pausedDisconnectTimeout time.Duration) {
+	rm.pausedDisconnectTimeout = pausedDisconnectTimeout
+}
+
+// SetSelectorPolicy sets limits that selectors must meet before requests are
+// sent with them. It must be called before Startup
+func (rm *RequestManager) SetSelectorPolicy(selectorPolicy selectorvalidator.SelectorPolicy) {
+	rm.selectorPolicy = &selectorPolicy
+}
+
 type inProgressRequest struct {
 	requestID     graphsync.RequestID
-	incoming      chan graphsync.ResponseProgress
-	incomingError chan error
+	incoming      <-chan graphsync.ResponseProgress
+	incomingError <-chan error
+	shared        bool
+	state         *requestState
 }
 
 type newRequestMessage struct {
-	p                     peer.ID
+	ctx                   context.Context
+	peers                 []peer.ID
 	root                  ipld.Link
 	selector              ipld.Node
-	extensions            []graphsync.ExtensionData
+	config                graphsync.RequestConfig
 	inProgressRequestChan chan<- inProgressRequest
 }
 
@@ -139,31 +340,151 @@ func (rm *RequestManager) SendRequest(ctx context.Context,
 	root ipld.Link,
 	selector ipld.Node,
 	extensions ...graphsync.ExtensionData) (<-chan graphsync.ResponseProgress, <-chan error) {
+	return rm.sendRequest(ctx, []peer.ID{p}, root, selector, graphsync.NewRequestConfig(graphsync.WithExtensions(extensions...)))
+}
+
+// SendRequestWithOptions initiates a new GraphSync request to the given peer,
+// configured with the given options
+func (rm *RequestManager) SendRequestWithOptions(ctx context.Context,
+	p peer.ID,
+	root ipld.Link,
+	selector ipld.Node,
+	options ...graphsync.RequestOption) (<-chan graphsync.ResponseProgress, <-chan error) {
+	return rm.sendRequest(ctx, []peer.ID{p}, root, selector, graphsync.NewRequestConfig(options...))
+}
+
+// SendRequestMulti initiates a new GraphSync request for a single traversal
+// to several peers at once, asking each for a disjoint partition of the
+// blocks. When a peer fails or is missing blocks, the request is sent again to
+// the remaining peers, partitioned between them and skipping blocks already
+// received. Redirects are not followed, and fail the peer that sent them. The
+// request only fails if every peer fails.
+func (rm *RequestManager) SendRequestMulti(ctx context.Context,
+	peers []peer.ID,
+	root ipld.Link,
+	selector ipld.Node,
+	extensions ...graphsync.ExtensionData) (<-chan graphsync.ResponseProgress, <-chan error) {
+	return rm.sendRequest(ctx, peers, root, selector, graphsync.NewRequestConfig(graphsync.WithExtensions(extensions...)))
+}
+
+// ResumeRequest resumes a request from the checkpoint with the given ID,
+// asking the peer not to send blocks already received
+func (rm *RequestManager) ResumeRequest(ctx context.Context, checkpointID string) (<-chan graphsync.ResponseProgress, <-chan error) {
+	if rm.checkpointStore == nil {
+		return rm.singleErrorResponse(errors.New("no checkpoint store configured"))
+	}
+	checkpoint, err := rm.checkpointStore.Get(checkpointID)
+	if err != nil {
+		return rm.singleErrorResponse(err)
+	}
+	doNotSendCidsData, err := cidset.EncodeCidSet(checkpoint.VerifiedCids)
+	if err != nil {
+		return rm.singleErrorResponse(err)
+	}
+	extensions := append(checkpoint.Extensions, graphsync.ExtensionData{
+		Name: graphsync.ExtensionDoNotSendCIDs,
+		Data: doNotSendCidsData,
+	})
+	config := graphsync.NewRequestConfig(graphsync.WithExtensions(extensions...), graphsync.WithCheckpoint(checkpointID))
+	return rm.sendRequest(ctx, []peer.ID{checkpoint.Peer}, checkpoint.Root, checkpoint.Selector, config)
+}
+
+// SendRequestWithHandle initiates a new GraphSync request to the given peer,
+// configured with the given options, returning a handle to follow and control it
+func (rm *RequestManager) SendRequestWithHandle(ctx context.Context,
+	p peer.ID,
+	root ipld.Link,
+	selector ipld.Node,
+	options ...graphsync.RequestOption) graphsync.RequestHandle {
+	ctx, cancel := context.WithCancel(ctx)
+	receivedInProgressRequest := rm.startRequest(ctx, []peer.ID{p}, root, selector, graphsync.NewRequestConfig(options...))
+	rh := &requestHandle{
+		ctx:       ctx,
+		cancel:    cancel,
+		rm:        rm,
+		requestID: receivedInProgressRequest.requestID,
+		p:         p,
+		state:     receivedInProgressRequest.state,
+	}
+	receivedInProgressRequest.incomingError = rh.watchErrors(receivedInProgressRequest.incomingError)
+	if receivedInProgressRequest.state == nil {
+		rh.responses, rh.errors = receivedInProgressRequest.incoming, receivedInProgressRequest.incomingError
+	} else {
+		rh.responses, rh.errors = rm.collectResponses(ctx, receivedInProgressRequest)
+	}
+	return rh
+}
+
+func (rm *RequestManager) sendRequest(ctx context.Context,
+	peers []peer.ID,
+	root ipld.Link,
+	selector ipld.Node,
+	config graphsync.RequestConfig) (<-chan graphsync.ResponseProgress, <-chan error) {
+	receivedInProgressRequest := rm.startRequest(ctx, peers, root, selector, config)
+	if receivedInProgressRequest.state == nil {
+		return receivedInProgressRequest.incoming, receivedInProgressRequest.incomingError
+	}
+	return rm.collectResponses(ctx, receivedInProgressRequest)
+}
+
+// checkSelector errors if a selector is not a valid selector, or does not meet
+// the selector policy
+func (rm *RequestManager) checkSelector(selector ipld.Node) error {
 	if _, err := ipldutil.ParseSelector(selector); err != nil {
-		return rm.singleErrorResponse(fmt.Errorf("Invalid Selector Spec"))
+		return fmt.Errorf("Invalid Selector Spec")
+	}
+	if rm.selectorPolicy != nil {
+		return rm.selectorPolicy.Validate(selector)
+	}
+	return nil
+}
+
+// startRequest hands a new request to the run loop. If the request did not
+// start, the returned request has no state, and its channels return any error
+func (rm *RequestManager) startRequest(ctx context.Context,
+	peers []peer.ID,
+	root ipld.Link,
+	selector ipld.Node,
+	config graphsync.RequestConfig) inProgressRequest {
+	if err := rm.checkSelector(selector); err != nil {
+		return rm.failedRequest(rm.singleErrorResponse(err))
+	}
+	peers = uniquePeers(peers)
+	if len(peers) == 0 {
+		return rm.failedRequest(rm.singleErrorResponse(fmt.Errorf("No peers to send request to")))
 	}
 
 	inProgressRequestChan := make(chan inProgressRequest)
 
 	select {
-	case rm.messages <- &newRequestMessage{p, root, selector, extensions, inProgressRequestChan}:
+	case rm.messages <- &newRequestMessage{ctx, peers, root, selector, config, inProgressRequestChan}:
 	case <-rm.ctx.Done():
-		return rm.emptyResponse()
+		return rm.failedRequest(rm.emptyResponse())
 	case <-ctx.Done():
-		return rm.emptyResponse()
+		return rm.failedRequest(rm.emptyResponse())
 	}
-	var receivedInProgressRequest inProgressRequest
 	select {
 	case <-rm.ctx.Done():
-		return rm.emptyResponse()
-	case receivedInProgressRequest = <-inProgressRequestChan:
+		return rm.failedRequest(rm.emptyResponse())
+	case receivedInProgressRequest := <-inProgressRequestChan:
+		return receivedInProgressRequest
 	}
+}
 
+func (rm *RequestManager) failedRequest(incoming chan graphsync.ResponseProgress, incomingError chan error) inProgressRequest {
+	return inProgressRequest{incoming: incoming, incomingError: incomingError}
+}
+
+func (rm *RequestManager) collectResponses(ctx context.Context, receivedInProgressRequest inProgressRequest) (<-chan graphsync.ResponseProgress, <-chan error) {
+	var cancelMessage requestManagerMessage = &cancelRequestMessage{receivedInProgressRequest.requestID, false}
+	if receivedInProgressRequest.shared {
+		cancelMessage = &unsubscribeRequestMessage{receivedInProgressRequest.requestID}
+	}
 	return rm.rc.collectResponses(ctx,
 		receivedInProgressRequest.incoming,
 		receivedInProgressRequest.incomingError,
 		func() {
-			rm.cancelRequest(receivedInProgressRequest.requestID,
+			rm.cancelRequest(cancelMessage,
 				receivedInProgressRequest.incoming,
 				receivedInProgressRequest.incomingError)
 		})
@@ -191,13 +512,19 @@ type cancelRequestMessage struct {
 	isPause   bool
 }
 
-func (rm *RequestManager) cancelRequest(requestID graphsync.RequestID,
-	incomingResponses chan graphsync.ResponseProgress,
-	incomingErrors chan error) {
+// unsubscribeRequestMessage removes a caller from a request shared by identical
+// callers, cancelling the request once no callers remain
+type unsubscribeRequestMessage struct {
+	requestID graphsync.RequestID
+}
+
+func (rm *RequestManager) cancelRequest(cancelMessage requestManagerMessage,
+	incomingResponses <-chan graphsync.ResponseProgress,
+	incomingErrors <-chan error) {
 	cancelMessageChannel := rm.messages
 	for cancelMessageChannel != nil || incomingResponses != nil || incomingErrors != nil {
 		select {
-		case cancelMessageChannel <- &cancelRequestMessage{requestID, false}:
+		case cancelMessageChannel <- cancelMessage:
 			cancelMessageChannel = nil
 		// clear out any remaining responses, in case and "incoming reponse"
 		// messages get processed before our cancel message
@@ -231,6 +558,24 @@ func (rm *RequestManager) ProcessResponses(p peer.ID, responses []gsmsg.GraphSyn
 	}
 }
 
+type peerDisconnectedMessage struct {
+	p peer.ID
+}
+
+// Disconnected fails any in progress requests waiting on the given peer, or
+// moves them to another peer if they can fail over
+func (rm *RequestManager) Disconnected(p peer.ID) {
+	select {
+	case rm.messages <- &peerDisconnectedMessage{p}:
+	case <-rm.ctx.Done():
+	}
+}
+
+type pausedDisconnectExpiredMessage struct {
+	requestID graphsync.RequestID
+	p         peer.ID
+}
+
 type unpauseRequestMessage struct {
 	id         graphsync.RequestID
 	extensions []graphsync.ExtensionData
@@ -238,7 +583,8 @@ type unpauseRequestMessage struct {
 }
 
 // UnpauseRequest unpauses a request that was paused in a block hook based request ID
-// Can also send extensions with unpause
+// Can also send extensions with unpause. For a request a responder paused with
+// NotEnoughGas, the extensions, such as a payment, are sent to that responder
 func (rm *RequestManager) UnpauseRequest(requestID graphsync.RequestID, extensions ...graphsync.ExtensionData) error {
 	response := make(chan error, 1)
 	return rm.sendSyncMessage(&unpauseRequestMessage{requestID, extensions, response}, response)
@@ -255,6 +601,40 @@ func (rm *RequestManager) PauseRequest(requestID graphsync.RequestID) error {
 	return rm.sendSyncMessage(&pauseRequestMessage{requestID, response}, response)
 }
 
+type updateRequestMessage struct {
+	id         graphsync.RequestID
+	extensions []graphsync.ExtensionData
+	response   chan error
+}
+
+// UpdateRequest sends the given extensions to the peers for an in progress request
+func (rm *RequestManager) UpdateRequest(requestID graphsync.RequestID, extensions ...graphsync.ExtensionData) error {
+	response := make(chan error, 1)
+	return rm.sendSyncMessage(&updateRequestMessage{requestID, extensions, response}, response)
+}
+
+// UpdatePriority changes the priority of an in progress request on the peers it
+// was sent to, or its place in the queue if it has not been sent yet. It has no
+// effect on requests the peers have finished
+func (rm *RequestManager) UpdatePriority(requestID graphsync.RequestID, priority graphsync.Priority) error {
+	data, err := updatepriority.EncodePriority(priority)
+	if err != nil {
+		return err
+	}
+	response := make(chan error, 1)
+	return rm.sendSyncMessage(&updateRequestMessage{requestID, []graphsync.ExtensionData{{
+		Name: graphsync.ExtensionUpdatePriority,
+		Data: data,
+	}}, response}, response)
+}
+
+// CommitStagedBlocks writes the blocks received so far for a request made with
+// graphsync.WithStagedPersistence to the store, as they would be once the request
+// completed, and writes any further blocks straight through
+func (rm *RequestManager) CommitStagedBlocks(requestID graphsync.RequestID) error {
+	return rm.asyncLoader.CommitRequest(requestID)
+}
+
 func (rm *RequestManager) sendSyncMessage(message requestManagerMessage, response chan error) error {
 	select {
 	case <-rm.ctx.Done():
@@ -284,10 +664,18 @@ func (rm *RequestManager) run() {
 	// event loop. Really, just don't do anything likely to block.
 	defer rm.cleanupInProcessRequests()
 
+	var throttleCheck <-chan time.Time
+	if rm.memoryBudget() > 0 {
+		throttleTicker := time.NewTicker(throttleCheckInterval)
+		defer throttleTicker.Stop()
+		throttleCheck = throttleTicker.C
+	}
 	for {
 		select {
 		case message := <-rm.messages:
 			message.handle(rm)
+		case <-throttleCheck:
+			rm.checkThrottledRequests()
 		case <-rm.ctx.Done():
 			return
 		}
@@ -298,14 +686,22 @@ func (rm *RequestManager) cleanupInProcessRequests() {
 	for _, requestStatus := range rm.inProgressRequestStatuses {
 		requestStatus.cancelFn()
 	}
+	for _, qr := range rm.queuedRequests.requests {
+		if qr.metadata != nil {
+			continue
+		}
+		close(qr.incoming)
+		close(qr.incomingError)
+	}
 }
 
 type terminateRequestMessage struct {
 	requestID graphsync.RequestID
 }
 
-func (nrm *newRequestMessage) setupRequest(requestID graphsync.RequestID, rm *RequestManager) (chan graphsync.ResponseProgress, chan error) {
-	request, hooksResult, err := rm.validateRequest(requestID, nrm.p, nrm.root, nrm.selector, nrm.extensions)
+func (nrm *newRequestMessage) setupRequest(requestID graphsync.RequestID, state *requestState, rm *RequestManager) (chan graphsync.ResponseProgress, chan error) {
+	p := nrm.peers[0]
+	request, hooksResult, err := rm.validateRequest(requestID, p, nrm.root, nrm.selector, nrm.config)
 	if err != nil {
 		return rm.singleErrorResponse(err)
 	}
@@ -320,42 +716,144 @@ func (nrm *newRequestMessage) setupRequest(requestID graphsync.RequestID, rm *Re
 		doNotSendCids = cid.NewSet()
 	}
 	ctx, cancel := context.WithCancel(rm.ctx)
-	p := nrm.p
+	peers := nrm.peers
 	resumeMessages := make(chan []graphsync.ExtensionData, 1)
 	pauseMessages := make(chan struct{}, 1)
+	failoverMessages := make(chan peer.ID, 1)
+	// each peer but the last can be dropped once
+	dropPeerMessages := make(chan peer.ID, len(peers))
+	responseMessages := make(chan struct{}, 1)
+	restartMessages := make(chan struct{}, 1)
 	networkError := make(chan error, 1)
 	requestStatus := &inProgressRequestStatus{
-		ctx: ctx, cancelFn: cancel, p: p, resumeMessages: resumeMessages, pauseMessages: pauseMessages, networkError: networkError,
+		ctx: ctx, cancelFn: cancel, request: request, p: p, peers: peers, terminated: make(map[peer.ID]error),
+		peerProvider: nrm.config.PeerProvider, maxRedirects: nrm.config.MaxRedirects,
+		resumeMessages: resumeMessages, pauseMessages: pauseMessages,
+		failoverMessages: failoverMessages, dropPeerMessages: dropPeerMessages, responseMessages: responseMessages,
+		restartMessages: restartMessages, networkError: networkError, state: state, receivedCids: cid.NewSet(), deadline: contextDeadline(nrm.ctx),
 	}
 	lastResponse := &requestStatus.lastResponse
 	lastResponse.Store(gsmsg.NewResponse(request.ID(), graphsync.RequestAcknowledged))
 	rm.inProgressRequestStatuses[request.ID()] = requestStatus
+	rm.occupySlots(peers)
 	incoming, incomingError := executor.ExecutionEnv{
-		Ctx:              rm.ctx,
-		SendRequest:      rm.peerHandler.SendRequest,
-		TerminateRequest: rm.terminateRequest,
-		RunBlockHooks:    rm.processBlockHooks,
-		Loader:           rm.asyncLoader.AsyncLoad,
+		Ctx:                      rm.ctx,
+		SendRequest:              withDeadline(requestStatus.deadline, rm.peerHandler.SendRequest),
+		TerminateRequest:         rm.terminateRequest,
+		RunBlockHooks:            rm.blockHooksFn(requestStatus.state),
+		NotifyCompletedListeners: rm.completedListeners.NotifyCompletedListeners,
+		NotifyErrorListeners:     rm.errorListeners.NotifyErrorListeners,
+		Loader:                   rm.asyncLoader.AsyncLoad,
 	}.Start(
 		executor.RequestExecution{
-			Ctx:              ctx,
-			P:                p,
-			Request:          request,
-			NetworkError:     networkError,
-			LastResponse:     lastResponse,
-			DoNotSendCids:    doNotSendCids,
-			NodeStyleChooser: hooksResult.CustomChooser,
-			ResumeMessages:   resumeMessages,
-			PauseMessages:    pauseMessages,
+			Ctx:                ctx,
+			P:                  p,
+			AdditionalPeers:    peers[1:],
+			Request:            request,
+			NetworkError:       networkError,
+			LastResponse:       lastResponse,
+			DoNotSendCids:      doNotSendCids,
+			NodeStyleChooser:   hooksResult.CustomChooser,
+			ResumeMessages:     resumeMessages,
+			PauseMessages:      pauseMessages,
+			FailoverMessages:   failoverMessages,
+			DropPeerMessages:   dropPeerMessages,
+			ResponseMessages:   responseMessages,
+			RestartMessages:    restartMessages,
+			LocalFirst:         nrm.config.LocalFirst,
+			StallTimeout:       nrm.config.StallTimeout,
+			Checkpoint:         rm.checkpointFn(nrm),
+			CheckpointInterval: rm.checkpointInterval,
+			Commit:             rm.commitFn(request.ID(), nrm.config.Staged),
 		})
 	return incoming, incomingError
 }
 
+// blockHooksFn returns a function that records each block loaded for a request
+// in the given state before running block hooks
+func (rm *RequestManager) blockHooksFn(state *requestState) func(peer.ID, graphsync.ResponseData, graphsync.BlockData) error {
+	return func(p peer.ID, response graphsync.ResponseData, block graphsync.BlockData) error {
+		state.recordBlock(block)
+		return rm.processBlockHooks(p, response, block)
+	}
+}
+
+// commitFn returns a function that commits the blocks staged for a request,
+// or nil if the request isn't staged
+func (rm *RequestManager) commitFn(requestID graphsync.RequestID, staged bool) func() error {
+	if !staged {
+		return nil
+	}
+	return func() error {
+		return rm.asyncLoader.CommitRequest(requestID)
+	}
+}
+
+// checkpointFn returns a function that records checkpoints for a new request
+// to the checkpoint store, or nil if the request isn't checkpointed. Checkpoints
+// name the peer the request is on when they are written, so a resumed request
+// goes to the peer it failed over or was redirected to
+func (rm *RequestManager) checkpointFn(nrm *newRequestMessage) func(peer.ID, *cid.Set, bool) {
+	checkpointID := nrm.config.CheckpointID
+	if checkpointID == "" || rm.checkpointStore == nil {
+		return nil
+	}
+	// the do not send cids are tracked separately as verified cids, and a
+	// resumed request sends the deadline of the context it is resumed with
+	extensions := make([]graphsync.ExtensionData, 0, len(nrm.config.Extensions))
+	for _, extension := range nrm.config.Extensions {
+		if extension.Name != graphsync.ExtensionDoNotSendCIDs && extension.Name != graphsync.ExtensionDeadline {
+			extensions = append(extensions, extension)
+		}
+	}
+	return func(p peer.ID, verifiedCids *cid.Set, complete bool) {
+		var err error
+		if complete {
+			err = rm.checkpointStore.Delete(checkpointID)
+		} else {
+			err = rm.checkpointStore.Put(graphsync.Checkpoint{
+				ID:           checkpointID,
+				Root:         nrm.root,
+				Selector:     nrm.selector,
+				Peer:         p,
+				Extensions:   extensions,
+				VerifiedCids: verifiedCids,
+			})
+		}
+		if err != nil {
+			log.Warnf("unable to update checkpoint %s: %s", checkpointID, err)
+		}
+	}
+}
+
 func (nrm *newRequestMessage) handle(rm *RequestManager) {
 	var ipr inProgressRequest
-	ipr.requestID = rm.nextRequestID
-	rm.nextRequestID++
-	ipr.incoming, ipr.incomingError = nrm.setupRequest(ipr.requestID, rm)
+	key, dedupable := dedupKey(nrm.peers, nrm.root, nrm.selector, nrm.config)
+	if dedupable {
+		ipr.requestID, ipr.incoming, ipr.incomingError, ipr.shared = rm.joinRequest(nrm.ctx, key)
+	}
+	if !ipr.shared {
+		ipr.requestID = rm.nextRequestID
+		rm.nextRequestID++
+		if !rm.hasFreeSlots(nrm.peers) {
+			ipr = rm.queueRequest(ipr.requestID, nrm, key, dedupable)
+		} else {
+			incoming, incomingError := nrm.setupRequest(ipr.requestID, &requestState{}, rm)
+			ipr.incoming, ipr.incomingError = incoming, incomingError
+			if requestStatus, ok := rm.inProgressRequestStatuses[ipr.requestID]; ok && dedupable {
+				requestStatus.fanout = newRequestFanout(rm.ctx, incoming, incomingError)
+				requestStatus.dedupKey = key
+				rm.dedupedRequests[key] = ipr.requestID
+				ipr.incoming, ipr.incomingError, ipr.shared = requestStatus.fanout.subscribe(nrm.ctx)
+				requestStatus.subscribers++
+			}
+		}
+	}
+	if requestStatus, ok := rm.inProgressRequestStatuses[ipr.requestID]; ok {
+		ipr.state = requestStatus.state
+	} else if qr := rm.queuedRequests.get(ipr.requestID); qr != nil {
+		ipr.state = qr.state
+	}
 
 	select {
 	case nrm.inProgressRequestChan <- ipr:
@@ -363,39 +861,324 @@ func (nrm *newRequestMessage) handle(rm *RequestManager) {
 	}
 }
 
+// joinRequest subscribes to an in progress request identical to a new one, if
+// there is one that can still be joined
+func (rm *RequestManager) joinRequest(ctx context.Context, key string) (graphsync.RequestID, <-chan graphsync.ResponseProgress, <-chan error, bool) {
+	requestID, ok := rm.dedupedRequests[key]
+	if !ok {
+		return 0, nil, nil, false
+	}
+	if qr := rm.queuedRequests.get(requestID); qr != nil {
+		// queued requests can be joined as long as they outlast the caller, as
+		// they have sent nothing yet
+		if !outlasts(contextDeadline(qr.nrm.ctx), contextDeadline(ctx)) {
+			return 0, nil, nil, false
+		}
+		incoming, incomingError, joined := qr.fanout.subscribe(ctx)
+		qr.subscribers++
+		return requestID, incoming, incomingError, joined
+	}
+	requestStatus := rm.inProgressRequestStatuses[requestID]
+	if !outlasts(requestStatus.deadline, contextDeadline(ctx)) {
+		return 0, nil, nil, false
+	}
+	incoming, incomingError, joined := requestStatus.fanout.subscribe(ctx)
+	if !joined {
+		return 0, nil, nil, false
+	}
+	log.Debugf("joining in progress request %d", requestID)
+	requestStatus.subscribers++
+	return requestID, incoming, incomingError, true
+}
+
 func (trm *terminateRequestMessage) handle(rm *RequestManager) {
+	if requestStatus, ok := rm.inProgressRequestStatuses[trm.requestID]; ok {
+		if requestStatus.fanout != nil && rm.dedupedRequests[requestStatus.dedupKey] == trm.requestID {
+			delete(rm.dedupedRequests, requestStatus.dedupKey)
+		}
+		rm.releaseSlots(requestStatus.peers)
+	}
 	delete(rm.inProgressRequestStatuses, trm.requestID)
 	rm.asyncLoader.CleanupRequest(trm.requestID)
+	rm.startQueuedRequests()
 }
 
 func (crm *cancelRequestMessage) handle(rm *RequestManager) {
+	if qr := rm.queuedRequests.remove(crm.requestID); qr != nil {
+		rm.discardQueuedRequest(qr)
+		return
+	}
 	inProgressRequestStatus, ok := rm.inProgressRequestStatuses[crm.requestID]
 	if !ok {
 		return
 	}
 
-	rm.peerHandler.SendRequest(inProgressRequestStatus.p, gsmsg.CancelRequest(crm.requestID))
+	rm.sendRequestToPeers(inProgressRequestStatus.peers, gsmsg.CancelRequest(crm.requestID))
+	if inProgressRequestStatus.metadata != nil {
+		rm.finishMetadataRequest(crm.requestID, inProgressRequestStatus, graphsync.RequestCancelled, graphsync.RequestCancelledErr{})
+		return
+	}
 	if crm.isPause {
-		inProgressRequestStatus.paused = true
+		inProgressRequestStatus.setPaused(true)
 	} else {
 		inProgressRequestStatus.cancelFn()
 	}
 }
 
+func (urm *unsubscribeRequestMessage) handle(rm *RequestManager) {
+	if qr := rm.queuedRequests.get(urm.requestID); qr != nil {
+		qr.subscribers--
+		if qr.subscribers == 0 {
+			(&cancelRequestMessage{urm.requestID, false}).handle(rm)
+		}
+		return
+	}
+	requestStatus, ok := rm.inProgressRequestStatuses[urm.requestID]
+	if !ok {
+		return
+	}
+	requestStatus.subscribers--
+	if requestStatus.subscribers > 0 {
+		return
+	}
+	if rm.dedupedRequests[requestStatus.dedupKey] == urm.requestID {
+		delete(rm.dedupedRequests, requestStatus.dedupKey)
+	}
+	(&cancelRequestMessage{urm.requestID, false}).handle(rm)
+}
+
+func (pdm *peerDisconnectedMessage) handle(rm *RequestManager) {
+	for requestID, requestStatus := range rm.inProgressRequestStatuses {
+		if !requestStatus.hasPeer(pdm.p) {
+			continue
+		}
+		if _, isTerminated := requestStatus.terminated[pdm.p]; isTerminated {
+			continue
+		}
+		// a responder can keep paused responses across restarts, so requests it
+		// paused that have no other peers to move to wait a while for it to
+		// reconnect and continue them rather than failing
+		if pausedByPeer(requestStatus, pdm.p) {
+			if !requestStatus.hasAlternatePeers() && len(requestStatus.redirectPeers) == 0 {
+				rm.waitForPausedPeer(requestID, requestStatus, pdm.p)
+				continue
+			}
+			delete(requestStatus.awaitingPayment, pdm.p)
+		}
+		rm.terminatePeer(requestID, pdm.p, graphsync.RequestFailedPeerDisconnectedErr{})
+	}
+}
+
+// waitForPausedPeer gives a peer that disconnected while it had a request
+// paused until the paused disconnect timeout to continue it
+func (rm *RequestManager) waitForPausedPeer(requestID graphsync.RequestID, requestStatus *inProgressRequestStatus, p peer.ID) {
+	if _, waiting := requestStatus.pausedDisconnects[p]; waiting {
+		return
+	}
+	log.Debugf("peer %s disconnected while request %d was paused, waiting for it to continue", p.Pretty(), requestID)
+	if requestStatus.pausedDisconnects == nil {
+		requestStatus.pausedDisconnects = make(map[peer.ID]*pausedDisconnectExpiredMessage)
+	}
+	expired := &pausedDisconnectExpiredMessage{requestID, p}
+	requestStatus.pausedDisconnects[p] = expired
+	time.AfterFunc(rm.pausedDisconnectTimeout, func() {
+		select {
+		case rm.messages <- expired:
+		case <-requestStatus.ctx.Done():
+		case <-rm.ctx.Done():
+		}
+	})
+}
+
+// clearPausedDisconnects stops waiting on peers that disconnected while they had
+// a request paused once they respond to it again
+func (rm *RequestManager) clearPausedDisconnects(responses []gsmsg.GraphSyncResponse, p peer.ID) {
+	for _, response := range responses {
+		delete(rm.inProgressRequestStatuses[response.RequestID()].pausedDisconnects, p)
+	}
+}
+
+func (pdem *pausedDisconnectExpiredMessage) handle(rm *RequestManager) {
+	requestStatus, ok := rm.inProgressRequestStatuses[pdem.requestID]
+	if !ok || requestStatus.pausedDisconnects[pdem.p] != pdem {
+		return
+	}
+	delete(requestStatus.pausedDisconnects, pdem.p)
+	if _, isTerminated := requestStatus.terminated[pdem.p]; isTerminated || !requestStatus.hasPeer(pdem.p) {
+		return
+	}
+	log.Infof("peer %s did not continue paused request %d after disconnecting", pdem.p.Pretty(), pdem.requestID)
+	delete(requestStatus.awaitingPayment, pdem.p)
+	rm.terminatePeer(pdem.requestID, pdem.p, graphsync.RequestFailedPeerDisconnectedErr{})
+}
+
+// pausedByPeer returns whether the given peer paused a request, either until it
+// is paid for or for its own reasons
+func pausedByPeer(requestStatus *inProgressRequestStatus, p peer.ID) bool {
+	if _, awaitingPayment := requestStatus.awaitingPayment[p]; awaitingPayment {
+		return true
+	}
+	lastResponse, ok := requestStatus.lastResponse.Load().(gsmsg.GraphSyncResponse)
+	return ok && len(requestStatus.peers) == 1 && lastResponse.Status() == graphsync.RequestPaused
+}
+
 func (prm *processResponseMessage) handle(rm *RequestManager) {
 	filteredResponses := rm.processExtensions(prm.responses, prm.p)
 	filteredResponses = rm.filterResponsesForPeer(filteredResponses, prm.p)
 	rm.updateLastResponses(filteredResponses)
+	rm.signalResponses(filteredResponses)
+	rm.clearPausedDisconnects(filteredResponses, prm.p)
+	rm.recordPaymentRequests(filteredResponses, prm.p)
+	rm.notifyPausedListeners(filteredResponses, prm.p)
 	responseMetadata := metadataForResponses(filteredResponses)
+	rm.collectMetadataOnly(responseMetadata)
+	rm.recordReceivedBlocks(responseMetadata, prm.blks)
+	rm.processMultiPeerMetadata(responseMetadata)
 	rm.asyncLoader.ProcessResponse(responseMetadata, prm.blks)
-	rm.processTerminations(filteredResponses)
+	rm.throttleAsNeeded(responseMetadata)
+	rm.processTerminations(filteredResponses, prm.p)
+}
+
+// recordReceivedBlocks records the blocks received from the network for each
+// request in its state
+func (rm *RequestManager) recordReceivedBlocks(responseMetadata map[graphsync.RequestID]metadata.Metadata, blks []blocks.Block) {
+	if len(blks) == 0 {
+		return
+	}
+	blockSizes := make(map[cid.Cid]uint64, len(blks))
+	for _, block := range blks {
+		blockSizes[block.Cid()] = uint64(len(block.RawData()))
+	}
+	for requestID, md := range responseMetadata {
+		requestStatus := rm.inProgressRequestStatuses[requestID]
+		for _, item := range md {
+			asCidLink, ok := item.Link.(cidlink.Link)
+			if !item.BlockPresent || !ok {
+				continue
+			}
+			size, received := blockSizes[asCidLink.Cid]
+			if !received {
+				continue
+			}
+			requestStatus.state.recordReceivedBlock(size, !requestStatus.receivedCids.Visit(asCidLink.Cid))
+		}
+	}
+}
+
+// throttleAsNeeded pauses requests that receive blocks while blocks buffered
+// in memory exceed the memory budget. Peers are told to stop sending,
+// while the request keeps traversing the blocks it already has
+func (rm *RequestManager) throttleAsNeeded(responseMetadata map[graphsync.RequestID]metadata.Metadata) {
+	if rm.memoryBudget() == 0 || !rm.overBudget() {
+		return
+	}
+	for requestID, md := range responseMetadata {
+		if hasBlocks(md) {
+			rm.throttle(requestID, rm.inProgressRequestStatuses[requestID])
+		}
+	}
+}
+
+func (rm *RequestManager) overBudget() bool {
+	return rm.asyncLoader.BufferedBytes() > rm.memoryBudget() || rm.asyncLoader.MemoryExhausted()
+}
+
+func (rm *RequestManager) throttle(requestID graphsync.RequestID, requestStatus *inProgressRequestStatus) {
+	// metadata only requests receive no blocks, so throttling them frees nothing
+	if requestStatus.throttled || requestStatus.paused || requestStatus.metadata != nil {
+		return
+	}
+	log.Debugf("inbound memory budget exceeded, pausing request %d", requestID)
+	requestStatus.throttled = true
+	rm.sendRequestToPeers(requestStatus.peers, gsmsg.CancelRequest(requestID))
+}
+
+// checkThrottledRequests pauses all requests still receiving blocks while the
+// unverified block store is exhausted, since blocks it drops may belong to any
+// of them, and continues requests paused by throttleAsNeeded once buffered
+// blocks drain to half the memory budget. Requests continue by sending
+// the request again, so any blocks dropped are sent again
+func (rm *RequestManager) checkThrottledRequests() {
+	if rm.asyncLoader.MemoryExhausted() {
+		for requestID, requestStatus := range rm.inProgressRequestStatuses {
+			if len(requestStatus.terminated) < len(requestStatus.peers) {
+				rm.throttle(requestID, requestStatus)
+			}
+		}
+		return
+	}
+	if rm.asyncLoader.BufferedBytes() > rm.memoryBudget()/2 {
+		return
+	}
+	for requestID, requestStatus := range rm.inProgressRequestStatuses {
+		if !requestStatus.throttled {
+			continue
+		}
+		requestStatus.throttled = false
+		// unpausing a paused request sends it again anyway
+		if requestStatus.paused || len(requestStatus.terminated) == len(requestStatus.peers) {
+			continue
+		}
+		log.Debugf("inbound memory budget available, resuming request %d", requestID)
+		select {
+		case requestStatus.restartMessages <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func hasBlocks(md metadata.Metadata) bool {
+	for _, item := range md {
+		if item.BlockPresent {
+			return true
+		}
+	}
+	return false
+}
+
+// processMultiPeerMetadata adjusts metadata for requests that can receive blocks
+// from several peers. A block one peer is missing may still come from another,
+// so missing blocks are dropped from the metadata.
+func (rm *RequestManager) processMultiPeerMetadata(responseMetadata map[graphsync.RequestID]metadata.Metadata) {
+	for requestID, md := range responseMetadata {
+		requestStatus := rm.inProgressRequestStatuses[requestID]
+		if !requestStatus.hasAlternatePeers() {
+			continue
+		}
+		presentItems := make(metadata.Metadata, 0, len(md))
+		for _, item := range md {
+			if item.BlockPresent {
+				presentItems = append(presentItems, item)
+			}
+		}
+		responseMetadata[requestID] = presentItems
+	}
+}
+
+func (rm *RequestManager) sendRequestToPeers(peers []peer.ID, request gsmsg.GraphSyncRequest) {
+	for _, p := range peers {
+		rm.peerHandler.SendRequest(p, request)
+	}
+}
+
+func uniquePeers(peers []peer.ID) []peer.ID {
+	seen := make(map[peer.ID]struct{}, len(peers))
+	unique := make([]peer.ID, 0, len(peers))
+	for _, p := range peers {
+		if _, ok := seen[p]; ok {
+			continue
+		}
+		seen[p] = struct{}{}
+		unique = append(unique, p)
+	}
+	return unique
 }
 
 func (rm *RequestManager) filterResponsesForPeer(responses []gsmsg.GraphSyncResponse, p peer.ID) []gsmsg.GraphSyncResponse {
 	responsesForPeer := make([]gsmsg.GraphSyncResponse, 0, len(responses))
 	for _, response := range responses {
 		requestStatus, ok := rm.inProgressRequestStatuses[response.RequestID()]
-		if !ok || requestStatus.p != p {
+		if !ok || !requestStatus.hasPeer(p) {
 			continue
 		}
 		responsesForPeer = append(responsesForPeer, response)
@@ -420,6 +1203,41 @@ func (rm *RequestManager) updateLastResponses(responses []gsmsg.GraphSyncRespons
 	}
 }
 
+// signalResponses tells the executors of requests that received responses
+// that their peers are still working on them
+func (rm *RequestManager) signalResponses(responses []gsmsg.GraphSyncResponse) {
+	for _, response := range responses {
+		select {
+		case rm.inProgressRequestStatuses[response.RequestID()].responseMessages <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// recordPaymentRequests tracks the peers that paused each request until it is
+// paid for, so the payment can be sent when the request is unpaused
+func (rm *RequestManager) recordPaymentRequests(responses []gsmsg.GraphSyncResponse, p peer.ID) {
+	for _, response := range responses {
+		requestStatus := rm.inProgressRequestStatuses[response.RequestID()]
+		if response.Status() != graphsync.NotEnoughGas {
+			delete(requestStatus.awaitingPayment, p)
+			continue
+		}
+		if requestStatus.awaitingPayment == nil {
+			requestStatus.awaitingPayment = make(map[peer.ID]struct{})
+		}
+		requestStatus.awaitingPayment[p] = struct{}{}
+	}
+}
+
+func (rm *RequestManager) notifyPausedListeners(responses []gsmsg.GraphSyncResponse, p peer.ID) {
+	for _, response := range responses {
+		if response.Status() == graphsync.RequestPaused || response.Status() == graphsync.NotEnoughGas {
+			rm.pausedListeners.NotifyPausedListeners(p, rm.inProgressRequestStatuses[response.RequestID()].request)
+		}
+	}
+}
+
 func (rm *RequestManager) processExtensionsForResponse(p peer.ID, response gsmsg.GraphSyncResponse) bool {
 	result := rm.responseHooks.ProcessResponseHooks(p, response)
 	if len(result.Extensions) > 0 {
@@ -432,36 +1250,190 @@ func (rm *RequestManager) processExtensionsForResponse(p peer.ID, response gsmsg
 			return false
 		}
 		responseError := rm.generateResponseErrorFromStatus(graphsync.RequestFailedUnknown)
+		if requestStatus.metadata != nil {
+			rm.sendRequestToPeers(requestStatus.peers, gsmsg.CancelRequest(response.RequestID()))
+			rm.finishMetadataRequest(response.RequestID(), requestStatus, graphsync.RequestFailedUnknown, responseError)
+			return false
+		}
 		select {
 		case requestStatus.networkError <- responseError:
 		case <-requestStatus.ctx.Done():
 		}
-		rm.peerHandler.SendRequest(p, gsmsg.CancelRequest(response.RequestID()))
+		rm.sendRequestToPeers(requestStatus.peers, gsmsg.CancelRequest(response.RequestID()))
 		requestStatus.cancelFn()
 		return false
 	}
 	return true
 }
 
-func (rm *RequestManager) processTerminations(responses []gsmsg.GraphSyncResponse) {
+func (rm *RequestManager) processTerminations(responses []gsmsg.GraphSyncResponse, p peer.ID) {
 	for _, response := range responses {
+		if response.Status() == graphsync.AdditionalPeers {
+			rm.processRedirect(response, p)
+			continue
+		}
+		// blocks missing from one peer's partition may still come from the others
+		if response.Status() == graphsync.RequestCompletedPartial && rm.dropPeer(response.RequestID(), p) {
+			continue
+		}
 		if gsmsg.IsTerminalResponseCode(response.Status()) {
+			var responseError error
 			if gsmsg.IsTerminalFailureCode(response.Status()) {
-				requestStatus := rm.inProgressRequestStatuses[response.RequestID()]
-				responseError := rm.generateResponseErrorFromStatus(response.Status())
-				select {
-				case requestStatus.networkError <- responseError:
-				case <-requestStatus.ctx.Done():
-				}
-				requestStatus.cancelFn()
+				responseError = rm.generateResponseErrorFromStatus(response.Status())
 			}
-			rm.asyncLoader.CompleteResponsesFor(response.RequestID())
+			rm.terminatePeer(response.RequestID(), p, responseError)
 		}
 	}
 }
 
+// terminatePeer records that a peer is done with a request, with the error it
+// failed with if any, and finishes the request once all its peers are done
+func (rm *RequestManager) terminatePeer(requestID graphsync.RequestID, p peer.ID, responseError error) {
+	if responseError != nil && rm.dropPeer(requestID, p) {
+		return
+	}
+	requestStatus := rm.inProgressRequestStatuses[requestID]
+	requestStatus.terminated[p] = responseError
+	if len(requestStatus.terminated) < len(requestStatus.peers) {
+		return
+	}
+	if requestStatus.metadata != nil {
+		rm.terminateMetadataRequest(requestID, requestStatus, responseError)
+		return
+	}
+	if requestStatus.allFailed() {
+		if rm.failover(requestID, requestStatus, p, responseError) {
+			return
+		}
+		select {
+		case requestStatus.networkError <- responseError:
+		case <-requestStatus.ctx.Done():
+		}
+		requestStatus.cancelFn()
+	}
+	rm.asyncLoader.CompleteResponsesFor(requestID)
+}
+
+// dropPeer takes a peer that failed or is missing blocks off a request it
+// shares with other peers, so the request is sent again to the remaining peers
+// and its partition of the blocks is shared out between them. The last peer
+// can't be dropped
+func (rm *RequestManager) dropPeer(requestID graphsync.RequestID, p peer.ID) bool {
+	requestStatus := rm.inProgressRequestStatuses[requestID]
+	if requestStatus.metadata != nil || len(requestStatus.peers) < 2 {
+		return false
+	}
+	remaining := make([]peer.ID, 0, len(requestStatus.peers)-1)
+	for _, requestPeer := range requestStatus.peers {
+		if requestPeer != p {
+			remaining = append(remaining, requestPeer)
+		}
+	}
+	log.Infof("request %d stopped on peer %s, sharing its blocks between the remaining peers", requestID, p.Pretty())
+	rm.releaseSlots([]peer.ID{p})
+	requestStatus.peers = remaining
+	if requestStatus.p == p {
+		requestStatus.p = remaining[0]
+	}
+	delete(requestStatus.awaitingPayment, p)
+	// the remaining peers are all sent the request again
+	requestStatus.terminated = make(map[peer.ID]error)
+	requestStatus.dropPeerMessages <- p
+	return true
+}
+
+// failover resumes a failed request against the next peer from its last
+// redirect or its peer provider, if it has either and the failure is one another
+// peer could recover from
+func (rm *RequestManager) failover(requestID graphsync.RequestID, requestStatus *inProgressRequestStatus, p peer.ID, responseError error) bool {
+	switch responseError.(type) {
+	case graphsync.RequestFailedBusyErr, graphsync.RequestFailedContentNotFoundErr, graphsync.RequestFailedPeerDisconnectedErr:
+	default:
+		return false
+	}
+	nextPeer, ok := rm.nextRedirectPeer(requestStatus)
+	if !ok && requestStatus.peerProvider != nil {
+		nextPeer, ok = requestStatus.peerProvider(p, responseError)
+	}
+	if !ok {
+		return false
+	}
+	log.Infof("request %d failed on peer %s, resuming with peer %s", requestID, p.Pretty(), nextPeer.Pretty())
+	rm.switchPeer(requestStatus, nextPeer)
+	return true
+}
+
+// processRedirect follows a redirect from a responder to other peers, if the
+// request follows redirects, and otherwise fails the request on that peer
+func (rm *RequestManager) processRedirect(response gsmsg.GraphSyncResponse, p peer.ID) {
+	requestID := response.RequestID()
+	var redirectErr error = graphsync.RequestFailedUnknownErr{}
+	if redirectData, has := response.Extension(graphsync.ExtensionRedirect); has {
+		peers, err := redirect.DecodeRedirect(redirectData)
+		if err != nil {
+			log.Warnf("unable to decode redirect for request %d: %s", requestID, err)
+		} else if rm.followRedirect(requestID, p, peers) {
+			return
+		} else {
+			redirectErr = graphsync.RequestRedirectedErr{Peers: peers}
+		}
+	}
+	rm.terminatePeer(requestID, p, redirectErr)
+}
+
+// followRedirect switches a request to the peers a responder redirected it to.
+// Requests sent to several peers don't follow redirects, and a redirect fails
+// the request on the peer that sent it
+func (rm *RequestManager) followRedirect(requestID graphsync.RequestID, p peer.ID, peers []peer.AddrInfo) bool {
+	requestStatus := rm.inProgressRequestStatuses[requestID]
+	if len(peers) == 0 || len(requestStatus.peers) > 1 || requestStatus.redirects >= requestStatus.maxRedirects {
+		return false
+	}
+	redirectPeers := make([]peer.ID, 0, len(peers))
+	for _, addrInfo := range peers {
+		if rm.addrBook != nil && len(addrInfo.Addrs) > 0 {
+			rm.addrBook.AddAddrs(addrInfo.ID, addrInfo.Addrs)
+		}
+		redirectPeers = append(redirectPeers, addrInfo.ID)
+	}
+	log.Infof("request %d redirected by peer %s, resuming with peer %s", requestID, p.Pretty(), redirectPeers[0].Pretty())
+	requestStatus.redirects++
+	requestStatus.redirectPeers = redirectPeers[1:]
+	requestStatus.state.recordRedirect(redirectPeers[0])
+	rm.switchPeer(requestStatus, redirectPeers[0])
+	return true
+}
+
+// nextRedirectPeer returns the next peer from the last redirect to try after
+// a failure another peer could recover from
+func (rm *RequestManager) nextRedirectPeer(requestStatus *inProgressRequestStatus) (peer.ID, bool) {
+	if len(requestStatus.redirectPeers) == 0 {
+		return "", false
+	}
+	nextPeer := requestStatus.redirectPeers[0]
+	requestStatus.redirectPeers = requestStatus.redirectPeers[1:]
+	return nextPeer, true
+}
+
+// switchPeer moves a request that failed on its peers to the given peer
+func (rm *RequestManager) switchPeer(requestStatus *inProgressRequestStatus, nextPeer peer.ID) {
+	rm.releaseSlots(requestStatus.peers)
+	requestStatus.p = nextPeer
+	requestStatus.peers = []peer.ID{nextPeer}
+	rm.occupySlots(requestStatus.peers)
+	requestStatus.terminated = make(map[peer.ID]error)
+	// only the latest peer matters if the executor has not picked up a previous one
+	select {
+	case <-requestStatus.failoverMessages:
+	default:
+	}
+	requestStatus.failoverMessages <- nextPeer
+}
+
 func (rm *RequestManager) generateResponseErrorFromStatus(status graphsync.ResponseStatusCode) error {
 	switch status {
+	case graphsync.RequestRejected:
+		return graphsync.RequestRejectedErr{}
 	case graphsync.RequestFailedBusy:
 		return graphsync.RequestFailedBusyErr{}
 	case graphsync.RequestFailedContentNotFound:
@@ -500,7 +1472,7 @@ func (rm *RequestManager) terminateRequest(requestID graphsync.RequestID) {
 	}
 }
 
-func (rm *RequestManager) validateRequest(requestID graphsync.RequestID, p peer.ID, root ipld.Link, selectorSpec ipld.Node, extensions []graphsync.ExtensionData) (gsmsg.GraphSyncRequest, hooks.RequestResult, error) {
+func (rm *RequestManager) validateRequest(requestID graphsync.RequestID, p peer.ID, root ipld.Link, selectorSpec ipld.Node, config graphsync.RequestConfig) (gsmsg.GraphSyncRequest, hooks.RequestResult, error) {
 	_, err := ipldutil.EncodeNode(selectorSpec)
 	if err != nil {
 		return gsmsg.GraphSyncRequest{}, hooks.RequestResult{}, err
@@ -513,7 +1485,11 @@ func (rm *RequestManager) validateRequest(requestID graphsync.RequestID, p peer.
 	if !ok {
 		return gsmsg.GraphSyncRequest{}, hooks.RequestResult{}, fmt.Errorf("request failed: link has no cid")
 	}
-	request := gsmsg.NewRequest(requestID, asCidLink.Cid, selectorSpec, defaultPriority, extensions...)
+	priority := defaultPriority
+	if config.Priority != 0 {
+		priority = config.Priority
+	}
+	request := gsmsg.NewRequest(requestID, asCidLink.Cid, selectorSpec, priority, config.Extensions...)
 	hooksResult := rm.requestHooks.ProcessRequestHooks(p, request)
 	if hooksResult.PersistenceOption != "" {
 		dedupData, err := dedupkey.EncodeDedupKey(hooksResult.PersistenceOption)
@@ -527,7 +1503,11 @@ func (rm *RequestManager) validateRequest(requestID graphsync.RequestID, p peer.
 			},
 		})
 	}
-	err = rm.asyncLoader.StartRequest(requestID, hooksResult.PersistenceOption)
+	if config.Staged {
+		err = rm.asyncLoader.StartStagedRequest(requestID, hooksResult.PersistenceOption)
+	} else {
+		err = rm.asyncLoader.StartRequest(requestID, hooksResult.PersistenceOption)
+	}
 	if err != nil {
 		return gsmsg.GraphSyncRequest{}, hooks.RequestResult{}, err
 	}
@@ -537,19 +1517,33 @@ func (rm *RequestManager) validateRequest(requestID graphsync.RequestID, p peer.
 func (urm *unpauseRequestMessage) unpause(rm *RequestManager) error {
 	inProgressRequestStatus, ok := rm.inProgressRequestStatuses[urm.id]
 	if !ok {
-		return errors.New("request not found")
+		return rm.notInProgressError(urm.id)
 	}
 	if !inProgressRequestStatus.paused {
-		return errors.New("request is not paused")
+		if len(inProgressRequestStatus.awaitingPayment) == 0 {
+			return errors.New("request is not paused")
+		}
+		// the responders paused the request until it is paid for, so the
+		// extensions go to them as an update
+		update := gsmsg.UpdateRequest(urm.id, urm.extensions...)
+		for p := range inProgressRequestStatus.awaitingPayment {
+			rm.peerHandler.SendRequest(p, update)
+		}
+		inProgressRequestStatus.awaitingPayment = nil
+		return nil
 	}
-	inProgressRequestStatus.paused = false
+	inProgressRequestStatus.setPaused(false)
 	select {
 	case <-inProgressRequestStatus.pauseMessages:
-		rm.peerHandler.SendRequest(inProgressRequestStatus.p, gsmsg.UpdateRequest(urm.id, urm.extensions...))
+		rm.sendRequestToPeers(inProgressRequestStatus.peers, gsmsg.UpdateRequest(urm.id, urm.extensions...))
+		inProgressRequestStatus.awaitingPayment = nil
 		return nil
 	case <-rm.ctx.Done():
 		return errors.New("context cancelled")
 	case inProgressRequestStatus.resumeMessages <- urm.extensions:
+		// the request will be sent again to all peers
+		inProgressRequestStatus.terminated = make(map[peer.ID]error)
+		inProgressRequestStatus.awaitingPayment = nil
 		return nil
 	}
 }
@@ -563,12 +1557,15 @@ func (urm *unpauseRequestMessage) handle(rm *RequestManager) {
 func (prm *pauseRequestMessage) pause(rm *RequestManager) error {
 	inProgressRequestStatus, ok := rm.inProgressRequestStatuses[prm.id]
 	if !ok {
-		return errors.New("request not found")
+		return rm.notInProgressError(prm.id)
 	}
 	if inProgressRequestStatus.paused {
 		return errors.New("request is already paused")
 	}
-	inProgressRequestStatus.paused = true
+	if inProgressRequestStatus.metadata != nil {
+		return errors.New("metadata only requests cannot be paused")
+	}
+	inProgressRequestStatus.setPaused(true)
 	select {
 	case <-rm.ctx.Done():
 		return errors.New("context cancelled")
@@ -583,3 +1580,27 @@ func (prm *pauseRequestMessage) handle(rm *RequestManager) {
 	case prm.response <- err:
 	}
 }
+
+func (urm *updateRequestMessage) update(rm *RequestManager) error {
+	if rm.queuedRequests.get(urm.id) != nil {
+		return rm.updateQueuedRequest(urm.id, urm.extensions)
+	}
+	inProgressRequestStatus, ok := rm.inProgressRequestStatuses[urm.id]
+	if !ok {
+		return errors.New("request not found")
+	}
+	update := gsmsg.UpdateRequest(urm.id, urm.extensions...)
+	for _, p := range inProgressRequestStatus.peers {
+		if _, isTerminated := inProgressRequestStatus.terminated[p]; !isTerminated {
+			rm.peerHandler.SendRequest(p, update)
+		}
+	}
+	return nil
+}
+func (urm *updateRequestMessage) handle(rm *RequestManager) {
+	err := urm.update(rm)
+	select {
+	case <-rm.ctx.Done():
+	case urm.response <- err:
+	}
+}
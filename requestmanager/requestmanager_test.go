@@ -4,24 +4,35 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"sync"
 	"testing"
 	"time"
 
 	blocks "github.com/ipfs/go-block-format"
 	"github.com/ipld/go-ipld-prime"
 	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	basicnode "github.com/ipld/go-ipld-prime/node/basic"
+	"github.com/ipld/go-ipld-prime/traversal/selector"
+	"github.com/ipld/go-ipld-prime/traversal/selector/builder"
 	"github.com/libp2p/go-libp2p-core/peer"
+	ma "github.com/multiformats/go-multiaddr"
 	"github.com/stretchr/testify/require"
 
 	"github.com/ipfs/go-graphsync"
 	"github.com/ipfs/go-graphsync/cidset"
+	"github.com/ipfs/go-graphsync/deadline"
 	"github.com/ipfs/go-graphsync/dedupkey"
 	gsmsg "github.com/ipfs/go-graphsync/message"
 	"github.com/ipfs/go-graphsync/metadata"
+	"github.com/ipfs/go-graphsync/partition"
+	"github.com/ipfs/go-graphsync/payments"
+	"github.com/ipfs/go-graphsync/redirect"
 	"github.com/ipfs/go-graphsync/requestmanager/hooks"
 	"github.com/ipfs/go-graphsync/requestmanager/testloader"
 	"github.com/ipfs/go-graphsync/requestmanager/types"
+	"github.com/ipfs/go-graphsync/selectorvalidator"
 	"github.com/ipfs/go-graphsync/testutil"
+	"github.com/ipfs/go-graphsync/updatepriority"
 )
 
 type requestRecord struct {
@@ -164,8 +175,9 @@ func TestCancelRequestInProgress(t *testing.T) {
 	defer cancel2()
 	peers := testutil.GeneratePeers(1)
 
-	returnedResponseChan1, returnedErrorChan1 := td.requestManager.SendRequest(requestCtx1, peers[0], td.blockChain.TipLink, td.blockChain.Selector())
-	returnedResponseChan2, returnedErrorChan2 := td.requestManager.SendRequest(requestCtx2, peers[0], td.blockChain.TipLink, td.blockChain.Selector())
+	// the requests differ, so they aren't shared
+	returnedResponseChan1, returnedErrorChan1 := td.requestManager.SendRequest(requestCtx1, peers[0], td.blockChain.TipLink, td.blockChain.Selector(), td.extension1)
+	returnedResponseChan2, returnedErrorChan2 := td.requestManager.SendRequest(requestCtx2, peers[0], td.blockChain.TipLink, td.blockChain.Selector(), td.extension2)
 
 	requestRecords := readNNetworkRequests(requestCtx, t, td.requestRecordChan, 2)
 
@@ -236,107 +248,1238 @@ func TestCancelManagerExitsGracefully(t *testing.T) {
 	testutil.VerifyEmptyErrors(requestCtx, t, returnedErrorChan)
 }
 
+func TestDeduplicateRequests(t *testing.T) {
+	ctx := context.Background()
+	td := newTestData(ctx, t)
+	requestCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	peers := testutil.GeneratePeers(1)
+
+	requestCtx1, cancel1 := context.WithCancel(requestCtx)
+	defer cancel1()
+	returnedResponseChan1, _ := td.requestManager.SendRequest(requestCtx1, peers[0], td.blockChain.TipLink, td.blockChain.Selector(), td.extension1)
+	rr := readNNetworkRequests(requestCtx, t, td.requestRecordChan, 1)[0]
+	td.fal.SuccessResponseOn(rr.gsr.ID(), td.blockChain.Blocks(0, 3))
+	td.blockChain.VerifyResponseRange(requestCtx, returnedResponseChan1, 0, 3)
+
+	// an identical request joins the one in progress, receiving responses sent so far
+	requestCtx2, cancel2 := context.WithCancel(requestCtx)
+	defer cancel2()
+	returnedResponseChan2, returnedErrorChan2 := td.requestManager.SendRequest(requestCtx2, peers[0], td.blockChain.TipLink, td.blockChain.Selector(), td.extension1)
+	td.blockChain.VerifyResponseRange(requestCtx, returnedResponseChan2, 0, 3)
+
+	// a request with different extensions does not
+	requestCtx3, cancel3 := context.WithCancel(requestCtx)
+	_, _ = td.requestManager.SendRequest(requestCtx3, peers[0], td.blockChain.TipLink, td.blockChain.Selector(), td.extension2)
+	otherRequest := readNNetworkRequests(requestCtx, t, td.requestRecordChan, 1)[0]
+	require.NotEqual(t, rr.gsr.ID(), otherRequest.gsr.ID())
+	cancel3()
+	otherCancel := readNNetworkRequests(requestCtx, t, td.requestRecordChan, 1)[0]
+	require.True(t, otherCancel.gsr.IsCancel())
+	require.Equal(t, otherRequest.gsr.ID(), otherCancel.gsr.ID())
+
+	// cancelling one caller leaves the request running for the other
+	cancel1()
+	testutil.VerifyEmptyResponse(requestCtx, t, returnedResponseChan1)
+	// the caller has unsubscribed once its responses close, and a round trip
+	// through the request manager makes sure that was processed
+	require.Error(t, td.requestManager.PauseRequest(graphsync.RequestID(-1)))
+	testutil.AssertChannelEmpty(t, td.requestRecordChan, "should not cancel request while callers remain")
+	td.fal.SuccessResponseOn(rr.gsr.ID(), td.blockChain.RemainderBlocks(3))
+	td.blockChain.VerifyRemainder(requestCtx, returnedResponseChan2, 3)
+	testutil.VerifyEmptyErrors(requestCtx, t, returnedErrorChan2)
+}
+
+func TestRequestPriority(t *testing.T) {
+	ctx := context.Background()
+	td := newTestData(ctx, t)
+	requestCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	peers := testutil.GeneratePeers(1)
+
+	_, _ = td.requestManager.SendRequestWithOptions(requestCtx, peers[0], td.blockChain.TipLink, td.blockChain.Selector(),
+		graphsync.WithPriority(graphsync.Priority(10)))
+	rr := readNNetworkRequests(requestCtx, t, td.requestRecordChan, 1)[0]
+	require.Equal(t, graphsync.Priority(10), rr.gsr.Priority())
+
+	err := td.requestManager.UpdatePriority(rr.gsr.ID(), graphsync.Priority(20))
+	require.NoError(t, err)
+	update := readNNetworkRequests(requestCtx, t, td.requestRecordChan, 1)[0]
+	require.True(t, update.gsr.IsUpdate())
+	priorityData, has := update.gsr.Extension(graphsync.ExtensionUpdatePriority)
+	require.True(t, has)
+	priority, err := updatepriority.DecodePriority(priorityData)
+	require.NoError(t, err)
+	require.Equal(t, graphsync.Priority(20), priority)
+
+	err = td.requestManager.UpdatePriority(graphsync.RequestID(-1), graphsync.Priority(20))
+	require.EqualError(t, err, "request not found")
+}
+
+func TestMaxInProgressRequests(t *testing.T) {
+	ctx := context.Background()
+	td := newTestData(ctx, t)
+	requestCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	peers := testutil.GeneratePeers(3)
+	requestManager := New(ctx, td.fal, td.requestHooks, td.responseHooks, td.blockHooks, td.completedListeners, td.pausedListeners, td.errorListeners)
+	requestManager.SetDelegate(td.fph)
+	requestManager.SetMaxInProgressRequests(2)
+	requestManager.SetMaxInProgressRequestsPerPeer(1)
+	requestManager.Startup()
+
+	completeRequest := func(rr requestRecord, returnedResponseChan <-chan graphsync.ResponseProgress, returnedErrorChan <-chan error) {
+		responses := []gsmsg.GraphSyncResponse{
+			gsmsg.NewResponse(rr.gsr.ID(), graphsync.RequestCompletedFull, encodedMetadataForBlocks(t, td.blockChain.AllBlocks(), true)),
+		}
+		requestManager.ProcessResponses(rr.p, responses, td.blockChain.AllBlocks())
+		td.fal.VerifyLastProcessedBlocks(ctx, t, td.blockChain.AllBlocks())
+		td.fal.VerifyLastProcessedResponses(ctx, t, map[graphsync.RequestID]metadata.Metadata{
+			rr.gsr.ID(): metadataForBlocks(td.blockChain.AllBlocks(), true),
+		})
+		td.fal.SuccessResponseOn(rr.gsr.ID(), td.blockChain.AllBlocks())
+		td.blockChain.VerifyWholeChain(requestCtx, returnedResponseChan)
+		testutil.VerifyEmptyErrors(requestCtx, t, returnedErrorChan)
+	}
+
+	returnedResponseChan1, returnedErrorChan1 := requestManager.SendRequest(requestCtx, peers[0], td.blockChain.TipLink, td.blockChain.Selector())
+	rr1 := readNNetworkRequests(requestCtx, t, td.requestRecordChan, 1)[0]
+
+	// the peer limit is reached, so the request is queued
+	rh2 := requestManager.SendRequestWithHandle(requestCtx, peers[0], td.blockChain.TipLink, td.blockChain.Selector(), graphsync.WithExtensions(td.extension1))
+	require.Equal(t, graphsync.RequestStatusQueued, rh2.Status())
+	require.EqualError(t, rh2.Pause(), "request has not started")
+
+	returnedResponseChan3, returnedErrorChan3 := requestManager.SendRequest(requestCtx, peers[1], td.blockChain.TipLink, td.blockChain.Selector())
+	rr3 := readNNetworkRequests(requestCtx, t, td.requestRecordChan, 1)[0]
+	require.Equal(t, peers[1], rr3.p)
+
+	// the total limit is reached, so requests to other peers are queued too
+	returnedResponseChan4, returnedErrorChan4 := requestManager.SendRequestWithOptions(requestCtx, peers[2], td.blockChain.TipLink, td.blockChain.Selector(), graphsync.WithPriority(graphsync.Priority(1)))
+	cancelledCtx, cancelQueued := context.WithCancel(requestCtx)
+	returnedResponseChan5, returnedErrorChan5 := requestManager.SendRequestWithOptions(cancelledCtx, peers[2], td.blockChain.TipLink, td.blockChain.Selector(), graphsync.WithPriority(graphsync.Priority(10)))
+	cancelQueued()
+	testutil.VerifyEmptyResponse(requestCtx, t, returnedResponseChan5)
+	testutil.VerifyEmptyErrors(requestCtx, t, returnedErrorChan5)
+	err := requestManager.UpdatePriority(rh2.ID(), graphsync.Priority(5))
+	require.NoError(t, err)
+	time.Sleep(100 * time.Millisecond)
+	testutil.AssertChannelEmpty(t, td.requestRecordChan, "should not send queued requests")
+
+	// the highest priority queued request starts first
+	completeRequest(rr1, returnedResponseChan1, returnedErrorChan1)
+	rr2 := readNNetworkRequests(requestCtx, t, td.requestRecordChan, 1)[0]
+	require.Equal(t, rh2.ID(), rr2.gsr.ID())
+	require.Equal(t, graphsync.Priority(5), rr2.gsr.Priority())
+	ext1Data, has := rr2.gsr.Extension(td.extensionName1)
+	require.True(t, has)
+	require.Equal(t, td.extensionData1, ext1Data)
+	require.Equal(t, graphsync.RequestStatusRunning, rh2.Status())
+
+	completeRequest(rr3, returnedResponseChan3, returnedErrorChan3)
+	rr4 := readNNetworkRequests(requestCtx, t, td.requestRecordChan, 1)[0]
+	require.Equal(t, peers[2], rr4.p)
+	require.Equal(t, graphsync.Priority(1), rr4.gsr.Priority())
+
+	completeRequest(rr2, rh2.Responses(), rh2.Errors())
+	require.Equal(t, graphsync.RequestStatusCompleted, rh2.Status())
+	completeRequest(rr4, returnedResponseChan4, returnedErrorChan4)
+}
+
 func TestFailedRequest(t *testing.T) {
 	ctx := context.Background()
 	td := newTestData(ctx, t)
 	requestCtx, cancel := context.WithTimeout(ctx, time.Second)
 	defer cancel()
-	peers := testutil.GeneratePeers(1)
+	peers := testutil.GeneratePeers(1)
+
+	returnedResponseChan, returnedErrorChan := td.requestManager.SendRequest(requestCtx, peers[0], td.blockChain.TipLink, td.blockChain.Selector())
+
+	rr := readNNetworkRequests(requestCtx, t, td.requestRecordChan, 1)[0]
+	failedResponses := []gsmsg.GraphSyncResponse{
+		gsmsg.NewResponse(rr.gsr.ID(), graphsync.RequestFailedContentNotFound),
+	}
+	td.requestManager.ProcessResponses(peers[0], failedResponses, nil)
+
+	testutil.VerifySingleTerminalError(requestCtx, t, returnedErrorChan)
+	testutil.VerifyEmptyResponse(requestCtx, t, returnedResponseChan)
+}
+
+func TestSelectorPolicy(t *testing.T) {
+	ctx := context.Background()
+	td := newTestData(ctx, t)
+	requestCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	peers := testutil.GeneratePeers(1)
+	requestManager := New(ctx, td.fal, td.requestHooks, td.responseHooks, td.blockHooks, td.completedListeners, td.pausedListeners, td.errorListeners)
+	requestManager.SetDelegate(td.fph)
+	requestManager.SetSelectorPolicy(selectorvalidator.SelectorPolicy{MaxRecursionDepth: 3})
+	requestManager.Startup()
+
+	// the block chain selector recurses 5 deep, so fails before it is sent
+	_, returnedErrorChan := requestManager.SendRequest(requestCtx, peers[0], td.blockChain.TipLink, td.blockChain.Selector())
+	var err error
+	testutil.AssertReceive(requestCtx, t, returnedErrorChan, &err, "should receive an error")
+	require.Equal(t, selectorvalidator.ErrInvalidLimit, err)
+	_, err = requestManager.RequestMetadata(requestCtx, peers[0], td.blockChain.TipLink, td.blockChain.Selector())
+	require.Equal(t, selectorvalidator.ErrInvalidLimit, err)
+	testutil.AssertChannelEmpty(t, td.requestRecordChan, "should not send request")
+
+	ssb := builder.NewSelectorSpecBuilder(basicnode.Style.Any)
+	shallowSelector := ssb.ExploreRecursive(selector.RecursionLimitDepth(2), ssb.ExploreFields(func(efsb builder.ExploreFieldsSpecBuilder) {
+		efsb.Insert("Parents", ssb.ExploreAll(ssb.ExploreRecursiveEdge()))
+	})).Node()
+	requestManager.SendRequest(requestCtx, peers[0], td.blockChain.TipLink, shallowSelector)
+	rr := readNNetworkRequests(requestCtx, t, td.requestRecordChan, 1)[0]
+	require.Equal(t, peers[0], rr.p)
+}
+
+func TestRequestListeners(t *testing.T) {
+	ctx := context.Background()
+	td := newTestData(ctx, t)
+	requestCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	peers := testutil.GeneratePeers(1)
+
+	paused := make(chan graphsync.RequestID, 1)
+	td.pausedListeners.Register(func(p peer.ID, request graphsync.RequestData) {
+		paused <- request.ID()
+	})
+	completed := make(chan graphsync.ResponseStatusCode, 1)
+	td.completedListeners.Register(func(p peer.ID, request graphsync.RequestData, status graphsync.ResponseStatusCode) {
+		completed <- status
+	})
+	errs := make(chan error, 1)
+	td.errorListeners.Register(func(p peer.ID, request graphsync.RequestData, err error) {
+		errs <- err
+	})
+
+	returnedResponseChan, returnedErrorChan := td.requestManager.SendRequest(requestCtx, peers[0], td.blockChain.TipLink, td.blockChain.Selector())
+	rr := readNNetworkRequests(requestCtx, t, td.requestRecordChan, 1)[0]
+
+	pausedResponses := []gsmsg.GraphSyncResponse{
+		gsmsg.NewResponse(rr.gsr.ID(), graphsync.RequestPaused),
+	}
+	td.requestManager.ProcessResponses(peers[0], pausedResponses, nil)
+	var pausedRequestID graphsync.RequestID
+	testutil.AssertReceive(requestCtx, t, paused, &pausedRequestID, "should notify paused listeners")
+	require.Equal(t, rr.gsr.ID(), pausedRequestID)
+	td.fal.VerifyLastProcessedBlocks(ctx, t, nil)
+	td.fal.VerifyLastProcessedResponses(ctx, t, map[graphsync.RequestID]metadata.Metadata{})
+
+	failedResponses := []gsmsg.GraphSyncResponse{
+		gsmsg.NewResponse(rr.gsr.ID(), graphsync.RequestFailedContentNotFound),
+	}
+	td.requestManager.ProcessResponses(peers[0], failedResponses, nil)
+	testutil.VerifySingleTerminalError(requestCtx, t, returnedErrorChan)
+	testutil.VerifyEmptyResponse(requestCtx, t, returnedResponseChan)
+
+	var err error
+	testutil.AssertReceive(requestCtx, t, errs, &err, "should notify error listeners")
+	require.IsType(t, graphsync.RequestFailedContentNotFoundErr{}, err)
+	var status graphsync.ResponseStatusCode
+	testutil.AssertReceive(requestCtx, t, completed, &status, "should notify completed listeners")
+	require.Equal(t, graphsync.RequestFailedContentNotFound, status)
+}
+
+func TestPaymentRequested(t *testing.T) {
+	ctx := context.Background()
+	td := newTestData(ctx, t)
+	requestCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	peers := testutil.GeneratePeers(1)
+
+	paused := make(chan graphsync.RequestID, 1)
+	td.pausedListeners.Register(func(p peer.ID, request graphsync.RequestData) {
+		paused <- request.ID()
+	})
+
+	returnedResponseChan, returnedErrorChan := td.requestManager.SendRequest(requestCtx, peers[0], td.blockChain.TipLink, td.blockChain.Selector())
+	rr := readNNetworkRequests(requestCtx, t, td.requestRecordChan, 1)[0]
+
+	err := td.requestManager.UnpauseRequest(rr.gsr.ID())
+	require.EqualError(t, err, "request is not paused")
+
+	paymentRequestData, err := payments.EncodePaymentRequest(100)
+	require.NoError(t, err)
+	paymentResponses := []gsmsg.GraphSyncResponse{
+		gsmsg.NewResponse(rr.gsr.ID(), graphsync.NotEnoughGas, graphsync.ExtensionData{
+			Name: graphsync.ExtensionPaymentRequest,
+			Data: paymentRequestData,
+		}),
+	}
+	td.requestManager.ProcessResponses(peers[0], paymentResponses, nil)
+	td.fal.VerifyLastProcessedBlocks(ctx, t, nil)
+	td.fal.VerifyLastProcessedResponses(ctx, t, map[graphsync.RequestID]metadata.Metadata{})
+	var pausedRequestID graphsync.RequestID
+	testutil.AssertReceive(requestCtx, t, paused, &pausedRequestID, "should notify paused listeners")
+	require.Equal(t, rr.gsr.ID(), pausedRequestID)
+
+	voucher, err := payments.NewMockChannel(100).CreateVoucher(100)
+	require.NoError(t, err)
+	voucherData, err := payments.EncodeVoucher(voucher)
+	require.NoError(t, err)
+	payment := graphsync.ExtensionData{Name: graphsync.ExtensionPayment, Data: voucherData}
+	err = td.requestManager.UnpauseRequest(rr.gsr.ID(), payment)
+	require.NoError(t, err)
+	rr = readNNetworkRequests(requestCtx, t, td.requestRecordChan, 1)[0]
+	require.Equal(t, peers[0], rr.p)
+	require.True(t, rr.gsr.IsUpdate())
+	receivedPayment, has := rr.gsr.Extension(graphsync.ExtensionPayment)
+	require.True(t, has)
+	require.Equal(t, voucherData, receivedPayment)
+	err = td.requestManager.UnpauseRequest(rr.gsr.ID(), payment)
+	require.EqualError(t, err, "request is not paused")
+
+	blks := td.blockChain.AllBlocks()
+	responses := []gsmsg.GraphSyncResponse{
+		gsmsg.NewResponse(rr.gsr.ID(), graphsync.RequestCompletedFull, encodedMetadataForBlocks(t, blks, true)),
+	}
+	td.requestManager.ProcessResponses(peers[0], responses, blks)
+	td.fal.SuccessResponseOn(rr.gsr.ID(), blks)
+	td.blockChain.VerifyWholeChain(requestCtx, returnedResponseChan)
+	testutil.VerifyEmptyErrors(requestCtx, t, returnedErrorChan)
+}
+
+func TestRequestMetadata(t *testing.T) {
+	ctx := context.Background()
+	td := newTestData(ctx, t)
+	requestCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	peers := testutil.GeneratePeers(1)
+	blks := td.blockChain.AllBlocks()
+
+	type metadataResult struct {
+		links []graphsync.LinkMetadata
+		err   error
+	}
+	requestMetadata := func() <-chan metadataResult {
+		results := make(chan metadataResult, 1)
+		go func() {
+			links, err := td.requestManager.RequestMetadata(requestCtx, peers[0], td.blockChain.TipLink, td.blockChain.Selector(), td.extension1)
+			results <- metadataResult{links, err}
+		}()
+		return results
+	}
+
+	results := requestMetadata()
+	rr := readNNetworkRequests(requestCtx, t, td.requestRecordChan, 1)[0]
+	_, has := rr.gsr.Extension(graphsync.ExtensionMetadataOnly)
+	require.True(t, has, "should ask for metadata only")
+	_, has = rr.gsr.Extension(td.extensionName1)
+	require.True(t, has, "should send other extensions")
+
+	md := make(metadata.Metadata, 0, len(blks))
+	for i, block := range blks {
+		item := metadata.Item{Link: cidlink.Link{Cid: block.Cid()}, BlockPresent: i < 3}
+		if item.BlockPresent {
+			item.BlockSize = uint64(len(block.RawData()))
+		}
+		md = append(md, item)
+	}
+	metadataEncoded, err := metadata.EncodeMetadata(md)
+	require.NoError(t, err)
+	responses := []gsmsg.GraphSyncResponse{
+		gsmsg.NewResponse(rr.gsr.ID(), graphsync.PartialResponse, graphsync.ExtensionData{
+			Name: graphsync.ExtensionMetadata,
+			Data: metadataEncoded,
+		}),
+	}
+	td.requestManager.ProcessResponses(peers[0], responses, nil)
+	td.fal.VerifyLastProcessedBlocks(ctx, t, nil)
+	td.fal.VerifyLastProcessedResponses(ctx, t, map[graphsync.RequestID]metadata.Metadata{})
+	responses = []gsmsg.GraphSyncResponse{
+		gsmsg.NewResponse(rr.gsr.ID(), graphsync.RequestCompletedPartial),
+	}
+	td.requestManager.ProcessResponses(peers[0], responses, nil)
+	td.fal.VerifyLastProcessedBlocks(ctx, t, nil)
+	td.fal.VerifyLastProcessedResponses(ctx, t, map[graphsync.RequestID]metadata.Metadata{})
+
+	var result metadataResult
+	testutil.AssertReceive(requestCtx, t, results, &result, "should return metadata")
+	require.NoError(t, result.err)
+	require.Len(t, result.links, len(blks))
+	for i, link := range result.links {
+		require.Equal(t, md[i].Link, link.Link)
+		require.Equal(t, md[i].BlockPresent, link.BlockPresent)
+		require.Equal(t, md[i].BlockSize, link.BlockSize)
+	}
+
+	results = requestMetadata()
+	rr = readNNetworkRequests(requestCtx, t, td.requestRecordChan, 1)[0]
+	responses = []gsmsg.GraphSyncResponse{
+		gsmsg.NewResponse(rr.gsr.ID(), graphsync.RequestFailedContentNotFound),
+	}
+	td.requestManager.ProcessResponses(peers[0], responses, nil)
+	testutil.AssertReceive(requestCtx, t, results, &result, "should return error")
+	require.IsType(t, graphsync.RequestFailedContentNotFoundErr{}, result.err)
+}
+
+func TestQueuedMetadataRequest(t *testing.T) {
+	ctx := context.Background()
+	td := newTestData(ctx, t)
+	requestCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	peers := testutil.GeneratePeers(2)
+	requestManager := New(ctx, td.fal, td.requestHooks, td.responseHooks, td.blockHooks, td.completedListeners, td.pausedListeners, td.errorListeners)
+	requestManager.SetDelegate(td.fph)
+	requestManager.SetMaxInProgressRequests(1)
+	requestManager.Startup()
+
+	returnedResponseChan, returnedErrorChan := requestManager.SendRequest(requestCtx, peers[0], td.blockChain.TipLink, td.blockChain.Selector())
+	rr := readNNetworkRequests(requestCtx, t, td.requestRecordChan, 1)[0]
+
+	// every slot is taken, so the metadata request waits
+	results := make(chan error, 1)
+	go func() {
+		_, err := requestManager.RequestMetadata(requestCtx, peers[1], td.blockChain.TipLink, td.blockChain.Selector())
+		results <- err
+	}()
+	time.Sleep(100 * time.Millisecond)
+	testutil.AssertChannelEmpty(t, td.requestRecordChan, "should not send queued metadata request")
+
+	responses := []gsmsg.GraphSyncResponse{
+		gsmsg.NewResponse(rr.gsr.ID(), graphsync.RequestCompletedFull, encodedMetadataForBlocks(t, td.blockChain.AllBlocks(), true)),
+	}
+	requestManager.ProcessResponses(peers[0], responses, td.blockChain.AllBlocks())
+	td.fal.VerifyLastProcessedBlocks(ctx, t, td.blockChain.AllBlocks())
+	td.fal.VerifyLastProcessedResponses(ctx, t, map[graphsync.RequestID]metadata.Metadata{
+		rr.gsr.ID(): metadataForBlocks(td.blockChain.AllBlocks(), true),
+	})
+	td.fal.SuccessResponseOn(rr.gsr.ID(), td.blockChain.AllBlocks())
+	td.blockChain.VerifyWholeChain(requestCtx, returnedResponseChan)
+	testutil.VerifyEmptyErrors(requestCtx, t, returnedErrorChan)
+
+	// the metadata request starts once the request finishes
+	rr = readNNetworkRequests(requestCtx, t, td.requestRecordChan, 1)[0]
+	require.Equal(t, peers[1], rr.p)
+	_, has := rr.gsr.Extension(graphsync.ExtensionMetadataOnly)
+	require.True(t, has)
+	responses = []gsmsg.GraphSyncResponse{
+		gsmsg.NewResponse(rr.gsr.ID(), graphsync.RequestCompletedFull),
+	}
+	requestManager.ProcessResponses(peers[1], responses, nil)
+	var err error
+	testutil.AssertReceive(requestCtx, t, results, &err, "should return metadata")
+	require.NoError(t, err)
+}
+
+func TestLocallyFulfilledFirstRequestFailsLater(t *testing.T) {
+	ctx := context.Background()
+	td := newTestData(ctx, t)
+
+	requestCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	peers := testutil.GeneratePeers(1)
+
+	returnedResponseChan, returnedErrorChan := td.requestManager.SendRequest(requestCtx, peers[0], td.blockChain.TipLink, td.blockChain.Selector())
+
+	rr := readNNetworkRequests(requestCtx, t, td.requestRecordChan, 1)[0]
+
+	// async loaded response responds immediately
+	td.fal.SuccessResponseOn(rr.gsr.ID(), td.blockChain.AllBlocks())
+
+	td.blockChain.VerifyWholeChain(requestCtx, returnedResponseChan)
+
+	// failure comes in later over network
+	failedResponses := []gsmsg.GraphSyncResponse{
+		gsmsg.NewResponse(rr.gsr.ID(), graphsync.RequestFailedContentNotFound),
+	}
+
+	td.requestManager.ProcessResponses(peers[0], failedResponses, nil)
+	testutil.VerifyEmptyErrors(ctx, t, returnedErrorChan)
+
+}
+
+func TestLocallyFulfilledFirstRequestSucceedsLater(t *testing.T) {
+	ctx := context.Background()
+	td := newTestData(ctx, t)
+
+	requestCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	peers := testutil.GeneratePeers(1)
+
+	called := make(chan struct{})
+	td.responseHooks.Register(func(p peer.ID, response graphsync.ResponseData, hookActions graphsync.IncomingResponseHookActions) {
+		close(called)
+	})
+	returnedResponseChan, returnedErrorChan := td.requestManager.SendRequest(requestCtx, peers[0], td.blockChain.TipLink, td.blockChain.Selector())
+
+	rr := readNNetworkRequests(requestCtx, t, td.requestRecordChan, 1)[0]
+
+	// async loaded response responds immediately
+	td.fal.SuccessResponseOn(rr.gsr.ID(), td.blockChain.AllBlocks())
+
+	td.blockChain.VerifyWholeChain(requestCtx, returnedResponseChan)
+
+	md := encodedMetadataForBlocks(t, td.blockChain.AllBlocks(), true)
+	firstResponses := []gsmsg.GraphSyncResponse{
+		gsmsg.NewResponse(rr.gsr.ID(), graphsync.RequestCompletedFull, md),
+	}
+	td.requestManager.ProcessResponses(peers[0], firstResponses, td.blockChain.AllBlocks())
+
+	td.fal.VerifyNoRemainingData(t, rr.gsr.ID())
+	testutil.VerifyEmptyErrors(ctx, t, returnedErrorChan)
+	testutil.AssertDoesReceive(requestCtx, t, called, "response hooks called for response")
+}
+
+func TestRequestReturnsMissingBlocks(t *testing.T) {
+	ctx := context.Background()
+	td := newTestData(ctx, t)
+
+	requestCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	peers := testutil.GeneratePeers(1)
+
+	returnedResponseChan, returnedErrorChan := td.requestManager.SendRequest(requestCtx, peers[0], td.blockChain.TipLink, td.blockChain.Selector())
+
+	rr := readNNetworkRequests(requestCtx, t, td.requestRecordChan, 1)[0]
+
+	md := encodedMetadataForBlocks(t, td.blockChain.AllBlocks(), false)
+	firstResponses := []gsmsg.GraphSyncResponse{
+		gsmsg.NewResponse(rr.gsr.ID(), graphsync.RequestCompletedPartial, md),
+	}
+	td.requestManager.ProcessResponses(peers[0], firstResponses, nil)
+	for _, block := range td.blockChain.AllBlocks() {
+		td.fal.ResponseOn(rr.gsr.ID(), cidlink.Link{Cid: block.Cid()}, types.AsyncLoadResult{Data: nil, Err: fmt.Errorf("Terrible Thing")})
+	}
+	testutil.VerifyEmptyResponse(ctx, t, returnedResponseChan)
+	errs := testutil.CollectErrors(ctx, t, returnedErrorChan)
+	require.NotEqual(t, len(errs), 0, "did not send errors")
+}
+
+func TestMultiPeerRequest(t *testing.T) {
+	ctx := context.Background()
+	td := newTestData(ctx, t)
+
+	requestCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	peers := testutil.GeneratePeers(2)
+
+	returnedResponseChan, returnedErrorChan := td.requestManager.SendRequestMulti(requestCtx, peers, td.blockChain.TipLink, td.blockChain.Selector())
+
+	requestRecords := readNNetworkRequests(requestCtx, t, td.requestRecordChan, 2)
+	requestID := requestRecords[0].gsr.ID()
+	for i, p := range peers {
+		require.Equal(t, p, requestRecords[i].p)
+		require.Equal(t, requestID, requestRecords[i].gsr.ID(), "should use one request id for all peers")
+		partitionData, has := requestRecords[i].gsr.Extension(graphsync.ExtensionPartition)
+		require.True(t, has, "should ask each peer for a partition of the blocks")
+		requestPartition, err := partition.DecodePartition(partitionData)
+		require.NoError(t, err)
+		require.Equal(t, partition.Partition{Index: i, Count: 2}, requestPartition)
+	}
+
+	blks := td.blockChain.AllBlocks()
+	firstBlocks := blks[:3]
+	md := append(metadataForBlocks(firstBlocks, true), metadataForBlocks(blks[3:4], false)...)
+	mdEncoded, err := metadata.EncodeMetadata(md)
+	require.NoError(t, err)
+	firstResponses := []gsmsg.GraphSyncResponse{
+		gsmsg.NewResponse(requestID, graphsync.PartialResponse, graphsync.ExtensionData{
+			Name: graphsync.ExtensionMetadata,
+			Data: mdEncoded,
+		}),
+	}
+	td.requestManager.ProcessResponses(peers[0], firstResponses, firstBlocks)
+	td.fal.VerifyLastProcessedBlocks(ctx, t, firstBlocks)
+	td.fal.VerifyLastProcessedResponses(ctx, t, map[graphsync.RequestID]metadata.Metadata{
+		requestID: metadataForBlocks(firstBlocks, true),
+	})
+	td.fal.SuccessResponseOn(requestID, firstBlocks)
+	td.blockChain.VerifyResponseRange(requestCtx, returnedResponseChan, 0, 3)
+	testutil.AssertChannelEmpty(t, td.requestRecordChan, "should not send updates while peers are working")
+
+	failedResponses := []gsmsg.GraphSyncResponse{
+		gsmsg.NewResponse(requestID, graphsync.RequestFailedContentNotFound),
+	}
+	td.requestManager.ProcessResponses(peers[0], failedResponses, nil)
+	td.fal.VerifyLastProcessedBlocks(ctx, t, nil)
+	td.fal.VerifyLastProcessedResponses(ctx, t, map[graphsync.RequestID]metadata.Metadata{})
+
+	rr := readNNetworkRequests(requestCtx, t, td.requestRecordChan, 1)[0]
+	require.Equal(t, peers[1], rr.p, "should send the request again to the remaining peer")
+	require.Equal(t, requestID, rr.gsr.ID())
+	require.False(t, rr.gsr.IsUpdate())
+	_, has := rr.gsr.Extension(graphsync.ExtensionPartition)
+	require.False(t, has, "should ask the remaining peer for every block")
+	doNotSendCidsData, has := rr.gsr.Extension(graphsync.ExtensionDoNotSendCIDs)
+	require.True(t, has)
+	doNotSendCids, err := cidset.DecodeCidSet(doNotSendCidsData)
+	require.NoError(t, err)
+	require.Equal(t, len(firstBlocks), doNotSendCids.Len())
+	for _, block := range firstBlocks {
+		require.True(t, doNotSendCids.Has(block.Cid()))
+	}
+
+	remainingBlocks := blks[3:]
+	secondResponses := []gsmsg.GraphSyncResponse{
+		gsmsg.NewResponse(requestID, graphsync.RequestCompletedFull, encodedMetadataForBlocks(t, remainingBlocks, true)),
+	}
+	td.requestManager.ProcessResponses(peers[1], secondResponses, remainingBlocks)
+	td.fal.VerifyLastProcessedBlocks(ctx, t, remainingBlocks)
+	td.fal.VerifyLastProcessedResponses(ctx, t, map[graphsync.RequestID]metadata.Metadata{
+		requestID: metadataForBlocks(remainingBlocks, true),
+	})
+
+	td.fal.SuccessResponseOn(requestID, remainingBlocks)
+	td.blockChain.VerifyRemainder(requestCtx, returnedResponseChan, 3)
+	testutil.VerifyEmptyErrors(requestCtx, t, returnedErrorChan)
+	testutil.AssertChannelEmpty(t, td.requestRecordChan, "should not send updates to finished peers")
+}
+
+func TestMultiPeerRequestPartialPeer(t *testing.T) {
+	ctx := context.Background()
+	td := newTestData(ctx, t)
+	requestCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	peers := testutil.GeneratePeers(2)
+
+	returnedResponseChan, returnedErrorChan := td.requestManager.SendRequestMulti(requestCtx, peers, td.blockChain.TipLink, td.blockChain.Selector())
+
+	requestRecords := readNNetworkRequests(requestCtx, t, td.requestRecordChan, 2)
+	requestID := requestRecords[0].gsr.ID()
+	partialResponses := []gsmsg.GraphSyncResponse{
+		gsmsg.NewResponse(requestID, graphsync.RequestCompletedPartial),
+	}
+	td.requestManager.ProcessResponses(peers[1], partialResponses, nil)
+	td.fal.VerifyLastProcessedBlocks(ctx, t, nil)
+	td.fal.VerifyLastProcessedResponses(ctx, t, map[graphsync.RequestID]metadata.Metadata{})
+
+	rr := readNNetworkRequests(requestCtx, t, td.requestRecordChan, 1)[0]
+	require.Equal(t, peers[0], rr.p, "should ask the remaining peer for the missing peer's blocks")
+	_, has := rr.gsr.Extension(graphsync.ExtensionPartition)
+	require.False(t, has)
+
+	blks := td.blockChain.AllBlocks()
+	responses := []gsmsg.GraphSyncResponse{
+		gsmsg.NewResponse(requestID, graphsync.RequestCompletedFull, encodedMetadataForBlocks(t, blks, true)),
+	}
+	td.requestManager.ProcessResponses(peers[0], responses, blks)
+	td.fal.VerifyLastProcessedBlocks(ctx, t, blks)
+	td.fal.VerifyLastProcessedResponses(ctx, t, map[graphsync.RequestID]metadata.Metadata{
+		requestID: metadataForBlocks(blks, true),
+	})
+	td.fal.SuccessResponseOn(requestID, blks)
+	td.blockChain.VerifyWholeChain(requestCtx, returnedResponseChan)
+	testutil.VerifyEmptyErrors(requestCtx, t, returnedErrorChan)
+}
+
+func TestMultiPeerRequestAllPeersFail(t *testing.T) {
+	ctx := context.Background()
+	td := newTestData(ctx, t)
+	requestCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	peers := testutil.GeneratePeers(2)
+
+	returnedResponseChan, returnedErrorChan := td.requestManager.SendRequestMulti(requestCtx, peers, td.blockChain.TipLink, td.blockChain.Selector())
+
+	requestRecords := readNNetworkRequests(requestCtx, t, td.requestRecordChan, 2)
+	requestID := requestRecords[0].gsr.ID()
+	for _, p := range peers {
+		failedResponses := []gsmsg.GraphSyncResponse{
+			gsmsg.NewResponse(requestID, graphsync.RequestFailedContentNotFound),
+		}
+		td.requestManager.ProcessResponses(p, failedResponses, nil)
+		td.fal.VerifyLastProcessedResponses(ctx, t, map[graphsync.RequestID]metadata.Metadata{})
+		td.fal.VerifyLastProcessedBlocks(ctx, t, nil)
+	}
+
+	testutil.VerifySingleTerminalError(requestCtx, t, returnedErrorChan)
+	testutil.VerifyEmptyResponse(requestCtx, t, returnedResponseChan)
+}
+
+func TestFailoverToFallbackPeers(t *testing.T) {
+	ctx := context.Background()
+	td := newTestData(ctx, t)
+
+	requestCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	peers := testutil.GeneratePeers(2)
+
+	returnedResponseChan, returnedErrorChan := td.requestManager.SendRequestWithOptions(requestCtx, peers[0], td.blockChain.TipLink, td.blockChain.Selector(),
+		graphsync.WithFallbackPeers(peers[1]))
+
+	rr := readNNetworkRequests(requestCtx, t, td.requestRecordChan, 1)[0]
+	require.Equal(t, peers[0], rr.p)
+	requestID := rr.gsr.ID()
+
+	firstBlocks := td.blockChain.Blocks(0, 3)
+	td.fal.SuccessResponseOn(requestID, firstBlocks)
+	td.blockChain.VerifyResponseRange(requestCtx, returnedResponseChan, 0, 3)
+
+	failedResponses := []gsmsg.GraphSyncResponse{
+		gsmsg.NewResponse(requestID, graphsync.RequestFailedBusy, encodedMetadataForBlocks(t, firstBlocks, true)),
+	}
+	td.requestManager.ProcessResponses(peers[0], failedResponses, firstBlocks)
+	td.fal.VerifyLastProcessedBlocks(ctx, t, firstBlocks)
+	td.fal.VerifyLastProcessedResponses(ctx, t, map[graphsync.RequestID]metadata.Metadata{
+		requestID: metadataForBlocks(firstBlocks, true),
+	})
+
+	rr = readNNetworkRequests(requestCtx, t, td.requestRecordChan, 1)[0]
+	require.Equal(t, peers[1], rr.p, "should resume request with fallback peer")
+	require.Equal(t, requestID, rr.gsr.ID())
+	require.False(t, rr.gsr.IsUpdate())
+	doNotSendCidsData, has := rr.gsr.Extension(graphsync.ExtensionDoNotSendCIDs)
+	require.True(t, has)
+	doNotSendCids, err := cidset.DecodeCidSet(doNotSendCidsData)
+	require.NoError(t, err)
+	require.Equal(t, len(firstBlocks), doNotSendCids.Len())
+	for _, block := range firstBlocks {
+		require.True(t, doNotSendCids.Has(block.Cid()))
+	}
+
+	remainingBlocks := td.blockChain.RemainderBlocks(3)
+	secondResponses := []gsmsg.GraphSyncResponse{
+		gsmsg.NewResponse(requestID, graphsync.RequestCompletedFull, encodedMetadataForBlocks(t, remainingBlocks, true)),
+	}
+	td.requestManager.ProcessResponses(peers[1], secondResponses, remainingBlocks)
+	td.fal.VerifyLastProcessedBlocks(ctx, t, remainingBlocks)
+	td.fal.VerifyLastProcessedResponses(ctx, t, map[graphsync.RequestID]metadata.Metadata{
+		requestID: metadataForBlocks(remainingBlocks, true),
+	})
+	td.fal.SuccessResponseOn(requestID, remainingBlocks)
+	td.blockChain.VerifyRemainder(requestCtx, returnedResponseChan, 3)
+	testutil.VerifyEmptyErrors(requestCtx, t, returnedErrorChan)
+}
+
+func TestFailoverFallbackPeersExhausted(t *testing.T) {
+	ctx := context.Background()
+	td := newTestData(ctx, t)
+	requestCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	peers := testutil.GeneratePeers(2)
+
+	returnedResponseChan, returnedErrorChan := td.requestManager.SendRequestWithOptions(requestCtx, peers[0], td.blockChain.TipLink, td.blockChain.Selector(),
+		graphsync.WithFallbackPeers(peers[1]))
+
+	for _, p := range peers {
+		rr := readNNetworkRequests(requestCtx, t, td.requestRecordChan, 1)[0]
+		require.Equal(t, p, rr.p)
+		failedResponses := []gsmsg.GraphSyncResponse{
+			gsmsg.NewResponse(rr.gsr.ID(), graphsync.RequestFailedContentNotFound),
+		}
+		td.requestManager.ProcessResponses(p, failedResponses, nil)
+		td.fal.VerifyLastProcessedResponses(ctx, t, map[graphsync.RequestID]metadata.Metadata{})
+		td.fal.VerifyLastProcessedBlocks(ctx, t, nil)
+	}
+
+	testutil.VerifySingleTerminalError(requestCtx, t, returnedErrorChan)
+	testutil.VerifyEmptyResponse(requestCtx, t, returnedResponseChan)
+}
+
+type fakeAddrBook struct {
+	lk    sync.Mutex
+	addrs map[peer.ID][]ma.Multiaddr
+}
+
+func (fab *fakeAddrBook) AddAddrs(p peer.ID, addrs []ma.Multiaddr) {
+	fab.lk.Lock()
+	fab.addrs[p] = append(fab.addrs[p], addrs...)
+	fab.lk.Unlock()
+}
+
+func TestFollowRedirects(t *testing.T) {
+	ctx := context.Background()
+	td := newTestData(ctx, t)
+	requestCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	peers := testutil.GeneratePeers(3)
+	addr, err := ma.NewMultiaddr("/ip4/127.0.0.1/tcp/4001")
+	require.NoError(t, err)
+	addrBook := &fakeAddrBook{addrs: make(map[peer.ID][]ma.Multiaddr)}
+	requestManager := New(ctx, td.fal, td.requestHooks, td.responseHooks, td.blockHooks, td.completedListeners, td.pausedListeners, td.errorListeners)
+	requestManager.SetDelegate(td.fph)
+	requestManager.SetAddrBook(addrBook)
+	requestManager.Startup()
+
+	redirectData, err := redirect.EncodeRedirect([]peer.AddrInfo{
+		{ID: peers[1], Addrs: []ma.Multiaddr{addr}},
+		{ID: peers[2]},
+	})
+	require.NoError(t, err)
+	redirectExtension := graphsync.ExtensionData{Name: graphsync.ExtensionRedirect, Data: redirectData}
+
+	rh := requestManager.SendRequestWithHandle(requestCtx, peers[0], td.blockChain.TipLink, td.blockChain.Selector(), graphsync.WithFollowRedirects(1))
+	rr := readNNetworkRequests(requestCtx, t, td.requestRecordChan, 1)[0]
+	require.Equal(t, peers[0], rr.p)
+	requestID := rr.gsr.ID()
+
+	requestManager.ProcessResponses(peers[0], []gsmsg.GraphSyncResponse{
+		gsmsg.NewResponse(requestID, graphsync.AdditionalPeers, redirectExtension),
+	}, nil)
+	rr = readNNetworkRequests(requestCtx, t, td.requestRecordChan, 1)[0]
+	require.Equal(t, peers[1], rr.p, "should send request to first peer redirected to")
+	require.Equal(t, requestID, rr.gsr.ID())
+	td.fal.VerifyLastProcessedBlocks(ctx, t, nil)
+	td.fal.VerifyLastProcessedResponses(ctx, t, map[graphsync.RequestID]metadata.Metadata{})
+	addrBook.lk.Lock()
+	require.Equal(t, []ma.Multiaddr{addr}, addrBook.addrs[peers[1]])
+	addrBook.lk.Unlock()
+
+	// a failure on the first peer moves on to the next peer redirected to
+	requestManager.ProcessResponses(peers[1], []gsmsg.GraphSyncResponse{
+		gsmsg.NewResponse(requestID, graphsync.RequestFailedBusy),
+	}, nil)
+	rr = readNNetworkRequests(requestCtx, t, td.requestRecordChan, 1)[0]
+	require.Equal(t, peers[2], rr.p, "should try next peer redirected to")
+	td.fal.VerifyLastProcessedBlocks(ctx, t, nil)
+	td.fal.VerifyLastProcessedResponses(ctx, t, map[graphsync.RequestID]metadata.Metadata{})
+
+	// only one redirect is followed
+	requestManager.ProcessResponses(peers[2], []gsmsg.GraphSyncResponse{
+		gsmsg.NewResponse(requestID, graphsync.AdditionalPeers, redirectExtension),
+	}, nil)
+	var receivedErr error
+	testutil.AssertReceive(requestCtx, t, rh.Errors(), &receivedErr, "should receive redirect error")
+	redirectErr, ok := receivedErr.(graphsync.RequestRedirectedErr)
+	require.True(t, ok)
+	require.Len(t, redirectErr.Peers, 2)
+	require.Equal(t, peers[1], redirectErr.Peers[0].ID)
+	testutil.VerifyEmptyResponse(requestCtx, t, rh.Responses())
+	require.Equal(t, []peer.ID{peers[1]}, rh.Stats().Redirects)
+	td.fal.VerifyLastProcessedBlocks(ctx, t, nil)
+	td.fal.VerifyLastProcessedResponses(ctx, t, map[graphsync.RequestID]metadata.Metadata{})
+
+	// requests that do not follow redirects fail
+	_, returnedErrorChan := requestManager.SendRequest(requestCtx, peers[0], td.blockChain.TipLink, td.blockChain.Selector())
+	rr = readNNetworkRequests(requestCtx, t, td.requestRecordChan, 1)[0]
+	requestManager.ProcessResponses(peers[0], []gsmsg.GraphSyncResponse{
+		gsmsg.NewResponse(rr.gsr.ID(), graphsync.AdditionalPeers, redirectExtension),
+	}, nil)
+	testutil.AssertReceive(requestCtx, t, returnedErrorChan, &receivedErr, "should receive redirect error")
+	require.IsType(t, graphsync.RequestRedirectedErr{}, receivedErr)
+}
+
+func TestPeerDisconnected(t *testing.T) {
+	ctx := context.Background()
+	td := newTestData(ctx, t)
+	requestCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	peers := testutil.GeneratePeers(2)
 
 	returnedResponseChan, returnedErrorChan := td.requestManager.SendRequest(requestCtx, peers[0], td.blockChain.TipLink, td.blockChain.Selector())
+	readNNetworkRequests(requestCtx, t, td.requestRecordChan, 1)
 
-	rr := readNNetworkRequests(requestCtx, t, td.requestRecordChan, 1)[0]
-	failedResponses := []gsmsg.GraphSyncResponse{
-		gsmsg.NewResponse(rr.gsr.ID(), graphsync.RequestFailedContentNotFound),
-	}
-	td.requestManager.ProcessResponses(peers[0], failedResponses, nil)
+	// disconnects from other peers are ignored
+	td.requestManager.Disconnected(peers[1])
+	td.requestManager.Disconnected(peers[0])
 
-	testutil.VerifySingleTerminalError(requestCtx, t, returnedErrorChan)
+	errs := testutil.CollectErrors(requestCtx, t, returnedErrorChan)
+	require.Len(t, errs, 1)
+	require.IsType(t, graphsync.RequestFailedPeerDisconnectedErr{}, errs[0])
 	testutil.VerifyEmptyResponse(requestCtx, t, returnedResponseChan)
 }
 
-func TestLocallyFulfilledFirstRequestFailsLater(t *testing.T) {
+func TestPeerDisconnectedWhilePaused(t *testing.T) {
 	ctx := context.Background()
 	td := newTestData(ctx, t)
-
 	requestCtx, cancel := context.WithTimeout(ctx, time.Second)
 	defer cancel()
 	peers := testutil.GeneratePeers(1)
 
 	returnedResponseChan, returnedErrorChan := td.requestManager.SendRequest(requestCtx, peers[0], td.blockChain.TipLink, td.blockChain.Selector())
-
 	rr := readNNetworkRequests(requestCtx, t, td.requestRecordChan, 1)[0]
 
-	// async loaded response responds immediately
-	td.fal.SuccessResponseOn(rr.gsr.ID(), td.blockChain.AllBlocks())
+	td.requestManager.ProcessResponses(peers[0], []gsmsg.GraphSyncResponse{
+		gsmsg.NewResponse(rr.gsr.ID(), graphsync.RequestPaused),
+	}, nil)
+	td.fal.VerifyLastProcessedBlocks(ctx, t, nil)
+	td.fal.VerifyLastProcessedResponses(ctx, t, map[graphsync.RequestID]metadata.Metadata{})
 
-	td.blockChain.VerifyWholeChain(requestCtx, returnedResponseChan)
+	// the responder may continue the paused response once it reconnects
+	td.requestManager.Disconnected(peers[0])
+	err := td.requestManager.PauseRequest(graphsync.RequestID(-1))
+	require.Error(t, err)
+	testutil.AssertChannelEmpty(t, returnedErrorChan, "should not fail paused request")
 
-	// failure comes in later over network
-	failedResponses := []gsmsg.GraphSyncResponse{
-		gsmsg.NewResponse(rr.gsr.ID(), graphsync.RequestFailedContentNotFound),
+	md := encodedMetadataForBlocks(t, td.blockChain.AllBlocks(), true)
+	responses := []gsmsg.GraphSyncResponse{
+		gsmsg.NewResponse(rr.gsr.ID(), graphsync.RequestCompletedFull, md),
 	}
+	td.requestManager.ProcessResponses(peers[0], responses, td.blockChain.AllBlocks())
+	td.fal.VerifyLastProcessedBlocks(ctx, t, td.blockChain.AllBlocks())
+	td.fal.VerifyLastProcessedResponses(ctx, t, map[graphsync.RequestID]metadata.Metadata{
+		rr.gsr.ID(): metadataForBlocks(td.blockChain.AllBlocks(), true),
+	})
+	td.fal.SuccessResponseOn(rr.gsr.ID(), td.blockChain.AllBlocks())
+	td.blockChain.VerifyWholeChain(requestCtx, returnedResponseChan)
+	testutil.VerifyEmptyErrors(requestCtx, t, returnedErrorChan)
+}
 
-	td.requestManager.ProcessResponses(peers[0], failedResponses, nil)
-	testutil.VerifyEmptyErrors(ctx, t, returnedErrorChan)
+func TestPeerDisconnectedWhilePausedExpires(t *testing.T) {
+	ctx := context.Background()
+	td := newTestData(ctx, t)
+	requestCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	peers := testutil.GeneratePeers(1)
+	requestManager := New(ctx, td.fal, td.requestHooks, td.responseHooks, td.blockHooks, td.completedListeners, td.pausedListeners, td.errorListeners)
+	requestManager.SetDelegate(td.fph)
+	requestManager.SetPausedDisconnectTimeout(100 * time.Millisecond)
+	requestManager.Startup()
+
+	returnedResponseChan, returnedErrorChan := requestManager.SendRequest(requestCtx, peers[0], td.blockChain.TipLink, td.blockChain.Selector())
+	rr := readNNetworkRequests(requestCtx, t, td.requestRecordChan, 1)[0]
+
+	requestManager.ProcessResponses(peers[0], []gsmsg.GraphSyncResponse{
+		gsmsg.NewResponse(rr.gsr.ID(), graphsync.RequestPaused),
+	}, nil)
+	td.fal.VerifyLastProcessedBlocks(ctx, t, nil)
+	td.fal.VerifyLastProcessedResponses(ctx, t, map[graphsync.RequestID]metadata.Metadata{})
 
+	requestManager.Disconnected(peers[0])
+	timer := time.NewTimer(50 * time.Millisecond)
+	testutil.AssertDoesReceiveFirst(t, timer.C, "should wait for peer to continue paused request", returnedErrorChan)
+
+	// the peer never continues the request
+	errs := testutil.CollectErrors(requestCtx, t, returnedErrorChan)
+	require.Len(t, errs, 1)
+	require.IsType(t, graphsync.RequestFailedPeerDisconnectedErr{}, errs[0])
+	testutil.VerifyEmptyResponse(requestCtx, t, returnedResponseChan)
 }
 
-func TestLocallyFulfilledFirstRequestSucceedsLater(t *testing.T) {
+func TestPeerDisconnectedWhilePausedFailover(t *testing.T) {
 	ctx := context.Background()
 	td := newTestData(ctx, t)
-
 	requestCtx, cancel := context.WithTimeout(ctx, time.Second)
 	defer cancel()
-	peers := testutil.GeneratePeers(1)
+	peers := testutil.GeneratePeers(2)
 
-	called := make(chan struct{})
-	td.responseHooks.Register(func(p peer.ID, response graphsync.ResponseData, hookActions graphsync.IncomingResponseHookActions) {
-		close(called)
+	returnedResponseChan, returnedErrorChan := td.requestManager.SendRequestWithOptions(requestCtx, peers[0], td.blockChain.TipLink, td.blockChain.Selector(),
+		graphsync.WithFallbackPeers(peers[1]))
+	rr := readNNetworkRequests(requestCtx, t, td.requestRecordChan, 1)[0]
+
+	td.requestManager.ProcessResponses(peers[0], []gsmsg.GraphSyncResponse{
+		gsmsg.NewResponse(rr.gsr.ID(), graphsync.RequestPaused),
+	}, nil)
+	td.fal.VerifyLastProcessedBlocks(ctx, t, nil)
+	td.fal.VerifyLastProcessedResponses(ctx, t, map[graphsync.RequestID]metadata.Metadata{})
+
+	// requests with other peers to try move to them rather than waiting
+	td.requestManager.Disconnected(peers[0])
+	rr = readNNetworkRequests(requestCtx, t, td.requestRecordChan, 1)[0]
+	require.Equal(t, peers[1], rr.p, "should resume request with fallback peer")
+
+	md := encodedMetadataForBlocks(t, td.blockChain.AllBlocks(), true)
+	responses := []gsmsg.GraphSyncResponse{
+		gsmsg.NewResponse(rr.gsr.ID(), graphsync.RequestCompletedFull, md),
+	}
+	td.requestManager.ProcessResponses(peers[1], responses, td.blockChain.AllBlocks())
+	td.fal.VerifyLastProcessedBlocks(ctx, t, td.blockChain.AllBlocks())
+	td.fal.VerifyLastProcessedResponses(ctx, t, map[graphsync.RequestID]metadata.Metadata{
+		rr.gsr.ID(): metadataForBlocks(td.blockChain.AllBlocks(), true),
 	})
-	returnedResponseChan, returnedErrorChan := td.requestManager.SendRequest(requestCtx, peers[0], td.blockChain.TipLink, td.blockChain.Selector())
+	td.fal.SuccessResponseOn(rr.gsr.ID(), td.blockChain.AllBlocks())
+	td.blockChain.VerifyWholeChain(requestCtx, returnedResponseChan)
+	testutil.VerifyEmptyErrors(requestCtx, t, returnedErrorChan)
+}
+
+func TestPeerDisconnectedFailover(t *testing.T) {
+	ctx := context.Background()
+	td := newTestData(ctx, t)
+	requestCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	peers := testutil.GeneratePeers(2)
 
+	returnedResponseChan, returnedErrorChan := td.requestManager.SendRequestWithOptions(requestCtx, peers[0], td.blockChain.TipLink, td.blockChain.Selector(),
+		graphsync.WithFallbackPeers(peers[1]))
 	rr := readNNetworkRequests(requestCtx, t, td.requestRecordChan, 1)[0]
 
-	// async loaded response responds immediately
-	td.fal.SuccessResponseOn(rr.gsr.ID(), td.blockChain.AllBlocks())
+	td.requestManager.Disconnected(peers[0])
 
-	td.blockChain.VerifyWholeChain(requestCtx, returnedResponseChan)
+	rr = readNNetworkRequests(requestCtx, t, td.requestRecordChan, 1)[0]
+	require.Equal(t, peers[1], rr.p, "should resume request with fallback peer")
 
 	md := encodedMetadataForBlocks(t, td.blockChain.AllBlocks(), true)
-	firstResponses := []gsmsg.GraphSyncResponse{
+	responses := []gsmsg.GraphSyncResponse{
 		gsmsg.NewResponse(rr.gsr.ID(), graphsync.RequestCompletedFull, md),
 	}
-	td.requestManager.ProcessResponses(peers[0], firstResponses, td.blockChain.AllBlocks())
+	td.requestManager.ProcessResponses(peers[1], responses, td.blockChain.AllBlocks())
+	td.fal.VerifyLastProcessedBlocks(ctx, t, td.blockChain.AllBlocks())
+	td.fal.VerifyLastProcessedResponses(ctx, t, map[graphsync.RequestID]metadata.Metadata{
+		rr.gsr.ID(): metadataForBlocks(td.blockChain.AllBlocks(), true),
+	})
+	td.fal.SuccessResponseOn(rr.gsr.ID(), td.blockChain.AllBlocks())
+	td.blockChain.VerifyWholeChain(requestCtx, returnedResponseChan)
+	testutil.VerifyEmptyErrors(requestCtx, t, returnedErrorChan)
+}
 
-	td.fal.VerifyNoRemainingData(t, rr.gsr.ID())
-	testutil.VerifyEmptyErrors(ctx, t, returnedErrorChan)
-	testutil.AssertDoesReceive(requestCtx, t, called, "response hooks called for response")
+type fakeCheckpointStore struct {
+	checkpointsLk sync.Mutex
+	checkpoints   map[string]graphsync.Checkpoint
 }
 
-func TestRequestReturnsMissingBlocks(t *testing.T) {
+func (fcs *fakeCheckpointStore) Put(checkpoint graphsync.Checkpoint) error {
+	fcs.checkpointsLk.Lock()
+	defer fcs.checkpointsLk.Unlock()
+	fcs.checkpoints[checkpoint.ID] = checkpoint
+	return nil
+}
+
+func (fcs *fakeCheckpointStore) Get(id string) (graphsync.Checkpoint, error) {
+	fcs.checkpointsLk.Lock()
+	defer fcs.checkpointsLk.Unlock()
+	checkpoint, ok := fcs.checkpoints[id]
+	if !ok {
+		return graphsync.Checkpoint{}, errors.New("not found")
+	}
+	return checkpoint, nil
+}
+
+func (fcs *fakeCheckpointStore) Delete(id string) error {
+	fcs.checkpointsLk.Lock()
+	defer fcs.checkpointsLk.Unlock()
+	delete(fcs.checkpoints, id)
+	return nil
+}
+
+func TestCheckpointAndResumeRequest(t *testing.T) {
 	ctx := context.Background()
 	td := newTestData(ctx, t)
-
 	requestCtx, cancel := context.WithTimeout(ctx, time.Second)
 	defer cancel()
 	peers := testutil.GeneratePeers(1)
+	checkpointStore := &fakeCheckpointStore{checkpoints: make(map[string]graphsync.Checkpoint)}
+	td.requestManager.SetCheckpointStore(checkpointStore, time.Nanosecond)
 
-	returnedResponseChan, returnedErrorChan := td.requestManager.SendRequest(requestCtx, peers[0], td.blockChain.TipLink, td.blockChain.Selector())
+	returnedResponseChan, returnedErrorChan := td.requestManager.SendRequestWithOptions(requestCtx, peers[0], td.blockChain.TipLink, td.blockChain.Selector(),
+		graphsync.WithExtensions(td.extension1), graphsync.WithCheckpoint("applesauce"))
+	rr := readNNetworkRequests(requestCtx, t, td.requestRecordChan, 1)[0]
+
+	firstBlocks := td.blockChain.Blocks(0, 3)
+	td.fal.SuccessResponseOn(rr.gsr.ID(), firstBlocks)
+	td.blockChain.VerifyResponseRange(requestCtx, returnedResponseChan, 0, 3)
+	failedResponses := []gsmsg.GraphSyncResponse{
+		gsmsg.NewResponse(rr.gsr.ID(), graphsync.RequestFailedUnknown),
+	}
+	td.requestManager.ProcessResponses(peers[0], failedResponses, nil)
+	testutil.VerifySingleTerminalError(requestCtx, t, returnedErrorChan)
+
+	checkpoint, err := checkpointStore.Get("applesauce")
+	require.NoError(t, err, "should record checkpoint for failed request")
+	require.Equal(t, peers[0], checkpoint.Peer)
+	require.Equal(t, td.blockChain.TipLink, checkpoint.Root)
+	require.Equal(t, []graphsync.ExtensionData{td.extension1}, checkpoint.Extensions)
+	require.Equal(t, len(firstBlocks), checkpoint.VerifiedCids.Len())
+
+	returnedResponseChan, returnedErrorChan = td.requestManager.ResumeRequest(requestCtx, "applesauce")
+	rr = readNNetworkRequests(requestCtx, t, td.requestRecordChan, 1)[0]
+	require.Equal(t, peers[0], rr.p)
+	returnedData, found := rr.gsr.Extension(td.extensionName1)
+	require.True(t, found)
+	require.Equal(t, td.extensionData1, returnedData)
+	doNotSendCidsData, has := rr.gsr.Extension(graphsync.ExtensionDoNotSendCIDs)
+	require.True(t, has)
+	doNotSendCids, err := cidset.DecodeCidSet(doNotSendCidsData)
+	require.NoError(t, err)
+	require.Equal(t, len(firstBlocks), doNotSendCids.Len())
+	for _, block := range firstBlocks {
+		require.True(t, doNotSendCids.Has(block.Cid()))
+	}
+
+	td.fal.SuccessResponseOn(rr.gsr.ID(), td.blockChain.AllBlocks())
+	td.blockChain.VerifyWholeChain(requestCtx, returnedResponseChan)
+	testutil.VerifyEmptyErrors(requestCtx, t, returnedErrorChan)
+	_, err = checkpointStore.Get("applesauce")
+	require.Error(t, err, "should remove checkpoint once request completes")
+}
+
+func TestCheckpointAfterFailover(t *testing.T) {
+	ctx := context.Background()
+	td := newTestData(ctx, t)
+	requestCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	peers := testutil.GeneratePeers(2)
+	checkpointStore := &fakeCheckpointStore{checkpoints: make(map[string]graphsync.Checkpoint)}
+	td.requestManager.SetCheckpointStore(checkpointStore, time.Nanosecond)
 
+	returnedResponseChan, returnedErrorChan := td.requestManager.SendRequestWithOptions(requestCtx, peers[0], td.blockChain.TipLink, td.blockChain.Selector(),
+		graphsync.WithFallbackPeers(peers[1]), graphsync.WithCheckpoint("applesauce"))
 	rr := readNNetworkRequests(requestCtx, t, td.requestRecordChan, 1)[0]
+	requestID := rr.gsr.ID()
 
-	md := encodedMetadataForBlocks(t, td.blockChain.AllBlocks(), false)
+	firstBlocks := td.blockChain.Blocks(0, 3)
+	td.fal.SuccessResponseOn(requestID, firstBlocks)
+	td.blockChain.VerifyResponseRange(requestCtx, returnedResponseChan, 0, 3)
+	failedResponses := []gsmsg.GraphSyncResponse{
+		gsmsg.NewResponse(requestID, graphsync.RequestFailedBusy),
+	}
+	td.requestManager.ProcessResponses(peers[0], failedResponses, nil)
+	td.fal.VerifyLastProcessedBlocks(ctx, t, nil)
+	td.fal.VerifyLastProcessedResponses(ctx, t, map[graphsync.RequestID]metadata.Metadata{})
+	rr = readNNetworkRequests(requestCtx, t, td.requestRecordChan, 1)[0]
+	require.Equal(t, peers[1], rr.p)
+
+	nextBlocks := td.blockChain.Blocks(3, 4)
+	td.fal.SuccessResponseOn(requestID, nextBlocks)
+	td.blockChain.VerifyResponseRange(requestCtx, returnedResponseChan, 3, 4)
+	failedResponses = []gsmsg.GraphSyncResponse{
+		gsmsg.NewResponse(requestID, graphsync.RequestFailedUnknown),
+	}
+	td.requestManager.ProcessResponses(peers[1], failedResponses, nil)
+	testutil.VerifySingleTerminalError(requestCtx, t, returnedErrorChan)
+
+	checkpoint, err := checkpointStore.Get("applesauce")
+	require.NoError(t, err)
+	require.Equal(t, peers[1], checkpoint.Peer, "should record the peer the request failed over to")
+	require.Equal(t, 4, checkpoint.VerifiedCids.Len())
+}
+
+func TestStagedPersistence(t *testing.T) {
+	ctx := context.Background()
+	td := newTestData(ctx, t)
+
+	requestCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	peers := testutil.GeneratePeers(1)
+
+	// a request that fails is not committed
+	returnedResponseChan, returnedErrorChan := td.requestManager.SendRequestWithOptions(requestCtx, peers[0], td.blockChain.TipLink, td.blockChain.Selector(),
+		graphsync.WithStagedPersistence())
+	rr := readNNetworkRequests(requestCtx, t, td.requestRecordChan, 1)[0]
+	failedResponses := []gsmsg.GraphSyncResponse{
+		gsmsg.NewResponse(rr.gsr.ID(), graphsync.RequestFailedContentNotFound),
+	}
+	td.requestManager.ProcessResponses(peers[0], failedResponses, nil)
+	testutil.VerifySingleTerminalError(requestCtx, t, returnedErrorChan)
+	testutil.VerifyEmptyResponse(requestCtx, t, returnedResponseChan)
+	td.fal.VerifyLastProcessedBlocks(ctx, t, nil)
+	td.fal.VerifyLastProcessedResponses(ctx, t, map[graphsync.RequestID]metadata.Metadata{})
+	td.fal.VerifyNoCommitRequested(t)
+
+	// a request that completes with every block is committed
+	blockChain2 := testutil.SetupBlockChain(ctx, t, td.loader, td.storer, 100, 5)
+	returnedResponseChan, returnedErrorChan = td.requestManager.SendRequestWithOptions(requestCtx, peers[0], blockChain2.TipLink, blockChain2.Selector(),
+		graphsync.WithStagedPersistence())
+	rr = readNNetworkRequests(requestCtx, t, td.requestRecordChan, 1)[0]
+	md := encodedMetadataForBlocks(t, blockChain2.AllBlocks(), true)
 	firstResponses := []gsmsg.GraphSyncResponse{
-		gsmsg.NewResponse(rr.gsr.ID(), graphsync.RequestCompletedPartial, md),
+		gsmsg.NewResponse(rr.gsr.ID(), graphsync.RequestCompletedFull, md),
 	}
-	td.requestManager.ProcessResponses(peers[0], firstResponses, nil)
-	for _, block := range td.blockChain.AllBlocks() {
-		td.fal.ResponseOn(rr.gsr.ID(), cidlink.Link{Cid: block.Cid()}, types.AsyncLoadResult{Data: nil, Err: fmt.Errorf("Terrible Thing")})
+	td.requestManager.ProcessResponses(peers[0], firstResponses, blockChain2.AllBlocks())
+	td.fal.SuccessResponseOn(rr.gsr.ID(), blockChain2.AllBlocks())
+	blockChain2.VerifyWholeChain(requestCtx, returnedResponseChan)
+	testutil.VerifyEmptyErrors(requestCtx, t, returnedErrorChan)
+	td.fal.VerifyCommitRequested(requestCtx, t, rr.gsr.ID())
+}
+
+func TestInboundMemoryBudget(t *testing.T) {
+	ctx := context.Background()
+	td := newTestData(ctx, t)
+	requestCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	peers := testutil.GeneratePeers(1)
+	requestManager := New(ctx, td.fal, td.requestHooks, td.responseHooks, td.blockHooks, td.completedListeners, td.pausedListeners, td.errorListeners)
+	requestManager.SetDelegate(td.fph)
+	requestManager.SetInboundMemoryBudget(1000)
+	requestManager.Startup()
+
+	returnedResponseChan, returnedErrorChan := requestManager.SendRequest(requestCtx, peers[0], td.blockChain.TipLink, td.blockChain.Selector())
+	rr := readNNetworkRequests(requestCtx, t, td.requestRecordChan, 1)[0]
+
+	firstBlocks := td.blockChain.Blocks(0, 3)
+	md := metadataForBlocks(firstBlocks, true)
+	mdEncoded, err := metadata.EncodeMetadata(md)
+	require.NoError(t, err)
+	responses := []gsmsg.GraphSyncResponse{
+		gsmsg.NewResponse(rr.gsr.ID(), graphsync.PartialResponse, graphsync.ExtensionData{
+			Name: graphsync.ExtensionMetadata,
+			Data: mdEncoded,
+		}),
 	}
-	testutil.VerifyEmptyResponse(ctx, t, returnedResponseChan)
-	errs := testutil.CollectErrors(ctx, t, returnedErrorChan)
-	require.NotEqual(t, len(errs), 0, "did not send errors")
+	td.fal.SetBufferedBytes(2000)
+	requestManager.ProcessResponses(peers[0], responses, firstBlocks)
+
+	// over budget, so the peer is told to stop sending
+	pauseCancel := readNNetworkRequests(requestCtx, t, td.requestRecordChan, 1)[0]
+	require.True(t, pauseCancel.gsr.IsCancel())
+	require.Equal(t, rr.gsr.ID(), pauseCancel.gsr.ID())
+
+	// blocks already received are still processed
+	td.fal.SuccessResponseOn(rr.gsr.ID(), firstBlocks)
+	td.blockChain.VerifyResponseRange(requestCtx, returnedResponseChan, 0, 3)
+
+	// once drained, the request is sent again, skipping blocks already received
+	td.fal.SetBufferedBytes(0)
+	resumedRequest := readNNetworkRequests(requestCtx, t, td.requestRecordChan, 1)[0]
+	require.False(t, resumedRequest.gsr.IsCancel())
+	require.Equal(t, rr.gsr.ID(), resumedRequest.gsr.ID())
+	doNotSendCidsData, has := resumedRequest.gsr.Extension(graphsync.ExtensionDoNotSendCIDs)
+	require.True(t, has)
+	doNotSendCids, err := cidset.DecodeCidSet(doNotSendCidsData)
+	require.NoError(t, err)
+	require.Equal(t, len(firstBlocks), doNotSendCids.Len())
+
+	td.fal.SuccessResponseOn(rr.gsr.ID(), td.blockChain.RemainderBlocks(3))
+	td.blockChain.VerifyRemainder(requestCtx, returnedResponseChan, 3)
+	testutil.VerifyEmptyErrors(requestCtx, t, returnedErrorChan)
+}
+
+func TestInboundMemoryExhausted(t *testing.T) {
+	ctx := context.Background()
+	td := newTestData(ctx, t)
+	requestCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	peers := testutil.GeneratePeers(1)
+	requestManager := New(ctx, td.fal, td.requestHooks, td.responseHooks, td.blockHooks, td.completedListeners, td.pausedListeners, td.errorListeners)
+	requestManager.SetDelegate(td.fph)
+	requestManager.SetUnverifiedBlockBudget(1000)
+	requestManager.Startup()
+
+	returnedResponseChan, returnedErrorChan := requestManager.SendRequest(requestCtx, peers[0], td.blockChain.TipLink, td.blockChain.Selector())
+	rr := readNNetworkRequests(requestCtx, t, td.requestRecordChan, 1)[0]
+	td.fal.SuccessResponseOn(rr.gsr.ID(), td.blockChain.Blocks(0, 3))
+	td.blockChain.VerifyResponseRange(requestCtx, returnedResponseChan, 0, 3)
+
+	// blocks were dropped, so the request is paused even though it received none
+	td.fal.SetMemoryExhausted(true)
+	pauseCancel := readNNetworkRequests(requestCtx, t, td.requestRecordChan, 1)[0]
+	require.True(t, pauseCancel.gsr.IsCancel())
+
+	// once drained, the request is sent again so dropped blocks are sent again
+	td.fal.SetMemoryExhausted(false)
+	resumedRequest := readNNetworkRequests(requestCtx, t, td.requestRecordChan, 1)[0]
+	require.False(t, resumedRequest.gsr.IsCancel())
+	doNotSendCidsData, has := resumedRequest.gsr.Extension(graphsync.ExtensionDoNotSendCIDs)
+	require.True(t, has)
+	doNotSendCids, err := cidset.DecodeCidSet(doNotSendCidsData)
+	require.NoError(t, err)
+	require.Equal(t, 3, doNotSendCids.Len())
+
+	td.fal.SuccessResponseOn(rr.gsr.ID(), td.blockChain.RemainderBlocks(3))
+	td.blockChain.VerifyRemainder(requestCtx, returnedResponseChan, 3)
+	testutil.VerifyEmptyErrors(requestCtx, t, returnedErrorChan)
+}
+
+func TestDeadlineExtension(t *testing.T) {
+	ctx := context.Background()
+	td := newTestData(ctx, t)
+	requestCtx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	peers := testutil.GeneratePeers(1)
+
+	_, _ = td.requestManager.SendRequest(requestCtx, peers[0], td.blockChain.TipLink, td.blockChain.Selector())
+	rr := readNNetworkRequests(requestCtx, t, td.requestRecordChan, 1)[0]
+	deadlineData, has := rr.gsr.Extension(graphsync.ExtensionDeadline)
+	require.True(t, has, "should send deadline of request context")
+	sentDeadline, err := deadline.DecodeDeadline(deadlineData)
+	require.NoError(t, err)
+	contextDeadline, _ := requestCtx.Deadline()
+	require.True(t, contextDeadline.Truncate(time.Millisecond).Equal(sentDeadline))
+
+	// callers that stop waiting sooner share the request
+	shorterCtx, shorterCancel := context.WithTimeout(ctx, time.Second)
+	defer shorterCancel()
+	_, _ = td.requestManager.SendRequest(shorterCtx, peers[0], td.blockChain.TipLink, td.blockChain.Selector())
+	testutil.AssertChannelEmpty(t, td.requestRecordChan, "should share request with earlier deadline")
+
+	// callers that would outlast the request start their own, and requests
+	// without a deadline send none
+	_, _ = td.requestManager.SendRequest(ctx, peers[0], td.blockChain.TipLink, td.blockChain.Selector())
+	rr = readNNetworkRequests(requestCtx, t, td.requestRecordChan, 1)[0]
+	_, has = rr.gsr.Extension(graphsync.ExtensionDeadline)
+	require.False(t, has)
 }
 
 func TestEncodingExtensions(t *testing.T) {
@@ -770,6 +1913,97 @@ func TestPauseResume(t *testing.T) {
 	td.blockChain.VerifyRemainder(ctx, returnedResponseChan, pauseAt-1)
 	testutil.VerifyEmptyErrors(ctx, t, returnedErrorChan)
 }
+func TestRequestHandle(t *testing.T) {
+	ctx := context.Background()
+	td := newTestData(ctx, t)
+
+	requestCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	peers := testutil.GeneratePeers(1)
+
+	rh := td.requestManager.SendRequestWithHandle(requestCtx, peers[0], td.blockChain.TipLink, td.blockChain.Selector())
+	rr := readNNetworkRequests(requestCtx, t, td.requestRecordChan, 1)[0]
+	require.Equal(t, rr.gsr.ID(), rh.ID())
+	require.Equal(t, peers[0], rh.Peer())
+	require.Equal(t, graphsync.RequestStatusRunning, rh.Status())
+
+	err := rh.Update(td.extension1)
+	require.NoError(t, err)
+	update := readNNetworkRequests(requestCtx, t, td.requestRecordChan, 1)[0]
+	require.True(t, update.gsr.IsUpdate())
+	ext1Data, has := update.gsr.Extension(td.extensionName1)
+	require.True(t, has)
+	require.Equal(t, td.extensionData1, ext1Data)
+
+	// send the first blocks twice
+	firstBlocks := td.blockChain.Blocks(0, 3)
+	firstResponses := []gsmsg.GraphSyncResponse{
+		gsmsg.NewResponse(rr.gsr.ID(), graphsync.PartialResponse, encodedMetadataForBlocks(t, firstBlocks, true)),
+	}
+	td.requestManager.ProcessResponses(peers[0], firstResponses, firstBlocks)
+	td.fal.VerifyLastProcessedBlocks(ctx, t, firstBlocks)
+	td.fal.VerifyLastProcessedResponses(ctx, t, map[graphsync.RequestID]metadata.Metadata{
+		rr.gsr.ID(): metadataForBlocks(firstBlocks, true),
+	})
+	allResponses := []gsmsg.GraphSyncResponse{
+		gsmsg.NewResponse(rr.gsr.ID(), graphsync.RequestCompletedFull, encodedMetadataForBlocks(t, td.blockChain.AllBlocks(), true)),
+	}
+	td.requestManager.ProcessResponses(peers[0], allResponses, td.blockChain.AllBlocks())
+	td.fal.VerifyLastProcessedBlocks(ctx, t, td.blockChain.AllBlocks())
+	td.fal.SuccessResponseOn(rr.gsr.ID(), td.blockChain.AllBlocks())
+
+	td.blockChain.VerifyWholeChain(requestCtx, rh.Responses())
+	testutil.VerifyEmptyErrors(requestCtx, t, rh.Errors())
+	require.Equal(t, graphsync.RequestStatusCompleted, rh.Status())
+
+	var totalSize, firstSize uint64
+	for _, block := range td.blockChain.AllBlocks() {
+		totalSize += uint64(len(block.RawData()))
+	}
+	for _, block := range firstBlocks {
+		firstSize += uint64(len(block.RawData()))
+	}
+	require.Equal(t, graphsync.RequestStats{
+		BlocksReceived:  uint64(len(td.blockChain.AllBlocks())),
+		BytesReceived:   totalSize,
+		BytesOnWire:     totalSize + firstSize,
+		RemoteBlocks:    uint64(len(td.blockChain.AllBlocks())),
+		DuplicateBlocks: uint64(len(firstBlocks)),
+	}, rh.Stats())
+
+	err = rh.Pause()
+	require.EqualError(t, err, "request not found")
+}
+
+func TestRequestHandlePauseCancel(t *testing.T) {
+	ctx := context.Background()
+	td := newTestData(ctx, t)
+
+	requestCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	peers := testutil.GeneratePeers(1)
+
+	rh := td.requestManager.SendRequestWithHandle(requestCtx, peers[0], td.blockChain.TipLink, td.blockChain.Selector())
+	readNNetworkRequests(requestCtx, t, td.requestRecordChan, 1)
+
+	err := rh.Pause()
+	require.NoError(t, err)
+	require.Equal(t, graphsync.RequestStatusPaused, rh.Status())
+	err = rh.Unpause()
+	require.NoError(t, err)
+	require.Equal(t, graphsync.RequestStatusRunning, rh.Status())
+
+	rh.Cancel()
+	testutil.VerifyEmptyResponse(requestCtx, t, rh.Responses())
+	testutil.VerifyEmptyErrors(requestCtx, t, rh.Errors())
+	require.Equal(t, graphsync.RequestStatusCancelled, rh.Status())
+
+	// a request that never starts fails
+	rh = td.requestManager.SendRequestWithHandle(requestCtx, peers[0], td.blockChain.TipLink, basicnode.NewString("applesauce"))
+	testutil.VerifySingleTerminalError(requestCtx, t, rh.Errors())
+	require.Equal(t, graphsync.RequestStatusFailed, rh.Status())
+}
+
 func TestPauseResumeExternal(t *testing.T) {
 	ctx := context.Background()
 	td := newTestData(ctx, t)
@@ -852,23 +2086,26 @@ func TestPauseResumeExternal(t *testing.T) {
 }
 
 type testData struct {
-	requestRecordChan chan requestRecord
-	fph               *fakePeerHandler
-	fal               *testloader.FakeAsyncLoader
-	requestHooks      *hooks.OutgoingRequestHooks
-	responseHooks     *hooks.IncomingResponseHooks
-	blockHooks        *hooks.IncomingBlockHooks
-	requestManager    *RequestManager
-	blockStore        map[ipld.Link][]byte
-	loader            ipld.Loader
-	storer            ipld.Storer
-	blockChain        *testutil.TestBlockChain
-	extensionName1    graphsync.ExtensionName
-	extensionData1    []byte
-	extension1        graphsync.ExtensionData
-	extensionName2    graphsync.ExtensionName
-	extensionData2    []byte
-	extension2        graphsync.ExtensionData
+	requestRecordChan  chan requestRecord
+	fph                *fakePeerHandler
+	fal                *testloader.FakeAsyncLoader
+	requestHooks       *hooks.OutgoingRequestHooks
+	responseHooks      *hooks.IncomingResponseHooks
+	blockHooks         *hooks.IncomingBlockHooks
+	completedListeners *hooks.CompletedRequestListeners
+	pausedListeners    *hooks.RequestPausedListeners
+	errorListeners     *hooks.RequestErrorListeners
+	requestManager     *RequestManager
+	blockStore         map[ipld.Link][]byte
+	loader             ipld.Loader
+	storer             ipld.Storer
+	blockChain         *testutil.TestBlockChain
+	extensionName1     graphsync.ExtensionName
+	extensionData1     []byte
+	extension1         graphsync.ExtensionData
+	extensionName2     graphsync.ExtensionName
+	extensionData2     []byte
+	extension2         graphsync.ExtensionData
 }
 
 func newTestData(ctx context.Context, t *testing.T) *testData {
@@ -879,7 +2116,10 @@ func newTestData(ctx context.Context, t *testing.T) *testData {
 	td.requestHooks = hooks.NewRequestHooks()
 	td.responseHooks = hooks.NewResponseHooks()
 	td.blockHooks = hooks.NewBlockHooks()
-	td.requestManager = New(ctx, td.fal, td.requestHooks, td.responseHooks, td.blockHooks)
+	td.completedListeners = hooks.NewCompletedRequestListeners()
+	td.pausedListeners = hooks.NewRequestPausedListeners()
+	td.errorListeners = hooks.NewRequestErrorListeners()
+	td.requestManager = New(ctx, td.fal, td.requestHooks, td.responseHooks, td.blockHooks, td.completedListeners, td.pausedListeners, td.errorListeners)
 	td.requestManager.SetDelegate(td.fph)
 	td.requestManager.Startup()
 	td.blockStore = make(map[ipld.Link][]byte)
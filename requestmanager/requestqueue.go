@@ -0,0 +1,302 @@
+package requestmanager
+
+import (
+	"context"
+	"errors"
+	"sort"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+
+	"github.com/ipfs/go-graphsync"
+	"github.com/ipfs/go-graphsync/updatepriority"
+)
+
+// queuedRequest is a new request waiting for the requests in progress to drop
+// below the in progress request limits. Callers receive its responses and
+// errors from the moment it is queued. Metadata only requests are queued with
+// metadata set instead of nrm, and their callers wait for their result
+type queuedRequest struct {
+	requestID     graphsync.RequestID
+	nrm           *newRequestMessage
+	metadata      *newMetadataRequestMessage
+	seq           uint64
+	incoming      chan graphsync.ResponseProgress
+	incomingError chan error
+	state         *requestState
+	// fanout and dedupKey are set for requests shared by identical callers
+	fanout      *requestFanout
+	dedupKey    string
+	subscribers int
+}
+
+func (qr *queuedRequest) before(other *queuedRequest) bool {
+	if qr.priority() != other.priority() {
+		return qr.priority() > other.priority()
+	}
+	return qr.seq < other.seq
+}
+
+func (qr *queuedRequest) priority() graphsync.Priority {
+	if qr.metadata != nil {
+		return 0
+	}
+	return qr.nrm.config.Priority
+}
+
+func (qr *queuedRequest) peers() []peer.ID {
+	if qr.metadata != nil {
+		return []peer.ID{qr.metadata.p}
+	}
+	return qr.nrm.peers
+}
+
+// requestQueue holds queued requests from highest to lowest priority, in the
+// order they were queued within a priority. It is only accessed from the
+// request manager's run loop
+type requestQueue struct {
+	nextSeq  uint64
+	requests []*queuedRequest
+}
+
+func (rq *requestQueue) push(qr *queuedRequest) {
+	qr.seq = rq.nextSeq
+	rq.nextSeq++
+	rq.insert(qr)
+}
+
+func (rq *requestQueue) insert(qr *queuedRequest) {
+	index := sort.Search(len(rq.requests), func(i int) bool {
+		return qr.before(rq.requests[i])
+	})
+	rq.requests = append(rq.requests, nil)
+	copy(rq.requests[index+1:], rq.requests[index:])
+	rq.requests[index] = qr
+}
+
+func (rq *requestQueue) get(requestID graphsync.RequestID) *queuedRequest {
+	for _, qr := range rq.requests {
+		if qr.requestID == requestID {
+			return qr
+		}
+	}
+	return nil
+}
+
+func (rq *requestQueue) remove(requestID graphsync.RequestID) *queuedRequest {
+	for i, qr := range rq.requests {
+		if qr.requestID == requestID {
+			rq.removeAt(i)
+			return qr
+		}
+	}
+	return nil
+}
+
+func (rq *requestQueue) removeAt(index int) {
+	copy(rq.requests[index:], rq.requests[index+1:])
+	rq.requests[len(rq.requests)-1] = nil
+	rq.requests = rq.requests[:len(rq.requests)-1]
+}
+
+// setPriority moves a queued request to its place for a new priority, keeping
+// its place among requests of the same priority by the time it was queued
+func (rq *requestQueue) setPriority(requestID graphsync.RequestID, priority graphsync.Priority) bool {
+	qr := rq.remove(requestID)
+	if qr == nil {
+		return false
+	}
+	qr.nrm.config.Priority = priority
+	rq.insert(qr)
+	return true
+}
+
+// hasFreeSlots indicates a new request to the given peers can start without
+// exceeding the in progress request limits
+func (rm *RequestManager) hasFreeSlots(peers []peer.ID) bool {
+	if rm.maxInProgressRequests > 0 && len(rm.inProgressRequestStatuses) >= rm.maxInProgressRequests {
+		return false
+	}
+	if rm.maxInProgressRequestsPerPeer > 0 {
+		for _, p := range peers {
+			if rm.inProgressRequestsPerPeer[p] >= rm.maxInProgressRequestsPerPeer {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (rm *RequestManager) occupySlots(peers []peer.ID) {
+	for _, p := range peers {
+		rm.inProgressRequestsPerPeer[p]++
+	}
+}
+
+func (rm *RequestManager) releaseSlots(peers []peer.ID) {
+	for _, p := range peers {
+		rm.inProgressRequestsPerPeer[p]--
+		if rm.inProgressRequestsPerPeer[p] <= 0 {
+			delete(rm.inProgressRequestsPerPeer, p)
+		}
+	}
+}
+
+// queueRequest queues a new request until there are free slots for it
+func (rm *RequestManager) queueRequest(requestID graphsync.RequestID, nrm *newRequestMessage, key string, dedupable bool) inProgressRequest {
+	log.Debugf("in progress request limit reached, queueing request %d", requestID)
+	state := &requestState{}
+	state.setQueued(true)
+	qr := &queuedRequest{
+		requestID:     requestID,
+		nrm:           nrm,
+		incoming:      make(chan graphsync.ResponseProgress),
+		incomingError: make(chan error),
+		state:         state,
+	}
+	rm.queuedRequests.push(qr)
+	ipr := inProgressRequest{
+		requestID:     requestID,
+		incoming:      qr.incoming,
+		incomingError: qr.incomingError,
+		state:         state,
+	}
+	if dedupable {
+		qr.fanout = newRequestFanout(rm.ctx, qr.incoming, qr.incomingError)
+		qr.dedupKey = key
+		rm.dedupedRequests[key] = requestID
+		ipr.incoming, ipr.incomingError, ipr.shared = qr.fanout.subscribe(nrm.ctx)
+		qr.subscribers++
+	}
+	return ipr
+}
+
+// queueMetadataRequest queues a new metadata only request until there are free
+// slots for it
+func (rm *RequestManager) queueMetadataRequest(requestID graphsync.RequestID, nmrm *newMetadataRequestMessage) {
+	log.Debugf("in progress request limit reached, queueing metadata request %d", requestID)
+	rm.queuedRequests.push(&queuedRequest{
+		requestID: requestID,
+		metadata:  nmrm,
+		state:     &requestState{},
+	})
+}
+
+// startQueuedRequests starts queued requests, highest priority first, until
+// there are no free slots left for them
+func (rm *RequestManager) startQueuedRequests() {
+	for i := 0; i < len(rm.queuedRequests.requests); {
+		if rm.maxInProgressRequests > 0 && len(rm.inProgressRequestStatuses) >= rm.maxInProgressRequests {
+			return
+		}
+		qr := rm.queuedRequests.requests[i]
+		if !rm.hasFreeSlots(qr.peers()) {
+			i++
+			continue
+		}
+		rm.queuedRequests.removeAt(i)
+		rm.startQueuedRequest(qr)
+	}
+}
+
+func (rm *RequestManager) startQueuedRequest(qr *queuedRequest) {
+	log.Debugf("starting queued request %d", qr.requestID)
+	if qr.metadata != nil {
+		qr.metadata.setupRequest(qr.requestID, rm)
+		return
+	}
+	qr.state.setQueued(false)
+	incoming, incomingError := qr.nrm.setupRequest(qr.requestID, qr.state, rm)
+	if requestStatus, ok := rm.inProgressRequestStatuses[qr.requestID]; ok {
+		requestStatus.fanout = qr.fanout
+		requestStatus.dedupKey = qr.dedupKey
+		requestStatus.subscribers = qr.subscribers
+	} else if qr.fanout != nil && rm.dedupedRequests[qr.dedupKey] == qr.requestID {
+		delete(rm.dedupedRequests, qr.dedupKey)
+	}
+	go forwardResponses(rm.ctx, incoming, incomingError, qr.incoming, qr.incomingError)
+}
+
+// discardQueuedRequest finishes a request removed from the queue before it started
+func (rm *RequestManager) discardQueuedRequest(qr *queuedRequest) {
+	if qr.metadata != nil {
+		qr.metadata.result <- metadataResult{err: graphsync.RequestCancelledErr{}}
+		return
+	}
+	if qr.fanout != nil && rm.dedupedRequests[qr.dedupKey] == qr.requestID {
+		delete(rm.dedupedRequests, qr.dedupKey)
+	}
+	close(qr.incoming)
+	close(qr.incomingError)
+}
+
+// updateQueuedRequest applies extensions sent for a request before it started.
+// Priority updates move it in the queue, and other extensions are sent with
+// the request when it starts
+func (rm *RequestManager) updateQueuedRequest(requestID graphsync.RequestID, extensions []graphsync.ExtensionData) error {
+	qr := rm.queuedRequests.get(requestID)
+	if qr.metadata != nil {
+		return errors.New("metadata only requests cannot be updated")
+	}
+	for _, extension := range extensions {
+		if extension.Name == graphsync.ExtensionUpdatePriority {
+			priority, err := updatepriority.DecodePriority(extension.Data)
+			if err != nil {
+				return err
+			}
+			rm.queuedRequests.setPriority(requestID, priority)
+			continue
+		}
+		requestExtensions := make([]graphsync.ExtensionData, 0, len(qr.nrm.config.Extensions)+1)
+		for _, requestExtension := range qr.nrm.config.Extensions {
+			if requestExtension.Name != extension.Name {
+				requestExtensions = append(requestExtensions, requestExtension)
+			}
+		}
+		qr.nrm.config.Extensions = append(requestExtensions, extension)
+	}
+	return nil
+}
+
+func (rm *RequestManager) notInProgressError(requestID graphsync.RequestID) error {
+	if rm.queuedRequests.get(requestID) != nil {
+		return errors.New("request has not started")
+	}
+	return errors.New("request not found")
+}
+
+// forwardResponses sends the responses and errors for a queued request, once it
+// starts, to the channels its callers received when it was queued
+func forwardResponses(ctx context.Context,
+	incomingResponses <-chan graphsync.ResponseProgress,
+	incomingErrors <-chan error,
+	outgoingResponses chan<- graphsync.ResponseProgress,
+	outgoingErrors chan<- error) {
+	defer close(outgoingResponses)
+	defer close(outgoingErrors)
+	for incomingResponses != nil || incomingErrors != nil {
+		select {
+		case <-ctx.Done():
+			return
+		case response, ok := <-incomingResponses:
+			if !ok {
+				incomingResponses = nil
+				continue
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case outgoingResponses <- response:
+			}
+		case err, ok := <-incomingErrors:
+			if !ok {
+				incomingErrors = nil
+				continue
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case outgoingErrors <- err:
+			}
+		}
+	}
+}
@@ -6,6 +6,11 @@ import (
 	"github.com/ipfs/go-graphsync"
 )
 
+// maxCollectedResponses is how many responses are held for a caller that has
+// not read them yet. Past that, no more are read until the caller catches up,
+// so a slow caller slows down the request rather than using up memory
+const maxCollectedResponses = 256
+
 type responseCollector struct {
 	ctx context.Context
 }
@@ -38,6 +43,12 @@ func (rc *responseCollector) collectResponses(
 			}
 			return receivedResponses[0]
 		}
+		incomingIfRoom := func() <-chan graphsync.ResponseProgress {
+			if len(receivedResponses) >= maxCollectedResponses {
+				return nil
+			}
+			return incomingResponses
+		}
 		for len(receivedResponses) > 0 || incomingResponses != nil {
 			select {
 			case <-rc.ctx.Done():
@@ -47,9 +58,15 @@ func (rc *responseCollector) collectResponses(
 					cancelRequest()
 				}
 				return
-			case response, ok := <-incomingResponses:
+			case response, ok := <-incomingIfRoom():
 				if !ok {
 					incomingResponses = nil
+					// a shared request closes a caller's responses as soon as the
+					// caller's context is done, which may be seen first
+					if requestCtx.Err() != nil {
+						cancelRequest()
+						return
+					}
 				} else {
 					receivedResponses = append(receivedResponses, response)
 				}
@@ -2,7 +2,9 @@ package testloader
 
 import (
 	"context"
+	"errors"
 	"sync"
+	"sync/atomic"
 	"testing"
 
 	blocks "github.com/ipfs/go-block-format"
@@ -31,12 +33,16 @@ type storeKey struct {
 // executor.AsycLoadFn -- all responses are stubbed and no actual processing is
 // done
 type FakeAsyncLoader struct {
+	bufferedBytes      uint64
+	memoryExhausted    int32
 	responseChannelsLk sync.RWMutex
 	responseChannels   map[requestKey]chan types.AsyncLoadResult
 	responses          chan map[graphsync.RequestID]metadata.Metadata
 	blks               chan []blocks.Block
 	storesRequestedLk  sync.RWMutex
 	storesRequested    map[storeKey]struct{}
+	stagedRequests     map[graphsync.RequestID]struct{}
+	commitsRequested   chan graphsync.RequestID
 	cb                 func(graphsync.RequestID, ipld.Link, <-chan types.AsyncLoadResult)
 }
 
@@ -47,6 +53,8 @@ func NewFakeAsyncLoader() *FakeAsyncLoader {
 		responses:        make(chan map[graphsync.RequestID]metadata.Metadata, 1),
 		blks:             make(chan []blocks.Block, 1),
 		storesRequested:  make(map[storeKey]struct{}),
+		stagedRequests:   make(map[graphsync.RequestID]struct{}),
+		commitsRequested: make(chan graphsync.RequestID, 16),
 	}
 }
 
@@ -58,6 +66,40 @@ func (fal *FakeAsyncLoader) StartRequest(requestID graphsync.RequestID, name str
 	return nil
 }
 
+// StartStagedRequest records what store was requested for a given requestID,
+// and that it was staged
+func (fal *FakeAsyncLoader) StartStagedRequest(requestID graphsync.RequestID, name string) error {
+	fal.storesRequestedLk.Lock()
+	fal.storesRequested[storeKey{requestID, name}] = struct{}{}
+	fal.stagedRequests[requestID] = struct{}{}
+	fal.storesRequestedLk.Unlock()
+	return nil
+}
+
+// CommitRequest records that a commit was requested for the given staged request
+func (fal *FakeAsyncLoader) CommitRequest(requestID graphsync.RequestID) error {
+	fal.storesRequestedLk.RLock()
+	_, ok := fal.stagedRequests[requestID]
+	fal.storesRequestedLk.RUnlock()
+	if !ok {
+		return errors.New("request is not staged")
+	}
+	fal.commitsRequested <- requestID
+	return nil
+}
+
+// VerifyCommitRequested verifies a commit was requested for the given staged request
+func (fal *FakeAsyncLoader) VerifyCommitRequested(ctx context.Context, t *testing.T, requestID graphsync.RequestID) {
+	var committed graphsync.RequestID
+	testutil.AssertReceive(ctx, t, fal.commitsRequested, &committed, "did not commit request")
+	require.Equal(t, requestID, committed, "did not commit correct request")
+}
+
+// VerifyNoCommitRequested verifies no commits were requested
+func (fal *FakeAsyncLoader) VerifyNoCommitRequested(t *testing.T) {
+	testutil.AssertChannelEmpty(t, fal.commitsRequested, "should not commit request")
+}
+
 // ProcessResponse just records values passed to verify expectations later
 func (fal *FakeAsyncLoader) ProcessResponse(responses map[graphsync.RequestID]metadata.Metadata,
 	blks []blocks.Block) {
@@ -125,6 +167,30 @@ func (fal *FakeAsyncLoader) AsyncLoad(requestID graphsync.RequestID, link ipld.L
 	return res
 }
 
+// SetBufferedBytes sets the value returned by BufferedBytes
+func (fal *FakeAsyncLoader) SetBufferedBytes(bufferedBytes uint64) {
+	atomic.StoreUint64(&fal.bufferedBytes, bufferedBytes)
+}
+
+// BufferedBytes returns the value set with SetBufferedBytes
+func (fal *FakeAsyncLoader) BufferedBytes() uint64 {
+	return atomic.LoadUint64(&fal.bufferedBytes)
+}
+
+// SetMemoryExhausted sets the value returned by MemoryExhausted
+func (fal *FakeAsyncLoader) SetMemoryExhausted(memoryExhausted bool) {
+	var value int32
+	if memoryExhausted {
+		value = 1
+	}
+	atomic.StoreInt32(&fal.memoryExhausted, value)
+}
+
+// MemoryExhausted returns the value set with SetMemoryExhausted
+func (fal *FakeAsyncLoader) MemoryExhausted() bool {
+	return atomic.LoadInt32(&fal.memoryExhausted) == 1
+}
+
 // CompleteResponsesFor in the case of the test loader does nothing
 func (fal *FakeAsyncLoader) CompleteResponsesFor(requestID graphsync.RequestID) {}
 
@@ -1,7 +1,18 @@
 package requestmanager
 
 import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"strconv"
+	"time"
+
+	"github.com/ipld/go-ipld-prime"
+	"github.com/libp2p/go-libp2p-core/peer"
+
 	"github.com/ipfs/go-graphsync"
+	"github.com/ipfs/go-graphsync/deadline"
+	"github.com/ipfs/go-graphsync/ipldutil"
 	gsmsg "github.com/ipfs/go-graphsync/message"
 	"github.com/ipfs/go-graphsync/metadata"
 )
@@ -23,3 +34,87 @@ func metadataForResponses(responses []gsmsg.GraphSyncResponse) map[graphsync.Req
 	}
 	return responseMetadata
 }
+
+// dedupKey identifies requests that can share a single in progress request: the
+// same peers, root, selector and extensions. Requests that fail over to other
+// peers or record checkpoints are never shared. The deadlines of the callers'
+// contexts are left out, and checked with outlasts when a caller joins
+func dedupKey(peers []peer.ID, root ipld.Link, selector ipld.Node, config graphsync.RequestConfig) (string, bool) {
+	if config.PeerProvider != nil || config.CheckpointID != "" {
+		return "", false
+	}
+	selectorBytes, err := ipldutil.EncodeNode(selector)
+	if err != nil {
+		return "", false
+	}
+	var key bytes.Buffer
+	writeKeyLength := func(length int) {
+		var buf [binary.MaxVarintLen64]byte
+		n := binary.PutUvarint(buf[:], uint64(length))
+		key.Write(buf[:n])
+	}
+	writeKeyField := func(field []byte) {
+		writeKeyLength(len(field))
+		key.Write(field)
+	}
+	writeKeyLength(len(peers))
+	for _, p := range peers {
+		writeKeyField([]byte(p))
+	}
+	writeKeyField([]byte(root.String()))
+	writeKeyField(selectorBytes)
+	writeKeyLength(len(config.Extensions))
+	for _, extension := range config.Extensions {
+		writeKeyField([]byte(extension.Name))
+		writeKeyField(extension.Data)
+	}
+	writeKeyField([]byte(config.StallTimeout.String()))
+	writeKeyField([]byte(strconv.FormatBool(config.LocalFirst)))
+	writeKeyField([]byte(strconv.FormatBool(config.Staged)))
+	writeKeyField([]byte(strconv.Itoa(int(config.Priority))))
+	return key.String(), true
+}
+
+// withDeadline wraps a function that sends requests so each new request tells
+// the responder the given deadline, letting it stop once the requestor stops
+// waiting, including when the request is sent again to another peer. Requests
+// that already carry a deadline, and all requests when there is no deadline,
+// are sent as they are
+func withDeadline(requestDeadline time.Time, sendRequest func(peer.ID, gsmsg.GraphSyncRequest)) func(peer.ID, gsmsg.GraphSyncRequest) {
+	if requestDeadline.IsZero() {
+		return sendRequest
+	}
+	return func(p peer.ID, request gsmsg.GraphSyncRequest) {
+		if _, has := request.Extension(graphsync.ExtensionDeadline); has || request.IsCancel() || request.IsUpdate() {
+			sendRequest(p, request)
+			return
+		}
+		deadlineData, err := deadline.EncodeDeadline(requestDeadline)
+		if err != nil {
+			log.Warnf("unable to encode deadline for request %d: %s", request.ID(), err)
+			sendRequest(p, request)
+			return
+		}
+		sendRequest(p, request.ReplaceExtensions([]graphsync.ExtensionData{{Name: graphsync.ExtensionDeadline, Data: deadlineData}}))
+	}
+}
+
+// contextDeadline returns the deadline of a context, or the zero time if it has
+// none
+func contextDeadline(ctx context.Context) time.Time {
+	requestDeadline, ok := ctx.Deadline()
+	if !ok {
+		return time.Time{}
+	}
+	return requestDeadline
+}
+
+// outlasts returns whether a request sent with the given deadline keeps running
+// for as long as a caller with the given deadline waits, so the caller can
+// share it
+func outlasts(requestDeadline time.Time, callerDeadline time.Time) bool {
+	if requestDeadline.IsZero() {
+		return true
+	}
+	return !callerDeadline.IsZero() && !callerDeadline.After(requestDeadline)
+}
@@ -0,0 +1,125 @@
+package graphsync
+
+import (
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// PeerProvider is called when a request to a peer fails in a way another peer
+// might be able to recover from. It receives the peer that failed and the error
+// it failed with, and returns the next peer to resume the request with, or false
+// if there are no more peers to try
+type PeerProvider func(failedPeer peer.ID, err error) (peer.ID, bool)
+
+// RequestConfig is the configuration for a single request, as built up from
+// RequestOptions
+type RequestConfig struct {
+	Extensions   []ExtensionData
+	PeerProvider PeerProvider
+	StallTimeout time.Duration
+	CheckpointID string
+	LocalFirst   bool
+	Staged       bool
+	Priority     Priority
+	MaxRedirects int
+}
+
+// RequestOption configures a single request made with RequestWithOptions
+type RequestOption func(*RequestConfig)
+
+// NewRequestConfig applies the given options to an empty request configuration
+func NewRequestConfig(options ...RequestOption) RequestConfig {
+	var config RequestConfig
+	for _, option := range options {
+		option(&config)
+	}
+	return config
+}
+
+// WithExtensions adds extensions to send with the request
+func WithExtensions(extensions ...ExtensionData) RequestOption {
+	return func(config *RequestConfig) {
+		config.Extensions = append(config.Extensions, extensions...)
+	}
+}
+
+// WithPriority sets the priority the responder gives the request relative to
+// other requests from this peer
+func WithPriority(priority Priority) RequestOption {
+	return func(config *RequestConfig) {
+		config.Priority = priority
+	}
+}
+
+// WithFallbackPeers resumes the request against each of the given peers in turn
+// when the peer the request was sent to fails
+func WithFallbackPeers(peers ...peer.ID) RequestOption {
+	return func(config *RequestConfig) {
+		remaining := append([]peer.ID(nil), peers...)
+		config.PeerProvider = func(peer.ID, error) (peer.ID, bool) {
+			if len(remaining) == 0 {
+				return "", false
+			}
+			next := remaining[0]
+			remaining = remaining[1:]
+			return next, true
+		}
+	}
+}
+
+// WithPeerProvider resumes the request against peers returned by the given
+// provider when the peer the request was sent to fails
+func WithPeerProvider(peerProvider PeerProvider) RequestOption {
+	return func(config *RequestConfig) {
+		config.PeerProvider = peerProvider
+	}
+}
+
+// WithFollowRedirects follows up to maxRedirects AdditionalPeers responses by
+// sending the request to the peers the responder suggested, trying each in turn.
+// Without it, a redirected request fails with RequestRedirectedErr
+func WithFollowRedirects(maxRedirects int) RequestOption {
+	return func(config *RequestConfig) {
+		config.MaxRedirects = maxRedirects
+	}
+}
+
+// WithStallTimeout fails the request with RequestStalledErr if no response or
+// new block is received for the given duration while waiting on the network.
+// The timeout doesn't run while the responder has paused the request, and
+// starts over when the request moves to other peers. This is separate from any
+// deadline on the request context
+func WithStallTimeout(stallTimeout time.Duration) RequestOption {
+	return func(config *RequestConfig) {
+		config.StallTimeout = stallTimeout
+	}
+}
+
+// WithCheckpoint periodically records the progress of the request under the given
+// ID in the configured checkpoint store, so it can be continued with ResumeRequest.
+// The checkpoint is removed once the request completes
+func WithCheckpoint(checkpointID string) RequestOption {
+	return func(config *RequestConfig) {
+		config.CheckpointID = checkpointID
+	}
+}
+
+// WithLocalFirst loads blocks from local storage before going to the network.
+// The request is only sent once a block is missing locally, asking the peer not
+// to send blocks already loaded, and never sent if the whole DAG is present
+func WithLocalFirst() RequestOption {
+	return func(config *RequestConfig) {
+		config.LocalFirst = true
+	}
+}
+
+// WithStagedPersistence holds blocks received for the request apart from the
+// store until the request completes with every block, discarding them if it
+// doesn't. GraphExchange.CommitStagedBlocks writes them to the store early.
+// The request fails if the blocks held outgrow the local staging limit
+func WithStagedPersistence() RequestOption {
+	return func(config *RequestConfig) {
+		config.Staged = true
+	}
+}
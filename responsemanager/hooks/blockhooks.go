@@ -5,6 +5,7 @@ import (
 	peer "github.com/libp2p/go-libp2p-core/peer"
 
 	"github.com/ipfs/go-graphsync"
+	"github.com/ipfs/go-graphsync/payments"
 )
 
 // ErrPaused indicates a request should stop processing, but only cause it's paused
@@ -45,6 +46,9 @@ func (obh *OutgoingBlockHooks) Register(hook graphsync.OnOutgoingBlockHook) grap
 type BlockResult struct {
 	Err        error
 	Extensions []graphsync.ExtensionData
+	// PaymentRequired is set when the response is paused until the requestor
+	// pays for it
+	PaymentRequired bool
 }
 
 // ProcessBlockHooks runs block hooks against a request and block data
@@ -55,12 +59,13 @@ func (obh *OutgoingBlockHooks) ProcessBlockHooks(p peer.ID, request graphsync.Re
 }
 
 type blockHookActions struct {
-	err        error
-	extensions []graphsync.ExtensionData
+	err             error
+	extensions      []graphsync.ExtensionData
+	paymentRequired bool
 }
 
 func (bha *blockHookActions) result() BlockResult {
-	return BlockResult{bha.err, bha.extensions}
+	return BlockResult{bha.err, bha.extensions, bha.paymentRequired}
 }
 
 func (bha *blockHookActions) SendExtensionData(data graphsync.ExtensionData) {
@@ -74,3 +79,17 @@ func (bha *blockHookActions) TerminateWithError(err error) {
 func (bha *blockHookActions) PauseResponse() {
 	bha.err = ErrPaused{}
 }
+
+func (bha *blockHookActions) RequestPayment(amount uint64) {
+	data, err := payments.EncodePaymentRequest(amount)
+	if err != nil {
+		bha.TerminateWithError(err)
+		return
+	}
+	bha.SendExtensionData(graphsync.ExtensionData{
+		Name: graphsync.ExtensionPaymentRequest,
+		Data: data,
+	})
+	bha.PauseResponse()
+	bha.paymentRequired = true
+}
@@ -183,6 +183,20 @@ func TestRequestHookProcessing(t *testing.T) {
 				require.NoError(t, result.Err)
 			},
 		},
+		"hooks set peer bandwidth limit": {
+			configure: func(t *testing.T, requestHooks *hooks.IncomingRequestHooks) {
+				requestHooks.Register(func(p peer.ID, requestData graphsync.RequestData, hookActions graphsync.IncomingRequestHookActions) {
+					hookActions.SetPeerBandwidthLimit(1 << 20)
+					hookActions.ValidateRequest()
+				})
+			},
+			assert: func(t *testing.T, result hooks.RequestResult) {
+				require.True(t, result.IsValidated)
+				require.NotNil(t, result.PeerBandwidthLimit)
+				require.Equal(t, uint64(1<<20), *result.PeerBandwidthLimit)
+				require.NoError(t, result.Err)
+			},
+		},
 		"hooks start request paused": {
 			configure: func(t *testing.T, requestHooks *hooks.IncomingRequestHooks) {
 				requestHooks.Register(func(p peer.ID, requestData graphsync.RequestData, hookActions graphsync.IncomingRequestHookActions) {
@@ -9,6 +9,8 @@ import (
 	peer "github.com/libp2p/go-libp2p-core/peer"
 
 	"github.com/ipfs/go-graphsync"
+	"github.com/ipfs/go-graphsync/payments"
+	"github.com/ipfs/go-graphsync/rejectreason"
 )
 
 // PersistenceOptions is an interface for getting loaders by name
@@ -56,6 +58,16 @@ type RequestResult struct {
 	CustomChooser traversal.LinkTargetNodeStyleChooser
 	Err           error
 	Extensions    []graphsync.ExtensionData
+	// PeerBandwidthLimit is nil unless a hook set a bandwidth limit for the peer
+	PeerBandwidthLimit *uint64
+	// RedirectPeers are the peers a hook redirected the request to, if any
+	RedirectPeers []peer.AddrInfo
+	// IsPaymentRequired is set when the response is paused until the requestor
+	// pays for it
+	IsPaymentRequired bool
+	// RejectStatus is the status to end the request with if a hook rejected it,
+	// or zero
+	RejectStatus graphsync.ResponseStatusCode
 }
 
 // ProcessRequestHooks runs request hooks against an incoming request
@@ -71,20 +83,28 @@ type requestHookActions struct {
 	persistenceOptions PersistenceOptions
 	isValidated        bool
 	isPaused           bool
+	isPaymentRequired  bool
 	err                error
 	loader             ipld.Loader
 	chooser            traversal.LinkTargetNodeStyleChooser
 	extensions         []graphsync.ExtensionData
+	peerBandwidthLimit *uint64
+	redirectPeers      []peer.AddrInfo
+	rejectStatus       graphsync.ResponseStatusCode
 }
 
 func (ha *requestHookActions) result() RequestResult {
 	return RequestResult{
-		IsValidated:   ha.isValidated,
-		IsPaused:      ha.isPaused,
-		CustomLoader:  ha.loader,
-		CustomChooser: ha.chooser,
-		Err:           ha.err,
-		Extensions:    ha.extensions,
+		IsValidated:        ha.isValidated,
+		IsPaused:           ha.isPaused,
+		IsPaymentRequired:  ha.isPaymentRequired,
+		CustomLoader:       ha.loader,
+		CustomChooser:      ha.chooser,
+		Err:                ha.err,
+		Extensions:         ha.extensions,
+		PeerBandwidthLimit: ha.peerBandwidthLimit,
+		RedirectPeers:      ha.redirectPeers,
+		RejectStatus:       ha.rejectStatus,
 	}
 }
 
@@ -116,3 +136,42 @@ func (ha *requestHookActions) UseLinkTargetNodeStyleChooser(chooser traversal.Li
 func (ha *requestHookActions) PauseResponse() {
 	ha.isPaused = true
 }
+
+func (ha *requestHookActions) SetPeerBandwidthLimit(bytesPerSecond uint64) {
+	ha.peerBandwidthLimit = &bytesPerSecond
+}
+
+func (ha *requestHookActions) RedirectRequest(peers ...peer.AddrInfo) {
+	ha.redirectPeers = append(ha.redirectPeers, peers...)
+}
+
+func (ha *requestHookActions) RequestPayment(amount uint64) {
+	data, err := payments.EncodePaymentRequest(amount)
+	if err != nil {
+		ha.TerminateWithError(err)
+		return
+	}
+	ha.SendExtensionData(graphsync.ExtensionData{
+		Name: graphsync.ExtensionPaymentRequest,
+		Data: data,
+	})
+	ha.isPaused = true
+	ha.isPaymentRequired = true
+}
+
+func (ha *requestHookActions) RejectRequest(status graphsync.ResponseStatusCode, reason string) {
+	if status != graphsync.RequestFailedLegal {
+		status = graphsync.RequestRejected
+	}
+	data, err := rejectreason.EncodeRejectReason(reason)
+	if err != nil {
+		ha.TerminateWithError(err)
+		return
+	}
+	ha.SendExtensionData(graphsync.ExtensionData{
+		Name: graphsync.ExtensionRejectReason,
+		Data: data,
+	})
+	ha.rejectStatus = status
+	ha.TerminateWithError(errors.New("request rejected"))
+}
@@ -0,0 +1,74 @@
+package pausedresponses
+
+import (
+	"strconv"
+
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	logging "github.com/ipfs/go-log"
+	"github.com/libp2p/go-libp2p-core/peer"
+
+	"github.com/ipfs/go-graphsync"
+)
+
+var log = logging.Logger("graphsync")
+
+// keyPrefix keeps paused responses apart from anything else in a shared
+// datastore
+var keyPrefix = datastore.NewKey("/graphsync/paused-responses")
+
+// DatastoreStore is a graphsync.PausedResponseStore that persists paused
+// responses in a go-datastore, keyed by peer and request ID under its own
+// prefix
+type DatastoreStore struct {
+	ds datastore.Datastore
+}
+
+var _ graphsync.PausedResponseStore = (*DatastoreStore)(nil)
+
+// NewDatastoreStore returns a new paused response store backed by the given datastore
+func NewDatastoreStore(ds datastore.Datastore) *DatastoreStore {
+	return &DatastoreStore{ds}
+}
+
+func key(p peer.ID, requestID graphsync.RequestID) datastore.Key {
+	return keyPrefix.ChildString(p.Pretty()).ChildString(strconv.Itoa(int(requestID)))
+}
+
+// Put records a paused response, replacing any previous record for the same
+// peer and request ID
+func (dss *DatastoreStore) Put(pausedResponse graphsync.PausedResponse) error {
+	data, err := EncodePausedResponse(pausedResponse)
+	if err != nil {
+		return err
+	}
+	return dss.ds.Put(key(pausedResponse.Peer, pausedResponse.RequestID), data)
+}
+
+// Delete removes the paused response for the given peer and request ID
+func (dss *DatastoreStore) Delete(p peer.ID, requestID graphsync.RequestID) error {
+	return dss.ds.Delete(key(p, requestID))
+}
+
+// List returns all paused responses in the store. Entries that can't be
+// decoded are skipped
+func (dss *DatastoreStore) List() ([]graphsync.PausedResponse, error) {
+	results, err := dss.ds.Query(query.Query{Prefix: keyPrefix.String()})
+	if err != nil {
+		return nil, err
+	}
+	entries, err := results.Rest()
+	if err != nil {
+		return nil, err
+	}
+	pausedResponses := make([]graphsync.PausedResponse, 0, len(entries))
+	for _, entry := range entries {
+		pausedResponse, err := DecodePausedResponse(entry.Value)
+		if err != nil {
+			log.Warnf("unable to decode paused response %s: %s", entry.Key, err)
+			continue
+		}
+		pausedResponses = append(pausedResponses, pausedResponse)
+	}
+	return pausedResponses, nil
+}
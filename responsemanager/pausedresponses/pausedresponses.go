@@ -0,0 +1,151 @@
+package pausedresponses
+
+import (
+	ipld "github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/fluent"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	basicnode "github.com/ipld/go-ipld-prime/node/basic"
+	"github.com/libp2p/go-libp2p-core/peer"
+
+	"github.com/ipfs/go-graphsync"
+	"github.com/ipfs/go-graphsync/ipldutil"
+)
+
+// EncodePausedResponse encodes a paused response to an IPLD node then
+// serializes it to raw bytes
+func EncodePausedResponse(pausedResponse graphsync.PausedResponse) ([]byte, error) {
+	selectorData, err := ipldutil.EncodeNode(pausedResponse.Selector)
+	if err != nil {
+		return nil, err
+	}
+	node, err := fluent.Build(basicnode.Style.Map, func(na fluent.NodeAssembler) {
+		na.CreateMap(7, func(na fluent.MapAssembler) {
+			na.AssembleEntry("peer").AssignBytes([]byte(pausedResponse.Peer))
+			na.AssembleEntry("requestID").AssignInt(int(pausedResponse.RequestID))
+			na.AssembleEntry("root").AssignLink(cidlink.Link{Cid: pausedResponse.Root})
+			na.AssembleEntry("selector").AssignBytes(selectorData)
+			na.AssembleEntry("priority").AssignInt(int(pausedResponse.Priority))
+			na.AssembleEntry("extensions").CreateList(len(pausedResponse.Extensions), func(na fluent.ListAssembler) {
+				for _, extension := range pausedResponse.Extensions {
+					na.AssembleValue().CreateMap(2, func(na fluent.MapAssembler) {
+						na.AssembleEntry("name").AssignString(string(extension.Name))
+						na.AssembleEntry("data").AssignBytes(extension.Data)
+					})
+				}
+			})
+			na.AssembleEntry("sentLinks").CreateList(len(pausedResponse.SentLinks), func(na fluent.ListAssembler) {
+				for _, link := range pausedResponse.SentLinks {
+					na.AssembleValue().AssignLink(link)
+				}
+			})
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ipldutil.EncodeNode(node)
+}
+
+// DecodePausedResponse assembles a paused response from a raw byte array, first
+// deserializing as a node and then assembling into a paused response struct
+func DecodePausedResponse(data []byte) (graphsync.PausedResponse, error) {
+	node, err := ipldutil.DecodeNode(data)
+	if err != nil {
+		return graphsync.PausedResponse{}, err
+	}
+	peerData, err := lookupBytes(node, "peer")
+	if err != nil {
+		return graphsync.PausedResponse{}, err
+	}
+	requestID, err := lookupInt(node, "requestID")
+	if err != nil {
+		return graphsync.PausedResponse{}, err
+	}
+	rootNode, err := node.LookupString("root")
+	if err != nil {
+		return graphsync.PausedResponse{}, err
+	}
+	root, err := rootNode.AsLink()
+	if err != nil {
+		return graphsync.PausedResponse{}, err
+	}
+	selectorData, err := lookupBytes(node, "selector")
+	if err != nil {
+		return graphsync.PausedResponse{}, err
+	}
+	selector, err := ipldutil.DecodeNode(selectorData)
+	if err != nil {
+		return graphsync.PausedResponse{}, err
+	}
+	priority, err := lookupInt(node, "priority")
+	if err != nil {
+		return graphsync.PausedResponse{}, err
+	}
+	extensionsNode, err := node.LookupString("extensions")
+	if err != nil {
+		return graphsync.PausedResponse{}, err
+	}
+	var extensions []graphsync.ExtensionData
+	iterator := extensionsNode.ListIterator()
+	for !iterator.Done() {
+		_, item, err := iterator.Next()
+		if err != nil {
+			return graphsync.PausedResponse{}, err
+		}
+		nameNode, err := item.LookupString("name")
+		if err != nil {
+			return graphsync.PausedResponse{}, err
+		}
+		name, err := nameNode.AsString()
+		if err != nil {
+			return graphsync.PausedResponse{}, err
+		}
+		data, err := lookupBytes(item, "data")
+		if err != nil {
+			return graphsync.PausedResponse{}, err
+		}
+		extensions = append(extensions, graphsync.ExtensionData{Name: graphsync.ExtensionName(name), Data: data})
+	}
+	sentLinksNode, err := node.LookupString("sentLinks")
+	if err != nil {
+		return graphsync.PausedResponse{}, err
+	}
+	var sentLinks []ipld.Link
+	iterator = sentLinksNode.ListIterator()
+	for !iterator.Done() {
+		_, item, err := iterator.Next()
+		if err != nil {
+			return graphsync.PausedResponse{}, err
+		}
+		link, err := item.AsLink()
+		if err != nil {
+			return graphsync.PausedResponse{}, err
+		}
+		sentLinks = append(sentLinks, link)
+	}
+	return graphsync.PausedResponse{
+		Peer:       peer.ID(peerData),
+		RequestID:  graphsync.RequestID(requestID),
+		Root:       root.(cidlink.Link).Cid,
+		Selector:   selector,
+		Priority:   graphsync.Priority(priority),
+		Extensions: extensions,
+		SentLinks:  sentLinks,
+	}, nil
+}
+
+func lookupBytes(node ipld.Node, key string) ([]byte, error) {
+	valueNode, err := node.LookupString(key)
+	if err != nil {
+		return nil, err
+	}
+	return valueNode.AsBytes()
+}
+
+func lookupInt(node ipld.Node, key string) (int, error) {
+	valueNode, err := node.LookupString(key)
+	if err != nil {
+		return 0, err
+	}
+	return valueNode.AsInt()
+}
@@ -0,0 +1,94 @@
+package pausedresponses
+
+import (
+	"testing"
+
+	"github.com/ipfs/go-datastore"
+	dss "github.com/ipfs/go-datastore/sync"
+	ipld "github.com/ipld/go-ipld-prime"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	basicnode "github.com/ipld/go-ipld-prime/node/basic"
+	"github.com/ipld/go-ipld-prime/traversal/selector"
+	"github.com/ipld/go-ipld-prime/traversal/selector/builder"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ipfs/go-graphsync"
+	"github.com/ipfs/go-graphsync/ipldutil"
+	"github.com/ipfs/go-graphsync/testutil"
+)
+
+func newTestPausedResponse() graphsync.PausedResponse {
+	ssb := builder.NewSelectorSpecBuilder(basicnode.Style.Any)
+	var sentLinks []ipld.Link
+	for _, c := range testutil.GenerateCids(10) {
+		sentLinks = append(sentLinks, cidlink.Link{Cid: c})
+	}
+	return graphsync.PausedResponse{
+		Peer:      testutil.GeneratePeers(1)[0],
+		RequestID: graphsync.RequestID(17),
+		Root:      testutil.GenerateCids(1)[0],
+		Selector:  ssb.ExploreRecursive(selector.RecursionLimitDepth(5), ssb.ExploreAll(ssb.ExploreRecursiveEdge())).Node(),
+		Priority:  graphsync.Priority(3),
+		Extensions: []graphsync.ExtensionData{
+			{
+				Name: graphsync.ExtensionName("AppleSauce/McGee"),
+				Data: testutil.RandomBytes(100),
+			},
+		},
+		SentLinks: sentLinks,
+	}
+}
+
+func requirePausedResponsesEqual(t *testing.T, expected graphsync.PausedResponse, actual graphsync.PausedResponse) {
+	require.Equal(t, expected.Peer, actual.Peer)
+	require.Equal(t, expected.RequestID, actual.RequestID)
+	require.Equal(t, expected.Root, actual.Root)
+	require.Equal(t, expected.Priority, actual.Priority)
+	require.Equal(t, expected.Extensions, actual.Extensions)
+	require.Equal(t, expected.SentLinks, actual.SentLinks)
+	expectedSelector, err := ipldutil.EncodeNode(expected.Selector)
+	require.NoError(t, err)
+	actualSelector, err := ipldutil.EncodeNode(actual.Selector)
+	require.NoError(t, err)
+	require.Equal(t, expectedSelector, actualSelector)
+}
+
+func TestDecodeEncodePausedResponse(t *testing.T) {
+	pausedResponse := newTestPausedResponse()
+	encoded, err := EncodePausedResponse(pausedResponse)
+	require.NoError(t, err, "encode errored")
+	decoded, err := DecodePausedResponse(encoded)
+	require.NoError(t, err, "decode errored")
+	requirePausedResponsesEqual(t, pausedResponse, decoded)
+}
+
+func TestDatastoreStore(t *testing.T) {
+	ds := dss.MutexWrap(datastore.NewMapDatastore())
+	store := NewDatastoreStore(ds)
+	pausedResponse := newTestPausedResponse()
+
+	// other data in the datastore, and entries that don't decode, are not listed
+	require.NoError(t, ds.Put(datastore.NewKey("/other"), []byte("applesauce")))
+	require.NoError(t, ds.Put(keyPrefix.ChildString("invalid"), []byte("applesauce")))
+	pausedResponses, err := store.List()
+	require.NoError(t, err)
+	require.Len(t, pausedResponses, 0)
+
+	require.NoError(t, store.Put(pausedResponse))
+	pausedResponses, err = store.List()
+	require.NoError(t, err)
+	require.Len(t, pausedResponses, 1)
+	requirePausedResponsesEqual(t, pausedResponse, pausedResponses[0])
+
+	pausedResponse.SentLinks = append(pausedResponse.SentLinks, cidlink.Link{Cid: testutil.GenerateCids(1)[0]})
+	require.NoError(t, store.Put(pausedResponse))
+	pausedResponses, err = store.List()
+	require.NoError(t, err)
+	require.Len(t, pausedResponses, 1)
+	requirePausedResponsesEqual(t, pausedResponse, pausedResponses[0])
+
+	require.NoError(t, store.Delete(pausedResponse.Peer, pausedResponse.RequestID))
+	pausedResponses, err = store.List()
+	require.NoError(t, err)
+	require.Len(t, pausedResponses, 0)
+}
@@ -13,6 +13,7 @@ import (
 	"github.com/ipfs/go-graphsync"
 	"github.com/ipfs/go-graphsync/linktracker"
 	gsmsg "github.com/ipfs/go-graphsync/message"
+	"github.com/ipfs/go-graphsync/partition"
 	"github.com/ipfs/go-graphsync/peermanager"
 	"github.com/ipfs/go-graphsync/responsemanager/responsebuilder"
 )
@@ -44,6 +45,8 @@ type peerResponseSender struct {
 	linkTracker        *linktracker.LinkTracker
 	altTrackers        map[string]*linktracker.LinkTracker
 	dedupKeys          map[graphsync.RequestID]string
+	metadataOnly       map[graphsync.RequestID]struct{}
+	partitions         map[graphsync.RequestID]partition.Partition
 	responseBuildersLk sync.RWMutex
 	responseBuilders   []*responsebuilder.ResponseBuilder
 }
@@ -53,7 +56,9 @@ type peerResponseSender struct {
 type PeerResponseSender interface {
 	peermanager.PeerProcess
 	DedupKey(requestID graphsync.RequestID, key string)
+	MetadataOnly(requestID graphsync.RequestID)
 	IgnoreBlocks(requestID graphsync.RequestID, links []ipld.Link)
+	Partition(requestID graphsync.RequestID, p partition.Partition)
 	SendResponse(
 		requestID graphsync.RequestID,
 		link ipld.Link,
@@ -67,6 +72,7 @@ type PeerResponseSender interface {
 	// Note: if the transaction function errors, the results will not execute
 	Transaction(requestID graphsync.RequestID, transaction Transaction) error
 	PauseRequest(requestID graphsync.RequestID)
+	PauseRequestForPayment(requestID graphsync.RequestID)
 }
 
 // PeerResponseTransactionSender is a limited interface for sending responses inside a transaction
@@ -80,6 +86,7 @@ type PeerResponseTransactionSender interface {
 	FinishRequest() graphsync.ResponseStatusCode
 	FinishWithError(status graphsync.ResponseStatusCode)
 	PauseRequest()
+	PauseRequestForPayment()
 }
 
 // NewResponseSender generates a new PeerResponseSender for the given context, peer ID,
@@ -94,6 +101,8 @@ func NewResponseSender(ctx context.Context, p peer.ID, peerHandler PeerMessageHa
 		outgoingWork: make(chan struct{}, 1),
 		linkTracker:  linktracker.New(),
 		dedupKeys:    make(map[graphsync.RequestID]string),
+		metadataOnly: make(map[graphsync.RequestID]struct{}),
+		partitions:   make(map[graphsync.RequestID]partition.Partition),
 		altTrackers:  make(map[string]*linktracker.LinkTracker),
 	}
 }
@@ -121,6 +130,22 @@ func (prs *peerResponseSender) DedupKey(requestID graphsync.RequestID, key strin
 	}
 }
 
+// MetadataOnly marks the given requestID as only sending metadata about the
+// blocks traversed, including their sizes, and never the blocks themselves
+func (prs *peerResponseSender) MetadataOnly(requestID graphsync.RequestID) {
+	prs.linkTrackerLk.Lock()
+	defer prs.linkTrackerLk.Unlock()
+	prs.metadataOnly[requestID] = struct{}{}
+}
+
+// Partition marks the given requestID as only sending the blocks in the given
+// partition. Other blocks are listed as present but not sent
+func (prs *peerResponseSender) Partition(requestID graphsync.RequestID, p partition.Partition) {
+	prs.linkTrackerLk.Lock()
+	defer prs.linkTrackerLk.Unlock()
+	prs.partitions[requestID] = p
+}
+
 func (prs *peerResponseSender) IgnoreBlocks(requestID graphsync.RequestID, links []ipld.Link) {
 	prs.linkTrackerLk.Lock()
 	linkTracker := prs.getLinkTracker(requestID)
@@ -201,6 +226,10 @@ func (prts *peerResponseTransactionSender) PauseRequest() {
 	prts.operations = append(prts.operations, statusOperation{prts.requestID, graphsync.RequestPaused})
 }
 
+func (prts *peerResponseTransactionSender) PauseRequestForPayment() {
+	prts.operations = append(prts.operations, statusOperation{prts.requestID, graphsync.NotEnoughGas})
+}
+
 func (prts *peerResponseTransactionSender) FinishWithCancel() {
 	_ = prts.prs.finishTracking(prts.requestID)
 }
@@ -218,10 +247,11 @@ func (prs *peerResponseSender) Transaction(requestID graphsync.RequestID, transa
 }
 
 type blockOperation struct {
-	data      []byte
-	sendBlock bool
-	link      ipld.Link
-	requestID graphsync.RequestID
+	data         []byte
+	sendBlock    bool
+	link         ipld.Link
+	requestID    graphsync.RequestID
+	metadataOnly bool
 }
 
 func (bo blockOperation) build(responseBuilder *responsebuilder.ResponseBuilder) {
@@ -233,6 +263,10 @@ func (bo blockOperation) build(responseBuilder *responsebuilder.ResponseBuilder)
 		}
 		responseBuilder.AddBlock(block)
 	}
+	if bo.metadataOnly {
+		responseBuilder.AddLinkMetadata(bo.requestID, bo.link, bo.data != nil, bo.BlockSize())
+		return
+	}
 	responseBuilder.AddLink(bo.requestID, bo.link, bo.data != nil)
 }
 
@@ -259,12 +293,28 @@ func (prs *peerResponseSender) setupBlockOperation(requestID graphsync.RequestID
 	link ipld.Link, data []byte) blockOperation {
 	hasBlock := data != nil
 	prs.linkTrackerLk.Lock()
+	defer prs.linkTrackerLk.Unlock()
 	linkTracker := prs.getLinkTracker(requestID)
+	if _, ok := prs.metadataOnly[requestID]; ok {
+		// blocks are never sent, so only missing blocks are tracked, to tell
+		// whether the response is complete
+		if !hasBlock {
+			linkTracker.RecordLinkTraversal(requestID, link, hasBlock)
+		}
+		return blockOperation{
+			data, false, link, requestID, true,
+		}
+	}
+	if p, ok := prs.partitions[requestID]; ok && hasBlock && !p.Has(link) {
+		// another peer sends the block, so it is not tracked as sent
+		return blockOperation{
+			data, false, link, requestID, false,
+		}
+	}
 	sendBlock := hasBlock && linkTracker.BlockRefCount(link) == 0
 	linkTracker.RecordLinkTraversal(requestID, link, hasBlock)
-	prs.linkTrackerLk.Unlock()
 	return blockOperation{
-		data, sendBlock, link, requestID,
+		data, sendBlock, link, requestID, false,
 	}
 }
 
@@ -300,6 +350,8 @@ func (prs *peerResponseSender) finishTracking(requestID graphsync.RequestID) boo
 	defer prs.linkTrackerLk.Unlock()
 	linkTracker := prs.getLinkTracker(requestID)
 	allBlocks := linkTracker.FinishRequest(requestID)
+	delete(prs.metadataOnly, requestID)
+	delete(prs.partitions, requestID)
 	key, ok := prs.dedupKeys[requestID]
 	if ok {
 		delete(prs.dedupKeys, requestID)
@@ -343,6 +395,12 @@ func (prs *peerResponseSender) PauseRequest(requestID graphsync.RequestID) {
 	prs.execute([]responseOperation{statusOperation{requestID, graphsync.RequestPaused}})
 }
 
+// PauseRequestForPayment marks the given requestID as paused until the
+// requestor pays for it
+func (prs *peerResponseSender) PauseRequestForPayment(requestID graphsync.RequestID) {
+	prs.execute([]responseOperation{statusOperation{requestID, graphsync.NotEnoughGas}})
+}
+
 func (prs *peerResponseSender) FinishWithCancel(requestID graphsync.RequestID) {
 	_ = prs.finishTracking(requestID)
 }
@@ -8,6 +8,7 @@ import (
 	"time"
 
 	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
 	"github.com/ipld/go-ipld-prime"
 	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
 	"github.com/libp2p/go-libp2p-core/peer"
@@ -15,6 +16,8 @@ import (
 
 	"github.com/ipfs/go-graphsync"
 	gsmsg "github.com/ipfs/go-graphsync/message"
+	"github.com/ipfs/go-graphsync/metadata"
+	"github.com/ipfs/go-graphsync/partition"
 	"github.com/ipfs/go-graphsync/testutil"
 )
 
@@ -528,3 +531,129 @@ func findResponseForRequestID(responses []gsmsg.GraphSyncResponse, requestID gra
 	}
 	return gsmsg.GraphSyncResponse{}, fmt.Errorf("Response Not Found")
 }
+
+func TestPeerResponseSenderMetadataOnly(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	p := testutil.GeneratePeers(1)[0]
+	requestID1 := graphsync.RequestID(rand.Int31())
+	requestID2 := graphsync.RequestID(rand.Int31())
+	blks := testutil.GenerateBlocksOfSize(3, 100)
+	links := make([]ipld.Link, 0, len(blks))
+	for _, block := range blks {
+		links = append(links, cidlink.Link{Cid: block.Cid()})
+	}
+	done := make(chan struct{}, 1)
+	sent := make(chan struct{}, 1)
+	fph := &fakePeerHandler{
+		done: done,
+		sent: sent,
+	}
+	peerResponseSender := NewResponseSender(ctx, p, fph)
+	peerResponseSender.Startup()
+
+	peerResponseSender.MetadataOnly(requestID1)
+
+	bd := peerResponseSender.SendResponse(requestID1, links[0], blks[0].RawData())
+	require.Equal(t, links[0], bd.Link())
+	require.Equal(t, uint64(len(blks[0].RawData())), bd.BlockSize())
+	require.Equal(t, uint64(0), bd.BlockSizeOnWire())
+	testutil.AssertDoesReceive(ctx, t, sent, "did not send first message")
+
+	require.Len(t, fph.lastBlocks, 0, "should not send blocks for metadata only requests")
+	require.Len(t, fph.lastResponses, 1)
+	require.Equal(t, requestID1, fph.lastResponses[0].RequestID())
+	require.Equal(t, graphsync.PartialResponse, fph.lastResponses[0].Status())
+	md, err := metadataForResponse(fph.lastResponses[0])
+	require.NoError(t, err)
+	require.Equal(t, metadata.Metadata{
+		{Link: links[0], BlockPresent: true, BlockSize: uint64(len(blks[0].RawData()))},
+	}, md)
+
+	peerResponseSender.SendResponse(requestID1, links[1], nil)
+	peerResponseSender.FinishRequest(requestID1)
+	// metadata only requests do not stop other requests receiving blocks
+	peerResponseSender.SendResponse(requestID2, links[0], blks[0].RawData())
+
+	// let peer reponse manager know last message was sent so message sending can continue
+	done <- struct{}{}
+
+	testutil.AssertDoesReceive(ctx, t, sent, "did not send second message")
+
+	require.Len(t, fph.lastBlocks, 1)
+	require.Equal(t, blks[0].Cid(), fph.lastBlocks[0].Cid(), "did not send block for other request")
+	response1, err := findResponseForRequestID(fph.lastResponses, requestID1)
+	require.NoError(t, err)
+	require.Equal(t, graphsync.RequestCompletedPartial, response1.Status(), "did not send correct response code for missing block")
+	md, err = metadataForResponse(response1)
+	require.NoError(t, err)
+	require.Equal(t, metadata.Metadata{
+		{Link: links[1], BlockPresent: false},
+	}, md)
+}
+
+func TestPeerResponseSenderPartition(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	p := testutil.GeneratePeers(1)[0]
+	requestID1 := graphsync.RequestID(rand.Int31())
+	blks := testutil.GenerateBlocksOfSize(10, 100)
+	links := make([]ipld.Link, 0, len(blks))
+	for _, block := range blks {
+		links = append(links, cidlink.Link{Cid: block.Cid()})
+	}
+	done := make(chan struct{}, 1)
+	sent := make(chan struct{}, 1)
+	fph := &fakePeerHandler{
+		done: done,
+		sent: sent,
+	}
+	peerResponseSender := NewResponseSender(ctx, p, fph)
+	peerResponseSender.Startup()
+
+	requestPartition := partition.Partition{Index: 0, Count: 2}
+	peerResponseSender.Partition(requestID1, requestPartition)
+
+	peerResponseSender.SendResponse(requestID1, links[0], blks[0].RawData())
+	testutil.AssertDoesReceive(ctx, t, sent, "did not send first message")
+	sentBlocks := fph.lastBlocks
+	var sentMetadata metadata.Metadata
+	md, err := metadataForResponse(fph.lastResponses[0])
+	require.NoError(t, err)
+	sentMetadata = append(sentMetadata, md...)
+
+	for i := 1; i < len(blks); i++ {
+		peerResponseSender.SendResponse(requestID1, links[i], blks[i].RawData())
+	}
+	peerResponseSender.FinishRequest(requestID1)
+	done <- struct{}{}
+	testutil.AssertDoesReceive(ctx, t, sent, "did not send second message")
+	sentBlocks = append(sentBlocks, fph.lastBlocks...)
+	md, err = metadataForResponse(fph.lastResponses[0])
+	require.NoError(t, err)
+	sentMetadata = append(sentMetadata, md...)
+	require.Equal(t, graphsync.RequestCompletedFull, fph.lastResponses[0].Status())
+
+	sentCids := make(map[cid.Cid]struct{}, len(sentBlocks))
+	for _, block := range sentBlocks {
+		sentCids[block.Cid()] = struct{}{}
+	}
+	for i, link := range links {
+		_, wasSent := sentCids[blks[i].Cid()]
+		require.Equal(t, requestPartition.Has(link), wasSent, "should only send blocks in the partition")
+	}
+	require.Len(t, sentMetadata, len(links))
+	for _, item := range sentMetadata {
+		require.True(t, item.BlockPresent, "should list blocks outside the partition as present")
+	}
+}
+
+func metadataForResponse(response gsmsg.GraphSyncResponse) (metadata.Metadata, error) {
+	data, ok := response.Extension(graphsync.ExtensionMetadata)
+	if !ok {
+		return nil, fmt.Errorf("no metadata in response")
+	}
+	return metadata.DecodeMetadata(data)
+}
@@ -12,10 +12,13 @@ import (
 	"github.com/libp2p/go-libp2p-core/peer"
 
 	"github.com/ipfs/go-graphsync"
+	"github.com/ipfs/go-graphsync/byterange"
 	"github.com/ipfs/go-graphsync/cidset"
 	"github.com/ipfs/go-graphsync/dedupkey"
 	"github.com/ipfs/go-graphsync/ipldutil"
 	gsmsg "github.com/ipfs/go-graphsync/message"
+	"github.com/ipfs/go-graphsync/partition"
+	"github.com/ipfs/go-graphsync/redirect"
 	"github.com/ipfs/go-graphsync/responsemanager/hooks"
 	"github.com/ipfs/go-graphsync/responsemanager/peerresponsemanager"
 	"github.com/ipfs/go-graphsync/responsemanager/runtraversal"
@@ -23,6 +26,10 @@ import (
 
 var errCancelledByCommand = errors.New("response cancelled by responder")
 
+var errRequestRedirected = errors.New("request redirected to other peers")
+
+var errDeadlinePassed = errors.New("request deadline passed")
+
 // TODO: Move this into a seperate module and fully seperate from the ResponseManager
 type queryExecutor struct {
 	requestHooks       RequestHooks
@@ -31,12 +38,17 @@ type queryExecutor struct {
 	completedListeners CompletedListeners
 	cancelledListeners CancelledListeners
 	peerManager        PeerManager
+	bandwidthLimiter   BandwidthLimiter
 	loader             ipld.Loader
 	queryQueue         QueryQueue
 	messages           chan responseManagerMessage
 	ctx                context.Context
 	workSignal         chan struct{}
+	thawSpeed          time.Duration
 	ticker             *time.Ticker
+	// recordSentLinks is set when paused responses are stored, as only they
+	// need the links sent so far
+	recordSentLinks bool
 }
 
 func (qe *queryExecutor) processQueriesWorker() {
@@ -72,7 +84,7 @@ func (qe *queryExecutor) processQueriesWorker() {
 				log.Info("Empty task on peer request stack")
 				continue
 			}
-			status, err := qe.executeTask(key, taskData)
+			status, sentLinks, err := qe.executeTask(key, taskData)
 			_, isPaused := err.(hooks.ErrPaused)
 			isCancelled := err != nil && isContextErr(err)
 			if isCancelled {
@@ -81,7 +93,7 @@ func (qe *queryExecutor) processQueriesWorker() {
 				qe.completedListeners.NotifyCompletedListeners(key.p, taskData.request, status)
 			}
 			select {
-			case qe.messages <- &finishTaskRequest{key, status, err}:
+			case qe.messages <- &finishTaskRequest{key, status, err, sentLinks}:
 			case <-qe.ctx.Done():
 			}
 		}
@@ -91,31 +103,42 @@ func (qe *queryExecutor) processQueriesWorker() {
 
 }
 
-func (qe *queryExecutor) executeTask(key responseKey, taskData responseTaskData) (graphsync.ResponseStatusCode, error) {
+func (qe *queryExecutor) executeTask(key responseKey, taskData responseTaskData) (graphsync.ResponseStatusCode, []ipld.Link, error) {
 	var err error
+	if isPastDeadline(taskData.deadline) {
+		qe.peerManager.SenderForPeer(key.p).FinishWithError(taskData.request.ID(), graphsync.RequestCancelled)
+		return graphsync.RequestCancelled, nil, errDeadlinePassed
+	}
 	loader := taskData.loader
 	traverser := taskData.traverser
 	if loader == nil || traverser == nil {
 		var isPaused bool
-		loader, traverser, isPaused, err = qe.prepareQuery(taskData.ctx, key.p, taskData.request)
+		loader, traverser, isPaused, err = qe.prepareQuery(taskData.ctx, key.p, taskData.request, taskData.isRestored)
+		if err == errRequestRedirected {
+			return graphsync.AdditionalPeers, nil, err
+		}
 		if err != nil {
-			return graphsync.RequestFailedUnknown, err
+			return graphsync.RequestFailedUnknown, nil, err
 		}
 		select {
 		case <-qe.ctx.Done():
-			return graphsync.RequestFailedUnknown, errors.New("context cancelled")
+			return graphsync.RequestFailedUnknown, nil, errors.New("context cancelled")
 		case qe.messages <- &setResponseDataRequest{key, loader, traverser}:
 		}
 		if isPaused {
-			return graphsync.RequestPaused, hooks.ErrPaused{}
+			return graphsync.RequestPaused, nil, hooks.ErrPaused{}
 		}
 	}
-	return qe.executeQuery(key.p, taskData.request, loader, traverser, taskData.signals)
+	return qe.executeQuery(key.p, taskData.request, loader, traverser, taskData.signals, taskData.deadline)
 }
 
+// prepareQuery runs the request hooks for a request and starts its traversal.
+// Restored responses were already paused before the responder restarted, and
+// being unpaused is the go ahead to continue, so they are not paused again
 func (qe *queryExecutor) prepareQuery(ctx context.Context,
 	p peer.ID,
-	request gsmsg.GraphSyncRequest) (ipld.Loader, ipldutil.Traverser, bool, error) {
+	request gsmsg.GraphSyncRequest,
+	isRestored bool) (ipld.Loader, ipldutil.Traverser, bool, error) {
 	result := qe.requestHooks.ProcessRequestHooks(p, request)
 	peerResponseSender := qe.peerManager.SenderForPeer(p)
 	var transactionError error
@@ -124,10 +147,21 @@ func (qe *queryExecutor) prepareQuery(ctx context.Context,
 		for _, extension := range result.Extensions {
 			transaction.SendExtensionData(extension)
 		}
-		if result.Err != nil || !result.IsValidated {
+		if result.RejectStatus != 0 {
+			transaction.FinishWithError(result.RejectStatus)
+			transactionError = result.Err
+		} else if result.Err != nil {
+			transaction.FinishWithError(graphsync.RequestFailedUnknown)
+			transactionError = errors.New("request not valid")
+		} else if len(result.RedirectPeers) > 0 {
+			transactionError = qe.redirect(transaction, result.RedirectPeers)
+		} else if !result.IsValidated {
 			transaction.FinishWithError(graphsync.RequestFailedUnknown)
 			transactionError = errors.New("request not valid")
-		} else if result.IsPaused {
+		} else if result.IsPaymentRequired && !isRestored {
+			transaction.PauseRequestForPayment()
+			isPaused = true
+		} else if result.IsPaused && !isRestored {
 			transaction.PauseRequest()
 			isPaused = true
 		}
@@ -139,17 +173,31 @@ func (qe *queryExecutor) prepareQuery(ctx context.Context,
 	if transactionError != nil {
 		return nil, nil, false, transactionError
 	}
+	if result.PeerBandwidthLimit != nil && qe.bandwidthLimiter != nil {
+		qe.bandwidthLimiter.SetRequestRate(ctx, p, *result.PeerBandwidthLimit)
+	}
 	if err := qe.processDedupByKey(request, peerResponseSender); err != nil {
 		return nil, nil, false, err
 	}
 	if err := qe.processDoNoSendCids(request, peerResponseSender); err != nil {
 		return nil, nil, false, err
 	}
+	if err := qe.processPartition(request, peerResponseSender); err != nil {
+		return nil, nil, false, err
+	}
+	if _, has := request.Extension(graphsync.ExtensionMetadataOnly); has {
+		peerResponseSender.MetadataOnly(request.ID())
+	}
+	byteRange, err := qe.processByteRange(request, peerResponseSender)
+	if err != nil {
+		return nil, nil, false, err
+	}
 	rootLink := cidlink.Link{Cid: request.Root()}
 	traverser := ipldutil.TraversalBuilder{
 		Root:     rootLink,
 		Selector: request.Selector(),
 		Chooser:  result.CustomChooser,
+		Range:    byteRange,
 	}.Start(ctx)
 	loader := result.CustomLoader
 	if loader == nil {
@@ -158,6 +206,22 @@ func (qe *queryExecutor) prepareQuery(ctx context.Context,
 	return loader, traverser, isPaused, nil
 }
 
+// redirect ends a request with an AdditionalPeers response naming the peers to
+// send it to instead
+func (qe *queryExecutor) redirect(transaction peerresponsemanager.PeerResponseTransactionSender, peers []peer.AddrInfo) error {
+	redirectData, err := redirect.EncodeRedirect(peers)
+	if err != nil {
+		transaction.FinishWithError(graphsync.RequestFailedUnknown)
+		return err
+	}
+	transaction.SendExtensionData(graphsync.ExtensionData{
+		Name: graphsync.ExtensionRedirect,
+		Data: redirectData,
+	})
+	transaction.FinishWithError(graphsync.AdditionalPeers)
+	return errRequestRedirected
+}
+
 func (qe *queryExecutor) processDedupByKey(request gsmsg.GraphSyncRequest, peerResponseSender peerresponsemanager.PeerResponseSender) error {
 	dedupData, has := request.Extension(graphsync.ExtensionDeDupByKey)
 	if !has {
@@ -172,26 +236,65 @@ func (qe *queryExecutor) processDedupByKey(request gsmsg.GraphSyncRequest, peerR
 	return nil
 }
 
+func isPastDeadline(requestDeadline time.Time) bool {
+	return !requestDeadline.IsZero() && !time.Now().Before(requestDeadline)
+}
+
+// processByteRange returns the byte range a request for a UnixFS file is
+// limited to, if any
+func (qe *queryExecutor) processByteRange(request gsmsg.GraphSyncRequest, peerResponseSender peerresponsemanager.PeerResponseSender) (*ipldutil.ByteRange, error) {
+	byteRange, err := byterange.RangeForRequest(request)
+	if err != nil {
+		peerResponseSender.FinishWithError(request.ID(), graphsync.RequestFailedUnknown)
+		return nil, err
+	}
+	return byteRange, nil
+}
+
 func (qe *queryExecutor) processDoNoSendCids(request gsmsg.GraphSyncRequest, peerResponseSender peerresponsemanager.PeerResponseSender) error {
 	doNotSendCidsData, has := request.Extension(graphsync.ExtensionDoNotSendCIDs)
 	if !has {
 		return nil
 	}
-	cidSet, err := cidset.DecodeCidSet(doNotSendCidsData)
+	links, err := decodeDoNotSendLinks(doNotSendCidsData)
+	if err != nil {
+		peerResponseSender.FinishWithError(request.ID(), graphsync.RequestFailedUnknown)
+		return err
+	}
+	peerResponseSender.IgnoreBlocks(request.ID(), links)
+	return nil
+}
+
+// processPartition limits the blocks sent for a request sent to several peers
+// to this peer's share
+func (qe *queryExecutor) processPartition(request gsmsg.GraphSyncRequest, peerResponseSender peerresponsemanager.PeerResponseSender) error {
+	partitionData, has := request.Extension(graphsync.ExtensionPartition)
+	if !has {
+		return nil
+	}
+	p, err := partition.DecodePartition(partitionData)
 	if err != nil {
 		peerResponseSender.FinishWithError(request.ID(), graphsync.RequestFailedUnknown)
 		return err
 	}
+	peerResponseSender.Partition(request.ID(), p)
+	return nil
+}
+
+func decodeDoNotSendLinks(doNotSendCidsData []byte) ([]ipld.Link, error) {
+	cidSet, err := cidset.DecodeCidSet(doNotSendCidsData)
+	if err != nil {
+		return nil, err
+	}
 	links := make([]ipld.Link, 0, cidSet.Len())
 	err = cidSet.ForEach(func(c cid.Cid) error {
 		links = append(links, cidlink.Link{Cid: c})
 		return nil
 	})
 	if err != nil {
-		return err
+		return nil, err
 	}
-	peerResponseSender.IgnoreBlocks(request.ID(), links)
-	return nil
+	return links, nil
 }
 
 func (qe *queryExecutor) executeQuery(
@@ -199,24 +302,33 @@ func (qe *queryExecutor) executeQuery(
 	request gsmsg.GraphSyncRequest,
 	loader ipld.Loader,
 	traverser ipldutil.Traverser,
-	signals signals) (graphsync.ResponseStatusCode, error) {
+	signals signals,
+	requestDeadline time.Time) (graphsync.ResponseStatusCode, []ipld.Link, error) {
 	updateChan := make(chan []gsmsg.GraphSyncRequest)
 	peerResponseSender := qe.peerManager.SenderForPeer(p)
+	var sentLinks []ipld.Link
 	err := runtraversal.RunTraversal(loader, traverser, func(link ipld.Link, data []byte) error {
 		var err error
 		_ = peerResponseSender.Transaction(request.ID(), func(transaction peerresponsemanager.PeerResponseTransactionSender) error {
-			err = qe.checkForUpdates(p, request, signals, updateChan, transaction)
+			err = qe.checkForUpdates(p, request, signals, updateChan, requestDeadline, transaction)
 			if _, ok := err.(hooks.ErrPaused); !ok && err != nil {
 				return nil
 			}
 			blockData := transaction.SendResponse(link, data)
+			if data != nil && qe.recordSentLinks {
+				sentLinks = append(sentLinks, link)
+			}
 			if blockData.BlockSize() > 0 {
 				result := qe.blockHooks.ProcessBlockHooks(p, request, blockData)
 				for _, extension := range result.Extensions {
 					transaction.SendExtensionData(extension)
 				}
 				if _, ok := result.Err.(hooks.ErrPaused); ok {
-					transaction.PauseRequest()
+					if result.PaymentRequired {
+						transaction.PauseRequestForPayment()
+					} else {
+						transaction.PauseRequest()
+					}
 				}
 				if result.Err != nil {
 					err = result.Err
@@ -229,20 +341,20 @@ func (qe *queryExecutor) executeQuery(
 	if err != nil {
 		_, isPaused := err.(hooks.ErrPaused)
 		if isPaused {
-			return graphsync.RequestPaused, err
+			return graphsync.RequestPaused, sentLinks, err
 		}
 		if isContextErr(err) {
 			peerResponseSender.FinishWithCancel(request.ID())
-			return graphsync.RequestCancelled, err
+			return graphsync.RequestCancelled, sentLinks, err
 		}
-		if err == errCancelledByCommand {
+		if err == errCancelledByCommand || err == errDeadlinePassed {
 			peerResponseSender.FinishWithError(request.ID(), graphsync.RequestCancelled)
-			return graphsync.RequestCancelled, err
+			return graphsync.RequestCancelled, sentLinks, err
 		}
 		peerResponseSender.FinishWithError(request.ID(), graphsync.RequestFailedUnknown)
-		return graphsync.RequestFailedUnknown, err
+		return graphsync.RequestFailedUnknown, sentLinks, err
 	}
-	return peerResponseSender.FinishRequest(request.ID()), nil
+	return peerResponseSender.FinishRequest(request.ID()), sentLinks, nil
 }
 
 func (qe *queryExecutor) checkForUpdates(
@@ -250,6 +362,7 @@ func (qe *queryExecutor) checkForUpdates(
 	request gsmsg.GraphSyncRequest,
 	signals signals,
 	updateChan chan []gsmsg.GraphSyncRequest,
+	requestDeadline time.Time,
 	peerResponseSender peerresponsemanager.PeerResponseTransactionSender) error {
 	for {
 		select {
@@ -280,6 +393,9 @@ func (qe *queryExecutor) checkForUpdates(
 			case <-qe.ctx.Done():
 			}
 		default:
+			if isPastDeadline(requestDeadline) {
+				return errDeadlinePassed
+			}
 			return nil
 		}
 	}
@@ -51,6 +51,13 @@ func (rb *ResponseBuilder) AddLink(requestID graphsync.RequestID, link ipld.Link
 	rb.outgoingResponses[requestID] = append(rb.outgoingResponses[requestID], metadata.Item{Link: link, BlockPresent: blockPresent})
 }
 
+// AddLinkMetadata adds the given link, whether its block is present and the
+// block size to the response for the given request ID, for responses that
+// describe blocks without sending them
+func (rb *ResponseBuilder) AddLinkMetadata(requestID graphsync.RequestID, link ipld.Link, blockPresent bool, blockSize uint64) {
+	rb.outgoingResponses[requestID] = append(rb.outgoingResponses[requestID], metadata.Item{Link: link, BlockPresent: blockPresent, BlockSize: blockSize})
+}
+
 // AddResponseCode marks the given request as completed in the response,
 // as well as whether the graphsync request responded with complete or partial
 // data.
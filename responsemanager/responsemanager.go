@@ -12,17 +12,21 @@ import (
 	"github.com/libp2p/go-libp2p-core/peer"
 
 	"github.com/ipfs/go-graphsync"
+	"github.com/ipfs/go-graphsync/deadline"
 	"github.com/ipfs/go-graphsync/ipldutil"
 	gsmsg "github.com/ipfs/go-graphsync/message"
 	"github.com/ipfs/go-graphsync/responsemanager/hooks"
 	"github.com/ipfs/go-graphsync/responsemanager/peerresponsemanager"
+	"github.com/ipfs/go-graphsync/retryafter"
+	"github.com/ipfs/go-graphsync/updatepriority"
 )
 
 var log = logging.Logger("graphsync")
 
 const (
-	maxInProcessRequests = 6
-	thawSpeed            = time.Millisecond * 100
+	defaultMaxInProcessRequests = 6
+	defaultThawSpeed            = time.Millisecond * 100
+	defaultBusyRetryAfter       = time.Second * 5
 )
 
 type inProgressResponseStatus struct {
@@ -34,6 +38,15 @@ type inProgressResponseStatus struct {
 	signals   signals
 	updates   []gsmsg.GraphSyncRequest
 	isPaused  bool
+	sentLinks []ipld.Link
+	isStored  bool
+	isQueued  bool
+	// isRestored is set for responses restored from the paused response store,
+	// which were paused before and are not paused again by request hooks
+	isRestored bool
+	// deadline is when the requestor stops waiting for the response, as sent
+	// with the request, or the zero time if it sent none
+	deadline time.Time
 }
 
 type responseKey struct {
@@ -48,12 +61,14 @@ type signals struct {
 }
 
 type responseTaskData struct {
-	empty     bool
-	ctx       context.Context
-	request   gsmsg.GraphSyncRequest
-	loader    ipld.Loader
-	traverser ipldutil.Traverser
-	signals   signals
+	empty      bool
+	ctx        context.Context
+	request    gsmsg.GraphSyncRequest
+	loader     ipld.Loader
+	traverser  ipldutil.Traverser
+	signals    signals
+	deadline   time.Time
+	isRestored bool
 }
 
 // QueryQueue is an interface that can receive new selector query tasks
@@ -91,6 +106,12 @@ type CancelledListeners interface {
 	NotifyCancelledListeners(p peer.ID, request graphsync.RequestData)
 }
 
+// BandwidthLimiter is an interface for setting outgoing bandwidth limits for
+// peers while a request from them is in progress, until its context is done
+type BandwidthLimiter interface {
+	SetRequestRate(ctx context.Context, p peer.ID, rate uint64)
+}
+
 // PeerManager is an interface that returns sender interfaces for peer responses.
 type PeerManager interface {
 	SenderForPeer(p peer.ID) peerresponsemanager.PeerResponseSender
@@ -103,17 +124,24 @@ type responseManagerMessage interface {
 // ResponseManager handles incoming requests from the network, initiates selector
 // traversals, and transmits responses
 type ResponseManager struct {
-	ctx                 context.Context
-	cancelFn            context.CancelFunc
-	peerManager         PeerManager
-	queryQueue          QueryQueue
-	updateHooks         UpdateHooks
-	cancelledListeners  CancelledListeners
-	completedListeners  CompletedListeners
-	messages            chan responseManagerMessage
-	workSignal          chan struct{}
-	qe                  *queryExecutor
-	inProgressResponses map[responseKey]*inProgressResponseStatus
+	ctx                      context.Context
+	cancelFn                 context.CancelFunc
+	peerManager              PeerManager
+	queryQueue               QueryQueue
+	updateHooks              UpdateHooks
+	cancelledListeners       CancelledListeners
+	completedListeners       CompletedListeners
+	messages                 chan responseManagerMessage
+	workSignal               chan struct{}
+	qe                       *queryExecutor
+	inProgressResponses      map[responseKey]*inProgressResponseStatus
+	pausedResponseStore      graphsync.PausedResponseStore
+	maxInProcessRequests     int
+	maxQueuedRequestsPerPeer int
+	maxTotalQueuedRequests   int
+	busyRetryAfter           time.Duration
+	queuedRequestsPerPeer    map[peer.ID]int
+	totalQueuedRequests      int
 }
 
 // New creates a new response manager from the given context, loader,
@@ -143,21 +171,73 @@ func New(ctx context.Context,
 		messages:           messages,
 		ctx:                ctx,
 		workSignal:         workSignal,
-		ticker:             time.NewTicker(thawSpeed),
+		thawSpeed:          defaultThawSpeed,
 	}
 	return &ResponseManager{
-		ctx:                 ctx,
-		cancelFn:            cancelFn,
-		peerManager:         peerManager,
-		queryQueue:          queryQueue,
-		updateHooks:         updateHooks,
-		completedListeners:  completedListeners,
-		cancelledListeners:  cancelledListeners,
-		messages:            messages,
-		workSignal:          workSignal,
-		qe:                  qe,
-		inProgressResponses: make(map[responseKey]*inProgressResponseStatus),
-	}
+		ctx:                   ctx,
+		cancelFn:              cancelFn,
+		peerManager:           peerManager,
+		queryQueue:            queryQueue,
+		updateHooks:           updateHooks,
+		completedListeners:    completedListeners,
+		cancelledListeners:    cancelledListeners,
+		messages:              messages,
+		workSignal:            workSignal,
+		qe:                    qe,
+		inProgressResponses:   make(map[responseKey]*inProgressResponseStatus),
+		maxInProcessRequests:  defaultMaxInProcessRequests,
+		busyRetryAfter:        defaultBusyRetryAfter,
+		queuedRequestsPerPeer: make(map[peer.ID]int),
+	}
+}
+
+// SetMaxInProcessRequests sets how many responses are processed at once, which
+// must be at least one. It must be called before Startup
+func (rm *ResponseManager) SetMaxInProcessRequests(maxInProcessRequests int) error {
+	if maxInProcessRequests < 1 {
+		return errors.New("must process at least one response at once")
+	}
+	rm.maxInProcessRequests = maxInProcessRequests
+	return nil
+}
+
+// SetMaxQueuedRequestsPerPeer sets how many requests from a single peer can wait
+// to be processed before further requests from that peer are rejected as busy.
+// Zero means no limit
+func (rm *ResponseManager) SetMaxQueuedRequestsPerPeer(maxQueuedRequestsPerPeer int) {
+	rm.maxQueuedRequestsPerPeer = maxQueuedRequestsPerPeer
+}
+
+// SetMaxTotalQueuedRequests sets how many requests across all peers can wait to
+// be processed before further requests are rejected as busy. Zero means no limit
+func (rm *ResponseManager) SetMaxTotalQueuedRequests(maxTotalQueuedRequests int) {
+	rm.maxTotalQueuedRequests = maxTotalQueuedRequests
+}
+
+// SetBusyRetryAfter sets how long requestors are told to wait before trying
+// again when their requests are rejected as busy
+func (rm *ResponseManager) SetBusyRetryAfter(busyRetryAfter time.Duration) {
+	rm.busyRetryAfter = busyRetryAfter
+}
+
+// SetBandwidthLimiter sets the limiter that peer bandwidth limits chosen by
+// request hooks are applied to. It must be called before Startup
+func (rm *ResponseManager) SetBandwidthLimiter(bandwidthLimiter BandwidthLimiter) {
+	rm.qe.bandwidthLimiter = bandwidthLimiter
+}
+
+// SetThawSpeed sets how often peers frozen in the query queue are thawed.
+// It must be called before Startup
+func (rm *ResponseManager) SetThawSpeed(thawSpeed time.Duration) {
+	rm.qe.thawSpeed = thawSpeed
+}
+
+// SetPausedResponseStore sets a store that paused responses are recorded to, so they
+// can be continued after a restart. Paused responses in the store are restored on
+// Startup, so this must be called before then
+func (rm *ResponseManager) SetPausedResponseStore(pausedResponseStore graphsync.PausedResponseStore) {
+	rm.pausedResponseStore = pausedResponseStore
+	rm.qe.recordSentLinks = pausedResponseStore != nil
 }
 
 type processRequestMessage struct {
@@ -241,9 +321,10 @@ type responseDataRequest struct {
 }
 
 type finishTaskRequest struct {
-	key    responseKey
-	status graphsync.ResponseStatusCode
-	err    error
+	key       responseKey
+	status    graphsync.ResponseStatusCode
+	err       error
+	sentLinks []ipld.Link
 }
 
 type setResponseDataRequest struct {
@@ -252,6 +333,11 @@ type setResponseDataRequest struct {
 	traverser ipldutil.Traverser
 }
 
+type expireResponseMessage struct {
+	key      responseKey
+	response *inProgressResponseStatus
+}
+
 type responseUpdateRequest struct {
 	key        responseKey
 	updateChan chan []gsmsg.GraphSyncRequest
@@ -275,7 +361,10 @@ func (rm *ResponseManager) cleanupInProcessResponses() {
 
 func (rm *ResponseManager) run() {
 	defer rm.cleanupInProcessResponses()
-	for i := 0; i < maxInProcessRequests; i++ {
+	rm.restorePausedResponses()
+	rm.qe.ticker = time.NewTicker(rm.qe.thawSpeed)
+	defer rm.qe.ticker.Stop()
+	for i := 0; i < rm.maxInProcessRequests; i++ {
 		go rm.qe.processQueriesWorker()
 	}
 
@@ -289,12 +378,119 @@ func (rm *ResponseManager) run() {
 	}
 }
 
+// restorePausedResponses loads responses that were paused when the responder
+// last shut down. They are restored as paused responses with no traversal, so
+// the query is prepared again when they are unpaused, skipping the blocks
+// already sent
+func (rm *ResponseManager) restorePausedResponses() {
+	if rm.pausedResponseStore == nil {
+		return
+	}
+	pausedResponses, err := rm.pausedResponseStore.List()
+	if err != nil {
+		log.Errorf("unable to load paused responses: %s", err)
+		return
+	}
+	for _, pausedResponse := range pausedResponses {
+		key := responseKey{pausedResponse.Peer, pausedResponse.RequestID}
+		request := gsmsg.NewRequest(pausedResponse.RequestID, pausedResponse.Root, pausedResponse.Selector, pausedResponse.Priority, pausedResponse.Extensions...)
+		ctx, cancelFn := context.WithCancel(rm.ctx)
+		rm.inProgressResponses[key] =
+			&inProgressResponseStatus{
+				ctx:      ctx,
+				cancelFn: cancelFn,
+				request:  request,
+				signals: signals{
+					pauseSignal:  make(chan struct{}, 1),
+					updateSignal: make(chan struct{}, 1),
+					stopSignal:   make(chan bool, 1),
+				},
+				isPaused:   true,
+				sentLinks:  pausedResponse.SentLinks,
+				isStored:   true,
+				isRestored: true,
+			}
+		if len(pausedResponse.SentLinks) > 0 {
+			rm.peerManager.SenderForPeer(key.p).IgnoreBlocks(key.requestID, pausedResponse.SentLinks)
+		}
+	}
+}
+
+func (rm *ResponseManager) storePausedResponse(key responseKey, response *inProgressResponseStatus) {
+	if rm.pausedResponseStore == nil {
+		return
+	}
+	err := rm.pausedResponseStore.Put(graphsync.PausedResponse{
+		Peer:       key.p,
+		RequestID:  key.requestID,
+		Root:       response.request.Root(),
+		Selector:   response.request.Selector(),
+		Priority:   response.request.Priority(),
+		Extensions: response.request.Extensions(),
+		SentLinks:  response.sentLinks,
+	})
+	if err != nil {
+		log.Errorf("unable to store paused response, peer %s, request ID %d: %s", key.p.Pretty(), key.requestID, err)
+		return
+	}
+	response.isStored = true
+}
+
+func (rm *ResponseManager) deletePausedResponse(key responseKey, response *inProgressResponseStatus) {
+	if rm.pausedResponseStore == nil || !response.isStored {
+		return
+	}
+	err := rm.pausedResponseStore.Delete(key.p, key.requestID)
+	if err != nil {
+		log.Errorf("unable to delete paused response, peer %s, request ID %d: %s", key.p.Pretty(), key.requestID, err)
+		return
+	}
+	response.isStored = false
+}
+
+// processDoNotSendCidsUpdate applies a do-not-send-cids set received in an
+// update, which requestors use to avoid receiving blocks they already have
+// from other peers
+func (rm *ResponseManager) processDoNotSendCidsUpdate(key responseKey, update gsmsg.GraphSyncRequest) {
+	doNotSendCidsData, has := update.Extension(graphsync.ExtensionDoNotSendCIDs)
+	if !has {
+		return
+	}
+	links, err := decodeDoNotSendLinks(doNotSendCidsData)
+	if err != nil {
+		log.Warnf("unable to decode do not send cids in update, peer %s, request ID %d: %s", key.p.Pretty(), key.requestID, err)
+		return
+	}
+	rm.peerManager.SenderForPeer(key.p).IgnoreBlocks(key.requestID, links)
+}
+
+// processPriorityUpdate moves a queued response to its new priority. Responses
+// already being processed keep running
+func (rm *ResponseManager) processPriorityUpdate(key responseKey, response *inProgressResponseStatus, update gsmsg.GraphSyncRequest) {
+	priorityData, has := update.Extension(graphsync.ExtensionUpdatePriority)
+	if !has {
+		return
+	}
+	priority, err := updatepriority.DecodePriority(priorityData)
+	if err != nil {
+		log.Warnf("unable to decode priority in update, peer %s, request ID %d: %s", key.p.Pretty(), key.requestID, err)
+		return
+	}
+	if !response.isQueued {
+		return
+	}
+	rm.queryQueue.Remove(key, key.p)
+	rm.queryQueue.PushTasks(key.p, peertask.Task{Topic: key, Priority: int(priority), Work: 1})
+}
+
 func (rm *ResponseManager) processUpdate(key responseKey, update gsmsg.GraphSyncRequest) {
 	response, ok := rm.inProgressResponses[key]
 	if !ok {
 		log.Warnf("received update for non existent request, peer %s, request ID %d", key.p.Pretty(), key.requestID)
 		return
 	}
+	rm.processDoNotSendCidsUpdate(key, update)
+	rm.processPriorityUpdate(key, response, update)
 	if !response.isPaused {
 		response.updates = append(response.updates, update)
 		select {
@@ -303,6 +499,12 @@ func (rm *ResponseManager) processUpdate(key responseKey, update gsmsg.GraphSync
 		}
 		return
 	}
+	rm.processPausedUpdate(key, response, update)
+}
+
+// processPausedUpdate runs update hooks for an update to a paused response,
+// which may unpause it
+func (rm *ResponseManager) processPausedUpdate(key responseKey, response *inProgressResponseStatus, update gsmsg.GraphSyncRequest) {
 	result := rm.updateHooks.ProcessUpdateHooks(key.p, response.request, update)
 	peerResponseSender := rm.peerManager.SenderForPeer(key.p)
 	err := peerResponseSender.Transaction(key.requestID, func(transaction peerresponsemanager.PeerResponseTransactionSender) error {
@@ -319,6 +521,7 @@ func (rm *ResponseManager) processUpdate(key responseKey, update gsmsg.GraphSync
 	}
 	if result.Err != nil {
 		delete(rm.inProgressResponses, key)
+		rm.deletePausedResponse(key, response)
 		response.cancelFn()
 		return
 	}
@@ -341,6 +544,7 @@ func (rm *ResponseManager) unpauseRequest(p peer.ID, requestID graphsync.Request
 		return errors.New("request is not paused")
 	}
 	inProgressResponse.isPaused = false
+	rm.deletePausedResponse(key, inProgressResponse)
 	if len(extensions) > 0 {
 		peerResponseSender := rm.peerManager.SenderForPeer(key.p)
 		_ = peerResponseSender.Transaction(requestID, func(transaction peerresponsemanager.PeerResponseTransactionSender) error {
@@ -350,6 +554,7 @@ func (rm *ResponseManager) unpauseRequest(p peer.ID, requestID graphsync.Request
 			return nil
 		})
 	}
+	rm.enqueue(key, inProgressResponse)
 	rm.queryQueue.PushTasks(p, peertask.Task{Topic: key, Priority: math.MaxInt32, Work: 1})
 	select {
 	case rm.workSignal <- struct{}{}:
@@ -365,8 +570,11 @@ func (rm *ResponseManager) cancelRequest(p peer.ID, requestID graphsync.RequestI
 	if !ok {
 		return errors.New("could not find request")
 	}
+	// queued responses haven't started, so like paused ones they finish here
+	isQueued := response.isQueued
+	rm.dequeue(key, response)
 
-	if response.isPaused {
+	if response.isPaused || isQueued {
 		peerResponseSender := rm.peerManager.SenderForPeer(key.p)
 		if selfCancel {
 			rm.completedListeners.NotifyCompletedListeners(p, response.request, graphsync.RequestCancelled)
@@ -376,6 +584,7 @@ func (rm *ResponseManager) cancelRequest(p peer.ID, requestID graphsync.RequestI
 			peerResponseSender.FinishWithCancel(requestID)
 		}
 		delete(rm.inProgressResponses, key)
+		rm.deletePausedResponse(key, response)
 		response.cancelFn()
 		return nil
 	}
@@ -386,6 +595,51 @@ func (rm *ResponseManager) cancelRequest(p peer.ID, requestID graphsync.RequestI
 	return nil
 }
 
+func (rm *ResponseManager) isBusy(p peer.ID) bool {
+	return (rm.maxQueuedRequestsPerPeer > 0 && rm.queuedRequestsPerPeer[p] >= rm.maxQueuedRequestsPerPeer) ||
+		(rm.maxTotalQueuedRequests > 0 && rm.totalQueuedRequests >= rm.maxTotalQueuedRequests)
+}
+
+func (rm *ResponseManager) rejectBusy(p peer.ID, request gsmsg.GraphSyncRequest) {
+	retryAfterData, err := retryafter.EncodeRetryAfter(rm.busyRetryAfter)
+	if err != nil {
+		log.Errorf("unable to encode retry after: %s", err)
+	}
+	peerResponseSender := rm.peerManager.SenderForPeer(p)
+	_ = peerResponseSender.Transaction(request.ID(), func(transaction peerresponsemanager.PeerResponseTransactionSender) error {
+		if retryAfterData != nil {
+			transaction.SendExtensionData(graphsync.ExtensionData{Name: graphsync.ExtensionRetryAfter, Data: retryAfterData})
+		}
+		transaction.FinishWithError(graphsync.RequestFailedBusy)
+		return nil
+	})
+	rm.completedListeners.NotifyCompletedListeners(p, request, graphsync.RequestFailedBusy)
+}
+
+// enqueue counts a response waiting to be processed against the queue limits
+func (rm *ResponseManager) enqueue(key responseKey, response *inProgressResponseStatus) {
+	if response.isQueued {
+		return
+	}
+	response.isQueued = true
+	rm.totalQueuedRequests++
+	rm.queuedRequestsPerPeer[key.p]++
+}
+
+// dequeue stops counting a response against the queue limits, once it starts
+// processing or is cancelled
+func (rm *ResponseManager) dequeue(key responseKey, response *inProgressResponseStatus) {
+	if !response.isQueued {
+		return
+	}
+	response.isQueued = false
+	rm.totalQueuedRequests--
+	rm.queuedRequestsPerPeer[key.p]--
+	if rm.queuedRequestsPerPeer[key.p] == 0 {
+		delete(rm.queuedRequestsPerPeer, key.p)
+	}
+}
+
 func (prm *processRequestMessage) handle(rm *ResponseManager) {
 	for _, request := range prm.requests {
 		key := responseKey{p: prm.p, requestID: request.ID()}
@@ -397,18 +651,31 @@ func (prm *processRequestMessage) handle(rm *ResponseManager) {
 			rm.processUpdate(key, request)
 			continue
 		}
+		if rm.isBusy(prm.p) {
+			rm.rejectBusy(prm.p, request)
+			continue
+		}
+		requestDeadline, hasDeadline, err := rm.processDeadline(prm.p, request)
+		if err != nil {
+			continue
+		}
 		ctx, cancelFn := context.WithCancel(rm.ctx)
-		rm.inProgressResponses[key] =
-			&inProgressResponseStatus{
-				ctx:      ctx,
-				cancelFn: cancelFn,
-				request:  request,
-				signals: signals{
-					pauseSignal:  make(chan struct{}, 1),
-					updateSignal: make(chan struct{}, 1),
-					stopSignal:   make(chan bool, 1),
-				},
-			}
+		response := &inProgressResponseStatus{
+			ctx:      ctx,
+			cancelFn: cancelFn,
+			request:  request,
+			signals: signals{
+				pauseSignal:  make(chan struct{}, 1),
+				updateSignal: make(chan struct{}, 1),
+				stopSignal:   make(chan bool, 1),
+			},
+		}
+		rm.inProgressResponses[key] = response
+		if hasDeadline {
+			response.deadline = requestDeadline
+			rm.expireAfter(key, response, time.Until(requestDeadline))
+		}
+		rm.enqueue(key, response)
 		// TODO: Use a better work estimation metric.
 		rm.queryQueue.PushTasks(prm.p, peertask.Task{Topic: key, Priority: int(request.Priority()), Work: 1})
 		select {
@@ -418,11 +685,44 @@ func (prm *processRequestMessage) handle(rm *ResponseManager) {
 	}
 }
 
+// processDeadline reads the deadline a requestor sent with a request, failing
+// the request if it can't be decoded
+func (rm *ResponseManager) processDeadline(p peer.ID, request gsmsg.GraphSyncRequest) (time.Time, bool, error) {
+	deadlineData, has := request.Extension(graphsync.ExtensionDeadline)
+	if !has {
+		return time.Time{}, false, nil
+	}
+	requestDeadline, err := deadline.DecodeDeadline(deadlineData)
+	if err != nil {
+		log.Warnf("unable to decode deadline, peer %s, request ID %d: %s", p.Pretty(), request.ID(), err)
+		rm.peerManager.SenderForPeer(p).FinishWithError(request.ID(), graphsync.RequestFailedUnknown)
+		rm.completedListeners.NotifyCompletedListeners(p, request, graphsync.RequestFailedUnknown)
+		return time.Time{}, false, err
+	}
+	return requestDeadline, true, nil
+}
+
+// expireAfter cancels a response once the requestor stops waiting for it,
+// whether it is queued, paused or running
+func (rm *ResponseManager) expireAfter(key responseKey, response *inProgressResponseStatus, timeLeft time.Duration) {
+	timer := time.AfterFunc(timeLeft, func() {
+		select {
+		case rm.messages <- &expireResponseMessage{key, response}:
+		case <-response.ctx.Done():
+		}
+	})
+	go func() {
+		<-response.ctx.Done()
+		timer.Stop()
+	}()
+}
+
 func (rdr *responseDataRequest) handle(rm *ResponseManager) {
 	response, ok := rm.inProgressResponses[rdr.key]
 	var taskData responseTaskData
 	if ok {
-		taskData = responseTaskData{false, response.ctx, response.request, response.loader, response.traverser, response.signals}
+		rm.dequeue(rdr.key, response)
+		taskData = responseTaskData{false, response.ctx, response.request, response.loader, response.traverser, response.signals, response.deadline, response.isRestored}
 	} else {
 		taskData = responseTaskData{empty: true}
 	}
@@ -437,14 +737,31 @@ func (ftr *finishTaskRequest) handle(rm *ResponseManager) {
 	if !ok {
 		return
 	}
+	response.sentLinks = append(response.sentLinks, ftr.sentLinks...)
 	if _, ok := ftr.err.(hooks.ErrPaused); ok {
 		response.isPaused = true
+		rm.storePausedResponse(ftr.key, response)
+		// updates that arrived while the response was pausing, such as a payment
+		// sent as soon as the requestor saw the pause, apply to the paused response
+		updates := response.updates
+		response.updates = nil
+		for i, update := range updates {
+			rm.processPausedUpdate(ftr.key, response, update)
+			if _, ok := rm.inProgressResponses[ftr.key]; !ok {
+				return
+			}
+			if !response.isPaused {
+				response.updates = append(response.updates, updates[i+1:]...)
+				break
+			}
+		}
 		return
 	}
 	if ftr.err != nil {
 		log.Infof("response failed: %w", ftr.err)
 	}
 	delete(rm.inProgressResponses, ftr.key)
+	rm.deletePausedResponse(ftr.key, response)
 	response.cancelFn()
 }
 
@@ -511,6 +828,16 @@ func (prm *pauseRequestMessage) handle(rm *ResponseManager) {
 	}
 }
 
+func (erm *expireResponseMessage) handle(rm *ResponseManager) {
+	// the response may have finished, and its request ID been reused, since
+	// the timer fired
+	if rm.inProgressResponses[erm.key] != erm.response {
+		return
+	}
+	log.Infof("response passed its deadline, peer %s, request ID %d", erm.key.p.Pretty(), erm.key.requestID)
+	_ = rm.cancelRequest(erm.key.p, erm.key.requestID, true)
+}
+
 func (crm *cancelRequestMessage) handle(rm *ResponseManager) {
 	err := rm.cancelRequest(crm.p, crm.requestID, true)
 	select {
@@ -18,13 +18,20 @@ import (
 
 	"github.com/ipfs/go-graphsync"
 	"github.com/ipfs/go-graphsync/cidset"
+	"github.com/ipfs/go-graphsync/deadline"
 	"github.com/ipfs/go-graphsync/dedupkey"
 	gsmsg "github.com/ipfs/go-graphsync/message"
+	"github.com/ipfs/go-graphsync/partition"
+	"github.com/ipfs/go-graphsync/payments"
+	"github.com/ipfs/go-graphsync/redirect"
+	"github.com/ipfs/go-graphsync/rejectreason"
 	"github.com/ipfs/go-graphsync/responsemanager/hooks"
 	"github.com/ipfs/go-graphsync/responsemanager/peerresponsemanager"
 	"github.com/ipfs/go-graphsync/responsemanager/persistenceoptions"
+	"github.com/ipfs/go-graphsync/retryafter"
 	"github.com/ipfs/go-graphsync/selectorvalidator"
 	"github.com/ipfs/go-graphsync/testutil"
+	"github.com/ipfs/go-graphsync/updatepriority"
 )
 
 type fakeQueryQueue struct {
@@ -102,6 +109,7 @@ type completedRequest struct {
 }
 type pausedRequest struct {
 	requestID graphsync.RequestID
+	status    graphsync.ResponseStatusCode
 }
 
 type cancelledRequest struct {
@@ -116,6 +124,8 @@ type fakePeerResponseSender struct {
 	cancelledRequests    chan cancelledRequest
 	ignoredLinks         chan []ipld.Link
 	dedupKeys            chan string
+	metadataOnlyRequests chan graphsync.RequestID
+	partitions           chan partition.Partition
 }
 
 func (fprs *fakePeerResponseSender) Startup()  {}
@@ -134,6 +144,14 @@ func (fprs *fakePeerResponseSender) DedupKey(requestID graphsync.RequestID, key
 	fprs.dedupKeys <- key
 }
 
+func (fprs *fakePeerResponseSender) MetadataOnly(requestID graphsync.RequestID) {
+	fprs.metadataOnlyRequests <- requestID
+}
+
+func (fprs *fakePeerResponseSender) Partition(requestID graphsync.RequestID, p partition.Partition) {
+	fprs.partitions <- p
+}
+
 func (fbd fakeBlkData) Link() ipld.Link {
 	return fbd.link
 }
@@ -172,7 +190,11 @@ func (fprs *fakePeerResponseSender) FinishWithError(requestID graphsync.RequestI
 }
 
 func (fprs *fakePeerResponseSender) PauseRequest(requestID graphsync.RequestID) {
-	fprs.pausedRequests <- pausedRequest{requestID}
+	fprs.pausedRequests <- pausedRequest{requestID, graphsync.RequestPaused}
+}
+
+func (fprs *fakePeerResponseSender) PauseRequestForPayment(requestID graphsync.RequestID) {
+	fprs.pausedRequests <- pausedRequest{requestID, graphsync.NotEnoughGas}
 }
 
 func (fprs *fakePeerResponseSender) FinishWithCancel(requestID graphsync.RequestID) {
@@ -209,6 +231,10 @@ func (fprts *fakePeerResponseTransactionSender) PauseRequest() {
 	fprts.prs.PauseRequest(fprts.requestID)
 }
 
+func (fprts *fakePeerResponseTransactionSender) PauseRequestForPayment() {
+	fprts.prs.PauseRequestForPayment(fprts.requestID)
+}
+
 func (fprts *fakePeerResponseTransactionSender) FinishWithCancel() {
 	fprts.prs.FinishWithCancel(fprts.requestID)
 }
@@ -355,6 +381,32 @@ func TestEarlyCancellation(t *testing.T) {
 	testutil.AssertDoesReceiveFirst(t, timer.C, "should not process more responses", td.sentResponses, td.completedRequestChan)
 }
 
+func TestUpdatePriority(t *testing.T) {
+	td := newTestData(t)
+	defer td.cancel()
+	td.queryQueue.popWait.Add(1)
+	defer td.queryQueue.popWait.Done()
+	responseManager := New(td.ctx, td.loader, td.peerManager, td.queryQueue, td.requestHooks, td.blockHooks, td.updateHooks, td.completedListeners, td.cancelledListeners)
+	responseManager.Startup()
+	responseManager.ProcessRequests(td.ctx, td.p, td.requests)
+
+	priorityData, err := updatepriority.EncodePriority(graphsync.Priority(100))
+	require.NoError(t, err)
+	updateRequests := []gsmsg.GraphSyncRequest{
+		gsmsg.UpdateRequest(td.requestID, graphsync.ExtensionData{
+			Name: graphsync.ExtensionUpdatePriority,
+			Data: priorityData,
+		}),
+	}
+	responseManager.ProcessRequests(td.ctx, td.p, updateRequests)
+	responseManager.synchronize()
+
+	td.queryQueue.queriesLk.RLock()
+	defer td.queryQueue.queriesLk.RUnlock()
+	require.Len(t, td.queryQueue.queries, 1)
+	require.Equal(t, 100, td.queryQueue.queries[0].Priority)
+}
+
 func TestValidationAndExtensions(t *testing.T) {
 	t.Run("on its own, should fail validation", func(t *testing.T) {
 		td := newTestData(t)
@@ -529,6 +581,110 @@ func TestValidationAndExtensions(t *testing.T) {
 		require.Equal(t, 5, customChooserCallCount)
 	})
 
+	t.Run("hooks can set peer bandwidth limit", func(t *testing.T) {
+		td := newTestData(t)
+		defer td.cancel()
+		responseManager := New(td.ctx, td.loader, td.peerManager, td.queryQueue, td.requestHooks, td.blockHooks, td.updateHooks, td.completedListeners, td.cancelledListeners)
+		bandwidthLimiter := &fakeBandwidthLimiter{make(chan peerRate, 1)}
+		responseManager.SetBandwidthLimiter(bandwidthLimiter)
+		responseManager.Startup()
+		td.requestHooks.Register(func(p peer.ID, requestData graphsync.RequestData, hookActions graphsync.IncomingRequestHookActions) {
+			hookActions.ValidateRequest()
+			hookActions.SetPeerBandwidthLimit(1 << 20)
+		})
+		responseManager.ProcessRequests(td.ctx, td.p, td.requests)
+		var lastRequest completedRequest
+		testutil.AssertReceive(td.ctx, t, td.completedRequestChan, &lastRequest, "should complete request")
+		require.True(t, gsmsg.IsTerminalSuccessCode(lastRequest.result), "request should succeed")
+		var rate peerRate
+		testutil.AssertReceive(td.ctx, t, bandwidthLimiter.rates, &rate, "should set peer rate")
+		require.Equal(t, td.p, rate.p)
+		require.Equal(t, uint64(1<<20), rate.rate)
+		testutil.AssertDoesReceive(td.ctx, t, rate.ctx.Done(), "should only limit peer until the response finishes")
+	})
+
+	t.Run("hooks can redirect requests", func(t *testing.T) {
+		td := newTestData(t)
+		defer td.cancel()
+		responseManager := New(td.ctx, td.loader, td.peerManager, td.queryQueue, td.requestHooks, td.blockHooks, td.updateHooks, td.completedListeners, td.cancelledListeners)
+		responseManager.Startup()
+		otherPeers := testutil.GeneratePeers(2)
+		td.requestHooks.Register(func(p peer.ID, requestData graphsync.RequestData, hookActions graphsync.IncomingRequestHookActions) {
+			hookActions.RedirectRequest(peer.AddrInfo{ID: otherPeers[0]}, peer.AddrInfo{ID: otherPeers[1]})
+		})
+		responseManager.ProcessRequests(td.ctx, td.p, td.requests)
+		var receivedExtension sentExtension
+		testutil.AssertReceive(td.ctx, t, td.sentExtensions, &receivedExtension, "should send redirect")
+		require.Equal(t, graphsync.ExtensionRedirect, receivedExtension.extension.Name)
+		redirectPeers, err := redirect.DecodeRedirect(receivedExtension.extension.Data)
+		require.NoError(t, err)
+		require.Len(t, redirectPeers, 2)
+		require.Equal(t, otherPeers[0], redirectPeers[0].ID)
+		require.Equal(t, otherPeers[1], redirectPeers[1].ID)
+		var lastRequest completedRequest
+		testutil.AssertReceive(td.ctx, t, td.completedRequestChan, &lastRequest, "should complete request")
+		require.Equal(t, graphsync.AdditionalPeers, lastRequest.result)
+		testutil.AssertChannelEmpty(t, td.sentResponses, "should not send blocks")
+	})
+
+	t.Run("rejecting or failing requests overrides redirects", func(t *testing.T) {
+		td := newTestData(t)
+		defer td.cancel()
+		responseManager := New(td.ctx, td.loader, td.peerManager, td.queryQueue, td.requestHooks, td.blockHooks, td.updateHooks, td.completedListeners, td.cancelledListeners)
+		responseManager.Startup()
+		otherPeers := testutil.GeneratePeers(1)
+		td.requestHooks.Register(func(p peer.ID, requestData graphsync.RequestData, hookActions graphsync.IncomingRequestHookActions) {
+			hookActions.RedirectRequest(peer.AddrInfo{ID: otherPeers[0]})
+			if requestData.ID() == td.requestID {
+				hookActions.RejectRequest(graphsync.RequestFailedLegal, "takedown notice")
+			} else {
+				hookActions.TerminateWithError(errors.New("something went wrong"))
+			}
+		})
+		responseManager.ProcessRequests(td.ctx, td.p, td.requests)
+		var receivedExtension sentExtension
+		testutil.AssertReceive(td.ctx, t, td.sentExtensions, &receivedExtension, "should send reject reason")
+		require.Equal(t, graphsync.ExtensionRejectReason, receivedExtension.extension.Name)
+		var lastRequest completedRequest
+		testutil.AssertReceive(td.ctx, t, td.completedRequestChan, &lastRequest, "should complete request")
+		require.Equal(t, graphsync.RequestFailedLegal, lastRequest.result)
+
+		failedRequestID := graphsync.RequestID(rand.Int31())
+		requests := []gsmsg.GraphSyncRequest{
+			gsmsg.NewRequest(failedRequestID, td.blockChain.TipLink.(cidlink.Link).Cid, td.blockChain.Selector(), graphsync.Priority(0)),
+		}
+		responseManager.ProcessRequests(td.ctx, td.p, requests)
+		testutil.AssertReceive(td.ctx, t, td.completedRequestChan, &lastRequest, "should complete request")
+		require.Equal(t, failedRequestID, lastRequest.requestID)
+		require.Equal(t, graphsync.RequestFailedUnknown, lastRequest.result)
+		testutil.AssertChannelEmpty(t, td.sentExtensions, "should not send redirect")
+		testutil.AssertChannelEmpty(t, td.sentResponses, "should not send blocks")
+	})
+
+	t.Run("hooks can reject requests with a reason", func(t *testing.T) {
+		td := newTestData(t)
+		defer td.cancel()
+		responseManager := New(td.ctx, td.loader, td.peerManager, td.queryQueue, td.requestHooks, td.blockHooks, td.updateHooks, td.completedListeners, td.cancelledListeners)
+		responseManager.Startup()
+		td.requestHooks.Register(func(p peer.ID, requestData graphsync.RequestData, hookActions graphsync.IncomingRequestHookActions) {
+			hookActions.RejectRequest(graphsync.RequestFailedLegal, "takedown notice")
+		})
+		td.requestHooks.Register(func(p peer.ID, requestData graphsync.RequestData, hookActions graphsync.IncomingRequestHookActions) {
+			hookActions.ValidateRequest()
+		})
+		responseManager.ProcessRequests(td.ctx, td.p, td.requests)
+		var receivedExtension sentExtension
+		testutil.AssertReceive(td.ctx, t, td.sentExtensions, &receivedExtension, "should send reject reason")
+		require.Equal(t, graphsync.ExtensionRejectReason, receivedExtension.extension.Name)
+		reason, err := rejectreason.DecodeRejectReason(receivedExtension.extension.Data)
+		require.NoError(t, err)
+		require.Equal(t, "takedown notice", reason)
+		var lastRequest completedRequest
+		testutil.AssertReceive(td.ctx, t, td.completedRequestChan, &lastRequest, "should complete request")
+		require.Equal(t, graphsync.RequestFailedLegal, lastRequest.result)
+		testutil.AssertChannelEmpty(t, td.sentResponses, "should not send blocks")
+	})
+
 	t.Run("do-not-send-cids extension", func(t *testing.T) {
 		td := newTestData(t)
 		defer td.cancel()
@@ -562,6 +718,52 @@ func TestValidationAndExtensions(t *testing.T) {
 			require.True(t, set.Has(link.(cidlink.Link).Cid))
 		}
 	})
+	t.Run("do-not-send-cids update", func(t *testing.T) {
+		td := newTestData(t)
+		defer td.cancel()
+		responseManager := New(td.ctx, td.loader, td.peerManager, td.queryQueue, td.requestHooks, td.blockHooks, td.updateHooks, td.completedListeners, td.cancelledListeners)
+		responseManager.Startup()
+		td.requestHooks.Register(func(p peer.ID, requestData graphsync.RequestData, hookActions graphsync.IncomingRequestHookActions) {
+			hookActions.ValidateRequest()
+		})
+		wait := make(chan struct{})
+		sent := make(chan struct{})
+		blkIndex := 0
+		td.blockHooks.Register(func(p peer.ID, requestData graphsync.RequestData, blockData graphsync.BlockData, hookActions graphsync.OutgoingBlockHookActions) {
+			blkIndex++
+			if blkIndex == 1 {
+				close(sent)
+				<-wait
+			}
+		})
+		set := cid.NewSet()
+		blks := td.blockChain.Blocks(1, 5)
+		for _, blk := range blks {
+			set.Add(blk.Cid())
+		}
+		data, err := cidset.EncodeCidSet(set)
+		require.NoError(t, err)
+		updateRequests := []gsmsg.GraphSyncRequest{
+			gsmsg.UpdateRequest(td.requestID, graphsync.ExtensionData{
+				Name: graphsync.ExtensionDoNotSendCIDs,
+				Data: data,
+			}),
+		}
+		responseManager.ProcessRequests(td.ctx, td.p, td.requests)
+		testutil.AssertDoesReceive(td.ctx, t, sent, "sends blocks")
+		responseManager.ProcessRequests(td.ctx, td.p, updateRequests)
+		responseManager.synchronize()
+		var lastLinks []ipld.Link
+		testutil.AssertReceive(td.ctx, t, td.ignoredLinks, &lastLinks, "should send ignored links")
+		require.Len(t, lastLinks, set.Len())
+		for _, link := range lastLinks {
+			require.True(t, set.Has(link.(cidlink.Link).Cid))
+		}
+		close(wait)
+		var lastRequest completedRequest
+		testutil.AssertReceive(td.ctx, t, td.completedRequestChan, &lastRequest, "should complete request")
+		require.True(t, gsmsg.IsTerminalSuccessCode(lastRequest.result), "request should succeed")
+	})
 	t.Run("dedup-by-key extension", func(t *testing.T) {
 		td := newTestData(t)
 		defer td.cancel()
@@ -587,6 +789,107 @@ func TestValidationAndExtensions(t *testing.T) {
 		testutil.AssertReceive(td.ctx, t, td.dedupKeys, &dedupKey, "should dedup by key")
 		require.Equal(t, dedupKey, "applesauce")
 	})
+	t.Run("metadata-only extension", func(t *testing.T) {
+		td := newTestData(t)
+		defer td.cancel()
+		responseManager := New(td.ctx, td.loader, td.peerManager, td.queryQueue, td.requestHooks, td.blockHooks, td.updateHooks, td.completedListeners, td.cancelledListeners)
+		td.requestHooks.Register(selectorvalidator.SelectorValidator(100))
+		responseManager.Startup()
+		requests := []gsmsg.GraphSyncRequest{
+			gsmsg.NewRequest(td.requestID, td.blockChain.TipLink.(cidlink.Link).Cid, td.blockChain.Selector(), graphsync.Priority(0),
+				graphsync.ExtensionData{
+					Name: graphsync.ExtensionMetadataOnly,
+				}),
+		}
+		responseManager.ProcessRequests(td.ctx, td.p, requests)
+		var lastRequest completedRequest
+		testutil.AssertReceive(td.ctx, t, td.completedRequestChan, &lastRequest, "should complete request")
+		require.True(t, gsmsg.IsTerminalSuccessCode(lastRequest.result), "request should succeed")
+		var metadataOnlyRequest graphsync.RequestID
+		testutil.AssertReceive(td.ctx, t, td.metadataOnlyRequests, &metadataOnlyRequest, "should send metadata only")
+		require.Equal(t, td.requestID, metadataOnlyRequest)
+	})
+	t.Run("partition extension", func(t *testing.T) {
+		td := newTestData(t)
+		defer td.cancel()
+		responseManager := New(td.ctx, td.loader, td.peerManager, td.queryQueue, td.requestHooks, td.blockHooks, td.updateHooks, td.completedListeners, td.cancelledListeners)
+		td.requestHooks.Register(selectorvalidator.SelectorValidator(100))
+		responseManager.Startup()
+		data, err := partition.EncodePartition(partition.Partition{Index: 1, Count: 2})
+		require.NoError(t, err)
+		requests := []gsmsg.GraphSyncRequest{
+			gsmsg.NewRequest(td.requestID, td.blockChain.TipLink.(cidlink.Link).Cid, td.blockChain.Selector(), graphsync.Priority(0),
+				graphsync.ExtensionData{
+					Name: graphsync.ExtensionPartition,
+					Data: data,
+				}),
+		}
+		responseManager.ProcessRequests(td.ctx, td.p, requests)
+		var lastRequest completedRequest
+		testutil.AssertReceive(td.ctx, t, td.completedRequestChan, &lastRequest, "should complete request")
+		require.True(t, gsmsg.IsTerminalSuccessCode(lastRequest.result), "request should succeed")
+		var p partition.Partition
+		testutil.AssertReceive(td.ctx, t, td.partitions, &p, "should partition blocks")
+		require.Equal(t, partition.Partition{Index: 1, Count: 2}, p)
+	})
+	t.Run("deadline extension", func(t *testing.T) {
+		td := newTestData(t)
+		defer td.cancel()
+		responseManager := New(td.ctx, td.loader, td.peerManager, td.queryQueue, td.requestHooks, td.blockHooks, td.updateHooks, td.completedListeners, td.cancelledListeners)
+		td.requestHooks.Register(selectorvalidator.SelectorValidator(100))
+		statusChan := make(chan graphsync.ResponseStatusCode, 1)
+		td.completedListeners.Register(func(p peer.ID, requestData graphsync.RequestData, status graphsync.ResponseStatusCode) {
+			select {
+			case statusChan <- status:
+			default:
+			}
+		})
+		responseManager.Startup()
+		data, err := deadline.EncodeDeadline(time.Now().Add(-time.Second))
+		require.NoError(t, err)
+		requests := []gsmsg.GraphSyncRequest{
+			gsmsg.NewRequest(td.requestID, td.blockChain.TipLink.(cidlink.Link).Cid, td.blockChain.Selector(), graphsync.Priority(0),
+				graphsync.ExtensionData{
+					Name: graphsync.ExtensionDeadline,
+					Data: data,
+				}),
+		}
+		responseManager.ProcessRequests(td.ctx, td.p, requests)
+		var lastRequest completedRequest
+		testutil.AssertReceive(td.ctx, t, td.completedRequestChan, &lastRequest, "should complete request")
+		require.Equal(t, graphsync.RequestCancelled, lastRequest.result)
+		var status graphsync.ResponseStatusCode
+		testutil.AssertReceive(td.ctx, t, statusChan, &status, "should receive status")
+		require.Equal(t, graphsync.RequestCancelled, status)
+		testutil.AssertChannelEmpty(t, td.sentResponses, "should not send blocks once the requestor stopped waiting")
+	})
+	t.Run("paused responses expire", func(t *testing.T) {
+		td := newTestData(t)
+		defer td.cancel()
+		responseManager := New(td.ctx, td.loader, td.peerManager, td.queryQueue, td.requestHooks, td.blockHooks, td.updateHooks, td.completedListeners, td.cancelledListeners)
+		responseManager.Startup()
+		td.requestHooks.Register(func(p peer.ID, requestData graphsync.RequestData, hookActions graphsync.IncomingRequestHookActions) {
+			hookActions.ValidateRequest()
+			hookActions.PauseResponse()
+		})
+		data, err := deadline.EncodeDeadline(time.Now().Add(100 * time.Millisecond))
+		require.NoError(t, err)
+		requests := []gsmsg.GraphSyncRequest{
+			gsmsg.NewRequest(td.requestID, td.blockChain.TipLink.(cidlink.Link).Cid, td.blockChain.Selector(), graphsync.Priority(0),
+				graphsync.ExtensionData{
+					Name: graphsync.ExtensionDeadline,
+					Data: data,
+				}),
+		}
+		responseManager.ProcessRequests(td.ctx, td.p, requests)
+		var pauseRequest pausedRequest
+		testutil.AssertReceive(td.ctx, t, td.pausedRequests, &pauseRequest, "should pause immediately")
+		var lastRequest completedRequest
+		testutil.AssertReceive(td.ctx, t, td.completedRequestChan, &lastRequest, "should finish paused request once it expires")
+		require.Equal(t, graphsync.RequestCancelled, lastRequest.result)
+		err = responseManager.UnpauseResponse(td.p, td.requestID)
+		require.Error(t, err, "should not unpause expired request")
+	})
 	t.Run("test pause/resume", func(t *testing.T) {
 		td := newTestData(t)
 		defer td.cancel()
@@ -760,6 +1063,79 @@ func TestValidationAndExtensions(t *testing.T) {
 			require.True(t, gsmsg.IsTerminalSuccessCode(lastRequest.result), "request should succeed")
 		})
 
+		t.Run("can pause for payment and unpause when paid", func(t *testing.T) {
+			td := newTestData(t)
+			defer td.cancel()
+			responseManager := New(td.ctx, td.loader, td.peerManager, td.queryQueue, td.requestHooks, td.blockHooks, td.updateHooks, td.completedListeners, td.cancelledListeners)
+			responseManager.Startup()
+			td.requestHooks.Register(func(p peer.ID, requestData graphsync.RequestData, hookActions graphsync.IncomingRequestHookActions) {
+				hookActions.ValidateRequest()
+			})
+			channel := payments.NewMockChannel(1000)
+			price := uint64(100)
+			blkIndex := 0
+			blockCount := 3
+			td.blockHooks.Register(func(p peer.ID, requestData graphsync.RequestData, blockData graphsync.BlockData, hookActions graphsync.OutgoingBlockHookActions) {
+				blkIndex++
+				if blkIndex == blockCount {
+					hookActions.RequestPayment(price)
+				}
+			})
+			td.updateHooks.Register(func(p peer.ID, requestData graphsync.RequestData, updateData graphsync.RequestData, hookActions graphsync.RequestUpdatedHookActions) {
+				amount, ok, err := payments.RedeemPayment(channel, updateData)
+				if !ok {
+					return
+				}
+				if err != nil {
+					hookActions.TerminateWithError(err)
+					return
+				}
+				if amount >= price {
+					hookActions.UnpauseResponse()
+				}
+			})
+			responseManager.ProcessRequests(td.ctx, td.p, td.requests)
+			timer := time.NewTimer(100 * time.Millisecond)
+			testutil.AssertDoesReceiveFirst(t, timer.C, "should not complete request while paused", td.completedRequestChan)
+			for i := 0; i < blockCount; i++ {
+				testutil.AssertDoesReceive(td.ctx, t, td.sentResponses, "should sent block")
+			}
+			testutil.AssertChannelEmpty(t, td.sentResponses, "should not send more blocks")
+			var pausedRequest pausedRequest
+			testutil.AssertReceive(td.ctx, t, td.pausedRequests, &pausedRequest, "should pause request")
+			require.Equal(t, graphsync.NotEnoughGas, pausedRequest.status)
+			var receivedExtension sentExtension
+			testutil.AssertReceive(td.ctx, t, td.sentExtensions, &receivedExtension, "should send payment request")
+			require.Equal(t, graphsync.ExtensionPaymentRequest, receivedExtension.extension.Name)
+			amount, err := payments.DecodePaymentRequest(receivedExtension.extension.Data)
+			require.NoError(t, err)
+			require.Equal(t, price, amount)
+
+			// an underpayment leaves the response paused
+			voucher, err := channel.CreateVoucher(price / 2)
+			require.NoError(t, err)
+			voucherData, err := payments.EncodeVoucher(voucher)
+			require.NoError(t, err)
+			responseManager.ProcessRequests(td.ctx, td.p, []gsmsg.GraphSyncRequest{
+				gsmsg.UpdateRequest(td.requestID, graphsync.ExtensionData{Name: graphsync.ExtensionPayment, Data: voucherData}),
+			})
+			timer = time.NewTimer(100 * time.Millisecond)
+			testutil.AssertDoesReceiveFirst(t, timer.C, "should not complete request while underpaid", td.completedRequestChan)
+			testutil.AssertChannelEmpty(t, td.sentResponses, "should not send more blocks")
+
+			voucher, err = channel.CreateVoucher(price)
+			require.NoError(t, err)
+			voucherData, err = payments.EncodeVoucher(voucher)
+			require.NoError(t, err)
+			responseManager.ProcessRequests(td.ctx, td.p, []gsmsg.GraphSyncRequest{
+				gsmsg.UpdateRequest(td.requestID, graphsync.ExtensionData{Name: graphsync.ExtensionPayment, Data: voucherData}),
+			})
+			var lastRequest completedRequest
+			testutil.AssertReceive(td.ctx, t, td.completedRequestChan, &lastRequest, "should complete request")
+			require.True(t, gsmsg.IsTerminalSuccessCode(lastRequest.result), "request should succeed")
+			require.Equal(t, price+price/2, channel.Redeemed())
+		})
+
 		t.Run("can send extension data", func(t *testing.T) {
 			t.Run("when unpaused", func(t *testing.T) {
 				td := newTestData(t)
@@ -948,6 +1324,206 @@ func TestValidationAndExtensions(t *testing.T) {
 	})
 }
 
+func TestQueueLimits(t *testing.T) {
+	t.Run("rejects requests once the queue is full", func(t *testing.T) {
+		td := newTestData(t)
+		defer td.cancel()
+		responseManager := New(td.ctx, td.loader, td.peerManager, td.queryQueue, td.requestHooks, td.blockHooks, td.updateHooks, td.completedListeners, td.cancelledListeners)
+		require.Error(t, responseManager.SetMaxInProcessRequests(0), "should need at least one worker")
+		require.NoError(t, responseManager.SetMaxInProcessRequests(1))
+		responseManager.SetMaxQueuedRequestsPerPeer(1)
+		responseManager.SetBusyRetryAfter(time.Second)
+		responseManager.Startup()
+
+		// the first request holds the only worker until released
+		hookCalled := make(chan struct{}, 1)
+		release := make(chan struct{})
+		defer close(release)
+		td.requestHooks.Register(func(p peer.ID, requestData graphsync.RequestData, hookActions graphsync.IncomingRequestHookActions) {
+			if requestData.ID() == td.requestID {
+				hookCalled <- struct{}{}
+				<-release
+			}
+			hookActions.ValidateRequest()
+		})
+		responseManager.ProcessRequests(td.ctx, td.p, td.requests)
+		testutil.AssertDoesReceive(td.ctx, t, hookCalled, "should start processing request")
+
+		queuedRequestID := td.requestID + 1
+		busyRequestID := td.requestID + 2
+		requests := []gsmsg.GraphSyncRequest{
+			gsmsg.NewRequest(queuedRequestID, td.blockChain.TipLink.(cidlink.Link).Cid, td.blockChain.Selector(), graphsync.Priority(0)),
+			gsmsg.NewRequest(busyRequestID, td.blockChain.TipLink.(cidlink.Link).Cid, td.blockChain.Selector(), graphsync.Priority(0)),
+		}
+		responseManager.ProcessRequests(td.ctx, td.p, requests)
+		var sentExtension sentExtension
+		testutil.AssertReceive(td.ctx, t, td.sentExtensions, &sentExtension, "should send retry after")
+		require.Equal(t, busyRequestID, sentExtension.requestID)
+		require.Equal(t, graphsync.ExtensionRetryAfter, sentExtension.extension.Name)
+		retryAfter, err := retryafter.DecodeRetryAfter(sentExtension.extension.Data)
+		require.NoError(t, err)
+		require.Equal(t, time.Second, retryAfter)
+		var lastRequest completedRequest
+		testutil.AssertReceive(td.ctx, t, td.completedRequestChan, &lastRequest, "should reject request")
+		require.Equal(t, busyRequestID, lastRequest.requestID)
+		require.Equal(t, graphsync.RequestFailedBusy, lastRequest.result)
+
+		// cancelling the queued request frees up space in the queue
+		err = responseManager.CancelResponse(td.p, queuedRequestID)
+		require.NoError(t, err)
+		testutil.AssertReceive(td.ctx, t, td.completedRequestChan, &lastRequest, "should cancel request")
+		require.Equal(t, queuedRequestID, lastRequest.requestID)
+		require.Equal(t, graphsync.RequestCancelled, lastRequest.result)
+		responseManager.ProcessRequests(td.ctx, td.p, requests[1:])
+		responseManager.synchronize()
+		testutil.AssertChannelEmpty(t, td.completedRequestChan, "should not reject request")
+	})
+
+	t.Run("counts unpaused responses waiting for a worker", func(t *testing.T) {
+		td := newTestData(t)
+		defer td.cancel()
+		responseManager := New(td.ctx, td.loader, td.peerManager, td.queryQueue, td.requestHooks, td.blockHooks, td.updateHooks, td.completedListeners, td.cancelledListeners)
+		require.NoError(t, responseManager.SetMaxInProcessRequests(1))
+		responseManager.SetMaxQueuedRequestsPerPeer(1)
+		responseManager.Startup()
+
+		// the first request pauses, the second holds the only worker until released
+		blockingRequestID := td.requestID + 1
+		hookCalled := make(chan struct{}, 1)
+		release := make(chan struct{})
+		defer close(release)
+		td.requestHooks.Register(func(p peer.ID, requestData graphsync.RequestData, hookActions graphsync.IncomingRequestHookActions) {
+			hookActions.ValidateRequest()
+			switch requestData.ID() {
+			case td.requestID:
+				hookActions.PauseResponse()
+			case blockingRequestID:
+				hookCalled <- struct{}{}
+				<-release
+			}
+		})
+		responseManager.ProcessRequests(td.ctx, td.p, td.requests)
+		var pauseRequest pausedRequest
+		testutil.AssertReceive(td.ctx, t, td.pausedRequests, &pauseRequest, "should pause immediately")
+		blockingRequest := gsmsg.NewRequest(blockingRequestID, td.blockChain.TipLink.(cidlink.Link).Cid, td.blockChain.Selector(), graphsync.Priority(0))
+		responseManager.ProcessRequests(td.ctx, td.p, []gsmsg.GraphSyncRequest{blockingRequest})
+		testutil.AssertDoesReceive(td.ctx, t, hookCalled, "should start processing request")
+
+		// the unpaused response waits for the worker, filling the queue
+		err := responseManager.UnpauseResponse(td.p, td.requestID)
+		require.NoError(t, err)
+		busyRequestID := td.requestID + 2
+		busyRequest := gsmsg.NewRequest(busyRequestID, td.blockChain.TipLink.(cidlink.Link).Cid, td.blockChain.Selector(), graphsync.Priority(0))
+		responseManager.ProcessRequests(td.ctx, td.p, []gsmsg.GraphSyncRequest{busyRequest})
+		var lastRequest completedRequest
+		testutil.AssertReceive(td.ctx, t, td.completedRequestChan, &lastRequest, "should reject request")
+		require.Equal(t, busyRequestID, lastRequest.requestID)
+		require.Equal(t, graphsync.RequestFailedBusy, lastRequest.result)
+	})
+}
+
+type peerRate struct {
+	ctx  context.Context
+	p    peer.ID
+	rate uint64
+}
+
+type fakeBandwidthLimiter struct {
+	rates chan peerRate
+}
+
+func (fbl *fakeBandwidthLimiter) SetRequestRate(ctx context.Context, p peer.ID, rate uint64) {
+	fbl.rates <- peerRate{ctx, p, rate}
+}
+
+type fakePausedResponseStore struct {
+	lk              sync.Mutex
+	pausedResponses map[responseKey]graphsync.PausedResponse
+}
+
+func (fprs *fakePausedResponseStore) Put(pausedResponse graphsync.PausedResponse) error {
+	fprs.lk.Lock()
+	defer fprs.lk.Unlock()
+	fprs.pausedResponses[responseKey{pausedResponse.Peer, pausedResponse.RequestID}] = pausedResponse
+	return nil
+}
+
+func (fprs *fakePausedResponseStore) Delete(p peer.ID, requestID graphsync.RequestID) error {
+	fprs.lk.Lock()
+	defer fprs.lk.Unlock()
+	delete(fprs.pausedResponses, responseKey{p, requestID})
+	return nil
+}
+
+func (fprs *fakePausedResponseStore) List() ([]graphsync.PausedResponse, error) {
+	fprs.lk.Lock()
+	defer fprs.lk.Unlock()
+	pausedResponses := make([]graphsync.PausedResponse, 0, len(fprs.pausedResponses))
+	for _, pausedResponse := range fprs.pausedResponses {
+		pausedResponses = append(pausedResponses, pausedResponse)
+	}
+	return pausedResponses, nil
+}
+
+func TestPausedResponsePersistence(t *testing.T) {
+	td := newTestData(t)
+	defer td.cancel()
+	store := &fakePausedResponseStore{pausedResponses: make(map[responseKey]graphsync.PausedResponse)}
+	responseManager := New(td.ctx, td.loader, td.peerManager, td.queryQueue, td.requestHooks, td.blockHooks, td.updateHooks, td.completedListeners, td.cancelledListeners)
+	responseManager.SetPausedResponseStore(store)
+	responseManager.Startup()
+	td.requestHooks.Register(func(p peer.ID, requestData graphsync.RequestData, hookActions graphsync.IncomingRequestHookActions) {
+		hookActions.ValidateRequest()
+	})
+	blkIndex := 0
+	blockCount := 3
+	td.blockHooks.Register(func(p peer.ID, requestData graphsync.RequestData, blockData graphsync.BlockData, hookActions graphsync.OutgoingBlockHookActions) {
+		blkIndex++
+		if blkIndex == blockCount {
+			hookActions.PauseResponse()
+		}
+	})
+	responseManager.ProcessRequests(td.ctx, td.p, td.requests)
+	for i := 0; i < blockCount; i++ {
+		testutil.AssertDoesReceive(td.ctx, t, td.sentResponses, "should sent block")
+	}
+	var pausedRequest pausedRequest
+	testutil.AssertReceive(td.ctx, t, td.pausedRequests, &pausedRequest, "should pause request")
+	responseManager.synchronize()
+
+	pausedResponses, err := store.List()
+	require.NoError(t, err)
+	require.Len(t, pausedResponses, 1)
+	require.Equal(t, td.p, pausedResponses[0].Peer)
+	require.Equal(t, td.requestID, pausedResponses[0].RequestID)
+	require.Equal(t, []graphsync.ExtensionData{td.extension}, pausedResponses[0].Extensions)
+	require.Len(t, pausedResponses[0].SentLinks, blockCount)
+
+	// simulate a restart of the responder
+	responseManager.Shutdown()
+	responseManager = New(td.ctx, td.loader, td.peerManager, td.queryQueue, td.requestHooks, td.blockHooks, td.updateHooks, td.completedListeners, td.cancelledListeners)
+	responseManager.SetPausedResponseStore(store)
+	responseManager.Startup()
+
+	var ignoredLinks []ipld.Link
+	testutil.AssertReceive(td.ctx, t, td.ignoredLinks, &ignoredLinks, "should ignore links already sent")
+	require.Equal(t, pausedResponses[0].SentLinks, ignoredLinks)
+
+	// the request hooks run again, but a restored response was already paused
+	td.requestHooks.Register(func(p peer.ID, requestData graphsync.RequestData, hookActions graphsync.IncomingRequestHookActions) {
+		hookActions.PauseResponse()
+	})
+	err = responseManager.UnpauseResponse(td.p, td.requestID)
+	require.NoError(t, err)
+	var lastRequest completedRequest
+	testutil.AssertReceive(td.ctx, t, td.completedRequestChan, &lastRequest, "should complete request")
+	require.True(t, gsmsg.IsTerminalSuccessCode(lastRequest.result), "request should succeed")
+
+	pausedResponses, err = store.List()
+	require.NoError(t, err)
+	require.Len(t, pausedResponses, 0)
+}
+
 type testData struct {
 	ctx                   context.Context
 	cancel                func()
@@ -963,6 +1539,8 @@ type testData struct {
 	cancelledRequests     chan cancelledRequest
 	ignoredLinks          chan []ipld.Link
 	dedupKeys             chan string
+	metadataOnlyRequests  chan graphsync.RequestID
+	partitions            chan partition.Partition
 	peerManager           *fakePeerManager
 	queryQueue            *fakeQueryQueue
 	extensionData         []byte
@@ -1001,6 +1579,8 @@ func newTestData(t *testing.T) testData {
 	td.cancelledRequests = make(chan cancelledRequest, 1)
 	td.ignoredLinks = make(chan []ipld.Link, 1)
 	td.dedupKeys = make(chan string, 1)
+	td.metadataOnlyRequests = make(chan graphsync.RequestID, 1)
+	td.partitions = make(chan partition.Partition, 1)
 	fprs := &fakePeerResponseSender{
 		lastCompletedRequest: td.completedRequestChan,
 		sentResponses:        td.sentResponses,
@@ -1009,6 +1589,8 @@ func newTestData(t *testing.T) testData {
 		cancelledRequests:    td.cancelledRequests,
 		ignoredLinks:         td.ignoredLinks,
 		dedupKeys:            td.dedupKeys,
+		metadataOnlyRequests: td.metadataOnlyRequests,
+		partitions:           td.partitions,
 	}
 	td.peerManager = &fakePeerManager{peerResponseSender: fprs}
 	td.queryQueue = &fakeQueryQueue{}
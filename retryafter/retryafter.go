@@ -0,0 +1,34 @@
+package retryafter
+
+import (
+	"time"
+
+	basicnode "github.com/ipld/go-ipld-prime/node/basic"
+
+	"github.com/ipfs/go-graphsync/ipldutil"
+)
+
+// EncodeRetryAfter returns encoded cbor data for a retry-after duration, in
+// milliseconds
+func EncodeRetryAfter(retryAfter time.Duration) ([]byte, error) {
+	nb := basicnode.Style.Int.NewBuilder()
+	err := nb.AssignInt(int(retryAfter / time.Millisecond))
+	if err != nil {
+		return nil, err
+	}
+	nd := nb.Build()
+	return ipldutil.EncodeNode(nd)
+}
+
+// DecodeRetryAfter returns a retry-after duration decoded from cbor data
+func DecodeRetryAfter(data []byte) (time.Duration, error) {
+	nd, err := ipldutil.DecodeNode(data)
+	if err != nil {
+		return 0, err
+	}
+	milliseconds, err := nd.AsInt()
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(milliseconds) * time.Millisecond, nil
+}
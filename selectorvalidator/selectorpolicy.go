@@ -0,0 +1,301 @@
+package selectorvalidator
+
+import (
+	"errors"
+	"math"
+
+	ipld "github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/traversal/selector"
+	"github.com/libp2p/go-libp2p-core/peer"
+
+	"github.com/ipfs/go-graphsync"
+)
+
+const defaultFanOut = 10
+
+var (
+	// ErrInvalidSelector means a selector is not a well formed selector spec
+	ErrInvalidSelector = errors.New("invalid selector spec")
+	// ErrTooManyNodes means a selector has more clauses than a policy allows
+	ErrTooManyNodes = errors.New("selector has too many nodes")
+	// ErrTooDeeplyNested means a selector nests clauses more deeply than a policy
+	// allows
+	ErrTooDeeplyNested = errors.New("selector is too deeply nested")
+	// ErrUnionTooWide means a selector has a union of more selectors than a
+	// policy allows
+	ErrUnionTooWide = errors.New("selector union is too wide")
+	// ErrKindNotAllowed means a selector uses a kind of clause a policy does not
+	// allow
+	ErrKindNotAllowed = errors.New("selector kind not allowed")
+	// ErrTooCostly means the estimated cost of traversing with a selector is
+	// higher than a policy allows
+	ErrTooCostly = errors.New("selector traversal cost too high")
+)
+
+// SelectorPolicy limits how large a selector may be and how expensive a
+// traversal with it may be. Limits left at zero are not enforced
+type SelectorPolicy struct {
+	// MaxNodes limits the total number of clauses in the selector
+	MaxNodes int `json:"maxNodes,omitempty" yaml:"maxNodes,omitempty"`
+	// MaxNestingDepth limits how deeply clauses are nested in the selector
+	MaxNestingDepth int `json:"maxNestingDepth,omitempty" yaml:"maxNestingDepth,omitempty"`
+	// MaxUnionWidth limits the number of selectors in any one union
+	MaxUnionWidth int `json:"maxUnionWidth,omitempty" yaml:"maxUnionWidth,omitempty"`
+	// MaxRecursionDepth limits the depth of every recursive clause, and means
+	// recursive clauses without a depth limit are not allowed
+	MaxRecursionDepth int `json:"maxRecursionDepth,omitempty" yaml:"maxRecursionDepth,omitempty"`
+	// AllowedKinds are the keys of the clauses the selector may use, such as
+	// selector.SelectorKey_ExploreAll. When empty, all kinds are allowed
+	AllowedKinds []string `json:"allowedKinds,omitempty" yaml:"allowedKinds,omitempty"`
+	// MaxCost limits the estimated number of nodes a traversal with the selector
+	// visits
+	MaxCost uint64 `json:"maxCost,omitempty" yaml:"maxCost,omitempty"`
+	// FanOut is how many children ExploreAll is assumed to visit when estimating
+	// cost. It defaults to 10
+	FanOut int `json:"fanOut,omitempty" yaml:"fanOut,omitempty"`
+}
+
+// SelectorPolicyValidator returns an OnIncomingRequestHook that only validates
+// requests if their selector meets the given policy
+func SelectorPolicyValidator(selectorPolicy SelectorPolicy) graphsync.OnIncomingRequestHook {
+	return func(p peer.ID, request graphsync.RequestData, hookActions graphsync.IncomingRequestHookActions) {
+		err := selectorPolicy.Validate(request.Selector())
+		if err == nil {
+			hookActions.ValidateRequest()
+		}
+	}
+}
+
+// Validate returns an error if the given selector does not meet the policy
+func (sp SelectorPolicy) Validate(node ipld.Node) error {
+	cost, err := sp.EstimateCost(node)
+	if err != nil {
+		return err
+	}
+	if sp.MaxCost > 0 && cost > float64(sp.MaxCost) {
+		return ErrTooCostly
+	}
+	return nil
+}
+
+// EstimateCost returns the estimated number of nodes a traversal with the given
+// selector visits, which is infinite for selectors that recurse without limit.
+// It errors if the selector does not meet the policy's limits other than cost
+func (sp SelectorPolicy) EstimateCost(node ipld.Node) (float64, error) {
+	sw := &selectorWalker{policy: sp, fanOut: defaultFanOut}
+	if sp.FanOut > 0 {
+		sw.fanOut = float64(sp.FanOut)
+	}
+	cost, err := sw.walk(node, 1)
+	if err != nil {
+		return 0, err
+	}
+	return cost.base + cost.edges, nil
+}
+
+// selectorCost is the cost of a clause as base + edges * the cost of recursing
+// again from the nearest enclosing recursive clause
+type selectorCost struct {
+	base  float64
+	edges float64
+}
+
+func (sc selectorCost) add(other selectorCost) selectorCost {
+	return selectorCost{sc.base + other.base, sc.edges + other.edges}
+}
+
+func (sc selectorCost) times(n float64) selectorCost {
+	return selectorCost{sc.base * n, sc.edges * n}
+}
+
+type selectorWalker struct {
+	policy SelectorPolicy
+	fanOut float64
+	nodes  int
+}
+
+func (sw *selectorWalker) walk(node ipld.Node, depth int) (selectorCost, error) {
+	sw.nodes++
+	if sw.policy.MaxNodes > 0 && sw.nodes > sw.policy.MaxNodes {
+		return selectorCost{}, ErrTooManyNodes
+	}
+	if sw.policy.MaxNestingDepth > 0 && depth > sw.policy.MaxNestingDepth {
+		return selectorCost{}, ErrTooDeeplyNested
+	}
+	if node.ReprKind() != ipld.ReprKind_Map || node.Length() != 1 {
+		return selectorCost{}, ErrInvalidSelector
+	}
+	kn, body, err := node.MapIterator().Next()
+	if err != nil {
+		return selectorCost{}, ErrInvalidSelector
+	}
+	kind, err := kn.AsString()
+	if err != nil {
+		return selectorCost{}, ErrInvalidSelector
+	}
+	if !sw.isAllowed(kind) {
+		return selectorCost{}, ErrKindNotAllowed
+	}
+	switch kind {
+	case selector.SelectorKey_Matcher:
+		return selectorCost{base: 1}, nil
+	case selector.SelectorKey_ExploreRecursiveEdge:
+		return selectorCost{edges: 1}, nil
+	case selector.SelectorKey_ExploreAll:
+		next, err := sw.walkField(body, selector.SelectorKey_Next, depth)
+		if err != nil {
+			return selectorCost{}, err
+		}
+		return selectorCost{base: 1}.add(next.times(sw.fanOut)), nil
+	case selector.SelectorKey_ExploreIndex, selector.SelectorKey_ExploreConditional:
+		next, err := sw.walkField(body, selector.SelectorKey_Next, depth)
+		if err != nil {
+			return selectorCost{}, err
+		}
+		return selectorCost{base: 1}.add(next), nil
+	case selector.SelectorKey_ExploreRange:
+		start, err := lookupInt(body, selector.SelectorKey_Start)
+		if err != nil {
+			return selectorCost{}, err
+		}
+		end, err := lookupInt(body, selector.SelectorKey_End)
+		if err != nil {
+			return selectorCost{}, err
+		}
+		next, err := sw.walkField(body, selector.SelectorKey_Next, depth)
+		if err != nil {
+			return selectorCost{}, err
+		}
+		return selectorCost{base: 1}.add(next.times(math.Max(float64(end-start), 0))), nil
+	case selector.SelectorKey_ExploreFields:
+		fields, err := body.LookupString(selector.SelectorKey_Fields)
+		if err != nil || fields.ReprKind() != ipld.ReprKind_Map {
+			return selectorCost{}, ErrInvalidSelector
+		}
+		cost := selectorCost{base: 1}
+		it := fields.MapIterator()
+		for !it.Done() {
+			_, field, err := it.Next()
+			if err != nil {
+				return selectorCost{}, ErrInvalidSelector
+			}
+			fieldCost, err := sw.walk(field, depth+1)
+			if err != nil {
+				return selectorCost{}, err
+			}
+			cost = cost.add(fieldCost)
+		}
+		return cost, nil
+	case selector.SelectorKey_ExploreUnion:
+		if body.ReprKind() != ipld.ReprKind_List {
+			return selectorCost{}, ErrInvalidSelector
+		}
+		if sw.policy.MaxUnionWidth > 0 && body.Length() > sw.policy.MaxUnionWidth {
+			return selectorCost{}, ErrUnionTooWide
+		}
+		var cost selectorCost
+		it := body.ListIterator()
+		for !it.Done() {
+			_, member, err := it.Next()
+			if err != nil {
+				return selectorCost{}, ErrInvalidSelector
+			}
+			memberCost, err := sw.walk(member, depth+1)
+			if err != nil {
+				return selectorCost{}, err
+			}
+			cost = cost.add(memberCost)
+		}
+		return cost, nil
+	case selector.SelectorKey_ExploreRecursive:
+		limit, hasLimit, err := recursionLimit(body)
+		if err != nil {
+			return selectorCost{}, err
+		}
+		if sw.policy.MaxRecursionDepth > 0 && (!hasLimit || limit > sw.policy.MaxRecursionDepth) {
+			return selectorCost{}, ErrInvalidLimit
+		}
+		sequence, err := sw.walkField(body, selector.SelectorKey_Sequence, depth)
+		if err != nil {
+			return selectorCost{}, err
+		}
+		return selectorCost{base: recursiveCost(sequence, limit, hasLimit)}, nil
+	default:
+		return selectorCost{}, ErrInvalidSelector
+	}
+}
+
+func (sw *selectorWalker) walkField(body ipld.Node, key string, depth int) (selectorCost, error) {
+	field, err := body.LookupString(key)
+	if err != nil {
+		return selectorCost{}, ErrInvalidSelector
+	}
+	return sw.walk(field, depth+1)
+}
+
+func (sw *selectorWalker) isAllowed(kind string) bool {
+	if len(sw.policy.AllowedKinds) == 0 {
+		return true
+	}
+	for _, allowed := range sw.policy.AllowedKinds {
+		if kind == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// recursiveCost is the cost of a recursive clause whose sequence has the given
+// cost. At the depth limit, recursion edges only visit the node they reach
+func recursiveCost(sequence selectorCost, limit int, hasLimit bool) float64 {
+	a, b := sequence.base, sequence.edges
+	if b == 0 {
+		return a
+	}
+	if !hasLimit {
+		return math.Inf(1)
+	}
+	// the cost at depth d is a + b * the cost at depth d-1, starting from a + b
+	atLimit := a + b
+	if b == 1 {
+		return atLimit + float64(limit)*a
+	}
+	growth := math.Pow(b, float64(limit))
+	return growth*atLimit + a*(growth-1)/(b-1)
+}
+
+func recursionLimit(body ipld.Node) (int, bool, error) {
+	limit, err := body.LookupString(selector.SelectorKey_Limit)
+	if err != nil || limit.ReprKind() != ipld.ReprKind_Map || limit.Length() != 1 {
+		return 0, false, ErrInvalidSelector
+	}
+	kn, v, err := limit.MapIterator().Next()
+	if err != nil {
+		return 0, false, ErrInvalidSelector
+	}
+	kstr, _ := kn.AsString()
+	switch kstr {
+	case selector.SelectorKey_LimitDepth:
+		depth, err := v.AsInt()
+		if err != nil {
+			return 0, false, ErrInvalidSelector
+		}
+		return depth, true, nil
+	case selector.SelectorKey_LimitNone:
+		return 0, false, nil
+	default:
+		return 0, false, ErrInvalidSelector
+	}
+}
+
+func lookupInt(body ipld.Node, key string) (int, error) {
+	node, err := body.LookupString(key)
+	if err != nil {
+		return 0, ErrInvalidSelector
+	}
+	value, err := node.AsInt()
+	if err != nil {
+		return 0, ErrInvalidSelector
+	}
+	return value, nil
+}
@@ -0,0 +1,136 @@
+package selectorvalidator
+
+import (
+	"math"
+	"testing"
+
+	ipld "github.com/ipld/go-ipld-prime"
+	basicnode "github.com/ipld/go-ipld-prime/node/basic"
+	"github.com/ipld/go-ipld-prime/traversal/selector"
+	"github.com/ipld/go-ipld-prime/traversal/selector/builder"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSelectorPolicy(t *testing.T) {
+	ssb := builder.NewSelectorSpecBuilder(basicnode.Style.Map)
+
+	exploreAll := ssb.ExploreAll(ssb.Matcher()).Node()
+	nestedExploreAll := ssb.ExploreAll(ssb.ExploreAll(ssb.ExploreAll(ssb.Matcher()))).Node()
+	wideUnion := ssb.ExploreUnion(ssb.Matcher(), ssb.Matcher(), ssb.Matcher()).Node()
+	limitedRecursion := ssb.ExploreRecursive(selector.RecursionLimitDepth(3), ssb.ExploreAll(ssb.ExploreRecursiveEdge())).Node()
+	unlimitedRecursion := ssb.ExploreRecursive(selector.RecursionLimitNone(), ssb.ExploreAll(ssb.ExploreRecursiveEdge())).Node()
+	hiddenUnlimitedRecursion := ssb.ExploreFields(func(efsb builder.ExploreFieldsSpecBuilder) {
+		efsb.Insert("Links", ssb.ExploreUnion(ssb.Matcher(), ssb.ExploreIndex(0, ssb.ExploreRecursive(selector.RecursionLimitNone(), ssb.ExploreAll(ssb.ExploreRecursiveEdge())))))
+	}).Node()
+
+	testCases := map[string]struct {
+		policy   SelectorPolicy
+		selector ipld.Node
+		err      error
+	}{
+		"no limits": {
+			selector: unlimitedRecursion,
+		},
+		"within node count": {
+			policy:   SelectorPolicy{MaxNodes: 4},
+			selector: wideUnion,
+		},
+		"too many nodes": {
+			policy:   SelectorPolicy{MaxNodes: 3},
+			selector: wideUnion,
+			err:      ErrTooManyNodes,
+		},
+		"within nesting depth": {
+			policy:   SelectorPolicy{MaxNestingDepth: 4},
+			selector: nestedExploreAll,
+		},
+		"too deeply nested": {
+			policy:   SelectorPolicy{MaxNestingDepth: 3},
+			selector: nestedExploreAll,
+			err:      ErrTooDeeplyNested,
+		},
+		"union too wide": {
+			policy:   SelectorPolicy{MaxUnionWidth: 2},
+			selector: wideUnion,
+			err:      ErrUnionTooWide,
+		},
+		"allowed kinds": {
+			policy:   SelectorPolicy{AllowedKinds: []string{selector.SelectorKey_ExploreAll, selector.SelectorKey_Matcher}},
+			selector: exploreAll,
+		},
+		"kind not allowed": {
+			policy:   SelectorPolicy{AllowedKinds: []string{selector.SelectorKey_Matcher}},
+			selector: exploreAll,
+			err:      ErrKindNotAllowed,
+		},
+		"recursion within depth": {
+			policy:   SelectorPolicy{MaxRecursionDepth: 3},
+			selector: limitedRecursion,
+		},
+		"recursion too deep": {
+			policy:   SelectorPolicy{MaxRecursionDepth: 2},
+			selector: limitedRecursion,
+			err:      ErrInvalidLimit,
+		},
+		"hidden unlimited recursion": {
+			policy:   SelectorPolicy{MaxRecursionDepth: 100},
+			selector: hiddenUnlimitedRecursion,
+			err:      ErrInvalidLimit,
+		},
+		"within cost": {
+			policy:   SelectorPolicy{MaxCost: 11},
+			selector: exploreAll,
+		},
+		"too costly": {
+			policy:   SelectorPolicy{MaxCost: 10},
+			selector: exploreAll,
+			err:      ErrTooCostly,
+		},
+		"unlimited recursion too costly": {
+			policy:   SelectorPolicy{MaxCost: 1 << 40},
+			selector: hiddenUnlimitedRecursion,
+			err:      ErrTooCostly,
+		},
+		"invalid selector": {
+			selector: basicnode.NewString("not a selector"),
+			err:      ErrInvalidSelector,
+		},
+	}
+	for testCase, data := range testCases {
+		t.Run(testCase, func(t *testing.T) {
+			err := data.policy.Validate(data.selector)
+			require.Equal(t, data.err, err)
+		})
+	}
+}
+
+func TestEstimateCost(t *testing.T) {
+	ssb := builder.NewSelectorSpecBuilder(basicnode.Style.Map)
+	sp := SelectorPolicy{FanOut: 2}
+
+	cost, err := sp.EstimateCost(ssb.Matcher().Node())
+	require.NoError(t, err)
+	require.Equal(t, float64(1), cost)
+
+	cost, err = sp.EstimateCost(ssb.ExploreAll(ssb.Matcher()).Node())
+	require.NoError(t, err)
+	require.Equal(t, float64(3), cost)
+
+	cost, err = sp.EstimateCost(ssb.ExploreRange(0, 5, ssb.Matcher()).Node())
+	require.NoError(t, err)
+	require.Equal(t, float64(6), cost)
+
+	// a chain: each level visits one node and follows one edge
+	cost, err = sp.EstimateCost(ssb.ExploreRecursive(selector.RecursionLimitDepth(3), ssb.ExploreIndex(0, ssb.ExploreRecursiveEdge())).Node())
+	require.NoError(t, err)
+	require.Equal(t, float64(5), cost)
+
+	// a binary tree: each level visits one node and follows two edges
+	cost, err = sp.EstimateCost(ssb.ExploreRecursive(selector.RecursionLimitDepth(2), ssb.ExploreAll(ssb.ExploreRecursiveEdge())).Node())
+	require.NoError(t, err)
+	require.Equal(t, float64(15), cost)
+
+	cost, err = sp.EstimateCost(ssb.ExploreRecursive(selector.RecursionLimitNone(), ssb.ExploreAll(ssb.ExploreRecursiveEdge())).Node())
+	require.NoError(t, err)
+	require.True(t, math.IsInf(cost, 1))
+}
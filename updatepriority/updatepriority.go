@@ -0,0 +1,32 @@
+package updatepriority
+
+import (
+	basicnode "github.com/ipld/go-ipld-prime/node/basic"
+
+	"github.com/ipfs/go-graphsync"
+	"github.com/ipfs/go-graphsync/ipldutil"
+)
+
+// EncodePriority returns encoded cbor data for a request priority
+func EncodePriority(priority graphsync.Priority) ([]byte, error) {
+	nb := basicnode.Style.Int.NewBuilder()
+	err := nb.AssignInt(int(priority))
+	if err != nil {
+		return nil, err
+	}
+	nd := nb.Build()
+	return ipldutil.EncodeNode(nd)
+}
+
+// DecodePriority returns a request priority decoded from cbor data
+func DecodePriority(data []byte) (graphsync.Priority, error) {
+	nd, err := ipldutil.DecodeNode(data)
+	if err != nil {
+		return 0, err
+	}
+	priority, err := nd.AsInt()
+	if err != nil {
+		return 0, err
+	}
+	return graphsync.Priority(priority), nil
+}